@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// installStep is one Inst, Conf or Remv action of an apt simulation, in the order apt
+// would run it
+type installStep struct {
+	action       string // "Inst" (unpack), "Conf" (configure) or "Remv" (remove)
+	name         string
+	qualifier    string // Architecture apt printed after the name, for foreign packages
+	architecture string // Architecture of the version installed; empty for removals
+	version      string
+}
+
+// target names the package of the step for dpkg, qualified by its architecture so that
+// multi-arch packages installed for several architectures are unambiguous
+func (s installStep) target() string {
+	switch {
+	case s.qualifier != "":
+		return s.name + ":" + s.qualifier
+	case s.architecture != "" && s.architecture != "all":
+		return s.name + ":" + s.architecture
+	}
+
+	return s.name
+}
+
+// policyRCScript keeps maintainer scripts from starting services inside the image
+const policyRCScript = "#!/bin/sh\nexit 101\n"
+
+// RunApplyCommand installs packages from the repository into a mounted target root
+// filesystem with dpkg --root, in the order apt computes from the target's status
+func RunApplyCommand(args []string) error {
+	var cfg config.Config
+	var root string
+	var dryRun bool
+
+	fs := newFlagSet("apply", &cfg)
+	fs.StringVar(&root, "root", "", "Mounted root filesystem of the target image")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the dpkg steps without running them")
+	fs.Parse(args)
+
+	if root == "" {
+		return fmt.Errorf("usage: apply --root DIR [PACKAGE...]")
+	}
+
+	root, err := filepath.Abs(root)
+
+	if err != nil {
+		return err
+	}
+
+	if !dryRun && os.Geteuid() != 0 {
+		return fmt.Errorf("apply must run as root to install into %s", root)
+	}
+
+	admindir := filepath.Join(root, "var/lib/dpkg")
+	statusFile := filepath.Join(admindir, "status")
+
+	if _, err := os.Stat(statusFile); err != nil {
+		return fmt.Errorf("%s does not look like a Debian root filesystem: %w", root, err)
+	}
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	packages := fs.Args()
+
+	if len(packages) == 0 {
+		packages = mfest.Requested
+	}
+
+	if len(packages) == 0 {
+		return fmt.Errorf("no packages given and the manifest records no requested packages")
+	}
+
+	env, cleanup, err := repositoryAptEnv(cfg.RepoPath, mfest, statusFile)
+
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	out, err := env.Command("apt-get", append([]string{"install", "--simulate", "-y"}, packages...)...).CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("apt cannot install %s into %s: %s", strings.Join(packages, " "), root, strings.TrimSpace(string(out)))
+	}
+
+	steps := parseInstallSteps(string(out))
+
+	if len(steps) == 0 {
+		output.Info("Nothing to install; %s already has everything requested", root)
+
+		return nil
+	}
+
+	files := make(map[string]string)
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded && pkg.Type != packageinfo.TypeSource {
+			files[pkg.Name+":"+pkg.Architecture+"="+pkg.Version] = filepath.Join(cfg.RepoPath, "pool", pkg.Filename)
+		}
+	}
+
+	if !dryRun {
+		restore, err := disableServiceStarts(root)
+
+		if err != nil {
+			return err
+		}
+
+		defer restore()
+	}
+
+	for i, step := range steps {
+		dpkgArgs := []string{"--root=" + root, "--admindir=" + admindir}
+
+		switch step.action {
+		case "Inst":
+			file, ok := files[step.name+":"+step.architecture+"="+step.version]
+
+			if !ok {
+				return fmt.Errorf("%s %s is not in the repository pool", step.target(), step.version)
+			}
+
+			dpkgArgs = append(dpkgArgs, "--unpack", file)
+		case "Conf":
+			dpkgArgs = append(dpkgArgs, "--configure", step.target())
+		case "Remv":
+			dpkgArgs = append(dpkgArgs, "--remove", step.target())
+		}
+
+		output.Info("[%d/%d] dpkg %s", i+1, len(steps), strings.Join(dpkgArgs, " "))
+
+		if dryRun {
+			continue
+		}
+
+		if out, err := exec.Command("dpkg", dpkgArgs...).CombinedOutput(); err != nil {
+			return fmt.Errorf("dpkg failed on %s: %w, output: %s", step.name, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	if !dryRun {
+		output.Success("Installed %s into %s", strings.Join(packages, " "), root)
+	}
+
+	return nil
+}
+
+// parseInstallSteps extracts the unpack, configure and remove steps of 'apt-get --simulate'
+// output, e.g. "Inst libfoo1:i386 [1.0] (1.1 Portaptable:jammy [i386])" or "Remv libbar0 [0.9]"
+func parseInstallSteps(out string) []installStep {
+	var steps []installStep
+	scanner := bufio.NewScanner(strings.NewReader(out))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 || (fields[0] != "Inst" && fields[0] != "Conf" && fields[0] != "Remv") {
+			continue
+		}
+
+		step := installStep{action: fields[0]}
+		step.name, step.qualifier, _ = strings.Cut(fields[1], ":")
+
+		for _, field := range fields[2:] {
+			switch {
+			case strings.HasPrefix(field, "(") && step.version == "":
+				step.version = strings.TrimPrefix(field, "(")
+
+			// The version's architecture closes the parenthesis: "[amd64])"
+			case step.version != "" && strings.HasPrefix(field, "[") && strings.HasSuffix(field, "])"):
+				step.architecture = strings.TrimSuffix(strings.TrimPrefix(field, "["), "])")
+			}
+		}
+
+		if step.architecture == "" && step.action != "Remv" {
+			step.architecture = step.qualifier
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps
+}
+
+// disableServiceStarts installs a policy-rc.d denying service starts unless the image has
+// its own, and returns a function removing it again
+func disableServiceStarts(root string) (func(), error) {
+	path := filepath.Join(root, "usr/sbin/policy-rc.d")
+
+	if _, err := os.Stat(path); err == nil {
+		return func() {}, nil
+	}
+
+	if err := os.WriteFile(path, []byte(policyRCScript), 0755); err != nil {
+		return nil, fmt.Errorf("failed to write policy-rc.d: %w", err)
+	}
+
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,420 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+)
+
+// aptDir is the repository subdirectory holding the private apt configuration
+const aptDir = ".apt"
+
+// backportsPriority keeps backports from replacing base suite packages unless selected
+const backportsPriority = 100
+
+// selectedBackportsPriority makes the explicitly selected packages prefer backports
+const selectedBackportsPriority = 990
+
+// aptCommand returns an apt-get or apt-cache invocation against the configured sources
+func (run *downloadRun) aptCommand(name string, args ...string) *exec.Cmd {
+	// apt cannot send arbitrary headers, but takes the User-Agent
+	if userAgent := remote.UserAgent(); userAgent != "" {
+		args = append([]string{"-o", "Acquire::http::User-Agent=" + userAgent, "-o", "Acquire::https::User-Agent=" + userAgent}, args...)
+	}
+
+	if run.aptEnv != nil {
+		return run.aptEnv.Command(name, args...)
+	}
+
+	// The private configuration carries the SHA256 policy itself
+	if run.requireSHA256 {
+		for _, option := range aptenv.WeakHashOptions {
+			args = append([]string{"-o", option}, args...)
+		}
+	}
+
+	return exec.Command(name, args...)
+}
+
+// Pocket names accepted by --pockets; the release pocket is the bare distribution suite
+const (
+	pocketRelease   = "release"
+	pocketUpdates   = "updates"
+	pocketSecurity  = "security"
+	pocketProposed  = "proposed"
+	pocketBackports = "backports"
+)
+
+// defaultPockets are the pockets a stock installation enables
+var defaultPockets = []string{pocketRelease, pocketUpdates, pocketSecurity}
+
+// usesPrivateSources reports whether the configuration needs sources the host may not have:
+// options selecting sources or pins, or a distribution or architecture the host's sources lack
+func (run *downloadRun) usesPrivateSources(config *config.Config) bool {
+	if run.hostMirror(config.Distribution, config.Architecture) == "" || !run.archiveSnapshot.IsZero() {
+		return true
+	}
+
+	return config.Preset != "" || config.Mirror != "" || len(config.Pockets) > 0 || config.ESMTokenFile != "" || config.Backports || len(config.BackportsPackages) > 0 ||
+		len(config.ForeignArchitectures) > 0 || len(config.AdditionalArchitectures) > 0 || len(run.suiteTargets) > 0 || len(run.extraRepositories) > 0 || config.PreferencesFile != ""
+}
+
+// validatePockets rejects unknown --pockets values
+func validatePockets(pockets []string) error {
+	for _, pocket := range pockets {
+		switch pocket {
+		case pocketRelease, pocketUpdates, pocketSecurity, pocketProposed, pocketBackports:
+		default:
+			return fmt.Errorf("invalid pocket %q (expected release, updates, security, proposed or backports)", pocket)
+		}
+	}
+
+	return nil
+}
+
+// pocketSuite returns the archive suite of a pocket; Debian names its proposed pocket proposed-updates
+func (run *downloadRun) pocketSuite(distribution, pocket string) string {
+	switch {
+	case pocket == pocketRelease:
+		return distribution
+	case pocket == pocketProposed && run.distroVendor(distribution) == "debian":
+		return distribution + "-proposed-updates"
+	default:
+		return distribution + "-" + pocket
+	}
+}
+
+// setupAptSources prepares the private apt configuration when the requested
+// pockets differ from the host's, and downloads its package indexes
+func (run *downloadRun) setupAptSources(config *config.Config) error {
+	if !run.usesPrivateSources(config) {
+		remote.ScopeHeaders(run.hostMirror(config.Distribution, config.Architecture))
+
+		return nil
+	}
+
+	if config.Preset != "" {
+		return run.setupPresetSources(config)
+	}
+
+	pockets := config.Pockets
+
+	if len(pockets) == 0 {
+		pockets = run.distroPockets(config.Distribution)
+	}
+
+	if err := validatePockets(pockets); err != nil {
+		return err
+	}
+
+	if config.Backports || len(config.BackportsPackages) > 0 {
+		pockets = appendMissing(pockets, []string{pocketBackports})
+	}
+
+	pockets = appendMissing(pockets, run.targetPockets(config.Distribution))
+	mirrors, byMirror := run.architectureMirrors(config)
+	keyring := run.archiveKeyring(config.Distribution)
+	components := run.sourceComponents(config)
+
+	var sources []aptenv.Source
+	var suites []string
+
+	for _, mirror := range mirrors {
+		// Each archive is limited to its architectures when Ubuntu's ports serves some
+		var architectures []string
+
+		if len(mirrors) > 1 {
+			architectures = byMirror[mirror]
+		}
+
+		for _, pocket := range pockets {
+			uri := mirror
+
+			if pocket == pocketSecurity {
+				uri = run.securityMirror(config.Distribution, mirror)
+			}
+
+			suite := run.pocketSuite(config.Distribution, pocket)
+
+			// A local mirror holds only the suites it was synced with
+			if !hasSuite(uri, suite) {
+				output.Warning("Local mirror %s has no %s suite; skipping the %s pocket", uri, suite, pocket)
+
+				continue
+			}
+
+			signedBy, err := sourceSignedBy(config, uri, suite, keyring)
+
+			if err != nil {
+				return err
+			}
+
+			sources = append(sources, aptenv.Source{URI: uri, Suite: suite, Components: components, SignedBy: signedBy, Architectures: architectures})
+			suites = appendMissing(suites, []string{suite})
+		}
+	}
+
+	var pins []aptenv.Pin
+
+	// Backports only win for the packages the user selected deliberately
+	if contains(pockets, pocketBackports) {
+		backports := run.pinRelease(config.Distribution, run.pocketSuite(config.Distribution, pocketBackports))
+		pins = append(pins, aptenv.Pin{Packages: []string{"*"}, Release: backports, Priority: backportsPriority})
+
+		if len(config.BackportsPackages) > 0 {
+			pins = append(pins, aptenv.Pin{Packages: config.BackportsPackages, Release: backports, Priority: selectedBackportsPriority})
+		}
+	}
+
+	pins = append(pins, run.targetPins(config.Distribution)...)
+
+	env, err := aptenv.Create(filepath.Join(config.RepoPath, aptDir), config.Architecture, sources, pins)
+
+	if err != nil {
+		return err
+	}
+
+	env.AddArchitectures(qualifiedArchitectures(config)...)
+
+	if run.requireSHA256 {
+		env.RequireSHA256()
+	}
+
+	// Release files of a snapshot are past the Valid-Until they were published with
+	if !run.archiveSnapshot.IsZero() {
+		env.AllowExpired()
+	}
+
+	if config.ESMTokenFile != "" {
+		if err := run.addESMSources(config, env); err != nil {
+			return err
+		}
+
+		suites = append(suites, "ESM")
+	}
+
+	if err := run.addRepositorySources(env); err != nil {
+		return err
+	}
+
+	if err := run.addHeaders(env, sources); err != nil {
+		return err
+	}
+
+	if err := run.addPreferences(env); err != nil {
+		return err
+	}
+
+	output.Info("Updating package indexes for %s...", strings.Join(suites, ", "))
+
+	if err := env.Update(); err != nil {
+		return err
+	}
+
+	run.aptEnv = env
+
+	return run.pinBackportsClosure(config)
+}
+
+// pinBackportsClosure extends the pin of the --from-backports packages to their
+// dependencies, resolved as apt-get install -t <suite>-backports would, so the
+// backports' newer dependencies are downloaded rather than the base suite's
+func (run *downloadRun) pinBackportsClosure(config *config.Config) error {
+	if len(config.BackportsPackages) == 0 {
+		return nil
+	}
+
+	suite := run.pocketSuite(config.Distribution, pocketBackports)
+	var closure []string
+
+	for _, pkg := range config.BackportsPackages {
+		args := append([]string{"-o", "APT::Default-Release=" + suite}, dependsArgs...)
+		out, err := run.aptCommand("apt-cache", append(args, pkg)...).Output()
+
+		if err != nil {
+			return fmt.Errorf("failed to resolve the dependencies of %s in %s: %w", pkg, suite, err)
+		}
+
+		for _, dependency := range run.parseDependencyOutput(string(out)) {
+			name, _, _ := strings.Cut(dependency, ":")
+			closure = appendMissing(closure, []string{name})
+		}
+	}
+
+	records := fmt.Sprintf("Package: %s\nPin: release %s\nPin-Priority: %d\n",
+		strings.Join(closure, " "), run.pinRelease(config.Distribution, suite), selectedBackportsPriority)
+
+	return run.aptEnv.AddPreferences("backports-closure", records)
+}
+
+// setupPresetSources prepares the private apt configuration from the sources of a preset
+func (run *downloadRun) setupPresetSources(config *config.Config) error {
+	if config.Mirror != "" || len(config.Pockets) > 0 || config.Backports || len(config.BackportsPackages) > 0 || config.ESMTokenFile != "" {
+		return fmt.Errorf("--preset selects its own sources and cannot be combined with --mirror, --pockets, backports or ESM")
+	}
+
+	root := filepath.Join(config.RepoPath, aptDir)
+	sources, err := presetSources(config, filepath.Join(root, "keyrings"))
+
+	if err != nil {
+		return err
+	}
+
+	env, err := aptenv.Create(root, config.Architecture, sources, nil)
+
+	if err != nil {
+		return err
+	}
+
+	env.AddArchitectures(qualifiedArchitectures(config)...)
+
+	if run.requireSHA256 {
+		env.RequireSHA256()
+	}
+
+	if err := run.addRepositorySources(env); err != nil {
+		return err
+	}
+
+	if err := run.addHeaders(env, sources); err != nil {
+		return err
+	}
+
+	if err := run.addPreferences(env); err != nil {
+		return err
+	}
+
+	output.Info("Updating package indexes for preset %s...", config.Preset)
+
+	if err := env.Update(); err != nil {
+		return err
+	}
+
+	run.aptEnv = env
+
+	return nil
+}
+
+// addHeaders scopes the --header values to the hosts of the private sources and hands
+// a Basic Authorization header to apt as credentials; apt cannot send other headers
+func (run *downloadRun) addHeaders(env *aptenv.Env, sources []aptenv.Source) error {
+	for _, source := range sources {
+		remote.ScopeHeaders(source.URI)
+	}
+
+	for _, repo := range run.extraRepositories {
+		remote.ScopeHeaders(repo.URL)
+	}
+
+	headers := remote.Headers()
+	login, password, basic := basicCredentials(headers.Get("Authorization"))
+
+	for name := range headers {
+		if name != "Authorization" || !basic {
+			output.Warning("apt cannot send the %s header; apt-resolved downloads go without it", name)
+		}
+	}
+
+	if !basic {
+		return nil
+	}
+
+	for i, host := range remote.HeaderHosts() {
+		if err := env.AddCredentials(fmt.Sprintf("80header-%d", i), host, login, password); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// basicCredentials returns the login and password of a Basic Authorization header
+func basicCredentials(header string) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(header, " ")
+
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+// contains reports whether values includes value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// archiveComponents returns the components of the vendor archive; Debian releases
+// before bookworm have no non-free-firmware
+func (run *downloadRun) archiveComponents(distribution string) []string {
+	vendor := run.distroVendor(distribution)
+	components := distroProfiles[vendor].Components
+
+	if vendor != "debian" || !debianWithoutFirmware[distribution] {
+		return components
+	}
+
+	var published []string
+
+	for _, component := range components {
+		if component != "non-free-firmware" {
+			published = append(published, component)
+		}
+	}
+
+	return published
+}
+
+// sourceSignedBy returns the keyrings a private source trusts: those the host's own
+// source for the same URI and suite is Signed-By, else fallback
+func sourceSignedBy(config *config.Config, uri, suite, fallback string) (string, error) {
+	keyrings, err := hostSourceKeyrings(uri, suite, filepath.Join(config.RepoPath, aptDir, "keyrings"))
+
+	if err != nil || len(keyrings) == 0 {
+		return fallback, err
+	}
+
+	return strings.Join(keyrings, ","), nil
+}
+
+// archiveKeyring returns the host's copy of the vendor archive keyring, or empty
+// to fall back to the keys trusted by the host's apt
+func (run *downloadRun) archiveKeyring(distribution string) string {
+	return run.vendorKeyring(run.distroVendor(distribution))
+}
+
+// vendorKeyring returns the host's copy of a vendor's archive keyring, else the key
+// fetched for it, or empty
+func (run *downloadRun) vendorKeyring(vendor string) string {
+	if keyring, ok := run.fetchedKeyrings[vendor]; ok {
+		return keyring
+	}
+
+	path := filepath.Join("/usr/share/keyrings", distroProfiles[vendor].Keyring)
+
+	if _, err := os.Stat(path); err != nil || distroProfiles[vendor].Keyring == "" {
+		return ""
+	}
+
+	return path
+}
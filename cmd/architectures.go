@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+)
+
+// parseArchitectures splits --arch amd64,arm64,i386 into the primary architecture
+// and the additional ones resolved independently next to it
+func parseArchitectures(config *config.Config) error {
+	architectures := strings.Split(config.Architecture, ",")
+
+	if len(architectures) == 1 {
+		return nil
+	}
+
+	for i, architecture := range architectures {
+		if architecture == "" || contains(architectures[:i], architecture) {
+			return fmt.Errorf("invalid --arch %q (expected distinct architectures, e.g. amd64,arm64)", config.Architecture)
+		}
+
+		if contains(config.ForeignArchitectures, architecture) {
+			return fmt.Errorf("%s is both a target architecture and a --foreign-archs architecture", architecture)
+		}
+	}
+
+	config.Architecture, config.AdditionalArchitectures = architectures[0], architectures[1:]
+
+	return nil
+}
+
+// qualifiedArchitectures returns the architectures whose packages download mode names
+// name:arch: the foreign ones and the additional targets
+func qualifiedArchitectures(config *config.Config) []string {
+	return append(append([]string{}, config.ForeignArchitectures...), config.AdditionalArchitectures...)
+}
+
+// targetArchitectures returns every architecture with an index tree in the repository
+func targetArchitectures(config *config.Config) []string {
+	return append([]string{config.Architecture}, qualifiedArchitectures(config)...)
+}
+
+// withAdditionalArchitectures requests each package given by its bare name for the
+// additional architectures as well, as name:arch
+func withAdditionalArchitectures(config *config.Config, packages []string) []string {
+	var qualified []string
+
+	for _, architecture := range config.AdditionalArchitectures {
+		for _, pkg := range packages {
+			if !strings.Contains(pkg, ":") {
+				qualified = append(qualified, pkg+":"+architecture)
+			}
+		}
+	}
+
+	return appendMissing(packages, qualified)
+}
+
+// architectureMirrors groups the target architectures by the archive serving them, in
+// order. --mirror and the host's mirror serve all of them; Ubuntu's default archives
+// leave all but amd64 and i386 to ports.
+func (run *downloadRun) architectureMirrors(config *config.Config) ([]string, map[string][]string) {
+	var mirrors []string
+	byMirror := make(map[string][]string)
+
+	for _, architecture := range targetArchitectures(config) {
+		mirror := normalizeMirror(config.Mirror)
+
+		if mirror == "" {
+			mirror = run.defaultMirror(config.Distribution, architecture)
+		}
+
+		if _, ok := byMirror[mirror]; !ok {
+			mirrors = append(mirrors, mirror)
+		}
+
+		byMirror[mirror] = append(byMirror[mirror], architecture)
+	}
+
+	return mirrors, byMirror
+}
+
+// qualifyFor names a dependency apt listed for a target of an additional architecture
+// as download mode does: that architecture's packages as name:arch, the primary's bare
+func qualifyFor(dependency, architecture string, config *config.Config) string {
+	name, qualifier, found := strings.Cut(dependency, ":")
+
+	switch {
+	case !found || qualifier == "any" || qualifier == architecture:
+		return name + ":" + architecture
+	case qualifier == config.Architecture:
+		return name
+	default:
+		return dependency
+	}
+}
+
+// architectureIndependent returns which of names apt's candidate for a target of
+// architecture builds as Architecture: all. apt knows those only without a qualifier.
+func (run *downloadRun) architectureIndependent(names []string, architecture string) map[string]bool {
+	independent := make(map[string]bool)
+
+	if len(names) == 0 {
+		return independent
+	}
+
+	args := append([]string{"-o", "APT::Architecture=" + architecture, "show", "--no-all-versions"}, names...)
+
+	// apt-cache fails when any name is virtual, but still shows the others
+	out, _ := run.aptCommand("apt-cache", args...).Output()
+	paragraphs, _ := deb822.Parse(bytes.NewReader(out))
+
+	for _, paragraph := range paragraphs {
+		if paragraph["Architecture"] == "all" {
+			independent[paragraph["Package"]] = true
+		}
+	}
+
+	return independent
+}
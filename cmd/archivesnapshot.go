@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Snapshot services republishing the vendor archives as they were at any point in time
+const (
+	debianSnapshot = "https://snapshot.debian.org/archive"
+	ubuntuSnapshot = "https://snapshot.ubuntu.com"
+)
+
+// snapshotArchives names each vendor archive on its snapshot service. Ubuntu's
+// snapshots of the primary archive carry its security pocket too.
+var snapshotArchives = map[string]string{
+	debianArchive:  debianSnapshot + "/debian",
+	debianSecurity: debianSnapshot + "/debian-security",
+	ubuntuArchive:  ubuntuSnapshot + "/ubuntu",
+	ubuntuPorts:    ubuntuSnapshot + "/ubuntu-ports",
+	ubuntuSecurity: ubuntuSnapshot + "/ubuntu",
+}
+
+// atSnapshot returns a vendor archive's URL at --snapshot, or the archive itself without one
+func (run *downloadRun) atSnapshot(archive string) string {
+	if run.archiveSnapshot.IsZero() {
+		return archive
+	}
+
+	return snapshotArchives[archive] + "/" + run.archiveSnapshot.UTC().Format("20060102T150405Z")
+}
+
+// snapshotVendor returns the vendor whose archive a snapshot service URL serves, or
+// empty for any other URL
+func snapshotVendor(uri string) string {
+	switch {
+	case strings.HasPrefix(uri, debianSnapshot+"/"):
+		return "debian"
+	case strings.HasPrefix(uri, ubuntuSnapshot+"/"):
+		return "ubuntu"
+	default:
+		return ""
+	}
+}
+
+// ParseSnapshotTime parses the --snapshot time of download mode, e.g. 2024-03-01T00:00:00Z
+func ParseSnapshotTime(value string) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --snapshot %q (download mode expects a time such as 2024-03-01T00:00:00Z)", value)
+	}
+
+	if at.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("--snapshot %s is in the future", value)
+	}
+
+	return at, nil
+}
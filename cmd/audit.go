@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/advisory"
+	"portaptable/pkg/config"
+	"portaptable/pkg/debfile"
+	"portaptable/pkg/manifest"
+)
+
+// advisoriesDir is the repository directory holding bundled advisory datasets
+const advisoriesDir = "advisories"
+
+// stringList collects a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
+
+// RunAuditCommand reports repository packages affected by known vulnerabilities.
+// "audit import FILE..." bundles advisory datasets into the repository first.
+func RunAuditCommand(args []string) error {
+	var cfg config.Config
+	var dataFiles stringList
+	var jsonOutput, failOnFindings bool
+
+	if len(args) > 0 && args[0] == "import" {
+		fs := newFlagSet("audit import", &cfg)
+		fs.Parse(args[1:])
+
+		return importAdvisories(cfg.RepoPath, fs.Args())
+	}
+
+	fs := newFlagSet("audit", &cfg)
+	fs.Var(&dataFiles, "data", "Additional advisory dataset (repeatable)")
+	fs.BoolVar(&jsonOutput, "json", false, "Print findings as JSON")
+	fs.BoolVar(&failOnFindings, "fail", false, "Exit with an error when vulnerable packages are found")
+	fs.Parse(args)
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	// Bundled datasets travel with the repository across the air gap
+	bundled, _ := filepath.Glob(filepath.Join(cfg.RepoPath, advisoriesDir, "*.json"))
+	dataFiles = append(bundled, dataFiles...)
+
+	if len(dataFiles) == 0 {
+		return fmt.Errorf("no advisory data found; add some with 'audit import FILE'")
+	}
+
+	var advisories []advisory.Advisory
+
+	for _, file := range dataFiles {
+		loaded, err := advisory.Load(file)
+
+		if err != nil {
+			return err
+		}
+
+		advisories = append(advisories, loaded...)
+	}
+
+	fillSourceNames(cfg.RepoPath, mfest)
+
+	findings := advisory.Audit(advisories, mfest.Distribution, mfest.Packages)
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(findings); err != nil {
+			return err
+		}
+	} else {
+		printFindings(findings, len(advisories))
+	}
+
+	if failOnFindings && len(findings) > 0 {
+		return fmt.Errorf("%d vulnerable package versions found", len(findings))
+	}
+
+	return nil
+}
+
+func importAdvisories(repoPath string, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no advisory files specified")
+	}
+
+	dir := filepath.Join(repoPath, advisoriesDir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create advisories directory: %w", err)
+	}
+
+	for _, file := range files {
+		// Parse before copying so broken datasets never enter the repository
+		loaded, err := advisory.Load(file)
+
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(file)
+
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		target := filepath.Join(dir, filepath.Base(file))
+
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+
+		fmt.Printf("Imported %d advisories from %s\n", len(loaded), file)
+	}
+
+	return nil
+}
+
+// fillSourceNames reads source package names from pool files for manifests
+// written before the source was recorded
+func fillSourceNames(repoPath string, mfest *manifest.Manifest) {
+	poolPath := filepath.Join(repoPath, "pool")
+
+	for i := range mfest.Packages {
+		pkg := &mfest.Packages[i]
+
+		if !pkg.Downloaded || pkg.Source != "" {
+			continue
+		}
+
+		if control, err := debfile.Control(filepath.Join(poolPath, pkg.Filename)); err == nil {
+			pkg.Source, pkg.SourceVersion = debfile.SourceName(control)
+		}
+	}
+
+	return
+}
+
+func printFindings(findings []advisory.Finding, total int) {
+	if len(findings) == 0 {
+		fmt.Printf("No known vulnerabilities (checked against %d advisories)\n", total)
+
+		return
+	}
+
+	for _, finding := range findings {
+		fixed := "no fix available"
+
+		if finding.Advisory.FixedVersion != "" {
+			fixed = "fixed in " + finding.Advisory.FixedVersion
+		}
+
+		severity := ""
+
+		if finding.Advisory.Severity != "" {
+			severity = fmt.Sprintf(" [%s]", finding.Advisory.Severity)
+		}
+
+		fmt.Printf("%s %s: %s%s, %s\n", finding.Package, finding.Version, finding.Advisory.ID, severity, fixed)
+	}
+
+	fmt.Printf("\n%d vulnerable package versions found (checked against %d advisories)\n", len(findings), total)
+
+	return
+}
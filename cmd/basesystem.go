@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"sort"
+
+	"portaptable/pkg/output"
+)
+
+// baseSystemExtras are what debootstrap's minbase variant installs besides the
+// Essential and required packages
+var baseSystemExtras = []string{"apt"}
+
+// addBaseSystem adds the packages debootstrap's minbase variant installs: every
+// Essential and required package and apt, so the repository alone bootstraps a new
+// machine. Their dependencies are resolved with the rest.
+func (run *downloadRun) addBaseSystem(packages []string) ([]string, error) {
+	records, err := run.candidateRecords()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var base []string
+	available := make(map[string]bool)
+
+	for _, record := range records {
+		name := record["Package"]
+
+		if (record["Essential"] == "yes" || record["Priority"] == "required") && !available[name] {
+			base = append(base, name)
+		}
+
+		available[name] = true
+	}
+
+	sort.Strings(base)
+	output.Info("Base system: %d Essential and required packages", len(base))
+
+	// An archive lacking apt still bootstraps with dpkg alone
+	for _, extra := range baseSystemExtras {
+		if available[extra] {
+			base = appendMissing(base, []string{extra})
+		}
+	}
+
+	return appendMissing(packages, base), nil
+}
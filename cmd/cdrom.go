@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/repometa"
+	"portaptable/pkg/signing"
+)
+
+// discSizes are the --disc-size names for common media capacities
+var discSizes = map[string]int64{
+	"cd":     700 << 20,
+	"dvd":    4700372992,
+	"dvd-dl": 8543666176,
+	"bd":     25025314816,
+}
+
+// discMetadataReserve is left free on every disc for indexes, Release files and the
+// filesystem's own overhead
+const discMetadataReserve = 32 << 20
+
+// parseDiscSize parses a --disc-size value: a media name or a byte count
+func parseDiscSize(value string) (int64, error) {
+	if size, ok := discSizes[strings.ToLower(value)]; ok {
+		return size, nil
+	}
+
+	size, err := ParseSize(value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid disc size %q (cd, dvd, dvd-dl, bd or a size such as 4G)", value)
+	}
+
+	if size <= discMetadataReserve {
+		return 0, fmt.Errorf("disc size %s leaves no room for packages", formatSize(size))
+	}
+
+	return size, nil
+}
+
+// exportDiscSet writes the repository to dir as a set of disc trees (disc1, disc2, ...)
+// laid out as apt-cdrom expects: each disc carries its share of the pool with its own
+// signed indexes, and .disk/info naming the disc so apt can ask for it by label.
+// It returns the number of discs.
+func exportDiscSet(cfg *config.Config, mfest *manifest.Manifest, dir string, discSize int64) (int, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("%s is not empty", dir)
+	}
+
+	discs, err := packDiscs(cfg.RepoPath, mfest, discSize-discMetadataReserve)
+
+	if err != nil {
+		return 0, err
+	}
+
+	date := time.Now().Format("20060102")
+
+	for i, packages := range discs {
+		number := i + 1
+		discPath := filepath.Join(dir, fmt.Sprintf("disc%d", number))
+		label := fmt.Sprintf("Portaptable %s %s Disc %d/%d (%s)", mfest.Distribution, mfest.Architecture, number, len(discs), date)
+
+		output.Info("Writing %s: %d packages", discPath, len(packages))
+
+		if err := writeDisc(cfg, mfest, discPath, label, number, len(discs), packages); err != nil {
+			return 0, fmt.Errorf("failed to write disc %d: %w", number, err)
+		}
+	}
+
+	return len(discs), nil
+}
+
+// packDiscs splits the downloaded packages into discs of at most capacity bytes,
+// filling each disc in name order before starting the next
+func packDiscs(repoPath string, mfest *manifest.Manifest, capacity int64) ([][]packageinfo.PackageInfo, error) {
+	var packages []packageinfo.PackageInfo
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			packages = append(packages, pkg)
+		}
+	}
+
+	sort.SliceStable(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+
+	var discs [][]packageinfo.PackageInfo
+	var used int64
+
+	for _, pkg := range packages {
+		info, err := os.Stat(filepath.Join(repoPath, "pool", pkg.Filename))
+
+		if err != nil {
+			return nil, fmt.Errorf("missing pool file %s: %w", pkg.Filename, err)
+		}
+
+		if info.Size() > capacity {
+			return nil, fmt.Errorf("%s (%s) does not fit on a disc of %s", pkg.Filename, formatSize(info.Size()), formatSize(capacity+discMetadataReserve))
+		}
+
+		if len(discs) == 0 || used+info.Size() > capacity {
+			discs = append(discs, nil)
+			used = 0
+		}
+
+		discs[len(discs)-1] = append(discs[len(discs)-1], pkg)
+		used += info.Size()
+	}
+
+	if len(discs) == 0 {
+		return nil, fmt.Errorf("the repository has no downloaded packages")
+	}
+
+	return discs, nil
+}
+
+// writeDisc builds one disc tree with its pool files, indexes and identification
+func writeDisc(cfg *config.Config, mfest *manifest.Manifest, discPath, label string, number, total int, packages []packageinfo.PackageInfo) error {
+	if err := os.MkdirAll(filepath.Join(discPath, "pool"), 0755); err != nil {
+		return err
+	}
+
+	for _, pkg := range packages {
+		source := filepath.Join(cfg.RepoPath, "pool", pkg.Filename)
+
+		if err := linkOrCopy(source, filepath.Join(discPath, "pool", pkg.Filename)); err != nil {
+			return fmt.Errorf("failed to stage %s: %w", pkg.Filename, err)
+		}
+	}
+
+	disc := *mfest
+	disc.Packages = packages
+
+	if err := repometa.Generate(discPath, &disc); err != nil {
+		return err
+	}
+
+	// The disc carries only what apt-cdrom reads, not the working state indexing left
+	if err := os.RemoveAll(filepath.Join(discPath, ".cache")); err != nil {
+		return err
+	}
+
+	signed, err := signRepository(discPath, mfest.Distribution, cfg)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(discPath, ".disk"), 0755); err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		".disk/info":         label,
+		"README.diskdefines": discDefines(label, mfest.Architecture, number, total),
+		"README.txt":         discReadme(mfest, number, total, signed),
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(discPath, filepath.FromSlash(name)), []byte(content+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discDefines returns README.diskdefines, the C-style disc description Debian media carry
+func discDefines(label, architecture string, number, total int) string {
+	lines := []string{
+		"#define DISKNAME  " + label,
+		"#define TYPE  binary",
+		"#define TYPEbinary  1",
+		"#define ARCH  " + architecture,
+		fmt.Sprintf("#define ARCH%s  1", architecture),
+		fmt.Sprintf("#define DISKNUM  %d", number),
+		fmt.Sprintf("#define DISKNUM%d  1", number),
+		fmt.Sprintf("#define TOTALNUM  %d", total),
+		fmt.Sprintf("#define TOTALNUM%d  1", total),
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// discReadme returns the instructions printed on every disc
+func discReadme(mfest *manifest.Manifest, number, total int, signed bool) string {
+	var readme strings.Builder
+
+	fmt.Fprintf(&readme, "Portaptable offline repository for %s (%s), disc %d of %d\n\n", mfest.Distribution, mfest.Architecture, number, total)
+
+	if signed {
+		fmt.Fprintf(&readme, "The discs are signed. Before adding the first one, make apt trust the key:\n\n")
+		fmt.Fprintf(&readme, "  sudo cp /media/cdrom/%s /etc/apt/trusted.gpg.d/\n\n", signing.PublicKeyringName)
+	}
+
+	fmt.Fprintf(&readme, "Insert each disc of the set in turn and register it with:\n\n")
+	fmt.Fprintf(&readme, "  sudo apt-cdrom add\n\n")
+	fmt.Fprintf(&readme, "apt then asks for the disc holding a package by its label when installing.")
+
+	return readme.String()
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bufio"
+	"strings"
+
+	"portaptable/pkg/changelog"
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/repometa"
+)
+
+// fetchChangelogs downloads the upstream changelog of every downloaded package's
+// source so `apt changelog` works against the offline repository
+func (run *downloadRun) fetchChangelogs(config *config.Config, mfest *manifest.Manifest) {
+	vendor := run.distroVendor(mfest.Distribution)
+	seen := make(map[string]bool)
+	fetched := 0
+
+	for i := range mfest.Packages {
+		pkg := &mfest.Packages[i]
+
+		if !pkg.Downloaded {
+			continue
+		}
+
+		if pkg.Component == "" {
+			pkg.Component = run.archiveComponent(pkg.Name, pkg.Version)
+		}
+
+		source, version := pkg.Source, pkg.SourceVersion
+
+		if source == "" {
+			source, version = pkg.Name, pkg.Version
+		}
+
+		if seen[source+"_"+version] {
+			continue
+		}
+
+		seen[source+"_"+version] = true
+
+		url := changelog.UpstreamURL(vendor, pkg.Component, source, version)
+		target := changelog.LocalPath(config.RepoPath, repometa.Component, source, version)
+
+		if err := remote.Download(url, target); err != nil {
+			output.Warning("No changelog for %s: %v", source, err)
+
+			continue
+		}
+
+		fetched++
+	}
+
+	output.Info("Fetched %d changelogs", fetched)
+
+	return
+}
+
+// archiveComponent returns the upstream archive component (main, universe, ...) of a
+// package version from its pool Filename, defaulting to main. An empty version
+// selects the candidate version.
+func (run *downloadRun) archiveComponent(name, version string) string {
+	spec := name
+
+	if version != "" {
+		spec += "=" + version
+	}
+
+	var filename string
+
+	if run.nativeIndex != nil {
+		record, _ := run.nativeIndex.record(name)
+		filename = record["Filename"]
+	} else if output, err := run.aptCommand("apt-cache", "show", "--no-all-versions", spec).Output(); err == nil {
+		scanner := bufio.NewScanner(strings.NewReader(string(output)))
+
+		for scanner.Scan() {
+			if value, found := strings.CutPrefix(scanner.Text(), "Filename: "); found {
+				filename = value
+
+				break
+			}
+		}
+	}
+
+	// Filename looks like pool/universe/n/nginx/nginx_1.18.0_amd64.deb
+	if parts := strings.Split(filename, "/"); len(parts) > 2 && parts[0] == "pool" {
+		return parts[1]
+	}
+
+	return "main"
+}
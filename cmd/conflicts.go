@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/output"
+	"portaptable/pkg/relation"
+)
+
+// conflictFields name the relations that keep dpkg from installing two packages together
+var conflictFields = []string{"Conflicts", "Breaks"}
+
+// packageConflict is a resolved package declaring another one it cannot be installed with
+type packageConflict struct {
+	pkg, other string
+	field      string // Conflicts or Breaks
+	relation   relation.Relation
+}
+
+// checkConflicts fails on resolved packages that Conflict with or Break each other,
+// typically two providers of one virtual package pulled in through different
+// requested packages, which the report names. --allow-conflicts downloads both sides.
+func (run *downloadRun) checkConflicts(config *config.Config, requested, packages []string) error {
+	conflicts := run.findConflicts(packages)
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	pulledIn := make(map[string][]string)
+
+	for _, pkg := range requested {
+		closure, err := run.resolveAllDependencies([]string{pkg}, config)
+
+		if err != nil {
+			continue
+		}
+
+		for _, dep := range closure {
+			pulledIn[dep] = append(pulledIn[dep], pkg)
+		}
+	}
+
+	var report strings.Builder
+
+	for _, c := range conflicts {
+		fmt.Fprintf(&report, "\n  %s %s %s", c.pkg, c.field, c.relation)
+
+		if name, _, _ := strings.Cut(c.other, ":"); name != c.relation.Name {
+			fmt.Fprintf(&report, ", which %s provides", c.other)
+		}
+
+		for _, pkg := range []string{c.pkg, c.other} {
+			if by := pulledIn[pkg]; contains(by, pkg) {
+				fmt.Fprintf(&report, "\n    %s is requested", pkg)
+			} else if len(by) > 0 {
+				fmt.Fprintf(&report, "\n    %s is pulled in by %s", pkg, strings.Join(by, ", "))
+			}
+		}
+	}
+
+	if config.AllowConflicts {
+		output.Warning("The resolved packages cannot all be installed together:%s", report.String())
+
+		return nil
+	}
+
+	return fmt.Errorf("the resolved packages cannot all be installed together:%s\n"+
+		"Drop one side with --exclude, choose between providers with --alternative or --prefer-providers, or download both with --allow-conflicts", report.String())
+}
+
+// findConflicts returns the Conflicts and Breaks between the candidates of packages,
+// each pair once. A package conflicting with a name it provides itself, as the
+// providers of a virtual package usually do, does not conflict with itself.
+func (run *downloadRun) findConflicts(packages []string) []packageConflict {
+	records := run.candidateParagraphs(packages)
+	providers := make(map[string][]string)
+
+	for _, pkg := range packages {
+		for _, group := range relation.Parse(records[pkg]["Provides"]) {
+			for _, provided := range group {
+				providers[provided.Name] = append(providers[provided.Name], pkg)
+			}
+		}
+	}
+
+	var conflicts []packageConflict
+	reported := make(map[string]bool)
+
+	for _, pkg := range packages {
+		record, ok := records[pkg]
+
+		if !ok {
+			continue
+		}
+
+		for _, field := range conflictFields {
+			for _, group := range relation.Parse(record[field]) {
+				for _, r := range group {
+					for _, other := range conflictingPackages(r, packages, records, providers) {
+						pair := []string{pkg, other}
+						sort.Strings(pair)
+
+						if name, _, _ := strings.Cut(other, ":"); name == record["Package"] || reported[strings.Join(pair, " ")] {
+							continue
+						}
+
+						reported[strings.Join(pair, " ")] = true
+						conflicts = append(conflicts, packageConflict{pkg: pkg, other: other, field: field, relation: r})
+					}
+				}
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// conflictingPackages returns the packages whose candidates satisfy r, by name or
+// through their Provides
+func conflictingPackages(r relation.Relation, packages []string, records map[string]deb822.Paragraph, providers map[string][]string) []string {
+	var matches []string
+
+	for _, pkg := range packages {
+		if name, _, _ := strings.Cut(pkg, ":"); name == r.Name && r.SatisfiedBy(records[pkg]["Version"]) {
+			matches = append(matches, pkg)
+		}
+	}
+
+	for _, pkg := range providers[r.Name] {
+		for _, group := range relation.Parse(records[pkg]["Provides"]) {
+			for _, provided := range group {
+				if provided.Name == r.Name && r.SatisfiedBy(provided.Version) {
+					matches = appendMissing(matches, []string{pkg})
+				}
+			}
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/contents"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// RunContentsCommand lists the files of repository packages, or with --search finds
+// the packages shipping a path, from the pool files alone (like apt-file, offline)
+func RunContentsCommand(args []string) error {
+	var cfg config.Config
+	var search string
+
+	fs := newFlagSet("contents", &cfg)
+	fs.StringVar(&search, "search", "", "Find the packages shipping this path, file name or glob (e.g. /usr/bin/foo, 'lib*.so.*')")
+	fs.Parse(args)
+
+	if (search == "") == (fs.NArg() == 0) {
+		return fmt.Errorf("usage: contents [OPTIONS] PACKAGE... | contents [OPTIONS] --search PATH")
+	}
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	if search != "" {
+		matches := searchContents(cfg.RepoPath, mfest, search)
+
+		if len(matches) == 0 {
+			return fmt.Errorf("no package in the repository ships %s", search)
+		}
+
+		for _, match := range matches {
+			fmt.Printf("%s: /%s\n", match.pkg.Name, match.file)
+		}
+
+		return nil
+	}
+
+	for _, name := range fs.Args() {
+		found := false
+
+		for _, pkg := range mfest.Packages {
+			if pkg.Name != name || !pkg.Downloaded || pkg.Type == packageinfo.TypeSource {
+				continue
+			}
+
+			found = true
+			files, err := contents.Files(cfg.RepoPath, pkg)
+
+			if err != nil {
+				return fmt.Errorf("failed to list %s: %w", pkg.Filename, err)
+			}
+
+			for _, file := range files {
+				fmt.Printf("%s: /%s\n", pkg.Name, file)
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("package %s is not in the repository", name)
+		}
+	}
+
+	return nil
+}
+
+// contentsMatch is a package file matching a search
+type contentsMatch struct {
+	pkg  packageinfo.PackageInfo
+	file string
+}
+
+// searchContents returns every file of the downloaded packages matching pattern.
+// Packages whose pool file cannot be read are reported and skipped.
+func searchContents(repoPath string, mfest *manifest.Manifest, pattern string) []contentsMatch {
+	var matches []contentsMatch
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded || pkg.Type == packageinfo.TypeSource {
+			continue
+		}
+
+		files, err := contents.Files(repoPath, pkg)
+
+		if err != nil {
+			output.Warning("Skipping %s: %v", pkg.Filename, err)
+
+			continue
+		}
+
+		for _, file := range files {
+			if contents.Match(pattern, file) {
+				matches = append(matches, contentsMatch{pkg: pkg, file: file})
+			}
+		}
+	}
+
+	return matches
+}
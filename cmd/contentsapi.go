@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"portaptable/pkg/contents"
+	"portaptable/pkg/depgraph"
+)
+
+// contentsAPIPath prefixes the package contents endpoints
+const contentsAPIPath = "/api/v1/contents/"
+
+// apiContentsMatch is a file found by a contents search
+type apiContentsMatch struct {
+	Package apiPackage `json:"package"`
+	File    string     `json:"file"`
+}
+
+// handleContents lists the files of a package, or finds the packages shipping a path:
+// GET /api/v1/contents/PACKAGE or GET /api/v1/contents/?path=PATH
+func (s *RepositoryServer) handleContents(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, contentsAPIPath)
+	mfest := s.current()
+
+	if name == "" {
+		pattern := r.URL.Query().Get("path")
+
+		if pattern == "" {
+			writeAPIError(w, http.StatusBadRequest, "a package name or ?path= is required")
+
+			return
+		}
+
+		matches := make([]apiContentsMatch, 0)
+
+		for _, match := range searchContents(s.config.RepoPath, mfest, pattern) {
+			matches = append(matches, apiContentsMatch{Package: newAPIPackage(match.pkg), File: "/" + match.file})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":    pattern,
+			"matches": matches,
+		})
+
+		return
+	}
+
+	pkg, ok := depgraph.New(mfest.Packages, mfest.Architecture).Find(name)
+
+	if !ok || !pkg.Downloaded {
+		writeAPIError(w, http.StatusNotFound, "package "+name+" is not in the repository")
+
+		return
+	}
+
+	files, err := contents.Files(s.config.RepoPath, pkg)
+
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to list "+pkg.Filename+": "+err.Error())
+
+		return
+	}
+
+	paths := make([]string, 0, len(files))
+
+	for _, file := range files {
+		paths = append(paths, "/"+file)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"package": newAPIPackage(pkg),
+		"files":   paths,
+	})
+}
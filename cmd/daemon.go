@@ -0,0 +1,378 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/debsig"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/repolock"
+)
+
+// refreshStatus tracks the daemon's scheduled refreshes for /health
+type refreshStatus struct {
+	mu           sync.Mutex
+	interval     time.Duration
+	running      bool
+	lastStarted  time.Time
+	lastFinished time.Time
+	lastError    string
+	next         time.Time
+}
+
+// snapshot returns the refresh status in its /health form
+func (r *refreshStatus) snapshot() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := map[string]interface{}{
+		"interval":     r.interval.String(),
+		"running":      r.running,
+		"next_refresh": r.next,
+	}
+
+	if !r.lastStarted.IsZero() {
+		status["last_started"] = r.lastStarted
+	}
+
+	if !r.lastFinished.IsZero() {
+		status["last_finished"] = r.lastFinished
+		status["last_error"] = r.lastError
+	}
+
+	return status
+}
+
+func (r *refreshStatus) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.running = true
+	r.lastStarted = time.Now()
+}
+
+func (r *refreshStatus) end(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.running = false
+	r.lastFinished = time.Now()
+	r.lastError = ""
+	r.next = r.lastFinished.Add(r.interval)
+
+	if err != nil {
+		r.lastError = err.Error()
+	}
+}
+
+// RunDaemonCommand serves the repository and re-resolves and refreshes it on a schedule.
+// Packages default to those requested when the repository was last downloaded.
+func RunDaemonCommand(args []string) error {
+	var cfg config.Config
+	var interval time.Duration
+
+	fs := newFlagSet("daemon", &cfg)
+	fs.StringVar(&cfg.Port, "port", config.DefaultPort, "Port to serve the repository on")
+	fs.StringVar(&cfg.DebSignatures, "deb-signatures", debsig.ModeOff, "Verify embedded .deb signatures: off, record or require")
+	fs.BoolVar(&cfg.VerifyPool, "verify-pool", false, "Check each pool file against the manifest's SHA256 before serving it (503 when corrupted)")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 1, "Packages to download in parallel during refreshes")
+	fs.Func("limit-rate", "Cap the combined download throughput of refreshes, e.g. 2M (bytes per second)", func(value string) error {
+		rate, err := ParseSize(value)
+		cfg.LimitRate = rate
+
+		return err
+	})
+	fs.IntVar(&cfg.Retries, "retries", 3, "Times to retry a failed package download during refreshes")
+	fs.DurationVar(&cfg.RetryDelay, "retry-delay", 2*time.Second, "Wait before the first retry, doubled for each further one")
+	fs.DurationVar(&interval, "refresh-interval", 24*time.Hour, "Time between refreshes")
+	fs.Func("conn-rate", "Cap each connection's throughput, e.g. 512K (bytes per second)", func(value string) error {
+		rate, err := ParseSize(value)
+		cfg.ConnectionRate = rate
+
+		return err
+	})
+	fs.StringVar(&cfg.Mirror, "mirror", "", "Archive to refresh from (default: the last download's)")
+	fs.StringVar(&cfg.Resolver, "resolver", "", "Resolve with apt or native (default: the last download's)")
+	fs.StringVar(&cfg.Preset, "preset", "", "Built-in sources selecting distribution and architecture (default: the last download's)")
+	fs.Func("pockets", "Comma-separated pockets to resolve from (default: the last download's)", listFlag(&cfg.Pockets))
+	fs.Func("components", "Comma-separated archive components packages may come from (default: the last download's)", listFlag(&cfg.Components))
+	fs.Func("exclude", "Comma-separated package patterns to drop from the resolved set (default: the last download's)", listFlag(&cfg.Exclude))
+	fs.Parse(args)
+
+	if interval <= 0 {
+		return fmt.Errorf("--refresh-interval must be positive")
+	}
+
+	set := make(map[string]bool)
+
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	packages := fs.Args()
+	mfest, err := manifest.Load(cfg.RepoPath)
+	loaded := err == nil
+
+	if loaded {
+		if err := replayDownloadOptions(&cfg, mfest, set); err != nil {
+			return err
+		}
+
+		if len(packages) == 0 {
+			packages = mfest.Requested
+		}
+	}
+
+	if len(packages) == 0 && !selectsPackages(&cfg) {
+		return fmt.Errorf("no packages given and the repository records none to refresh")
+	}
+
+	if cfg.Preset != "" {
+		if set["dist"] || set["arch"] {
+			return fmt.Errorf("--preset selects the distribution and architecture; drop --dist and --arch")
+		}
+
+		if err := ApplyPreset(&cfg); err != nil {
+			return err
+		}
+	}
+
+	ApplyHostDefaults(&cfg, set["dist"] || cfg.Preset != "" || loaded)
+
+	if err := parseArchitectures(&cfg); err != nil {
+		return err
+	}
+
+	cfg.Packages = packages
+	status := &refreshStatus{interval: interval}
+
+	// A new repository needs one refresh before there is anything to serve
+	if err != nil {
+		output.Info("Repository is empty; running the first refresh...")
+		status.begin()
+		err = RunDownloadMode(&cfg)
+		status.end(err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	server, err := newRepositoryServer(&cfg)
+
+	if err != nil {
+		return err
+	}
+
+	server.refresh = status
+
+	status.mu.Lock()
+	status.next = time.Now().Add(interval)
+	status.mu.Unlock()
+
+	go server.refreshLoop(&cfg, status)
+
+	return server.listen()
+}
+
+// replayDownloadOptions takes what the command line leaves unset from the repository's
+// last download: its distribution, architectures and source options. A repository of
+// another distribution or architecture is refused, as a refresh would replace it.
+func replayDownloadOptions(cfg *config.Config, mfest *manifest.Manifest, set map[string]bool) error {
+	if set["dist"] && cfg.Distribution != mfest.Distribution {
+		return fmt.Errorf("the repository holds %s, not --dist %s", mfest.Distribution, cfg.Distribution)
+	}
+
+	// --arch may list additional architectures after the primary one
+	if primary, _, _ := strings.Cut(cfg.Architecture, ","); set["arch"] && primary != mfest.Architecture {
+		return fmt.Errorf("the repository holds %s packages, not --arch %s", mfest.Architecture, primary)
+	}
+
+	cfg.Distribution = mfest.Distribution
+
+	// A single --arch keeps the additional architectures of the last download
+	if !set["arch"] || cfg.Architecture == mfest.Architecture {
+		cfg.Architecture, cfg.AdditionalArchitectures = mfest.Architecture, mfest.Additional
+	}
+
+	if len(cfg.ForeignArchitectures) == 0 {
+		cfg.ForeignArchitectures = mfest.Foreign
+	}
+
+	if options := mfest.Options; options != nil {
+		if !set["mirror"] {
+			cfg.Mirror = options.Mirror
+		}
+
+		if !set["resolver"] {
+			cfg.Resolver = options.Resolver
+		}
+
+		// The preset chose the distribution and architecture recorded above
+		if !set["preset"] && !set["dist"] && !set["arch"] {
+			cfg.Preset = options.Preset
+		}
+
+		if !set["pockets"] {
+			cfg.Pockets = options.Pockets
+		}
+
+		if !set["components"] {
+			cfg.Components = options.Components
+		}
+
+		if !set["exclude"] {
+			cfg.Exclude = options.Exclude
+		}
+
+		if !set["config"] {
+			cfg.ConfigFile = options.ConfigFile
+		}
+
+		cfg.ESMTokenFile, cfg.ESMServices = options.ESMTokenFile, options.ESMServices
+		cfg.Backports, cfg.BackportsPackages = options.Backports, options.BackportsPackages
+		cfg.PreferencesFile, cfg.StatusFile = options.PreferencesFile, options.StatusFile
+		cfg.PackageRegexes, cfg.Sections, cfg.Priorities = options.PackageRegexes, options.Sections, options.Priorities
+		cfg.BaseSystem, cfg.Languages = options.BaseSystem, options.Languages
+		cfg.DevPackages, cfg.InstallerPackages = options.DevPackages, options.InstallerPackages
+		cfg.DebugSymbols, cfg.Sources, cfg.Changelogs = options.DebugSymbols, options.Sources, options.Changelogs
+		cfg.DeniedLicenses, cfg.StrictLicenses = options.DeniedLicenses, options.StrictLicenses
+		cfg.MinimalRules, cfg.MinimalKeep = options.MinimalRules, options.MinimalKeep
+		cfg.PreferredProviders, cfg.Alternatives = options.PreferredProviders, options.Alternatives
+		cfg.AllowConflicts, cfg.FilterPlugins = options.AllowConflicts, options.FilterPlugins
+		cfg.MaxPackageSize, cfg.MaxTotalSize = options.MaxPackageSize, options.MaxTotalSize
+
+		if options.SizeLimitAction != "" {
+			cfg.SizeLimitAction = options.SizeLimitAction
+		}
+	}
+
+	// The snapshot the last download took the archives at stays fixed
+	if mfest.Snapshot != nil {
+		cfg.ArchiveSnapshot = *mfest.Snapshot
+	}
+
+	return nil
+}
+
+// recordDownloadOptions returns the options of a download for daemon refreshes to
+// replay; files are made absolute, as the daemon may run elsewhere
+func recordDownloadOptions(cfg *config.Config) *manifest.DownloadOptions {
+	return &manifest.DownloadOptions{
+		Mirror:             normalizeMirror(cfg.Mirror),
+		Resolver:           cfg.Resolver,
+		Preset:             cfg.Preset,
+		Pockets:            cfg.Pockets,
+		Components:         cfg.Components,
+		Exclude:            cfg.Exclude,
+		ConfigFile:         absolutePath(cfg.ConfigFile),
+		ESMTokenFile:       absolutePath(cfg.ESMTokenFile),
+		ESMServices:        cfg.ESMServices,
+		Backports:          cfg.Backports,
+		BackportsPackages:  cfg.BackportsPackages,
+		PreferencesFile:    absolutePath(cfg.PreferencesFile),
+		StatusFile:         absolutePath(cfg.StatusFile),
+		PackageRegexes:     cfg.PackageRegexes,
+		Sections:           cfg.Sections,
+		Priorities:         cfg.Priorities,
+		BaseSystem:         cfg.BaseSystem,
+		Languages:          cfg.Languages,
+		DevPackages:        cfg.DevPackages,
+		InstallerPackages:  cfg.InstallerPackages,
+		DebugSymbols:       cfg.DebugSymbols,
+		Sources:            cfg.Sources,
+		Changelogs:         cfg.Changelogs,
+		DeniedLicenses:     cfg.DeniedLicenses,
+		StrictLicenses:     cfg.StrictLicenses,
+		MinimalRules:       cfg.MinimalRules,
+		MinimalKeep:        cfg.MinimalKeep,
+		PreferredProviders: cfg.PreferredProviders,
+		Alternatives:       cfg.Alternatives,
+		AllowConflicts:     cfg.AllowConflicts,
+		FilterPlugins:      cfg.FilterPlugins,
+		MaxPackageSize:     cfg.MaxPackageSize,
+		MaxTotalSize:       cfg.MaxTotalSize,
+		SizeLimitAction:    cfg.SizeLimitAction,
+	}
+}
+
+// absolutePath returns path made absolute; empty stays empty
+func absolutePath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+// selectsPackages reports whether cfg selects anything beyond the requested packages
+func selectsPackages(cfg *config.Config) bool {
+	return len(cfg.BackportsPackages) > 0 || len(cfg.PackageRegexes) > 0 || len(cfg.Sections) > 0 ||
+		len(cfg.Priorities) > 0 || cfg.BaseSystem
+}
+
+// listFlag returns a flag.Func setter splitting a comma-separated list into values
+func listFlag(values *[]string) func(string) error {
+	return func(value string) error {
+		*values = strings.Split(value, ",")
+
+		return nil
+	}
+}
+
+// refreshLoop refreshes the repository every interval and reloads what is served.
+// Refreshes never overlap: the loop runs them one at a time and the repository
+// lock keeps out concurrent --download runs from other processes.
+func (s *RepositoryServer) refreshLoop(cfg *config.Config, status *refreshStatus) {
+	ticker := time.NewTicker(status.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		output.Info("Starting scheduled refresh...")
+		status.begin()
+
+		err := checkRefreshTarget(cfg)
+
+		if err == nil {
+			err = RunDownloadMode(cfg)
+		}
+
+		if errors.Is(err, repolock.ErrLocked) {
+			output.Warning("Skipping refresh: %v", err)
+		} else if err != nil {
+			output.Failure("Refresh failed: %v", err)
+		} else if err = s.loadRepository(); err != nil {
+			output.Failure("Failed to reload repository: %v", err)
+		}
+
+		status.end(err)
+	}
+}
+
+// checkRefreshTarget refuses a refresh when another download has since switched the
+// repository to a different distribution or architecture
+func checkRefreshTarget(cfg *config.Config) error {
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return nil
+	}
+
+	if mfest.Distribution != cfg.Distribution || mfest.Architecture != cfg.Architecture {
+		return fmt.Errorf("the repository now holds %s %s, not %s %s; restart the daemon to follow it",
+			mfest.Distribution, mfest.Architecture, cfg.Distribution, cfg.Architecture)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+)
+
+func TestReplayDownloadOptions(t *testing.T) {
+	snapshot := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	download := config.Config{
+		Distribution:      "bookworm",
+		Architecture:      "amd64",
+		Mirror:            "http://deb.example.org/debian",
+		Pockets:           []string{"release", "updates"},
+		Backports:         true,
+		BackportsPackages: []string{"golang"},
+		StatusFile:        "status",
+		Languages:         []string{"de"},
+		DeniedLicenses:    []string{"AGPL-*"},
+		Sections:          []string{"editors"},
+		DebugSymbols:      true,
+		Sources:           true,
+		InstallerPackages: true,
+		Changelogs:        true,
+		SizeLimitAction:   "skip",
+	}
+
+	mfest := &manifest.Manifest{
+		Distribution: "bookworm",
+		Architecture: "amd64",
+		Snapshot:     &snapshot,
+		Options:      recordDownloadOptions(&download),
+	}
+
+	var refresh config.Config
+
+	if err := replayDownloadOptions(&refresh, mfest, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if abs, _ := filepath.Abs("status"); refresh.StatusFile != abs {
+		t.Errorf("StatusFile = %q, want %q", refresh.StatusFile, abs)
+	}
+
+	if !refresh.ArchiveSnapshot.Equal(snapshot) {
+		t.Errorf("ArchiveSnapshot = %v, want %v", refresh.ArchiveSnapshot, snapshot)
+	}
+
+	refresh.StatusFile, refresh.ArchiveSnapshot = download.StatusFile, time.Time{}
+
+	if !reflect.DeepEqual(refresh, download) {
+		t.Errorf("replayed %+v, want %+v", refresh, download)
+	}
+}
+
+func TestReplayDownloadOptionsKeepsFlags(t *testing.T) {
+	mfest := &manifest.Manifest{
+		Distribution: "jammy",
+		Architecture: "amd64",
+		Options:      &manifest.DownloadOptions{Mirror: "http://old.example.org/ubuntu", Components: []string{"main"}},
+	}
+	refresh := config.Config{Mirror: "http://new.example.org/ubuntu"}
+
+	if err := replayDownloadOptions(&refresh, mfest, map[string]bool{"mirror": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if refresh.Mirror != "http://new.example.org/ubuntu" || !reflect.DeepEqual(refresh.Components, []string{"main"}) {
+		t.Errorf("replayed mirror %q and components %v, want the --mirror flag and main", refresh.Mirror, refresh.Components)
+	}
+
+	if err := replayDownloadOptions(&config.Config{Distribution: "noble"}, mfest, map[string]bool{"dist": true}); err == nil {
+		t.Errorf("replaying a jammy repository for --dist noble succeeded, want an error")
+	}
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/archive"
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+)
+
+// debugArchives maps distribution vendors to the archives publishing -dbgsym packages
+var debugArchives = map[string]string{
+	"ubuntu": "http://ddebs.ubuntu.com",
+	"debian": "http://deb.debian.org/debian-debug",
+}
+
+// debianSecurityDebugArchive publishes the -dbgsym packages of Debian security updates
+const debianSecurityDebugArchive = "http://deb.debian.org/debian-security-debug"
+
+// ubuntuDebugKeyring is where ubuntu-dbgsym-keyring installs the key signing ddebs.ubuntu.com
+const ubuntuDebugKeyring = "/usr/share/keyrings/ubuntu-dbgsym-keyring.gpg"
+
+// debugEntry is a -dbgsym package in a debug archive's indexes
+type debugEntry struct {
+	mirror archive.Mirror
+	entry  deb822.Paragraph
+}
+
+// debugSuites returns the debug archive and suite holding the -dbgsym packages of each
+// configured pocket: Ubuntu's mirror the archive's suites, Debian's append -debug
+func (run *downloadRun) debugSuites(config *config.Config, vendor string) ([][2]string, error) {
+	pockets, err := run.indexPockets(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var suites [][2]string
+
+	for _, pocket := range pockets {
+		uri, suite := debugArchives[vendor], run.pocketSuite(config.Distribution, pocket)
+
+		if vendor == "debian" {
+			suite += "-debug"
+
+			if pocket == pocketSecurity {
+				uri = debianSecurityDebugArchive
+			}
+		}
+
+		suites = append(suites, [2]string{uri, suite})
+	}
+
+	return suites, nil
+}
+
+// debugKey identifies a package version among the debug indexes' entries. Versions
+// compare without their epoch, which apt-get download leaves out of file names (or
+// escapes) and so out of the versions recorded for them.
+func debugKey(name, version, architecture string) string {
+	if unescaped, err := url.PathUnescape(version); err == nil {
+		version = unescaped
+	}
+
+	return name + "_" + archive.StripEpoch(version) + "_" + architecture
+}
+
+// fetchDebugSymbols downloads the -dbgsym package of every downloaded
+// architecture-specific package from the vendor's debug archive, found in its
+// signed indexes and checked against them, and adds it to the manifest. Packages
+// without a -dbgsym package in the archive are listed by name.
+func (run *downloadRun) fetchDebugSymbols(config *config.Config, mfest *manifest.Manifest) {
+	entries, err := run.debugIndex(config, mfest)
+
+	if err != nil {
+		output.Warning("Failed to fetch debug symbols: %v", err)
+
+		return
+	}
+
+	poolPath := filepath.Join(config.RepoPath, "pool")
+	fetched := 0
+	var missing []string
+
+	// Only iterate the packages present before debug packages are appended
+	count := len(mfest.Packages)
+
+	for i := 0; i < count; i++ {
+		pkg := mfest.Packages[i]
+
+		// Architecture-independent packages never have debug symbols
+		if !pkg.Downloaded || pkg.Type != "" || pkg.Architecture == "all" || strings.HasSuffix(pkg.Name, "-dbgsym") {
+			continue
+		}
+
+		debug, ok := entries[debugKey(pkg.Name+"-dbgsym", pkg.Version, pkg.Architecture)]
+
+		if !ok {
+			missing = append(missing, pkg.Name)
+
+			continue
+		}
+
+		info, err := mirrorPackage(debug.mirror, debug.entry, poolPath)
+
+		if err != nil {
+			output.Warning("Failed to download %s-dbgsym: %v", pkg.Name, err)
+
+			continue
+		}
+
+		output.Success("Downloaded %s (%d bytes)", info.Filename, info.Size)
+		mfest.Packages = append(mfest.Packages, info)
+		fetched++
+	}
+
+	output.Info("Fetched %d debug symbol packages", fetched)
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		output.Warning("%d packages have no -dbgsym package in the debug archive: %s", len(missing), strings.Join(missing, ", "))
+	}
+}
+
+// debugIndex returns the -dbgsym packages of the vendor's debug archive for the
+// configured pockets and components and the repository's architectures, by debugKey
+func (run *downloadRun) debugIndex(config *config.Config, mfest *manifest.Manifest) (map[string]debugEntry, error) {
+	vendor := run.distroVendor(mfest.Distribution)
+
+	if debugArchives[vendor] == "" {
+		return nil, fmt.Errorf("%s has no known debug symbol archive", mfest.Distribution)
+	}
+
+	suites, err := run.debugSuites(config, vendor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	components := run.sourceComponents(config)
+	entries := make(map[string]debugEntry)
+
+	// ddebs.ubuntu.com has a signing key of its own
+	var debugKeyrings []string
+
+	if _, err := os.Stat(ubuntuDebugKeyring); vendor == "ubuntu" && err == nil && len(run.archiveKeyrings) == 0 {
+		debugKeyrings = []string{ubuntuDebugKeyring}
+	}
+
+	for _, suite := range suites {
+		mirror := run.newArchiveMirror(suite[0], config.RepoPath)
+
+		if len(debugKeyrings) > 0 {
+			err = run.verifyReleaseWith(&mirror, suite[1], debugKeyrings)
+		} else {
+			err = run.verifyMirrorRelease(&mirror, suite[1], config.RepoPath)
+		}
+
+		// Not every pocket has a debug suite
+		if errors.Is(err, remote.ErrNotFound) {
+			output.Info("%s has no %s suite", suite[0], suite[1])
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		output.Info("Fetching %s debug symbol indexes from %s...", suite[1], suite[0])
+
+		for _, component := range components {
+			for _, architecture := range mfest.Architectures() {
+				index, err := mirror.FetchPackages(suite[1], component, architecture, false)
+
+				if errors.Is(err, remote.ErrNotFound) {
+					continue
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				for _, entry := range index {
+					entries[debugKey(entry["Package"], entry["Version"], entry["Architecture"])] = debugEntry{mirror, entry}
+				}
+			}
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+)
+
+func TestDebugKey(t *testing.T) {
+	tests := []struct {
+		name, version, architecture string
+		want                        string
+	}{
+		{"app-dbgsym", "1.0-1", "amd64", "app-dbgsym_1.0-1_amd64"},
+		{"app-dbgsym", "1:1.0-1", "amd64", "app-dbgsym_1.0-1_amd64"},
+		{"app-dbgsym", "1%3a1.0-1", "arm64", "app-dbgsym_1.0-1_arm64"},
+	}
+
+	for _, test := range tests {
+		if got := debugKey(test.name, test.version, test.architecture); got != test.want {
+			t.Errorf("debugKey(%q, %q, %q) = %q, want %q", test.name, test.version, test.architecture, got, test.want)
+		}
+	}
+}
+
+func TestDebugIndex(t *testing.T) {
+	files := map[string]string{
+		"/dists/jammy/Release":                    "Suite: jammy\n",
+		"/dists/jammy/main/binary-amd64/Packages": "Package: app-dbgsym\nArchitecture: amd64\nVersion: 1:1.0-1\nFilename: pool/main/a/app/app-dbgsym_1.0-1_amd64.ddeb\n",
+	}
+
+	// The updates and security pockets have no debug suites
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	defer func(archive string) { debugArchives["ubuntu"] = archive }(debugArchives["ubuntu"])
+	debugArchives["ubuntu"] = ts.URL
+
+	cfg := config.Config{RepoPath: t.TempDir(), Distribution: "jammy", Components: []string{"main"}, AllowUnauthenticated: true}
+	run := newDownloadRun(&cfg)
+	run.applyTrustPolicy(&cfg)
+
+	entries, err := run.debugIndex(&cfg, &manifest.Manifest{Distribution: "jammy", Architecture: "amd64"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := entries[debugKey("app-dbgsym", "1%3a1.0-1", "amd64")]; !ok || len(entries) != 1 {
+		t.Errorf("debugIndex() = %v, want app-dbgsym 1.0-1", entries)
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/debsig"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// checkPackageSignature records the embedded signature status of a downloaded package.
+// In require mode anything but a valid signature is reported as an error.
+func checkPackageSignature(pkg *packageinfo.PackageInfo, poolPath, mode string) error {
+	status, detail, err := debsig.Verify(filepath.Join(poolPath, pkg.Filename))
+
+	if err != nil {
+		return fmt.Errorf("failed to verify signature of %s: %w", pkg.Filename, err)
+	}
+
+	pkg.Signature = status
+
+	if mode == debsig.ModeRequire && status != debsig.StatusValid {
+		return fmt.Errorf("signature of %s is %s: %s", pkg.Filename, status, detail)
+	}
+
+	return nil
+}
+
+// validateSignatureMode rejects unknown --deb-signatures values
+func validateSignatureMode(mode string) error {
+	if !debsig.ValidMode(mode) {
+		return fmt.Errorf("invalid signature mode %q (expected off, record or require)", mode)
+	}
+
+	return nil
+}
+
+// rejectPackage removes a package file that failed verification from the pool
+func rejectPackage(pkg *packageinfo.PackageInfo, poolPath string, reason error) {
+	if err := os.Remove(filepath.Join(poolPath, pkg.Filename)); err != nil && !os.IsNotExist(err) {
+		output.Warning("Failed to remove %s: %v", pkg.Filename, err)
+	}
+
+	pkg.Downloaded = false
+	pkg.Failure = reason.Error()
+
+	return
+}
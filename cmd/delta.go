@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"portaptable/pkg/bundle"
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/snapshot"
+)
+
+// deltaMarker is the bundle entry identifying a delta bundle and what it builds on
+const deltaMarker = "portaptable-delta.json"
+
+// deltaStagingDir is where import unpacks a delta before applying it
+const deltaStagingDir = ".delta"
+
+// deltaInfo is the content of deltaMarker
+type deltaInfo struct {
+	Base         string    `json:"base"`                 // Snapshot the delta was taken against
+	BaseManifest string    `json:"base_manifest_sha256"` // Of the snapshot's manifest.json
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// deltaOptions returns the bundle options leaving out what the snapshot base already
+// has: its pool files and the snapshots. Metadata is small and always shipped whole.
+// It also counts the packages of mfest the delta ships.
+func deltaOptions(repoPath, base string, mfest *manifest.Manifest, options bundle.Options) (bundle.Options, int, error) {
+	if err := snapshot.ValidName(base); err != nil {
+		return options, 0, err
+	}
+
+	basePath := snapshot.Path(repoPath, base)
+	sums, err := checksum.File(filepath.Join(basePath, manifest.FileName))
+
+	if err != nil {
+		return options, 0, fmt.Errorf("snapshot %s not found: %w", base, err)
+	}
+
+	marker, err := json.MarshalIndent(deltaInfo{Base: base, BaseManifest: sums.SHA256, CreatedAt: time.Now()}, "", "  ")
+
+	if err != nil {
+		return options, 0, err
+	}
+
+	// Pool files are never rewritten, so one of the same name and size is unchanged
+	inBase := func(rel string, size int64) bool {
+		info, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(rel)))
+
+		return err == nil && info.Size() == size
+	}
+
+	options.Files = map[string][]byte{deltaMarker: marker}
+	options.Exclude = func(rel string, info os.FileInfo) bool {
+		if rel == snapshot.Dir {
+			return true
+		}
+
+		return !info.IsDir() && strings.HasPrefix(rel, "pool/") && inBase(rel, info.Size())
+	}
+
+	shipped := 0
+
+	for _, pkg := range mfest.Packages {
+		if info, err := os.Stat(filepath.Join(repoPath, "pool", pkg.Filename)); err == nil && !inBase("pool/"+pkg.Filename, info.Size()) {
+			shipped++
+		}
+	}
+
+	return options, shipped, nil
+}
+
+// readDeltaInfo returns the description of a delta bundle, or nil for a full bundle
+func readDeltaInfo(bundlePath string) (*deltaInfo, error) {
+	data, err := bundle.ReadFile(bundlePath, deltaMarker)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &deltaInfo{}
+
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", deltaMarker, err)
+	}
+
+	return info, nil
+}
+
+// applyDelta applies a delta bundle on top of the repository. The delta is unpacked
+// aside and checked to complete the repository's pool before anything is replaced;
+// the pool grows first and the metadata referencing it is swapped in last.
+func applyDelta(bundlePath, repoPath string, info *deltaInfo) error {
+	current, err := checksum.File(filepath.Join(repoPath, manifest.FileName))
+
+	if err != nil {
+		return fmt.Errorf("a delta bundle applies on top of an existing repository; import a full bundle first: %w", err)
+	}
+
+	if current.SHA256 != info.BaseManifest {
+		output.Warning("The repository's manifest differs from snapshot %s the delta was made against", info.Base)
+	}
+
+	staging := filepath.Join(repoPath, deltaStagingDir)
+	os.RemoveAll(staging)
+
+	defer os.RemoveAll(staging)
+
+	if err := bundle.Extract(bundlePath, staging); err != nil {
+		return err
+	}
+
+	os.Remove(filepath.Join(staging, deltaMarker))
+
+	mfest, err := manifest.Load(staging)
+
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(staging, "pool", pkg.Filename)); err == nil {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(repoPath, "pool", pkg.Filename)); err != nil {
+			missing = append(missing, pkg.Filename)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+
+		return fmt.Errorf("the repository lacks %d pool files the delta builds on (first: %s); it is not at snapshot %s, import a full bundle instead",
+			len(missing), missing[0], info.Base)
+	}
+
+	added := 0
+	stagedPool := filepath.Join(staging, "pool")
+
+	err = filepath.Walk(stagedPool, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil || fileInfo.IsDir() {
+			return err
+		}
+
+		rel, _ := filepath.Rel(stagedPool, path)
+		target := filepath.Join(repoPath, "pool", rel)
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		added++
+
+		return os.Rename(path, target)
+	})
+
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to add pool files: %w", err)
+	}
+
+	// Replace the metadata, the manifest last, once every file it references is in the pool
+	entries, err := os.ReadDir(staging)
+
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() != manifest.FileName && entries[j].Name() == manifest.FileName
+	})
+
+	for _, entry := range entries {
+		if entry.Name() == "pool" {
+			continue
+		}
+
+		target := filepath.Join(repoPath, entry.Name())
+
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", entry.Name(), err)
+		}
+
+		if err := os.Rename(filepath.Join(staging, entry.Name()), target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", entry.Name(), err)
+		}
+	}
+
+	fmt.Printf("Applied delta since snapshot %s: %d new pool files\n", info.Base, added)
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"portaptable/pkg/depgraph"
+	"portaptable/pkg/packageinfo"
+)
+
+// dependsAPIPath and rdependsAPIPath prefix the dependency query endpoints
+const (
+	dependsAPIPath  = "/api/v1/depends/"
+	rdependsAPIPath = "/api/v1/rdepends/"
+)
+
+// apiPackage is a package in dependency API responses
+type apiPackage struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Architecture  string `json:"architecture"`
+	Filename      string `json:"filename"`
+	Size          int64  `json:"size"`
+	InstalledSize string `json:"installed_size,omitempty"` // KiB, from Installed-Size
+}
+
+func newAPIPackage(pkg packageinfo.PackageInfo) apiPackage {
+	return apiPackage{
+		Name:          pkg.Name,
+		Version:       pkg.Version,
+		Architecture:  pkg.Architecture,
+		Filename:      pkg.Filename,
+		Size:          pkg.Size,
+		InstalledSize: pkg.Control["Installed-Size"],
+	}
+}
+
+// dependencyFields returns the fields a query follows: the hard dependencies, and
+// Recommends with ?recommends=1 (apt installs them by default)
+func dependencyFields(r *http.Request) []string {
+	if r.URL.Query().Get("recommends") == "1" {
+		return append(append([]string{}, depgraph.Hard...), "Recommends")
+	}
+
+	return depgraph.Hard
+}
+
+// handleDepends answers what installing a package from the repository pulls in:
+// GET /api/v1/depends/PACKAGE[?recommends=1]
+func (s *RepositoryServer) handleDepends(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, dependsAPIPath)
+	mfest := s.current()
+	graph := depgraph.New(mfest.Packages, mfest.Architecture)
+
+	closure, missing, ok := graph.Closure(name, dependencyFields(r))
+
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "package "+name+" is not in the repository")
+
+		return
+	}
+
+	packages := make([]apiPackage, 0, len(closure))
+	var downloadSize int64
+
+	for _, pkg := range closure {
+		packages = append(packages, newAPIPackage(pkg))
+		downloadSize += pkg.Size
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"package":       packages[0],
+		"depends":       packages[1:],
+		"missing":       missing,
+		"download_size": downloadSize,
+	})
+}
+
+// handleRdepends answers which repository packages depend on a package:
+// GET /api/v1/rdepends/PACKAGE[?recursive=1][&recommends=1]
+func (s *RepositoryServer) handleRdepends(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, rdependsAPIPath)
+	mfest := s.current()
+	graph := depgraph.New(mfest.Packages, mfest.Architecture)
+
+	target, ok := graph.Find(name)
+
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "package "+name+" is not in the repository")
+
+		return
+	}
+
+	reverse := graph.ReverseDepends(name, dependencyFields(r), r.URL.Query().Get("recursive") == "1")
+	packages := make([]apiPackage, 0, len(reverse))
+
+	for _, pkg := range reverse {
+		packages = append(packages, newAPIPackage(pkg))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"package":   newAPIPackage(target),
+		"rdepends":  packages,
+		"recursive": r.URL.Query().Get("recursive") == "1",
+	})
+}
+
+// writeAPIError writes a JSON error response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/bundle"
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/signing"
+)
+
+// deployScript runs on the target: it unpacks the subset repository from stdin into a
+// temporary directory, installs from it with a private source list and removes it again.
+// apt's own sources and lists are never touched.
+const deployScript = `set -e
+SUDO=; [ "$(id -u)" = 0 ] || SUDO="sudo -n"
+DIR=$(mktemp -d /tmp/portaptable-deploy.XXXXXX)
+trap 'rm -rf "$DIR"' EXIT
+tar -xzf - -C "$DIR"
+chmod 755 "$DIR"
+mkdir -p "$DIR/lists/partial"
+echo "deb [%[1]s] file:$DIR %[2]s main" > "$DIR/sources.list"
+set -- -o Dir::Etc::SourceList="$DIR/sources.list" -o Dir::Etc::SourceParts=- -o Dir::State::Lists="$DIR/lists"
+$SUDO apt-get "$@" update
+$SUDO env DEBIAN_FRONTEND=noninteractive apt-get "$@" install -y %[3]s
+`
+
+// RunDeployCommand installs packages on a remote machine over SSH, shipping only the
+// repository files its current package state is missing
+func RunDeployCommand(args []string) error {
+	var cfg config.Config
+	var identity string
+	var port int
+
+	fs := newFlagSet("deploy", &cfg)
+	fs.StringVar(&identity, "identity", "", "SSH private key file")
+	fs.IntVar(&port, "ssh-port", 0, "SSH port (default: from the SSH configuration)")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: deploy [OPTIONS] USER@HOST PACKAGE...")
+	}
+
+	target, packages := fs.Arg(0), fs.Args()[1:]
+	sshArgs := []string{}
+
+	if identity != "" {
+		sshArgs = append(sshArgs, "-i", identity)
+	}
+
+	if port != 0 {
+		sshArgs = append(sshArgs, "-p", strconv.Itoa(port))
+	}
+
+	sshArgs = append(sshArgs, target)
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	work, err := os.MkdirTemp("", "portaptable-deploy-")
+
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	defer os.RemoveAll(work)
+
+	output.Info("Reading package state of %s...", target)
+
+	statusFile := filepath.Join(work, "status")
+
+	if err := fetchTargetStatus(sshArgs, mfest.Architecture, statusFile); err != nil {
+		return err
+	}
+
+	env, cleanup, err := repositoryAptEnv(cfg.RepoPath, mfest, statusFile)
+
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	out, err := env.Command("apt-get", append([]string{"install", "--simulate", "-y"}, packages...)...).CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("apt cannot install %s on %s: %s", strings.Join(packages, " "), target, strings.TrimSpace(string(out)))
+	}
+
+	subset := &manifest.Manifest{
+		CreatedAt:    mfest.CreatedAt,
+		Architecture: mfest.Architecture,
+		Distribution: mfest.Distribution,
+		Requested:    packages,
+	}
+
+	if err := buildSubset(cfg.RepoPath, filepath.Join(work, "repo"), mfest, subset, parseInstallSteps(string(out))); err != nil {
+		return err
+	}
+
+	if len(subset.Packages) == 0 {
+		output.Info("Nothing to install; %s already has everything requested", target)
+
+		return nil
+	}
+
+	if err := publishMetadata(filepath.Join(work, "repo"), subset, &cfg); err != nil {
+		return fmt.Errorf("failed to generate metadata for the subset: %w", err)
+	}
+
+	bundlePath := filepath.Join(work, "deploy.tar.gz")
+
+	if err := bundle.Create(filepath.Join(work, "repo"), bundlePath); err != nil {
+		return err
+	}
+
+	sourceOption := "trusted=yes"
+
+	if isSigned(filepath.Join(work, "repo"), subset.Distribution) {
+		sourceOption, err = deploySignedBy(sshArgs, filepath.Join(work, "repo"), filepath.Join(work, "target"))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	quoted := make([]string, len(packages))
+
+	for i, pkg := range packages {
+		quoted[i] = shellQuote(pkg)
+	}
+
+	script := fmt.Sprintf(deployScript, sourceOption, subset.Distribution, strings.Join(quoted, " "))
+
+	stat, _ := os.Stat(bundlePath)
+	output.Info("Sending %d packages (%s) to %s...", len(subset.Packages), formatSize(stat.Size()), target)
+
+	file, err := os.Open(bundlePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	cmd := exec.Command("ssh", append(sshArgs, "sh -c "+shellQuote(script))...)
+	cmd.Stdin = file
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("remote install on %s failed: %w", target, err)
+	}
+
+	output.Success("Installed %s on %s", strings.Join(packages, " "), target)
+
+	return nil
+}
+
+// deploySignedBy returns the source option verifying the subset repository on the target:
+// the target's own keyring when it already trusts the repository key, else the shipped copy
+func deploySignedBy(sshArgs []string, repoPath, targetRoot string) (string, error) {
+	shipped := "signed-by=$DIR/" + signing.PublicKeyringName
+
+	if err := fetchTargetApt(sshArgs, targetRoot); err != nil {
+		return "", err
+	}
+
+	trusted, err := targetTrustedKeys(targetRoot)
+
+	if err != nil {
+		return "", err
+	}
+
+	fingerprints, err := repositoryKeys(repoPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	if keyring := trustedKeyring(trusted, fingerprints); keyring != "" {
+		output.Info("Target already trusts the repository key in %s", keyring)
+
+		return "signed-by=" + keyring, nil
+	}
+
+	output.Info("Target does not trust the repository key; sending it with the packages")
+
+	return shipped, nil
+}
+
+// fetchTargetStatus copies the target's dpkg status to statusFile after checking its architecture
+func fetchTargetStatus(sshArgs []string, architecture, statusFile string) error {
+	out, err := exec.Command("ssh", append(sshArgs, "dpkg --print-architecture && cat /var/lib/dpkg/status")...).Output()
+
+	if err != nil {
+		return fmt.Errorf("failed to read the target's package state: %w", err)
+	}
+
+	arch, status, _ := strings.Cut(string(out), "\n")
+
+	if arch != architecture {
+		return fmt.Errorf("target architecture %s does not match the repository (%s)", arch, architecture)
+	}
+
+	return os.WriteFile(statusFile, []byte(status), 0644)
+}
+
+// buildSubset links the pool files of the simulated install steps into a new repository at dir
+func buildSubset(repoPath, dir string, mfest, subset *manifest.Manifest, steps []installStep) error {
+	available := make(map[string]packageinfo.PackageInfo)
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded && pkg.Type != packageinfo.TypeSource {
+			available[pkg.Name+"="+pkg.Version] = pkg
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "pool"), 0755); err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if step.action != "Inst" {
+			continue
+		}
+
+		pkg, ok := available[step.name+"="+step.version]
+
+		if !ok {
+			return fmt.Errorf("%s %s is not in the repository pool", step.name, step.version)
+		}
+
+		source := filepath.Join(repoPath, "pool", pkg.Filename)
+		target := filepath.Join(dir, "pool", pkg.Filename)
+
+		if err := linkOrCopy(source, target); err != nil {
+			return fmt.Errorf("failed to stage %s: %w", pkg.Filename, err)
+		}
+
+		subset.Packages = append(subset.Packages, pkg)
+	}
+
+	return nil
+}
+
+// linkOrCopy hard-links source to target, copying when they are on different filesystems
+func linkOrCopy(source, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(target)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	return out.Close()
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
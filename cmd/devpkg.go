@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+
+	"portaptable/pkg/output"
+)
+
+// sonameSuffix matches the version/ABI suffix of library package names (libssl3, libfoo1.2t64)
+var sonameSuffix = regexp.MustCompile(`[-.0-9]*(t64)?$`)
+
+// addDevPackages returns packages extended with the -dev companion of every library among them
+func (run *downloadRun) addDevPackages(packages []string) []string {
+	result := append([]string{}, packages...)
+	seen := make(map[string]bool)
+
+	for _, pkg := range packages {
+		seen[pkg] = true
+	}
+
+	for _, pkg := range packages {
+		if !strings.HasPrefix(pkg, "lib") || strings.HasSuffix(pkg, "-dev") {
+			continue
+		}
+
+		dev := run.devPackageFor(pkg)
+
+		if dev == "" {
+			output.Warning("No -dev package found for %s", pkg)
+
+			continue
+		}
+
+		if !seen[dev] {
+			output.Info("Adding %s for %s", dev, pkg)
+			result = append(result, dev)
+			seen[dev] = true
+		}
+	}
+
+	return result
+}
+
+// devPackageFor finds the -dev package depending on a library, matching its name stem
+// so that e.g. libssl3 maps to libssl-dev rather than an unrelated consumer
+func (run *downloadRun) devPackageFor(library string) string {
+	var candidates []string
+
+	if run.nativeIndex != nil {
+		candidates = run.nativeIndex.reverseDepends(library)
+	} else {
+		cmd := run.aptCommand("apt-cache", "rdepends", "--no-recommends", "--no-suggests",
+			"--no-conflicts", "--no-breaks", "--no-replaces", "--no-enhances", library)
+
+		out, err := cmd.Output()
+
+		if err != nil {
+			return ""
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(string(out)))
+
+		for scanner.Scan() {
+			candidates = append(candidates, strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "|"))
+		}
+	}
+
+	stem := sonameSuffix.ReplaceAllString(library, "")
+
+	for _, candidate := range candidates {
+		if !strings.HasSuffix(candidate, "-dev") {
+			continue
+		}
+
+		if sonameSuffix.ReplaceAllString(strings.TrimSuffix(candidate, "-dev"), "") == stem {
+			return candidate
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+)
+
+// Vendor archives used when neither --mirror nor the host's sources name one
+const (
+	debianArchive    = "http://deb.debian.org/debian"
+	debianSecurity   = "http://security.debian.org/debian-security"
+	ubuntuArchive    = "http://archive.ubuntu.com/ubuntu"
+	ubuntuPorts      = "http://ports.ubuntu.com/ubuntu-ports"
+	ubuntuSecurity   = "http://security.ubuntu.com/ubuntu"
+	linuxMintArchive = "http://packages.linuxmint.com"
+)
+
+// linuxMintKeyURL serves the key signing packages.linuxmint.com, which the
+// linuxmint-keyring package installs
+const linuxMintKeyURL = "https://keyserver.ubuntu.com/pks/lookup?op=get&options=mr&search=0xA6616109451BBBF2"
+
+// distroProfile describes where a distribution publishes its packages
+type distroProfile struct {
+	Archive    string
+	Ports      string // Archive of the architectures other than amd64 and i386, when separate
+	Keyring    string // Archive keyring in /usr/share/keyrings, from the vendor's keyring package
+	KeyURL     string // Where to fetch the key when the host lacks the keyring
+	Components []string
+	Pockets    []string // Pockets published besides the release pocket
+}
+
+// distroProfiles maps vendors to their archives. Debian and Raspbian share codenames,
+// so Raspbian is only selected by the --config file's "distro".
+var distroProfiles = map[string]distroProfile{
+	"debian": {Archive: debianArchive, Keyring: "debian-archive-keyring.gpg",
+		Components: []string{"main", "contrib", "non-free", "non-free-firmware"}, Pockets: []string{pocketUpdates, pocketSecurity}},
+	"ubuntu": {Archive: ubuntuArchive, Ports: ubuntuPorts, Keyring: "ubuntu-archive-keyring.gpg",
+		Components: []string{"main", "restricted", "universe", "multiverse"}, Pockets: []string{pocketUpdates, pocketSecurity}},
+	"raspbian": {Archive: raspbianURI, Keyring: "raspbian-archive-keyring.gpg", KeyURL: raspbianKeyURL,
+		Components: []string{"main", "contrib", "non-free", "rpi"}},
+}
+
+// debianReleases lists Debian codenames
+var debianReleases = map[string]bool{
+	"buster": true, "bullseye": true, "bookworm": true, "trixie": true, "forky": true, "duke": true, "sid": true,
+}
+
+// debianWithoutFirmware are the Debian releases from before non-free-firmware was split
+// out of non-free, in bookworm
+var debianWithoutFirmware = map[string]bool{"buster": true, "bullseye": true}
+
+// ubuntuReleases lists Ubuntu codenames; a distribution in neither list needs the
+// --config file's "distro" to name its vendor
+var ubuntuReleases = map[string]bool{
+	"trusty": true, "xenial": true, "bionic": true, "focal": true, "jammy": true, "lunar": true, "mantic": true,
+	"noble": true, "oracular": true, "plucky": true, "questing": true, "resolute": true, "devel": true,
+}
+
+// debianAliases are Debian's suites named for their role, which follow its releases
+var debianAliases = map[string]bool{"oldstable": true, "stable": true, "testing": true, "unstable": true}
+
+// debianRolling are the Debian suites without updates or security pockets
+var debianRolling = map[string]bool{"sid": true, "unstable": true}
+
+// linuxMintReleases maps Linux Mint and LMDE codenames to the Ubuntu or Debian release
+// they build on. packages.linuxmint.com only carries Mint's own packages; the rest
+// comes from the base release's archive.
+var linuxMintReleases = map[string]string{
+	"ulyana": "focal", "ulyssa": "focal", "uma": "focal", "una": "focal",
+	"vanessa": "jammy", "vera": "jammy", "victoria": "jammy", "virginia": "jammy",
+	"wilma": "noble", "xia": "noble", "zara": "noble",
+	"elsie": "bullseye", "faye": "bookworm", "gigi": "trixie",
+}
+
+// linuxMintComponents are the components of packages.linuxmint.com
+var linuxMintComponents = []string{"main", "upstream", "import", "backport"}
+
+// distroVendor returns the vendor of a distribution codename: "debian", "ubuntu" or
+// the --config file's "distro", else empty for an unknown codename
+func (run *downloadRun) distroVendor(distribution string) string {
+	if vendor, ok := run.distroVendors[distribution]; ok {
+		return vendor
+	}
+
+	switch {
+	case debianReleases[distribution] || debianAliases[distribution]:
+		return "debian"
+	case ubuntuReleases[distribution]:
+		return "ubuntu"
+	default:
+		return ""
+	}
+}
+
+// checkDistribution rejects a codename of no known vendor, rather than guessing at the
+// archive a new release is published in
+func (run *downloadRun) checkDistribution(distribution string) error {
+	if run.distroVendor(distribution) == "" {
+		return fmt.Errorf("unknown distribution %q (expected a Debian or Ubuntu codename, or name its vendor with the --config file's \"distro\")", distribution)
+	}
+
+	return nil
+}
+
+// distroPockets returns the pockets resolved by default: those of a stock installation
+// that the distribution publishes
+func (run *downloadRun) distroPockets(distribution string) []string {
+	if debianRolling[distribution] {
+		return []string{pocketRelease}
+	}
+
+	published := distroProfiles[run.distroVendor(distribution)].Pockets
+	pockets := []string{pocketRelease}
+
+	for _, pocket := range defaultPockets {
+		if contains(published, pocket) {
+			pockets = append(pockets, pocket)
+		}
+	}
+
+	return pockets
+}
+
+// applyDistroProfile selects the distribution profile of download mode: the --config
+// file's "distro", else the one --dist names. Linux Mint resolves from the Ubuntu or
+// Debian release it builds on, with packages.linuxmint.com added by prepareRepositories.
+func (run *downloadRun) applyDistroProfile(config *config.Config) error {
+	file, err := readConfigFile(config)
+
+	if err != nil {
+		return err
+	}
+
+	if base, ok := linuxMintReleases[config.Distribution]; ok {
+		if file.Distro != "" && file.Distro != "linuxmint" {
+			return fmt.Errorf("%s is a Linux Mint release, not %s", config.Distribution, file.Distro)
+		}
+
+		output.Info("Linux Mint %s builds on %s; resolving from %s and the %s archive", config.Distribution, base, linuxMintArchive, base)
+		run.linuxMintRelease, config.Distribution = config.Distribution, base
+
+		return nil
+	}
+
+	switch file.Distro {
+	case "", "debian", "ubuntu":
+	case "linuxmint":
+		return fmt.Errorf("%s is not a Linux Mint release (expected a codename such as virginia or faye)", config.Distribution)
+	case "raspbian":
+		if run.distroVendor(config.Distribution) != "debian" || debianRolling[config.Distribution] {
+			return fmt.Errorf("Raspbian publishes Debian's stable releases, not %s", config.Distribution)
+		}
+
+		if config.Architecture != "armhf" || len(config.AdditionalArchitectures) > 0 {
+			return fmt.Errorf("Raspbian only publishes armhf; use --arch armhf")
+		}
+	default:
+		return fmt.Errorf("invalid distro %q in %s (expected debian, ubuntu, raspbian or linuxmint)", file.Distro, config.ConfigFile)
+	}
+
+	if file.Distro != "" && file.Distro != run.distroVendor(config.Distribution) {
+		run.distroVendors[config.Distribution] = file.Distro
+	}
+
+	if err := run.checkDistribution(config.Distribution); err != nil {
+		return err
+	}
+
+	if vendor := run.distroVendor(config.Distribution); vendor != "debian" && vendor != "ubuntu" && !config.ArchiveSnapshot.IsZero() {
+		return fmt.Errorf("--snapshot takes Debian and Ubuntu archives only, not %s", vendor)
+	}
+
+	return nil
+}
+
+// prepareDistroKeyring fetches the archive key of a vendor whose keyring package the
+// host lacks, such as Raspbian's on a Debian host
+func (run *downloadRun) prepareDistroKeyring(config *config.Config) {
+	vendor := run.distroVendor(config.Distribution)
+	profile := distroProfiles[vendor]
+
+	if profile.KeyURL == "" {
+		return
+	}
+
+	keyring, err := presetKeyring(presetSource{Keyring: profile.Keyring, KeyURL: profile.KeyURL}, filepath.Join(config.RepoPath, aptDir, "keyrings"))
+
+	// --archive-keyring may name the key instead, which verification will tell
+	if err != nil {
+		output.Warning("%v; install %s or name it with --archive-keyring", err, profile.Keyring)
+
+		return
+	}
+
+	run.fetchedKeyrings[vendor] = keyring
+}
+
+// linuxMintArchiveRepository returns packages.linuxmint.com as a --config repository
+// when --dist names a Linux Mint release
+func (run *downloadRun) linuxMintArchiveRepository() []repository {
+	if run.linuxMintRelease == "" {
+		return nil
+	}
+
+	key := linuxMintKeyURL
+
+	if _, err := os.Stat("/usr/share/keyrings/linuxmint-keyring.gpg"); err == nil {
+		key = "/usr/share/keyrings/linuxmint-keyring.gpg"
+	}
+
+	return []repository{{URL: linuxMintArchive, Suite: run.linuxMintRelease, Components: linuxMintComponents, Key: key}}
+}
+
+// defaultMirror returns the host's mirror for distribution, or else the vendor archive
+// serving it for architecture. Ubuntu publishes architectures other than amd64 and
+// i386 on its ports archive.
+func (run *downloadRun) defaultMirror(distribution, architecture string) string {
+	// The host's mirror has no history; --snapshot needs the vendor's snapshot service
+	if mirror := run.hostMirror(distribution, architecture); mirror != "" && run.archiveSnapshot.IsZero() {
+		return mirror
+	}
+
+	profile := distroProfiles[run.distroVendor(distribution)]
+
+	if profile.Ports != "" && architecture != "amd64" && architecture != "i386" {
+		return run.atSnapshot(profile.Ports)
+	}
+
+	return run.atSnapshot(profile.Archive)
+}
+
+// archiveMirror returns the archive a download resolves from: --mirror, else the default
+func (run *downloadRun) archiveMirror(config *config.Config) string {
+	if config.Mirror != "" {
+		return normalizeMirror(config.Mirror)
+	}
+
+	return run.defaultMirror(config.Distribution, config.Architecture)
+}
+
+// securityMirror returns the archive carrying the -security suite. Local mirrors carry
+// it alongside the rest, so offline runs never leave them; Debian publishes it
+// separately; Ubuntu's ports archive and third-party mirrors carry it alongside the rest.
+func (run *downloadRun) securityMirror(distribution, mirror string) string {
+	switch {
+	case isLocalMirror(mirror):
+		return mirror
+	case run.distroVendor(distribution) == "debian":
+		return run.atSnapshot(debianSecurity)
+	case mirror == ubuntuArchive:
+		return ubuntuSecurity
+	default:
+		return mirror
+	}
+}
+
+// normalizeMirror returns mirror with a local mirror, given as a directory or in apt's
+// file:/path form, written as a file:///path URL
+func normalizeMirror(mirror string) string {
+	switch {
+	case mirror == "" || strings.HasPrefix(mirror, "file://"):
+		return mirror
+	case strings.HasPrefix(mirror, "file:"):
+		return "file://" + strings.TrimPrefix(mirror, "file:")
+	case !strings.Contains(mirror, "://"):
+		if path, err := filepath.Abs(mirror); err == nil {
+			return "file://" + filepath.ToSlash(path)
+		}
+	}
+
+	return mirror
+}
+
+// isLocalMirror reports whether a normalized mirror is on the local filesystem
+func isLocalMirror(mirror string) bool {
+	return strings.HasPrefix(mirror, "file://")
+}
+
+// hasSuite reports whether a local mirror carries suite; remote mirrors are assumed to
+func hasSuite(mirror, suite string) bool {
+	if !isLocalMirror(mirror) {
+		return true
+	}
+
+	_, err := os.Stat(filepath.Join(filepath.FromSlash(strings.TrimPrefix(mirror, "file://")), "dists", suite))
+
+	return err == nil
+}
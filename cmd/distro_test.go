@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"portaptable/pkg/config"
+)
+
+func TestDistroVendor(t *testing.T) {
+	run := newDownloadRun(&config.Config{})
+	run.distroVendors["bookworm"] = "raspbian"
+
+	tests := map[string]string{
+		"jammy":    "ubuntu",
+		"noble":    "ubuntu",
+		"trixie":   "debian",
+		"stable":   "debian",
+		"bookworm": "raspbian",
+		"nosuch":   "",
+	}
+
+	for distribution, want := range tests {
+		if got := run.distroVendor(distribution); got != want {
+			t.Errorf("distroVendor(%q) = %q, want %q", distribution, got, want)
+		}
+	}
+
+	if err := run.checkDistribution("nosuch"); err == nil {
+		t.Error("checkDistribution() accepted an unknown codename")
+	}
+
+	if err := run.checkDistribution("trixie"); err != nil {
+		t.Errorf("checkDistribution(trixie) = %v", err)
+	}
+}
+
+func TestArchiveComponents(t *testing.T) {
+	run := newDownloadRun(&config.Config{})
+
+	tests := map[string][]string{
+		"bullseye": {"main", "contrib", "non-free"},
+		"bookworm": {"main", "contrib", "non-free", "non-free-firmware"},
+		"jammy":    {"main", "restricted", "universe", "multiverse"},
+	}
+
+	for distribution, want := range tests {
+		if got := run.archiveComponents(distribution); !reflect.DeepEqual(got, want) {
+			t.Errorf("archiveComponents(%q) = %v, want %v", distribution, got, want)
+		}
+	}
+}
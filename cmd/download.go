@@ -1,92 +1,542 @@
 package cmd
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"portaptable/pkg/checksum"
 	"portaptable/pkg/config"
+	"portaptable/pkg/debfile"
+	"portaptable/pkg/debsig"
+	"portaptable/pkg/fetch"
 	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
 	"portaptable/pkg/packageinfo"
-	"regexp"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/repolock"
+	"portaptable/pkg/repometa"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// maxRetryDelay caps the exponential backoff between download attempts
+const maxRetryDelay = time.Minute
+
 func RunDownloadMode(config *config.Config) error {
-	fmt.Println("Resolving package dependencies...")
+	if err := validateSignatureMode(config.DebSignatures); err != nil {
+		return err
+	}
+
+	if config.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+
+	if config.Retries < 0 || config.RetryDelay < 0 {
+		return fmt.Errorf("--retries and --retry-delay must not be negative")
+	}
+
+	if err := parseArchitectures(config); err != nil {
+		return err
+	}
+
+	if config.Sources && (config.Preset != "" || config.ESMTokenFile != "") {
+		return fmt.Errorf("--source does not support --preset or --esm-token sources")
+	}
+
+	if !config.ArchiveSnapshot.IsZero() && (config.Mirror != "" || config.Preset != "" || config.ESMTokenFile != "") {
+		return fmt.Errorf("--snapshot takes the vendor archives from their snapshot service; drop --mirror, --preset and --esm-token")
+	}
+
+	resolver, err := chooseResolver(config)
+
+	if err != nil {
+		return err
+	}
+
+	// The apt resolver needs apt-get, which Windows and other non-Debian hosts lack
+	if _, err := exec.LookPath("apt-get"); err != nil && resolver == resolverApt {
+		return fmt.Errorf("--resolver apt needs apt-get, which this host lacks; use --resolver native, which reads the archive indexes directly")
+	}
+
+	run := newDownloadRun(config)
+
+	if err := run.applyDistroProfile(config); err != nil {
+		return err
+	}
+
+	names, targets, err := run.parseSuiteTargets(config, config.Packages)
+
+	if err != nil {
+		return err
+	}
+
+	names, pins, err := parseVersionPins(names)
+
+	if err != nil {
+		return err
+	}
+
+	run.suiteTargets, run.versionPins = targets, pins
+	fetch.SetRateLimit(config.LimitRate)
+
+	lock, err := repolock.Acquire(config.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	run.applyHashPolicy(config)
+	run.applyTrustPolicy(config)
+
+	if err := run.prepareRepositories(config); err != nil {
+		return err
+	}
+
+	if err := run.preparePreferences(config); err != nil {
+		return err
+	}
+
+	run.prepareDistroKeyring(config)
+
+	if resolver == resolverNative {
+		err = run.setupNativeIndex(config)
+	} else {
+		err = run.setupAptSources(config)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to set up package sources: %w", err)
+	}
+
+	if resolver == resolverApt {
+		if err := run.checkAptPins(); err != nil {
+			return err
+		}
+
+		run.pinAptDependencies()
+	}
+
+	names, err = run.expandTasks(names)
+
+	if err != nil {
+		return fmt.Errorf("failed to expand tasks: %w", err)
+	}
+
+	names, err = run.expandPatterns(names, config.PackageRegexes)
+
+	if err != nil {
+		return fmt.Errorf("failed to expand package patterns: %w", err)
+	}
+
+	names, err = run.addSectionPackages(config, names)
+
+	if err != nil {
+		return fmt.Errorf("failed to select packages by section: %w", err)
+	}
+
+	if config.BaseSystem {
+		if names, err = run.addBaseSystem(names); err != nil {
+			return fmt.Errorf("failed to select the base system: %w", err)
+		}
+	}
+
+	packages := appendMissing(names, config.BackportsPackages)
+
+	if config.DevPackages {
+		packages = run.addDevPackages(packages)
+	}
+
+	packages = withAdditionalArchitectures(config, packages)
+
+	output.Info("Resolving package dependencies...")
 
 	// Get all dependencies for the requested packages
-	allPackages, err := resolveAllDependencies(config.Packages, config.Architecture)
+	allPackages, err := run.resolveAllDependencies(packages, config)
 
 	if err != nil {
 		return fmt.Errorf("failed to resolve dependencies: %w", err)
 	}
 
-	fmt.Printf("Found %d packages to download (including dependencies)\n", len(allPackages))
+	if len(config.Languages) > 0 {
+		if allPackages, err = run.addLanguagePackages(config, allPackages); err != nil {
+			return fmt.Errorf("failed to select language packages: %w", err)
+		}
+	}
+
+	output.Info("Found %d packages to download (including dependencies)", len(allPackages))
+
+	allPackages, err = applyExclusions(config, allPackages)
+
+	if err != nil {
+		return err
+	}
+
+	allPackages, err = run.dropInstalled(config, allPackages)
+
+	if err != nil {
+		return err
+	}
+
+	allPackages, err = applyFilterPlugins(config, allPackages)
+
+	if err != nil {
+		return err
+	}
+
+	allPackages, err = run.applyMinimalProfile(config, allPackages)
+
+	if err != nil {
+		return err
+	}
+
+	allPackages, err = run.applySizeCaps(config, packages, allPackages)
+
+	if err != nil {
+		return err
+	}
+
+	if config.GraphFile != "" {
+		if err := run.writeDependencyGraph(config, packages, allPackages); err != nil {
+			return err
+		}
+	}
+
+	if err := run.checkConflicts(config, packages, allPackages); err != nil {
+		return err
+	}
+
+	allPackages = run.scheduleDownloads(allPackages)
+
+	if err := run.checkProjectedQuota(config, allPackages); err != nil {
+		return err
+	}
+
+	if config.DryRun {
+		run.printDryRun(config, allPackages, filepath.Join(config.RepoPath, "pool"))
+
+		return nil
+	}
 
 	// Create manifest
 	mfest := manifest.Manifest{
-		CreatedAt:    time.Now(),
-		Architecture: config.Architecture,
-		Distribution: config.Distribution,
-		Packages:     make([]packageinfo.PackageInfo, 0, len(allPackages)),
+		CreatedAt:     time.Now(),
+		Architecture:  config.Architecture,
+		Foreign:       config.ForeignArchitectures,
+		Additional:    config.AdditionalArchitectures,
+		Distribution:  config.Distribution,
+		Requested:     config.Packages,
+		RequireSHA256: config.RequireSHA256,
+		Options:       recordDownloadOptions(config),
+		Packages:      make([]packageinfo.PackageInfo, 0, len(allPackages)),
+	}
+
+	if !run.archiveSnapshot.IsZero() {
+		mfest.Snapshot = &run.archiveSnapshot
 	}
 
 	// Download each package
 	poolPath := filepath.Join(config.RepoPath, "pool")
 
-	for i, pkg := range allPackages {
-		fmt.Printf("[%d/%d] Processing %s...\n", i+1, len(allPackages), pkg)
+	// Packages an interrupted run completed need not be fetched again
+	allPackages, recovered := run.recoverJournal(config, allPackages, poolPath)
+	mfest.Packages = append(mfest.Packages, recovered...)
+	journal, err := manifest.OpenJournal(config.RepoPath, journalTarget(config))
 
-		packageInfo, err := downloadPackage(pkg, poolPath, config.Architecture)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			fmt.Printf("Warning: Failed to download %s: %v\n", pkg, err)
-			packageInfo = packageinfo.PackageInfo{
-				Name:         pkg,
-				Architecture: config.Architecture,
-				Downloaded:   false,
-			}
-		} else {
-			fmt.Printf("Downloaded %s (%d bytes)\n", packageInfo.Filename, packageInfo.Size)
+	defer journal.Close()
+
+	// Packages an earlier run left in the pool are kept rather than downloaded again
+	previous := previousManifest(config)
+	allPackages, reused := run.reusePooled(allPackages, previous, poolPath)
+	mfest.Packages = append(mfest.Packages, reused...)
+
+	mfest.Packages = append(mfest.Packages, run.downloadPackages(config, allPackages, poolPath, journal)...)
+
+	if config.DebugSymbols {
+		run.fetchDebugSymbols(config, &mfest)
+	}
+
+	if config.InstallerPackages {
+		if err := run.fetchInstallerPackages(config, &mfest); err != nil {
+			output.Failure("Failed to fetch installer packages: %v", err)
 		}
+	}
+
+	if config.Sources {
+		if err := run.fetchSourcePackages(config, &mfest); err != nil {
+			output.Failure("Failed to fetch source packages: %v", err)
+		}
+	}
+
+	if config.Changelogs {
+		run.fetchChangelogs(config, &mfest)
+	}
 
-		mfest.Packages = append(mfest.Packages, packageInfo)
+	// The quota never evicts what this run resolved, only what earlier runs left
+	requested := make(map[string]bool, len(mfest.Packages))
+
+	for _, pkg := range mfest.Packages {
+		requested[pkg.Filename] = true
+	}
+
+	if previous != nil && !config.Fresh {
+		mergePrevious(&mfest, previous, poolPath)
 	}
 
+	if err := enforceQuota(config, &mfest, requested); err != nil {
+		return fmt.Errorf("failed to enforce repository quota: %w", err)
+	}
+
+	run.recordInstallOrder(&mfest)
+
 	// Save manifest
-	if err := saveManifest(config.RepoPath, mfest); err != nil {
+	if err := manifest.Save(config.RepoPath, &mfest); err != nil {
 		return fmt.Errorf("failed to save manifest: %w", err)
 	}
 
+	if err := manifest.RemoveJournal(config.RepoPath); err != nil {
+		output.Warning("%v", err)
+	}
+
 	// Generate repository metadata
-	if err := generateRepositoryMetadata(config); err != nil {
+	if err := publishMetadata(config.RepoPath, &mfest, config); err != nil {
 		return fmt.Errorf("failed to generate repository metadata: %w", err)
 	}
 
-	fmt.Printf("Successfully processed %d packages\n", len(mfest.Packages))
+	recordHistory(config, "download: %s (%d packages)", strings.Join(config.Packages, " "), len(mfest.Packages))
+	printDownloadSummary(&mfest)
 
 	return nil
 }
 
-func resolveAllDependencies(packages []string, architecture string) ([]string, error) {
+// downloadPackages fetches packages into the pool with --concurrency workers. Only
+// the calling goroutine writes the journal, and the results keep the order of packages.
+func (run *downloadRun) downloadPackages(config *config.Config, packages []string, poolPath string, journal *manifest.Journal) []packageinfo.PackageInfo {
+	results := make([]packageinfo.PackageInfo, len(packages))
+	queue := make(chan int)
+	done := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < min(config.Concurrency, max(len(packages), 1)); w++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			for i := range queue {
+				label := fmt.Sprintf("[%d/%d]", i+1, len(packages))
+
+				if config.Concurrency > 1 {
+					label = fmt.Sprintf("[%d/%d worker %d]", i+1, len(packages), worker)
+				}
+
+				output.Info("%s Processing %s...", label, packages[i])
+				results[i] = run.processPackage(config, packages[i], poolPath)
+				done <- i
+			}
+		}(w + 1)
+	}
+
+	go func() {
+		for i := range packages {
+			queue <- i
+		}
+
+		close(queue)
+		wg.Wait()
+		close(done)
+	}()
+
+	for i := range done {
+		if results[i].Downloaded {
+			if err := journal.Append(results[i]); err != nil {
+				output.Warning("%v", err)
+			}
+		}
+	}
+
+	return results
+}
+
+// processPackage downloads and checks one package, returning its manifest entry
+func (run *downloadRun) processPackage(config *config.Config, pkg, poolPath string) packageinfo.PackageInfo {
+	if allowed, component := run.componentAllowed(config, pkg); !allowed {
+		output.Warning("Skipping %s: component %s is not allowed", pkg, component)
+
+		return packageinfo.PackageInfo{
+			Name:         pkg,
+			Architecture: config.Architecture,
+			Failure:      fmt.Sprintf("component %s is not allowed", component),
+		}
+	}
+
+	packageInfo, err := run.downloadWithRetries(config, pkg, poolPath)
+
+	if err != nil {
+		output.Failure("Failed to download %s: %v", pkg, err)
+
+		return packageinfo.PackageInfo{
+			Name:         pkg,
+			Architecture: config.Architecture,
+			Downloaded:   false,
+			Failure:      err.Error(),
+		}
+	}
+
+	output.Success("Downloaded %s (%d bytes)", packageInfo.Filename, packageInfo.Size)
+
+	if err := checkLicenses(config, &packageInfo, poolPath); err != nil {
+		output.Warning("Rejecting %s: %v", pkg, err)
+		rejectPackage(&packageInfo, poolPath, err)
+	}
+
+	if packageInfo.Downloaded && config.DebSignatures != debsig.ModeOff {
+		if err := checkPackageSignature(&packageInfo, poolPath, config.DebSignatures); err != nil {
+			output.Warning("Rejecting %s: %v", pkg, err)
+			rejectPackage(&packageInfo, poolPath, err)
+		}
+	}
+
+	return packageInfo
+}
+
+// recoverJournal takes the packages the journal of an interrupted run of the same
+// target records as complete out of packages. An entry is kept only when it is the
+// current candidate, by name, version and architecture, and its pool file is intact.
+func (run *downloadRun) recoverJournal(config *config.Config, packages []string, poolPath string) ([]string, []packageinfo.PackageInfo) {
+	entries, err := manifest.ReadJournal(config.RepoPath, journalTarget(config))
+
+	if errors.Is(err, manifest.ErrJournalTarget) {
+		output.Info("Discarding the journal of an interrupted run for another distribution or architecture")
+	} else if err != nil {
+		output.Warning("%v", err)
+	}
+
+	if len(entries) == 0 {
+		return packages, nil
+	}
+
+	journaled := make(map[string]packageinfo.PackageInfo, len(entries))
+
+	for _, pkg := range entries {
+		// Versions come from pool file names, which escape the epoch separator
+		if version, err := url.PathUnescape(pkg.Version); err == nil {
+			journaled[indexKey(packageinfo.PackageInfo{Name: pkg.Name, Version: version, Architecture: pkg.Architecture})] = pkg
+		}
+	}
+
+	records := run.candidateParagraphs(packages)
+	var remaining []string
+	var recovered []packageinfo.PackageInfo
+
+	for _, spec := range packages {
+		record, ok := records[spec]
+		candidate := packageinfo.PackageInfo{Name: record["Package"], Version: record["Version"], Architecture: record["Architecture"]}
+		pkg, found := journaled[indexKey(candidate)]
+
+		if !ok || !found || (record["SHA256"] != "" && record["SHA256"] != pkg.SHA256) {
+			remaining = append(remaining, spec)
+
+			continue
+		}
+
+		if sums, err := checksum.File(filepath.Join(poolPath, pkg.Filename)); err != nil || sums.SHA256 != pkg.SHA256 {
+			remaining = append(remaining, spec)
+
+			continue
+		}
+
+		output.Success("Kept %s from the interrupted run", pkg.Filename)
+		recovered = append(recovered, pkg)
+	}
+
+	if len(recovered) > 0 {
+		output.Info("Resuming an interrupted run: %d packages are already downloaded", len(recovered))
+	}
+
+	return remaining, recovered
+}
+
+// journalTarget returns the distribution and architectures a download run journals for
+func journalTarget(config *config.Config) manifest.JournalTarget {
+	architectures := append([]string{config.Architecture}, config.ForeignArchitectures...)
+
+	return manifest.JournalTarget{Distribution: config.Distribution, Architectures: append(architectures, config.AdditionalArchitectures...)}
+}
+
+func (run *downloadRun) resolveAllDependencies(packages []string, config *config.Config) ([]string, error) {
+	if run.nativeIndex != nil {
+		return run.nativeIndex.resolve(packages)
+	}
+
 	allPackages := make(map[string]bool)
+	qualified := qualifiedArchitectures(config)
+
+	// Packages of an additional architecture resolve as on a target of that architecture
+	byArchitecture := make(map[string]map[string]bool)
 
 	for _, pkg := range packages {
-		deps, err := getDependencies(pkg, architecture)
+		if name, architecture, found := strings.Cut(pkg, ":"); found && contains(config.AdditionalArchitectures, architecture) {
+			deps, err := run.getDependencies(name, architecture)
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to get dependencies for %s: %w", pkg, err)
+			}
+
+			if byArchitecture[architecture] == nil {
+				byArchitecture[architecture] = make(map[string]bool)
+			}
+
+			for _, dep := range append([]string{name}, deps...) {
+				byArchitecture[architecture][qualifyFor(dep, architecture, config)] = true
+			}
+
+			continue
+		}
+
+		deps, err := run.getDependencies(pkg, "")
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to get dependencies for %s: %w", pkg, err)
 		}
 
 		// Add the package itself and all its dependencies
-		allPackages[pkg] = true
+		allPackages[qualifyArchitecture(pkg, config.Architecture, qualified)] = true
 
 		for _, dep := range deps {
-			allPackages[dep] = true
+			allPackages[qualifyArchitecture(dep, config.Architecture, qualified)] = true
+		}
+	}
+
+	// apt has Architecture: all packages only under their bare names
+	for architecture, specs := range byArchitecture {
+		var names []string
+
+		for spec := range specs {
+			if name, qualifier, _ := strings.Cut(spec, ":"); qualifier == architecture {
+				names = append(names, name)
+			}
+		}
+
+		independent := run.architectureIndependent(names, architecture)
+
+		for spec := range specs {
+			if name, qualifier, _ := strings.Cut(spec, ":"); qualifier == architecture && independent[name] {
+				spec = name
+			}
+
+			allPackages[spec] = true
 		}
 	}
 
@@ -100,11 +550,33 @@ func resolveAllDependencies(packages []string, architecture string) ([]string, e
 	return result, nil
 }
 
-func getDependencies(packageName, architecture string) ([]string, error) {
+// qualifyArchitecture keeps the :arch qualifier of a foreign-architecture package and
+// drops qualifiers naming the primary architecture or any (libc6:any is libc6)
+func qualifyArchitecture(pkg, architecture string, foreign []string) string {
+	name, qualifier, found := strings.Cut(pkg, ":")
+
+	if found && contains(foreign, qualifier) {
+		return pkg
+	}
+
+	return name
+}
+
+// dependsArgs make apt-cache list the hard dependency closure of a package
+var dependsArgs = []string{"depends", "--recurse", "--no-recommends",
+	"--no-suggests", "--no-conflicts", "--no-breaks", "--no-replaces", "--no-enhances"}
+
+// getDependencies lists a package and its recursive dependencies as apt-cache depends
+// does; a non-empty architecture resolves them as on a target of that architecture
+func (run *downloadRun) getDependencies(packageName, architecture string) ([]string, error) {
+	args := append(append([]string{}, dependsArgs...), run.pinnedSpec(packageName))
+
+	if architecture != "" {
+		args = append([]string{"-o", "APT::Architecture=" + architecture}, args...)
+	}
+
 	// Use apt-cache to get recursive dependencies
-	cmd := exec.Command("apt-cache", "depends", "--recurse", "--no-recommends",
-		"--no-suggests", "--no-conflicts", "--no-breaks", "--no-replaces",
-		"--no-enhances", packageName)
+	cmd := run.aptCommand("apt-cache", args...)
 
 	output, err := cmd.Output()
 
@@ -112,39 +584,98 @@ func getDependencies(packageName, architecture string) ([]string, error) {
 		return nil, fmt.Errorf("apt-cache command failed: %w", err)
 	}
 
-	return parseDependencyOutput(string(output)), nil
+	return run.parseDependencyOutput(string(output)), nil
 }
 
-func parseDependencyOutput(output string) []string {
-	var packages []string
-	seen := make(map[string]bool)
+// dependencyTarget is one alternative of a dependency in apt-cache depends output: a
+// package, or a virtual package with the packages apt lists as providing it
+type dependencyTarget struct {
+	name      string
+	virtual   bool
+	providers []string
+}
 
-	// Regular expression to match package names from apt-cache depends output
-	// Looks for lines like "  Depends: package-name" or "package-name"
-	packageRegex := regexp.MustCompile(`^\s*(?:Depends:\s+)?([a-zA-Z0-9][a-zA-Z0-9\-\+\.]+)`)
+// parseDependencyOutput returns the packages installing the first package of apt-cache
+// depends --recurse output pulls in, itself first. Like apt, it follows the first
+// satisfiable alternative of each dependency (or the one --alternative chose) and one
+// provider of a virtual package, though the output lists every alternative and provider.
+func (run *downloadRun) parseDependencyOutput(text string) []string {
+	dependencies := make(map[string][][]dependencyTarget)
+	var root, current string
+	var group []dependencyTarget
 
-	scanner := bufio.NewScanner(strings.NewReader(output))
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case trimmed == "":
+			continue
 
-		// Skip empty lines and certain dependency types we don't want
-		if line == "" || strings.Contains(line, "|") ||
-			strings.Contains(line, "Recommends:") ||
-			strings.Contains(line, "Suggests:") {
+		// A package (or <virtual package>) whose dependencies follow
+		case !strings.HasPrefix(line, " "):
+			current = strings.Trim(trimmed, "<>")
 
-			continue
+			if root == "" {
+				root = current
+			}
+
+		// A provider of the virtual package the previous line depends on
+		case strings.HasPrefix(line, "    "):
+			targets := group
+
+			// The virtual package was the last alternative, completing the group
+			if groups := dependencies[current]; len(targets) == 0 && len(groups) > 0 {
+				targets = groups[len(groups)-1]
+			}
+
+			if len(targets) > 0 && targets[len(targets)-1].virtual {
+				targets[len(targets)-1].providers = append(targets[len(targets)-1].providers, trimmed)
+			}
+
+		// "Depends: name", "|Depends: name" for all but the last alternative
+		default:
+			alternative := strings.HasPrefix(trimmed, "|")
+			field, target, _ := strings.Cut(strings.TrimPrefix(trimmed, "|"), ": ")
+
+			if field != "Depends" && field != "PreDepends" {
+				continue
+			}
+
+			fields := strings.Fields(target)
+
+			if len(fields) == 0 {
+				continue
+			}
+
+			group = append(group, dependencyTarget{name: strings.Trim(fields[0], "<>"), virtual: strings.HasPrefix(fields[0], "<")})
+
+			if !alternative {
+				dependencies[current] = append(dependencies[current], group)
+				group = nil
+			}
 		}
+	}
+
+	if root == "" {
+		return nil
+	}
+
+	packages := []string{root}
+	seen := map[string]bool{root: true}
 
-		matches := packageRegex.FindStringSubmatch(line)
+	for next := 0; next < len(packages); next++ {
+		for _, group := range dependencies[packages[next]] {
+			dep, ok := run.chooseDependency(group, seen)
 
-		if len(matches) > 1 {
-			pkg := matches[1]
+			if !ok {
+				output.Warning("%s depends on %s, which no package provides", packages[next], group[0].name)
 
-			// Filter out virtual packages and duplicates
-			if !seen[pkg] && !strings.HasPrefix(pkg, "<") {
-				packages = append(packages, pkg)
-				seen[pkg] = true
+				continue
+			}
+
+			if !seen[dep] {
+				seen[dep] = true
+				packages = append(packages, dep)
 			}
 		}
 	}
@@ -152,38 +683,126 @@ func parseDependencyOutput(output string) []string {
 	return packages
 }
 
-func downloadPackage(packageName, poolPath, architecture string) (packageinfo.PackageInfo, error) {
-	// Use apt-get download to get the package
-	cmd := exec.Command("apt-get", "download", packageName)
-	cmd.Dir = poolPath
+// chooseDependency picks the package satisfying a dependency group: the one --alternative
+// chose for a name it offers, else its first real alternative, else a provider of a
+// virtual one: the first --prefer-providers names, else one already selected, else the
+// first apt lists
+func (run *downloadRun) chooseDependency(group []dependencyTarget, selected map[string]bool) (string, bool) {
+	for _, target := range group {
+		choice, ok := run.alternativeChoices[target.name]
 
-	output, err := cmd.CombinedOutput()
+		if !ok {
+			continue
+		}
 
-	if err != nil {
-		return packageinfo.PackageInfo{}, fmt.Errorf("apt-get download failed: %w, output: %s", err, string(output))
+		for _, candidate := range group {
+			if (candidate.name == choice && !candidate.virtual) || contains(candidate.providers, choice) {
+				return choice, true
+			}
+		}
 	}
 
-	// Find the downloaded file
-	files, err := filepath.Glob(filepath.Join(poolPath, fmt.Sprintf("%s_*.deb", packageName)))
+	for _, target := range group {
+		if !target.virtual {
+			return target.name, true
+		}
 
-	if err != nil {
-		return packageinfo.PackageInfo{}, fmt.Errorf("failed to find downloaded file: %w", err)
+		if len(target.providers) == 0 {
+			continue
+		}
+
+		for _, preferred := range run.preferredProviders {
+			if contains(target.providers, preferred) {
+				return preferred, true
+			}
+		}
+
+		for _, provider := range target.providers {
+			if selected[provider] {
+				return provider, true
+			}
+		}
+
+		if len(target.providers) > 1 {
+			output.Info("Choosing %s to provide %s (of %s; see --prefer-providers)", target.providers[0], target.name, strings.Join(target.providers, ", "))
+		}
+
+		return target.providers[0], true
 	}
 
-	if len(files) == 0 {
-		return packageinfo.PackageInfo{}, fmt.Errorf("no .deb file found after download")
+	return "", false
+}
+
+// downloadWithRetries downloads a package, retrying failures --retries times with
+// exponential backoff. Packages the archive lacks or refuses are not retried.
+func (run *downloadRun) downloadWithRetries(config *config.Config, pkg, poolPath string) (packageinfo.PackageInfo, error) {
+	for attempt := 0; ; attempt++ {
+		packageInfo, err := run.downloadPackage(pkg, poolPath, config.Architecture)
+
+		if err == nil || attempt >= config.Retries || errors.Is(err, remote.ErrNotFound) || errors.Is(err, fetch.ErrUnauthorized) {
+			return packageInfo, err
+		}
+
+		delay := retryDelay(config.RetryDelay, attempt)
+		output.Warning("Downloading %s failed (%v); retry %d/%d in %s", pkg, err, attempt+1, config.Retries, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+// retryDelay returns the wait before retry attempt+1: base doubled per earlier retry,
+// capped at maxRetryDelay, then jittered down to half so that parallel workers that
+// failed together do not retry in lockstep
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+
+	for i := 0; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
 	}
 
-	// Get the most recent file (in case there are multiple versions)
-	filename := filepath.Base(files[len(files)-1])
+	delay = min(delay, maxRetryDelay)
+
+	if delay <= 0 {
+		return 0
+	}
 
-	// Get file info
-	stat, err := os.Stat(files[len(files)-1])
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (run *downloadRun) downloadPackage(packageName, poolPath, architecture string) (packageinfo.PackageInfo, error) {
+	var path string
+	var err error
+
+	if run.nativeIndex != nil {
+		path, err = run.nativeIndex.download(packageName, poolPath)
+	} else {
+		path, err = run.aptDownload(packageName, poolPath, architecture)
+	}
 
 	if err != nil {
-		return packageinfo.PackageInfo{}, fmt.Errorf("failed to stat downloaded file: %w", err)
+		return packageinfo.PackageInfo{}, err
 	}
 
+	// Get file size and checksums
+	sums, err := checksum.File(path)
+
+	if err != nil {
+		return packageinfo.PackageInfo{}, fmt.Errorf("failed to checksum downloaded file: %w", err)
+	}
+
+	// A mismatching file is removed so that a retry downloads it afresh
+	if err := run.checkAgainstIndex(packageName, path, sums); err != nil {
+		os.Remove(path)
+
+		return packageinfo.PackageInfo{}, err
+	}
+
+	// A foreign-architecture package is requested as name:arch
+	if name, foreign, found := strings.Cut(packageName, ":"); found {
+		packageName, architecture = name, foreign
+	}
+
+	filename := filepath.Base(path)
+
 	// Parse version from filename (format: package_version_architecture.deb)
 	version := "unknown"
 	parts := strings.Split(filename, "_")
@@ -192,47 +811,182 @@ func downloadPackage(packageName, poolPath, architecture string) (packageinfo.Pa
 		version = parts[1]
 	}
 
-	return packageinfo.PackageInfo{
+	info := packageinfo.PackageInfo{
 		Name:         packageName,
 		Version:      version,
 		Architecture: architecture,
 		Filename:     filename,
-		Size:         stat.Size(),
+		Size:         sums.Size,
+		MD5sum:       sums.MD5,
+		SHA1:         sums.SHA1,
+		SHA256:       sums.SHA256,
+		MTime:        sums.ModTime.UnixNano(),
 		Downloaded:   true,
-	}, nil
+	}
+
+	// Record the source package and the index fields the Packages file needs
+	if control, err := debfile.Control(path); err == nil {
+		info.Source, info.SourceVersion = debfile.SourceName(control)
+		info.Control = repometa.IndexFields(control)
+
+		// Architecture-independent packages belong in every architecture's index
+		if control["Architecture"] == "all" {
+			info.Architecture = "all"
+		}
+	}
+
+	return info, nil
+}
+
+// checkAgainstIndex compares a downloaded file with the size and SHA256 the index
+// publishes for the package's candidate, catching truncated and corrupted downloads
+func (run *downloadRun) checkAgainstIndex(spec, path string, sums checksum.Sums) error {
+	record := run.candidateParagraphs([]string{spec})[spec]
+
+	if size, err := strconv.ParseInt(record["Size"], 10, 64); err == nil && size != sums.Size {
+		return fmt.Errorf("%s is %d bytes, but the index publishes %d", filepath.Base(path), sums.Size, size)
+	}
+
+	if expected := record["SHA256"]; expected != "" && expected != sums.SHA256 {
+		return fmt.Errorf("%s has SHA256 %s, but the index publishes %s", filepath.Base(path), sums.SHA256, expected)
+	}
+
+	return nil
+}
+
+// aptDownload fetches the file apt would download for a package straight into the pool
+// and returns its path. Archives that want credentials only apt holds are left to
+// apt-get download.
+func (run *downloadRun) aptDownload(packageName, poolPath, architecture string) (string, error) {
+	file, err := run.aptPackageURI(packageName)
+
+	if err != nil {
+		return "", err
+	}
+
+	path, err := fetch.Download(file, poolPath)
+
+	if errors.Is(err, fetch.ErrUnauthorized) {
+		return run.aptGetDownload(packageName, poolPath, architecture)
+	}
+
+	return path, err
+}
+
+// aptPackageURI asks apt where the candidate version of a package is published. Each
+// line of --print-uris reads 'URI' filename size hashtype:digest; apt prints its
+// strongest hash, SHA512 on Ubuntu, unless Acquire::ForceHash selects SHA256.
+func (run *downloadRun) aptPackageURI(packageName string) (fetch.File, error) {
+	out, err := run.aptCommand("apt-get", "-o", "Acquire::ForceHash=SHA256", "download", "--print-uris", run.pinnedSpec(packageName)).CombinedOutput()
+
+	if err != nil {
+		return fetch.File{}, fmt.Errorf("apt-get download --print-uris failed: %w, output: %s", err, string(out))
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "'") {
+			continue
+		}
+
+		file := fetch.File{URL: strings.Trim(fields[0], "'"), Filename: fields[1]}
+		file.Size, _ = strconv.ParseInt(fields[2], 10, 64)
+
+		if len(fields) > 3 {
+			file.SHA256, _ = strings.CutPrefix(fields[3], "SHA256:")
+
+			if file.SHA256 == fields[3] {
+				file.SHA256 = ""
+			}
+		}
+
+		if run.requireSHA256 && file.SHA256 == "" {
+			return fetch.File{}, fmt.Errorf("%s fails the SHA256 policy: its index entry has only MD5 or SHA1", file.URL)
+		}
+
+		return file, nil
+	}
+
+	return fetch.File{}, fmt.Errorf("apt reported no download for %s", packageName)
 }
 
-func saveManifest(repoPath string, mfest manifest.Manifest) error {
-	manifestPath := filepath.Join(repoPath, "manifest.json")
-	data, err := json.MarshalIndent(mfest, "", "  ")
+// aptGetDownload fetches a package with apt-get download and returns the path of the file
+func (run *downloadRun) aptGetDownload(packageName, poolPath, architecture string) (string, error) {
+	cmd := run.aptCommand("apt-get", "download", run.pinnedSpec(packageName))
+	cmd.Dir = poolPath
+
+	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
+		return "", fmt.Errorf("apt-get download failed: %w, output: %s", err, string(output))
+	}
+
+	// A foreign-architecture package is requested as name:arch
+	if name, foreign, found := strings.Cut(packageName, ":"); found {
+		packageName, architecture = name, foreign
+	}
+
+	// Find the downloaded file; other architectures of the same package may share the pool
+	var files []string
+
+	for _, fileArch := range []string{architecture, "all"} {
+		matches, err := filepath.Glob(filepath.Join(poolPath, fmt.Sprintf("%s_*_%s.deb", packageName, fileArch)))
+
+		if err != nil {
+			return "", fmt.Errorf("failed to find downloaded file: %w", err)
+		}
+
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return "", fmt.Errorf("no .deb file found after download")
 	}
 
-	return os.WriteFile(manifestPath, data, 0644)
+	// Get the most recent file (in case there are multiple versions)
+	return files[len(files)-1], nil
 }
 
-func generateRepositoryMetadata(config *config.Config) error {
-	// TODO: Generate proper Debian repository metadata (Packages, Release files)
-	// This is complex and involves creating proper apt repository structure
-	fmt.Println("Repository metadata generation - placeholder")
+// printDownloadSummary prints the final result line, which is all --quiet shows on success
+func printDownloadSummary(mfest *manifest.Manifest) {
+	downloaded := 0
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			downloaded++
+		}
+	}
 
-	// Create basic directory structure for now
-	distPath := filepath.Join(config.RepoPath, "dists", config.Distribution)
-	mainPath := filepath.Join(distPath, "main", "binary-"+config.Architecture)
+	failed := len(mfest.Packages) - downloaded
 
-	if err := os.MkdirAll(mainPath, 0755); err != nil {
-		return fmt.Errorf("failed to create dist directories: %w", err)
+	if output.Quiet() {
+		fmt.Printf("portaptable: %d/%d packages downloaded, %d failed (%s %s)\n",
+			downloaded, len(mfest.Packages), failed, mfest.Distribution, mfest.Architecture)
+
+		return
 	}
 
-	// Create a basic Release file
-	releasePath := filepath.Join(distPath, "Release")
-	releaseContent := fmt.Sprintf(`Suite: %s
-Components: main
-Architectures: %s
-Date: %s
-`, config.Distribution, config.Architecture, time.Now().Format(time.RFC1123Z))
+	fmt.Printf("Successfully processed %d packages (%d downloaded, %d failed)\n", len(mfest.Packages), downloaded, failed)
+
+	return
+}
+
+// appendMissing returns packages followed by the extra names not already among them
+func appendMissing(packages, extra []string) []string {
+	result := append([]string{}, packages...)
+	seen := make(map[string]bool)
+
+	for _, pkg := range packages {
+		seen[pkg] = true
+	}
+
+	for _, pkg := range extra {
+		if !seen[pkg] {
+			result = append(result, pkg)
+			seen[pkg] = true
+		}
+	}
 
-	return os.WriteFile(releasePath, []byte(releaseContent), 0644)
+	return result
 }
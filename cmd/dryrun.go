@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+)
+
+// printDryRun lists the packages a download would fetch with their index sizes and
+// the total, marking those the pool already holds, without downloading anything
+func (run *downloadRun) printDryRun(config *config.Config, packages []string, poolPath string) {
+	remaining, _ := run.reusePooled(packages, previousManifest(config), poolPath)
+	fetched := make(map[string]bool, len(remaining))
+
+	for _, pkg := range remaining {
+		fetched[pkg] = true
+	}
+
+	records := run.candidateParagraphs(packages)
+	var total, pooled int64
+	unknown := 0
+
+	for _, pkg := range packages {
+		record, ok := records[pkg]
+		size, err := strconv.ParseInt(record["Size"], 10, 64)
+
+		if !ok || err != nil {
+			fmt.Printf("%-40s %-30s %12s\n", pkg, "?", "?")
+			unknown++
+
+			continue
+		}
+
+		note := ""
+
+		if fetched[pkg] {
+			total += size
+		} else {
+			pooled += size
+			note = " (in pool)"
+		}
+
+		fmt.Printf("%-40s %-30s %12s%s\n", pkg, record["Version"], formatSize(size), note)
+	}
+
+	output.Info("Dry run: %d packages, %s to download (%s already in the pool)", len(packages), formatSize(total), formatSize(pooled))
+
+	if unknown > 0 {
+		output.Warning("The indexes give no size for %d packages; they are not counted", unknown)
+	}
+}
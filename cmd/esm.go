@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+)
+
+// esmHost serves the Ubuntu Pro Expanded Security Maintenance archives
+const esmHost = "esm.ubuntu.com"
+
+// esmServices lists the ESM archives an Ubuntu Pro subscription can cover
+var esmServices = map[string]bool{"infra": true, "apps": true}
+
+// addESMSources adds the selected ESM services to the private configuration,
+// along with the bearer token apt authenticates with
+func (run *downloadRun) addESMSources(config *config.Config, env *aptenv.Env) error {
+	if run.distroVendor(config.Distribution) != "ubuntu" {
+		return fmt.Errorf("ESM archives are only available for Ubuntu releases")
+	}
+
+	token, err := readESMToken(config.ESMTokenFile)
+
+	if err != nil {
+		return err
+	}
+
+	services := config.ESMServices
+
+	if len(services) == 0 {
+		services = []string{"infra", "apps"}
+	}
+
+	var sources []aptenv.Source
+
+	for _, service := range services {
+		if !esmServices[service] {
+			return fmt.Errorf("invalid ESM service %q (expected infra or apps)", service)
+		}
+
+		uri := fmt.Sprintf("https://%s/%s/ubuntu", esmHost, service)
+
+		if err := env.AddCredentials("90esm-"+service, fmt.Sprintf("%s/%s/ubuntu/", esmHost, service), "bearer", token); err != nil {
+			return err
+		}
+
+		keyring := fmt.Sprintf("/usr/share/keyrings/ubuntu-pro-esm-%s.gpg", service)
+
+		if _, err := os.Stat(keyring); err != nil {
+			keyring = ""
+		}
+
+		for _, pocket := range []string{pocketSecurity, pocketUpdates} {
+			sources = append(sources, aptenv.Source{
+				URI:        uri,
+				Suite:      fmt.Sprintf("%s-%s-%s", config.Distribution, service, pocket),
+				Components: []string{"main"},
+				SignedBy:   keyring,
+			})
+		}
+	}
+
+	return env.AddSources("esm", sources)
+}
+
+// readESMToken reads the ESM resource token, as found in the host's
+// /etc/apt/auth.conf.d/90ubuntu-advantage after 'pro attach'
+func readESMToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read ESM token: %w", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+
+	if token == "" || strings.ContainsAny(token, " \t\n") {
+		return "", fmt.Errorf("ESM token file %s must contain a single token", path)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/namefilter"
+	"portaptable/pkg/output"
+)
+
+// applyExclusions drops the resolved packages matching --exclude. Unlike the minimal
+// profile nothing is restored: the target is expected to have them already.
+func applyExclusions(cfg *config.Config, packages []string) ([]string, error) {
+	if len(cfg.Exclude) == 0 {
+		return packages, nil
+	}
+
+	excluded, err := namefilter.New(cfg.Exclude, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	dropped := 0
+
+	for _, pkg := range packages {
+		name, _, _ := strings.Cut(pkg, ":")
+
+		if excluded.Match(name) {
+			dropped++
+
+			continue
+		}
+
+		kept = append(kept, pkg)
+	}
+
+	if dropped > 0 {
+		output.Info("Excluded %d packages matching --exclude", dropped)
+	}
+
+	return kept, nil
+}
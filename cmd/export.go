@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"portaptable/pkg/bundle"
+	"portaptable/pkg/config"
+	"portaptable/pkg/cosign"
+	"portaptable/pkg/debsig"
+	"portaptable/pkg/ipfs"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/snapshot"
+)
+
+// RunExportCommand packs the repository, its signed metadata and public keyring into a bundle
+func RunExportCommand(args []string) error {
+	var cfg config.Config
+	var output, ociRef, format, since, cdrom, discSize, compression string
+	var torrentOpts torrentOptions
+	var zsyncOpts zsyncOptions
+	var ipfsOpts ipfsOptions
+
+	fs := newFlagSet("export", &cfg)
+	fs.StringVar(&output, "output", "", "Bundle file (default: portaptable-<dist>-<arch>-<date>.tar.zst, .tar.gz or .tar)")
+	fs.StringVar(&compression, "compression", "", "Bundle compression: zstd, gzip or none (default: multithreaded zstd for bundles over 256MB, gzip otherwise)")
+	fs.StringVar(&format, "format", "", "Write the bundle with the export plugin portaptable-export-FORMAT instead of as a tarball")
+	fs.StringVar(&since, "since", "", "Only ship the packages added or changed since this snapshot, for import on top of a repository at that state")
+	fs.StringVar(&cdrom, "cdrom", "", "Write an apt-cdrom disc set (DIR/disc1, DIR/disc2, ...) instead of a bundle")
+	fs.StringVar(&discSize, "disc-size", "dvd", "Capacity of each --cdrom disc: cd, dvd, dvd-dl, bd or a size such as 4G")
+	fs.StringVar(&cfg.CosignKey, "cosign-key", "", "Cosign private key for signing the bundle")
+	fs.StringVar(&ociRef, "oci-ref", "", "Also push the bundle to this OCI reference and sign it (requires --cosign-key)")
+	fs.BoolVar(&torrentOpts.enabled, "torrent", false, "Also write BUNDLE.torrent for peer-to-peer distribution")
+	fs.Var(&torrentOpts.trackers, "tracker", "Tracker announce URL for --torrent (repeatable)")
+	fs.Var(&torrentOpts.webSeeds, "web-seed", "HTTP URL serving the bundle, listed as a web seed in --torrent (repeatable)")
+	fs.BoolVar(&zsyncOpts.enabled, "zsync", false, "Also write BUNDLE.zsync so fetch-bundle can update an older bundle by downloading only changed blocks")
+	fs.StringVar(&zsyncOpts.url, "zsync-url", "", "URL of the bundle recorded in --zsync (default: its name, next to the control file)")
+	fs.BoolVar(&ipfsOpts.bundle, "ipfs", false, "Also add and pin the bundle on IPFS, recording its CID in the manifest")
+	fs.BoolVar(&ipfsOpts.repository, "ipfs-repo", false, "Also add and pin the repository tree on IPFS for apt access through a gateway")
+	fs.StringVar(&ipfsOpts.api, "ipfs-api", ipfs.DefaultAPI, "Kubo RPC API (or IPFS Cluster proxy) address for --ipfs and --ipfs-repo")
+	fs.IntVar(&torrentOpts.seedPort, "seed-port", 0, "After writing --torrent, seed the bundle on this port until interrupted")
+	fs.Parse(args)
+
+	if ociRef != "" && cfg.CosignKey == "" {
+		return fmt.Errorf("--oci-ref requires --cosign-key")
+	}
+
+	if torrentOpts.seedPort != 0 && !torrentOpts.enabled {
+		return fmt.Errorf("--seed-port requires --torrent")
+	}
+
+	if zsyncOpts.url != "" && !zsyncOpts.enabled {
+		return fmt.Errorf("--zsync-url requires --zsync")
+	}
+
+	if since != "" && format != "" {
+		return fmt.Errorf("--since cannot be combined with --format")
+	}
+
+	if compression != "" && format != "" {
+		return fmt.Errorf("--compression cannot be combined with --format")
+	}
+
+	if cdrom != "" && (output != "" || compression != "" || format != "" || since != "" || ociRef != "" || cfg.CosignKey != "" ||
+		torrentOpts.enabled || zsyncOpts.enabled || ipfsOpts.bundle || ipfsOpts.repository) {
+		return fmt.Errorf("--cdrom writes disc trees and cannot be combined with bundle options")
+	}
+
+	capacity, err := parseDiscSize(discSize)
+
+	if err != nil {
+		return err
+	}
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	if compression, err = bundleCompression(compression, mfest); err != nil {
+		return err
+	}
+
+	// Refresh indexes and signatures so the bundle is self-consistent
+	if err := publishMetadata(cfg.RepoPath, mfest, &cfg); err != nil {
+		return fmt.Errorf("failed to generate repository metadata: %w", err)
+	}
+
+	if cdrom != "" {
+		fmt.Printf("Exporting %s as an apt-cdrom disc set to %s...\n", cfg.RepoPath, cdrom)
+
+		discs, err := exportDiscSet(&cfg, mfest, cdrom, capacity)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Exported %d packages on %d discs; burn each disc directory, e.g. with xorriso -as mkisofs -r -J\n", len(mfest.Packages), discs)
+
+		return nil
+	}
+
+	if output == "" {
+		extension := bundle.Extension(compression)
+
+		if format != "" {
+			extension = format
+		}
+
+		if since != "" {
+			extension = "since-" + since + "." + extension
+		}
+
+		output = fmt.Sprintf("portaptable-%s-%s-%s.%s",
+			mfest.Distribution, mfest.Architecture, time.Now().Format("20060102"), extension)
+	}
+
+	options := bundle.Options{Compression: compression, Rsyncable: zsyncOpts.enabled}
+	shipped := 0
+
+	if since != "" {
+		if options, shipped, err = deltaOptions(cfg.RepoPath, since, mfest, options); err != nil {
+			return err
+		}
+
+		fmt.Printf("Exporting changes to %s since snapshot %s to %s...\n", cfg.RepoPath, since, output)
+	} else {
+		// Snapshots hard-link the pool; archived, each would carry another copy of it
+		options.Exclude = func(rel string, info os.FileInfo) bool {
+			return rel == snapshot.Dir
+		}
+
+		fmt.Printf("Exporting %s to %s...\n", cfg.RepoPath, output)
+	}
+
+	if format != "" {
+		err = exportWithPlugin(&cfg, format, output)
+	} else {
+		err = bundle.CreateWith(cfg.RepoPath, output, options)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := signFile(output, &cfg); err != nil {
+		return err
+	}
+
+	if cfg.CosignKey != "" {
+		if err := cosign.SignBlob(cfg.CosignKey, output); err != nil {
+			return fmt.Errorf("failed to sign bundle with cosign: %w", err)
+		}
+
+		fmt.Printf("Wrote cosign signature %s\n", cosign.SignatureFile(output))
+	}
+
+	if ociRef != "" {
+		if err := cosign.PushAndSign(cfg.CosignKey, ociRef, output); err != nil {
+			return fmt.Errorf("failed to publish OCI artifact: %w", err)
+		}
+
+		fmt.Printf("Pushed and signed %s\n", ociRef)
+	}
+
+	if since != "" {
+		fmt.Printf("Exported %d of %d packages, those added or changed since snapshot %s\n", shipped, len(mfest.Packages), since)
+	} else {
+		fmt.Printf("Exported %d packages\n", len(mfest.Packages))
+	}
+
+	if ipfsOpts.bundle || ipfsOpts.repository {
+		if err := addToIPFS(&cfg, mfest, output, &ipfsOpts); err != nil {
+			return fmt.Errorf("failed to add to IPFS: %w", err)
+		}
+	}
+
+	if zsyncOpts.enabled {
+		if err := writeZsync(output, &zsyncOpts); err != nil {
+			return err
+		}
+	}
+
+	if torrentOpts.enabled {
+		return writeTorrent(output, &torrentOpts)
+	}
+
+	return nil
+}
+
+// largeBundleSize is the package total above which export defaults to zstd, whose
+// multithreaded compression is much faster than gzip and packs tighter
+const largeBundleSize = 256 << 20
+
+// bundleCompression validates --compression, or picks zstd for large repositories
+// when it was not given and zstd is installed
+func bundleCompression(compression string, mfest *manifest.Manifest) (string, error) {
+	switch compression {
+	case bundle.CompressionGzip, bundle.CompressionNone:
+		return compression, nil
+
+	case bundle.CompressionZstd:
+		if !bundle.ZstdAvailable() {
+			return "", fmt.Errorf("--compression zstd needs the zstd program")
+		}
+
+		return compression, nil
+
+	case "":
+		var total int64
+
+		for _, pkg := range mfest.Packages {
+			if pkg.Downloaded {
+				total += pkg.Size
+			}
+		}
+
+		if total > largeBundleSize && bundle.ZstdAvailable() {
+			return bundle.CompressionZstd, nil
+		}
+
+		return bundle.CompressionGzip, nil
+	}
+
+	return "", fmt.Errorf("invalid --compression %q (zstd, gzip or none)", compression)
+}
+
+// RunImportCommand unpacks a bundle created by export into the repository directory
+func RunImportCommand(args []string) error {
+	var cfg config.Config
+
+	fs := newFlagSet("import", &cfg)
+	fs.StringVar(&cfg.DebSignatures, "deb-signatures", debsig.ModeOff, "Verify embedded .deb signatures: off, record or require")
+	fs.StringVar(&cfg.CosignPublicKey, "cosign-pub", "", "Cosign public key the bundle signature must verify against")
+	registerLicenseFlags(fs, &cfg)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: import [OPTIONS] BUNDLE")
+	}
+
+	// Verify before anything from the bundle touches the repository
+	if cfg.CosignPublicKey != "" {
+		if err := cosign.VerifyBlob(cfg.CosignPublicKey, fs.Arg(0)); err != nil {
+			return fmt.Errorf("bundle signature verification failed: %w", err)
+		}
+
+		fmt.Println("Verified cosign signature of bundle")
+	}
+
+	if err := validateSignatureMode(cfg.DebSignatures); err != nil {
+		return err
+	}
+
+	delta, err := readDeltaInfo(fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Importing %s into %s...\n", fs.Arg(0), cfg.RepoPath)
+
+	if delta != nil {
+		err = applyDelta(fs.Arg(0), cfg.RepoPath, delta)
+	} else {
+		err = bundle.Extract(fs.Arg(0), cfg.RepoPath)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d packages\n", len(mfest.Packages))
+
+	denied := checkImportedLicenses(&cfg, mfest)
+	unsigned := 0
+
+	if cfg.DebSignatures != debsig.ModeOff {
+		unsigned = verifyImportedSignatures(&cfg, mfest)
+	}
+
+	// The manifest records the signature statuses; the bundle's indexes still list the rejected packages
+	if denied > 0 || cfg.DebSignatures != debsig.ModeOff {
+		if err := manifest.Save(cfg.RepoPath, mfest); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
+	}
+
+	if denied+unsigned > 0 {
+		if err := publishMetadata(cfg.RepoPath, mfest, &cfg); err != nil {
+			return fmt.Errorf("failed to generate repository metadata: %w", err)
+		}
+	}
+
+	if denied > 0 {
+		fmt.Printf("Rejected %d packages under --deny-licenses\n", denied)
+	}
+
+	if unsigned > 0 {
+		return fmt.Errorf("rejected %d packages that failed signature verification", unsigned)
+	}
+
+	if cfg.DebSignatures != debsig.ModeOff {
+		fmt.Println("Package signature verification completed")
+	}
+
+	if isSigned(cfg.RepoPath, mfest.Distribution) {
+		absRepoPath, _ := filepath.Abs(cfg.RepoPath)
+
+		fmt.Println("\nTo use this repository on this machine:")
+		fmt.Printf("  sudo sh %s\n", filepath.Join(absRepoPath, setupScriptName))
+	}
+
+	return nil
+}
+
+// checkImportedLicenses applies the license policy to every imported package,
+// rejecting those it denies, and returns how many it rejected
+func checkImportedLicenses(cfg *config.Config, mfest *manifest.Manifest) int {
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	rejected := 0
+
+	for i := range mfest.Packages {
+		pkg := &mfest.Packages[i]
+
+		if !pkg.Downloaded {
+			continue
+		}
+
+		if err := checkLicenses(cfg, pkg, poolPath); err != nil {
+			output.Warning("Rejecting %s: %v", pkg.Name, err)
+			rejectPackage(pkg, poolPath, err)
+			rejected++
+		}
+	}
+
+	return rejected
+}
+
+// verifyImportedSignatures checks the embedded signatures of every imported package,
+// rejecting those --deb-signatures=require refuses, and returns how many it rejected
+func verifyImportedSignatures(cfg *config.Config, mfest *manifest.Manifest) int {
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	rejected := 0
+
+	for i := range mfest.Packages {
+		pkg := &mfest.Packages[i]
+
+		// Source packages carry no embedded .deb signatures
+		if !pkg.Downloaded || pkg.Type == packageinfo.TypeSource {
+			continue
+		}
+
+		if err := checkPackageSignature(pkg, poolPath, cfg.DebSignatures); err != nil {
+			if cfg.DebSignatures != debsig.ModeRequire {
+				output.Warning("%v", err)
+
+				continue
+			}
+
+			output.Warning("Rejecting %s: %v", pkg.Name, err)
+			rejectPackage(pkg, poolPath, err)
+			rejected++
+		}
+	}
+
+	return rejected
+}
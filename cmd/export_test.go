@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/debsig"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+)
+
+func TestVerifyImportedSignatures(t *testing.T) {
+	deb, err := os.ReadFile(buildDeb(t, "Public domain\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{debsig.ModeRecord, debsig.ModeRequire} {
+		repo := t.TempDir()
+		path := filepath.Join(repo, "pool", "app_1.0_all.deb")
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, deb, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		mfest := &manifest.Manifest{Packages: []packageinfo.PackageInfo{
+			{Name: "app", Version: "1.0", Architecture: "all", Filename: "app_1.0_all.deb", Downloaded: true},
+		}}
+		rejected := verifyImportedSignatures(&config.Config{RepoPath: repo, DebSignatures: mode}, mfest)
+		pkg := mfest.Packages[0]
+
+		if pkg.Signature != debsig.StatusUnsigned {
+			t.Errorf("%s: recorded signature %q, want %q", mode, pkg.Signature, debsig.StatusUnsigned)
+		}
+
+		_, statErr := os.Stat(path)
+
+		switch mode {
+		case debsig.ModeRecord:
+			if rejected != 0 || !pkg.Downloaded || statErr != nil {
+				t.Errorf("record: rejected %d, downloaded %v, pool file %v; want the package kept", rejected, pkg.Downloaded, statErr)
+			}
+		case debsig.ModeRequire:
+			if rejected != 1 || pkg.Downloaded || pkg.Failure == "" || !os.IsNotExist(statErr) {
+				t.Errorf("require: rejected %d, downloaded %v, pool file %v; want the package rejected", rejected, pkg.Downloaded, statErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+)
+
+// RegisterFlags defines the options shared by every mode and subcommand
+func RegisterFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.StringVar(&cfg.RepoPath, "repo", config.DefaultRepoPath, "Repository directory path")
+	fs.StringVar(&cfg.ConfigFile, "config", "", "JSON configuration file; its repositories add PPAs and third-party archives")
+	fs.StringVar(&cfg.Architecture, "arch", "amd64", "Target architecture")
+	fs.StringVar(&cfg.Distribution, "dist", "focal", "Target distribution (e.g., jammy, bookworm, stable, or Linux Mint's virginia)")
+	fs.StringVar(&cfg.KeyringHome, "keyring", "", "GPG home directory holding the repository signing key")
+	fs.StringVar(&cfg.SigningKey, "signing-key", "", "Fingerprint or key ID of the signing key (default: first secret key)")
+	fs.StringVar(&cfg.PINFile, "pin-file", "", "File holding the key passphrase or hardware token PIN")
+
+	fs.Func("archive-keyring", "Comma-separated keyrings verifying natively fetched Release files instead of the host's and vendor's", func(value string) error {
+		for _, keyring := range strings.Split(value, ",") {
+			path, err := filepath.Abs(keyring)
+
+			if err != nil {
+				return err
+			}
+
+			cfg.ArchiveKeyrings = append(cfg.ArchiveKeyrings, path)
+		}
+
+		return nil
+	})
+	fs.BoolVar(&cfg.AllowUnauthenticated, "allow-unauthenticated", false, "Go on, with a warning, when a natively fetched Release file fails to verify")
+	fs.BoolVar(&cfg.RequireSHA256, "require-sha256", false, "Refuse sources and metadata relying only on MD5 or SHA1 (kept by the repository once set)")
+	fs.BoolVar(&cfg.GitHistory, "git-history", false, "Keep a git history of the manifest and indexes, committing after every operation")
+	fs.Func("max-size", "Repository size quota, e.g. 50G (evicts packages when exceeded)", func(value string) error {
+		size, err := ParseSize(value)
+		cfg.MaxSize = size
+
+		return err
+	})
+	fs.Func("user-agent", "User-Agent for mirror requests, including apt's", func(value string) error {
+		remote.SetHeader("User-Agent", value)
+
+		return nil
+	})
+	fs.Func("header", "Extra 'Name: value' header for requests to the configured mirror hosts (repeatable)", func(value string) error {
+		name, headerValue, ok := strings.Cut(value, ":")
+
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("expected 'Name: value', got %q", value)
+		}
+
+		remote.SetHeader(strings.TrimSpace(name), strings.TrimSpace(headerValue))
+
+		return nil
+	})
+	fs.Func("proxy", "Proxy for every download, apt's included: http, https or socks5://[user:pass@]host:port", setProxy)
+	fs.Func("transport-plugin", "Fetch SCHEME:// URLs with the plugin portaptable-transport-NAME, as SCHEME=NAME (repeatable)", registerTransportPlugin)
+	fs.StringVar(&cfg.Eviction, "evict", "superseded,lru", "Eviction policies applied in order when over --max-size")
+
+	// Colors are also disabled automatically when stdout is not a terminal
+	fs.BoolFunc("no-color", "Disable colored output", func(string) error {
+		output.Configure(true)
+
+		return nil
+	})
+
+	fs.BoolFunc("quiet", "Only print errors and a final summary line", func(string) error {
+		output.SetQuiet(true)
+
+		return nil
+	})
+}
+
+// setProxy routes our downloads and apt's through proxy via the proxy environment,
+// which takes precedence over the host's apt proxy; NO_PROXY still applies. apt only
+// knows SOCKS as socks5h, which Go treats the same as socks5.
+func setProxy(value string) error {
+	proxy, err := url.Parse(value)
+
+	if err != nil || proxy.Host == "" {
+		return fmt.Errorf("expected a proxy URL such as socks5://host:1080, got %q", value)
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5h":
+	case "socks5":
+		proxy.Scheme = "socks5h"
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q (http, https or socks5)", proxy.Scheme)
+	}
+
+	for _, variable := range []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"} {
+		os.Setenv(variable, proxy.String())
+	}
+
+	return nil
+}
+
+// newFlagSet returns a flag set for a subcommand with the shared options registered
+func newFlagSet(name string, cfg *config.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	RegisterFlags(fs, cfg)
+
+	return fs
+}
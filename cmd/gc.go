@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/repolock"
+)
+
+// RunGCCommand deletes (or moves aside) pool files the manifest does not reference,
+// such as leftovers of interrupted runs or files copied in by hand
+func RunGCCommand(args []string) error {
+	var cfg config.Config
+	var dryRun bool
+	var moveTo string
+
+	fs := newFlagSet("gc", &cfg)
+	fs.BoolVar(&dryRun, "dry-run", false, "Only report the files that would be removed")
+	fs.StringVar(&moveTo, "move-to", "", "Move unreferenced files into this directory instead of deleting them")
+	fs.Parse(args)
+
+	// A download holding the lock has files in the pool its manifest does not list yet
+	lock, err := repolock.Acquire(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]bool)
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			referenced[pkg.Filename] = true
+		}
+	}
+
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	var unreferenced []string
+	var reclaimed int64
+
+	err = filepath.Walk(poolPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(poolPath, path)
+
+		if err != nil {
+			return err
+		}
+
+		if !referenced[filepath.ToSlash(rel)] {
+			unreferenced = append(unreferenced, rel)
+			reclaimed += info.Size()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to scan pool: %w", err)
+	}
+
+	for _, rel := range unreferenced {
+		switch {
+		case dryRun:
+			output.Info("Would remove %s", rel)
+
+		case moveTo != "":
+			if err := moveFile(filepath.Join(poolPath, rel), filepath.Join(moveTo, rel)); err != nil {
+				return err
+			}
+
+			output.Info("Moved %s", rel)
+
+		default:
+			if err := os.Remove(filepath.Join(poolPath, rel)); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", rel, err)
+			}
+
+			output.Info("Removed %s", rel)
+		}
+	}
+
+	verb := "Reclaimed"
+
+	if dryRun {
+		verb = "Would reclaim"
+	}
+
+	fmt.Printf("%s %s from %d unreferenced files\n", verb, formatSize(reclaimed), len(unreferenced))
+
+	return nil
+}
+
+// moveFile renames source to target, copying when they are on different filesystems
+func moveFile(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(source)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return fmt.Errorf("failed to move %s: %w", source, err)
+	}
+
+	return os.Remove(source)
+}
+
+// formatSize renders a byte count with a binary unit, e.g. "1.5 GiB"
+func formatSize(bytes int64) string {
+	const unit = 1024
+
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/repolock"
+)
+
+// writeGCRepository writes a repository whose pool holds one referenced and one
+// unreferenced file
+func writeGCRepository(t *testing.T) string {
+	t.Helper()
+
+	repo := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(repo, "pool"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app_1.0_amd64.deb", "stale_0.9_amd64.deb"} {
+		if err := os.WriteFile(filepath.Join(repo, "pool", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mfest := &manifest.Manifest{Packages: []packageinfo.PackageInfo{
+		{Name: "app", Version: "1.0", Architecture: "amd64", Filename: "app_1.0_amd64.deb", Downloaded: true},
+	}}
+
+	if err := manifest.Save(repo, mfest); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestGC(t *testing.T) {
+	repo := writeGCRepository(t)
+
+	if err := RunGCCommand([]string{"--repo", repo}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo, "pool", "app_1.0_amd64.deb")); err != nil {
+		t.Errorf("gc removed a referenced file: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo, "pool", "stale_0.9_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("gc kept an unreferenced file")
+	}
+}
+
+func TestGCLocked(t *testing.T) {
+	repo := writeGCRepository(t)
+	lock, err := repolock.Acquire(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lock.Unlock()
+
+	if err := RunGCCommand([]string{"--repo", repo}); !errors.Is(err, repolock.ErrLocked) {
+		t.Errorf("gc of a locked repository = %v, want %v", err, repolock.ErrLocked)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo, "pool", "stale_0.9_amd64.deb")); err != nil {
+		t.Errorf("gc of a locked repository removed a file: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/depgraph"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// graphNode is a resolved package in the exported dependency graph
+type graphNode struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Architecture string   `json:"architecture"`
+	Size         int64    `json:"size"`
+	ClosureSize  int64    `json:"closure_size"` // Size of the package and everything it pulls in
+	Requested    bool     `json:"requested,omitempty"`
+	PreDepends   []string `json:"pre_depends,omitempty"`
+	Depends      []string `json:"depends,omitempty"`
+}
+
+// writeDependencyGraph writes the resolved packages and the dependencies between them
+// to --graph: Graphviz DOT, or JSON for a .json file. Each package carries the size of
+// its closure, which shows what pulls in the bulk of a download.
+func (run *downloadRun) writeDependencyGraph(config *config.Config, requested, packages []string) error {
+	records := run.candidateParagraphs(packages)
+	infos := make([]packageinfo.PackageInfo, 0, len(records))
+	ids := make(map[string]string)
+
+	for _, pkg := range packages {
+		record, ok := records[pkg]
+
+		if !ok {
+			continue
+		}
+
+		size, _ := strconv.ParseInt(record["Size"], 10, 64)
+		infos = append(infos, packageinfo.PackageInfo{
+			Name:         record["Package"],
+			Version:      record["Version"],
+			Architecture: record["Architecture"],
+			Size:         size,
+			Control:      record,
+		})
+		ids[record["Package"]+":"+record["Architecture"]] = pkg
+	}
+
+	graph := depgraph.New(infos, config.Architecture)
+	graph.PreferProviders(run.preferredProviders)
+	graph.PreferAlternatives(run.alternativeChoices)
+
+	nodes := make([]graphNode, 0, len(infos))
+	sizes := make(map[string]int64)
+	edges := make(map[string][]string)
+
+	for _, info := range infos {
+		id := ids[info.Name+":"+info.Architecture]
+		node := graphNode{Name: id, Version: info.Version, Architecture: info.Architecture, Size: info.Size, Requested: contains(requested, id)}
+
+		for _, field := range depgraph.Hard {
+			for _, dep := range graph.Depends(info, field) {
+				if depID := ids[dep.Name+":"+dep.Architecture]; depID != id {
+					if field == "Pre-Depends" {
+						node.PreDepends = appendMissing(node.PreDepends, []string{depID})
+					} else {
+						node.Depends = appendMissing(node.Depends, []string{depID})
+					}
+
+					edges[id] = appendMissing(edges[id], []string{depID})
+				}
+			}
+		}
+
+		sizes[id] = info.Size
+		nodes = append(nodes, node)
+	}
+
+	for i := range nodes {
+		nodes[i].ClosureSize = closureSize(nodes[i].Name, edges, sizes)
+	}
+
+	// The heaviest closures first, which is where pruning pays off
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].ClosureSize > nodes[j].ClosureSize
+	})
+
+	var data []byte
+
+	if strings.HasSuffix(config.GraphFile, ".json") {
+		var err error
+
+		if data, err = json.MarshalIndent(map[string]interface{}{"requested": requested, "packages": nodes}, "", "  "); err != nil {
+			return fmt.Errorf("failed to marshal dependency graph: %w", err)
+		}
+	} else {
+		data = []byte(dependencyDOT(nodes))
+	}
+
+	if err := os.WriteFile(config.GraphFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write dependency graph: %w", err)
+	}
+
+	output.Info("Wrote the dependency graph of %d packages to %s", len(nodes), config.GraphFile)
+
+	return nil
+}
+
+// closureSize returns the total size of id and the packages it reaches through edges
+func closureSize(id string, edges map[string][]string, sizes map[string]int64) int64 {
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	var total int64
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		total += sizes[current]
+
+		for _, dep := range edges[current] {
+			if !seen[dep] {
+				seen[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	return total
+}
+
+// dependencyDOT renders the graph for Graphviz: requested packages in bold, Pre-Depends
+// as bold edges, and each package labelled with its own and its closure's size
+func dependencyDOT(nodes []graphNode) string {
+	var b strings.Builder
+
+	b.WriteString("digraph dependencies {\n\trankdir=LR;\n\tnode [shape=box];\n")
+
+	for _, node := range nodes {
+		style := ""
+
+		if node.Requested {
+			style = ", style=bold"
+		}
+
+		fmt.Fprintf(&b, "\t%q [label=\"%s\\n%s\\n%s, closure %s\"%s];\n", node.Name, node.Name, node.Version, formatSize(node.Size), formatSize(node.ClosureSize), style)
+	}
+
+	for _, node := range nodes {
+		for _, dep := range node.PreDepends {
+			fmt.Fprintf(&b, "\t%q -> %q [style=bold];\n", node.Name, dep)
+		}
+
+		for _, dep := range node.Depends {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", node.Name, dep)
+		}
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
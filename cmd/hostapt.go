@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"portaptable/pkg/archive"
+	"portaptable/pkg/config"
+	"portaptable/pkg/hostapt"
+	"portaptable/pkg/output"
+)
+
+// hostAptRoot is the root directory whose etc/apt supplies the host defaults
+const hostAptRoot = "/"
+
+// debianArchitectures maps Go architectures to Debian's names for them
+var debianArchitectures = map[string]string{
+	"amd64":    "amd64",
+	"arm64":    "arm64",
+	"arm":      "armhf",
+	"386":      "i386",
+	"ppc64le":  "ppc64el",
+	"s390x":    "s390x",
+	"riscv64":  "riscv64",
+	"mips64le": "mips64el",
+}
+
+// hostSources returns the host's enabled binary sources, read once
+var hostSources = sync.OnceValue(func() []hostapt.Source {
+	sources, err := hostapt.ReadSources(hostAptRoot)
+
+	if err != nil {
+		output.Warning("Failed to read the host's apt sources: %v", err)
+
+		return nil
+	}
+
+	var binary []hostapt.Source
+
+	for _, source := range sources {
+		if source.Type == "deb" {
+			binary = append(binary, source)
+		}
+	}
+
+	return binary
+})
+
+// hostMirror returns the host's archive URI for distribution, or empty if the host does
+// not use that suite or runs a different architecture (whose mirror may not carry ours)
+func (run *downloadRun) hostMirror(distribution, architecture string) string {
+	// The host's sources cannot tell Raspbian's releases from Debian's
+	if run.distroVendors[distribution] == "raspbian" || debianArchitectures[runtime.GOARCH] != architecture {
+		return ""
+	}
+
+	for _, source := range hostSources() {
+		if !contains(source.Suites, distribution) {
+			continue
+		}
+
+		for _, uri := range source.URIs {
+			if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") || strings.HasPrefix(uri, "file:") {
+				return strings.TrimSuffix(normalizeMirror(uri), "/")
+			}
+		}
+	}
+
+	return ""
+}
+
+// hostSourceKeyrings returns the keyrings the host's source for uri and suite is
+// Signed-By, so fetching from it trusts exactly those keys as apt would. An embedded
+// key is written to keyringDir. It is empty when no host source names keyrings.
+func hostSourceKeyrings(uri, suite, keyringDir string) ([]string, error) {
+	for _, source := range hostSources() {
+		if !contains(source.Suites, suite) {
+			continue
+		}
+
+		for _, sourceURI := range source.URIs {
+			if strings.TrimSuffix(normalizeMirror(sourceURI), "/") != strings.TrimSuffix(normalizeMirror(uri), "/") {
+				continue
+			}
+
+			if source.SignedKey == "" {
+				return source.Keyrings(), nil
+			}
+
+			return embeddedKeyring(source.SignedKey, keyringDir)
+		}
+	}
+
+	return nil, nil
+}
+
+// embeddedKeyring stores a key embedded in a source definition as an armored keyring
+// named after its content, which apt and gpgv both read
+func embeddedKeyring(key, keyringDir string) ([]string, error) {
+	sum := sha256.Sum256([]byte(key))
+	path, err := filepath.Abs(filepath.Join(keyringDir, "signed-by-"+hex.EncodeToString(sum[:6])+".asc"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(keyringDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, []byte(key), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write embedded Signed-By key: %w", err)
+	}
+
+	return []string{path}, nil
+}
+
+// verifyMirrorRelease checks the suite's InRelease (or Release.gpg) and makes the mirror
+// check every index against it. The keys are --archive-keyring's, else the Signed-By
+// keyrings of the host's source for the mirror, else those apt trusts for the vendor
+// archive. A Release file they do not verify stops the run unless --allow-unauthenticated.
+func (run *downloadRun) verifyMirrorRelease(mirror *archive.Mirror, dist, repoPath string) error {
+	keyrings := run.archiveKeyrings
+
+	if len(keyrings) == 0 {
+		var err error
+
+		if keyrings, err = hostSourceKeyrings(mirror.URL, dist, filepath.Join(repoPath, aptDir, "keyrings")); err != nil {
+			return err
+		}
+	}
+
+	// No host source lists a snapshot, which is signed with the vendor's archive keys
+	if vendor := snapshotVendor(mirror.URL); len(keyrings) == 0 && vendor != "" && run.vendorKeyring(vendor) != "" {
+		keyrings = []string{run.vendorKeyring(vendor)}
+	}
+
+	if len(keyrings) == 0 {
+		keyrings = run.distroKeyrings(dist)
+	}
+
+	return run.verifyReleaseWith(mirror, dist, keyrings)
+}
+
+// verifyReleaseWith checks the suite's InRelease (or Release.gpg) against keyrings and
+// makes the mirror check every index against it
+func (run *downloadRun) verifyReleaseWith(mirror *archive.Mirror, dist string, keyrings []string) error {
+	if len(keyrings) == 0 && mirror.RequireSHA256 {
+		return fmt.Errorf("%s %s fails the SHA256 policy: no keyring verifies its Release file, so its SHA256 checksums cannot be trusted", mirror.URL, dist)
+	}
+
+	if len(keyrings) == 0 {
+		return run.unauthenticatedRelease(mirror, dist, fmt.Errorf("no keyring to verify its Release file with"))
+	}
+
+	release, checksums, err := mirror.FetchVerifiedRelease(dist, keyrings)
+
+	if err != nil {
+		return run.unauthenticatedRelease(mirror, dist, err)
+	}
+
+	if mirror.Logf != nil {
+		mirror.Logf("Verified %s %s against %s", mirror.URL, dist, strings.Join(keyrings, ", "))
+	}
+
+	mirror.Checksums, mirror.Release = checksums, release
+
+	return nil
+}
+
+// unauthenticatedRelease fails on a Release file that could not be verified, or lets the
+// run go on with a warning under --allow-unauthenticated, which the SHA256 policy overrides
+func (run *downloadRun) unauthenticatedRelease(mirror *archive.Mirror, dist string, cause error) error {
+	if !run.allowUnauthenticated || mirror.RequireSHA256 {
+		return fmt.Errorf("failed to verify %s %s: %w (name its keyring with --archive-keyring, or pass --allow-unauthenticated)", mirror.URL, dist, cause)
+	}
+
+	output.Warning("%s %s is not authenticated (%v); continuing as --allow-unauthenticated permits", mirror.URL, dist, cause)
+
+	return nil
+}
+
+// distroKeyrings returns the keyrings apt trusts for sources without Signed-By: the
+// archive keyring of the suite's distribution and the host's trusted.gpg keyrings
+func (run *downloadRun) distroKeyrings(suite string) []string {
+	var keyrings []string
+
+	if keyring := run.archiveKeyring(suiteDistribution(suite)); keyring != "" {
+		keyrings = append(keyrings, keyring)
+	}
+
+	trusted := filepath.Join(hostAptRoot, "etc/apt/trusted.gpg")
+
+	if _, err := os.Stat(trusted); err == nil {
+		keyrings = append(keyrings, trusted)
+	}
+
+	for _, pattern := range []string{"*.gpg", "*.asc"} {
+		matches, _ := filepath.Glob(filepath.Join(trusted+".d", pattern))
+		keyrings = appendMissing(keyrings, matches)
+	}
+
+	return keyrings
+}
+
+// pocketSuffixes are the suite suffixes of a distribution's pockets
+var pocketSuffixes = []string{"-proposed-updates", "-" + pocketUpdates, "-" + pocketSecurity, "-" + pocketProposed, "-" + pocketBackports}
+
+// suiteDistribution returns the distribution a suite belongs to, e.g. jammy for jammy-security
+func suiteDistribution(suite string) string {
+	for _, suffix := range pocketSuffixes {
+		if distribution, ok := strings.CutSuffix(suite, suffix); ok {
+			return distribution
+		}
+	}
+
+	return suite
+}
+
+// hostDistribution returns the distribution of the host's sources: the first suite that
+// also appears with a pocket (jammy alongside jammy-updates), which tells the vendor
+// archive apart from third-party repositories with suites like "stable"
+func hostDistribution() string {
+	suites := make(map[string]bool)
+	var ordered []string
+
+	for _, source := range hostSources() {
+		for _, suite := range source.Suites {
+			if !suites[suite] {
+				suites[suite] = true
+				ordered = append(ordered, suite)
+			}
+		}
+	}
+
+	for _, suite := range ordered {
+		for _, suffix := range pocketSuffixes {
+			if suites[suite+suffix] {
+				return suite
+			}
+		}
+	}
+
+	return ""
+}
+
+// ApplyHostDefaults takes the distribution (unless given on the command line) and
+// the proxies from the host's apt configuration, read without running apt
+func ApplyHostDefaults(config *config.Config, distributionSet bool) {
+	if !distributionSet {
+		if distribution := hostDistribution(); distribution != "" {
+			config.Distribution = distribution
+		}
+	}
+
+	aptConfig, err := hostapt.ReadConfig(hostAptRoot)
+
+	if err != nil {
+		output.Warning("Failed to read the host's apt configuration: %v", err)
+
+		return
+	}
+
+	// The proxy environment reaches both our own downloads and apt's
+	for _, scheme := range []string{"http", "https"} {
+		variable := scheme + "_proxy"
+		proxy := aptConfig.Proxy(scheme)
+
+		if proxy == "" || os.Getenv(variable) != "" || os.Getenv(strings.ToUpper(variable)) != "" {
+			continue
+		}
+
+		output.Info("Using %s proxy %s from the host's apt configuration", scheme, proxy)
+		os.Setenv(variable, proxy)
+	}
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// previousManifest returns the manifest of the last download into the repository,
+// nil when there is none or it was built for another distribution or architecture
+func previousManifest(config *config.Config) *manifest.Manifest {
+	previous, err := manifest.Load(config.RepoPath)
+
+	if err != nil || previous.Distribution != config.Distribution || previous.Architecture != config.Architecture {
+		return nil
+	}
+
+	return previous
+}
+
+// reusePooled splits the resolved packages into those to download and the manifest
+// entries of those the pool already holds: the candidate's file with its SHA256
+func (run *downloadRun) reusePooled(packages []string, previous *manifest.Manifest, poolPath string) ([]string, []packageinfo.PackageInfo) {
+	if previous == nil {
+		return packages, nil
+	}
+
+	pooled := make(map[string]packageinfo.PackageInfo)
+
+	for _, pkg := range previous.Packages {
+		if pkg.Downloaded && pkg.Type == "" {
+			pooled[pkg.Filename] = pkg
+		}
+	}
+
+	records := run.candidateParagraphs(packages)
+	var remaining []string
+	var reused []packageinfo.PackageInfo
+
+	for _, spec := range packages {
+		record := records[spec]
+		pkg, ok := pooled[filepath.Base(record["Filename"])]
+
+		if !ok || record["SHA256"] == "" || pkg.SHA256 != record["SHA256"] || !pooledIntact(pkg, poolPath) {
+			remaining = append(remaining, spec)
+
+			continue
+		}
+
+		reused = append(reused, pkg)
+	}
+
+	if len(reused) > 0 {
+		output.Info("Skipping %d packages already in the pool", len(reused))
+	}
+
+	return remaining, reused
+}
+
+// pooledIntact reports whether a pool file still has its recorded SHA256, trusting an
+// unchanged size and modification time as verify does
+func pooledIntact(pkg packageinfo.PackageInfo, poolPath string) bool {
+	path := filepath.Join(poolPath, pkg.Filename)
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return false
+	}
+
+	if pkg.MTime != 0 && pkg.MTime == info.ModTime().UnixNano() && pkg.Size == info.Size() {
+		return true
+	}
+
+	sums, err := checksum.File(path)
+
+	if err != nil {
+		return false
+	}
+
+	return sums.SHA256 == pkg.SHA256
+}
+
+// mergePrevious adds the previous manifest's downloaded files this run did not
+// replace, and its requested packages, so each run extends the repository
+func mergePrevious(mfest, previous *manifest.Manifest, poolPath string) {
+	current := make(map[string]bool)
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			current[pkg.Filename] = true
+		}
+	}
+
+	kept := 0
+
+	for _, pkg := range previous.Packages {
+		if !pkg.Downloaded || current[pkg.Filename] || !poolFileExists(poolPath, pkg.Filename) {
+			continue
+		}
+
+		current[pkg.Filename] = true
+		mfest.Packages = append(mfest.Packages, pkg)
+		kept++
+	}
+
+	mfest.Requested = appendMissing(previous.Requested, mfest.Requested)
+
+	if kept > 0 {
+		output.Info("Kept %d packages of earlier runs (--fresh drops them)", kept)
+	}
+}
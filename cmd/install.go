@@ -7,37 +7,135 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"portaptable/pkg/changelog"
 	"portaptable/pkg/config"
 	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/repometa"
+	"portaptable/pkg/servestats"
+	"portaptable/pkg/signing"
+	"portaptable/pkg/snapshot"
 )
 
 type RepositoryServer struct {
 	config   *config.Config
+	mu       sync.RWMutex
 	manifest *manifest.Manifest
+	signed   bool
+	stats    *servestats.Recorder
+
+	// refresh reports the daemon's scheduled refreshes; nil in plain serve mode
+	refresh *refreshStatus
+
+	// mux routes the repository's URLs; a multi-tenant server mounts it below prefix
+	mux    *http.ServeMux
+	prefix string
+
+	// tenant holds the credentials a multi-tenant server requires; nil serves openly
+	tenant *tenant
+
+	// verifier checks pool files before they are served; nil serves them unchecked
+	verifier *poolVerifier
 }
 
+// statsFlushInterval is how often serve statistics are written for quota eviction
+const statsFlushInterval = time.Minute
+
 func RunServeMode(config *config.Config) error {
-	server := &RepositoryServer{config: config}
+	// A snapshot is a complete repository of its own
+	if config.Snapshot != "" {
+		if err := snapshot.ValidName(config.Snapshot); err != nil {
+			return err
+		}
+
+		config.RepoPath = snapshot.Path(config.RepoPath, config.Snapshot)
+	}
+
+	if config.TenantsFile != "" {
+		return serveTenants(config)
+	}
+
+	if config.EmitConfig != "" && config.ConnectionRate > 0 {
+		return fmt.Errorf("--conn-rate applies to the built-in server; set the web server's own limit instead (e.g. nginx limit_rate)")
+	}
+
+	if config.EmitConfig != "" {
+		return emitWebServerConfig(config, config.EmitConfig)
+	}
+
+	server, err := newRepositoryServer(config)
+
+	if err != nil {
+		return err
+	}
+
+	return server.listen()
+}
+
+// newRepositoryServer loads the repository and registers the HTTP handlers serving it
+func newRepositoryServer(config *config.Config) (*RepositoryServer, error) {
+	server := &RepositoryServer{config: config, mux: http.NewServeMux()}
 
 	// Load and validate repository
 	if err := server.loadRepository(); err != nil {
-		return fmt.Errorf("failed to load repository: %w", err)
+		return nil, fmt.Errorf("failed to load repository: %w", err)
+	}
+
+	stats, err := servestats.Open(config.RepoPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	server.stats = stats
+	go server.flushStats()
+
+	if config.VerifyPool {
+		server.verifier = newPoolVerifier()
 	}
 
 	// Setup HTTP handlers
 	server.setupRoutes()
 
-	fmt.Printf("Starting repository server on http://localhost:%s\n", config.Port)
-	fmt.Printf("Repository path: %s\n", config.RepoPath)
-	fmt.Printf("Serving %d packages\n", len(server.manifest.Packages))
+	return server, nil
+}
+
+// listen prints the target setup instructions and serves until the process stops
+func (s *RepositoryServer) listen() error {
+	fmt.Printf("Starting repository server on http://localhost:%s\n", s.config.Port)
+	fmt.Printf("Repository path: %s\n", s.config.RepoPath)
+	fmt.Printf("Serving %d packages\n", len(s.current().Packages))
 	fmt.Println("\nTo use this repository on the target machine:")
-	fmt.Printf("  echo 'deb [trusted=yes] http://localhost:%s/ %s main' | sudo tee /etc/apt/sources.list.d/portaptable.list\n",
-		config.Port, config.Distribution)
-	fmt.Println("  sudo apt update")
+
+	for _, line := range s.setupInstructions("localhost:" + s.config.Port) {
+		fmt.Printf("  %s\n", line)
+	}
+
+	if s.config.ConnectionRate > 0 {
+		fmt.Printf("\nEach connection is limited to %s/s\n", formatSize(s.config.ConnectionRate))
+	}
+
 	fmt.Println("\nPress Ctrl+C to stop the server")
 
-	return http.ListenAndServe(":"+config.Port, nil)
+	return serveHTTP(":"+s.config.Port, s.mux, s.config.ConnectionRate, s.saveStats)
+}
+
+// flushStats periodically persists which pool files were served
+func (s *RepositoryServer) flushStats() {
+	for range time.Tick(statsFlushInterval) {
+		s.saveStats()
+	}
+}
+
+// saveStats writes the serve statistics recorded since the last flush
+func (s *RepositoryServer) saveStats() {
+	if err := s.stats.Flush(); err != nil {
+		output.Warning("%v", err)
+	}
 }
 
 func (s *RepositoryServer) loadRepository() error {
@@ -47,60 +145,125 @@ func (s *RepositoryServer) loadRepository() error {
 	}
 
 	// Load manifest
-	manifestPath := filepath.Join(s.config.RepoPath, "manifest.json")
-	manifestData, err := os.ReadFile(manifestPath)
+	mfest, err := manifest.Load(s.config.RepoPath)
 
 	if err != nil {
-		return fmt.Errorf("failed to read manifest: %w", err)
+		return err
 	}
 
-	s.manifest = &manifest.Manifest{}
+	signed := isSigned(s.config.RepoPath, mfest.Distribution)
+
+	s.mu.Lock()
+	s.manifest, s.signed = mfest, signed
+	s.mu.Unlock()
 
-	if err := json.Unmarshal(manifestData, s.manifest); err != nil {
-		return fmt.Errorf("failed to parse manifest: %w", err)
+	if !signed {
+		output.Warning("Repository metadata is not signed; targets will need [trusted=yes]")
 	}
 
 	// Validate that packages exist
 	poolPath := filepath.Join(s.config.RepoPath, "pool")
 	missingCount := 0
 
-	for _, pkg := range s.manifest.Packages {
+	for _, pkg := range mfest.Packages {
 		if pkg.Downloaded {
 			pkgPath := filepath.Join(poolPath, pkg.Filename)
 
 			if _, err := os.Stat(pkgPath); os.IsNotExist(err) {
-				fmt.Printf("Warning: Package file missing: %s\n", pkg.Filename)
+				output.Warning("Package file missing: %s", pkg.Filename)
 				missingCount++
 			}
 		}
 	}
 
 	if missingCount > 0 {
-		fmt.Printf("Warning: %d package files are missing from the repository\n", missingCount)
+		output.Warning("%d package files are missing from the repository", missingCount)
 	}
 
 	return nil
 }
 
+// current returns the manifest being served; a refresh may replace it at any time
+func (s *RepositoryServer) current() *manifest.Manifest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.manifest
+}
+
+// isSigned reports whether the served metadata carries signatures
+func (s *RepositoryServer) isSigned() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.signed
+}
+
 func (s *RepositoryServer) setupRoutes() {
 	// Serve the repository root
-	http.HandleFunc("/", s.handleRepositoryRoot)
+	s.mux.HandleFunc("/", s.handleRepositoryRoot)
 
 	// Serve distribution metadata
-	http.HandleFunc("/dists/", s.handleDists)
+	s.mux.HandleFunc("/dists/", s.handleDists)
 
 	// Serve package pool
-	http.HandleFunc("/pool/", s.handlePool)
+	s.mux.HandleFunc("/pool/", s.handlePool)
 
 	// Serve generated Packages file
-	http.HandleFunc(fmt.Sprintf("/dists/%s/main/binary-%s/Packages",
-		s.manifest.Distribution, s.manifest.Architecture), s.handlePackagesFile)
+	s.mux.HandleFunc(fmt.Sprintf("/dists/%s/main/binary-%s/Packages",
+		s.current().Distribution, s.current().Architecture), s.handlePackagesFile)
+
+	// Changelogs for offline 'apt changelog'
+	s.mux.HandleFunc("/changelogs/", s.handleChangelogs)
+
+	// Public signing key and target setup script
+	s.mux.HandleFunc("/"+signing.PublicKeyringName, s.handleKeyring)
+	s.mux.HandleFunc("/"+setupScriptName, s.handleSetupScript)
+
+	// Manifest for 'sync' replicas
+	s.mux.HandleFunc("/"+manifest.FileName, s.handleManifest)
+
+	// Dependency queries for target-side tooling
+	s.mux.HandleFunc(dependsAPIPath, s.handleDepends)
+	s.mux.HandleFunc(rdependsAPIPath, s.handleRdepends)
+
+	// File lists for offline "which package ships this path" queries
+	s.mux.HandleFunc(contentsAPIPath, s.handleContents)
 
 	// Health check endpoint
-	http.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/health", s.handleHealth)
 
 	// Repository info endpoint
-	http.HandleFunc("/info", s.handleInfo)
+	s.mux.HandleFunc("/info", s.handleInfo)
+}
+
+// setupInstructions returns the shell commands that point apt on a target at this server
+func (s *RepositoryServer) setupInstructions(host string) []string {
+	var instructions []string
+
+	// apt reads a tenant's credentials from auth.conf.d before it can fetch anything
+	if s.tenant != nil {
+		instructions = append(instructions, s.tenant.authInstruction(host+s.prefix))
+	}
+
+	if !s.isSigned() {
+		instructions = append(instructions,
+			fmt.Sprintf("echo 'deb [trusted=yes] http://%s%s/ %s main' | sudo tee /etc/apt/sources.list.d/portaptable.list",
+				host, s.prefix, s.current().Distribution))
+
+		if repometa.HasSources(s.current()) {
+			instructions = append(instructions,
+				fmt.Sprintf("echo 'deb-src [trusted=yes] http://%s%s/ %s main' | sudo tee -a /etc/apt/sources.list.d/portaptable.list",
+					host, s.prefix, s.current().Distribution))
+		}
+
+		return append(instructions, "sudo apt update")
+	}
+
+	return append(instructions,
+		fmt.Sprintf("/usr/lib/apt/apt-helper download-file http://%s%s/%s /tmp/%s", host, s.prefix, setupScriptName, setupScriptName),
+		fmt.Sprintf("sudo sh /tmp/%s", setupScriptName),
+	)
 }
 
 func (s *RepositoryServer) handleRepositoryRoot(w http.ResponseWriter, r *http.Request) {
@@ -116,17 +279,19 @@ func (s *RepositoryServer) handleRepositoryRoot(w http.ResponseWriter, r *http.R
     <h1>Portaptable - Portable APT Repository</h1>
     <p>This is a local APT repository serving %d packages.</p>
     <h2>Usage:</h2>
-    <pre>echo 'deb [trusted=yes] http://localhost:%s/ %s main' | sudo tee /etc/apt/sources.list.d/portaptable.list
-sudo apt update</pre>
+    <pre>%s</pre>
     <h2>Available Endpoints:</h2>
     <ul>
         <li><a href="/info">/info</a> - Repository information</li>
         <li><a href="/health">/health</a> - Health check</li>
         <li><a href="/dists/">/dists/</a> - Distribution metadata</li>
         <li><a href="/pool/">/pool/</a> - Package files</li>
+        <li>/api/v1/depends/PACKAGE - What installing a package pulls in (?recommends=1)</li>
+        <li>/api/v1/rdepends/PACKAGE - Packages depending on a package (?recursive=1)</li>
+        <li>/api/v1/contents/PACKAGE - Files a package installs (/api/v1/contents/?path=PATH finds the owning packages)</li>
     </ul>
 </body>
-</html>`, len(s.manifest.Packages), s.config.Port, s.manifest.Distribution)
+</html>`, len(s.current().Packages), strings.Join(s.setupInstructions(r.Host), "\n"))
 		return
 	}
 
@@ -185,47 +350,123 @@ func (s *RepositoryServer) handlePool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set appropriate headers for .deb files
-	if strings.HasSuffix(filename, ".deb") {
+	if s.verifier != nil {
+		if err := s.verifier.check(filename, filePath, s.poolPackage(filename)); err != nil {
+			http.Error(w, fmt.Sprintf("%s failed verification against the repository manifest: %v", filename, err),
+				http.StatusServiceUnavailable)
+
+			return
+		}
+	}
+
+	// Set appropriate headers for .deb and .ddeb files
+	if strings.HasSuffix(filename, ".deb") || strings.HasSuffix(filename, ".ddeb") {
 		w.Header().Set("Content-Type", "application/vnd.debian.binary-package")
 	}
 
 	// Serve the file
 	http.ServeFile(w, r, filePath)
+	s.stats.Record(filename)
+
+	return
+}
+
+// poolPackage returns the manifest entry of a pool file, or nil when none lists it
+func (s *RepositoryServer) poolPackage(filename string) *packageinfo.PackageInfo {
+	packages := s.current().Packages
+
+	for i := range packages {
+		if packages[i].Filename == filename {
+			return &packages[i]
+		}
+	}
+
+	return nil
+}
+
+func (s *RepositoryServer) handleChangelogs(w http.ResponseWriter, r *http.Request) {
+	// Remove /changelogs/ prefix
+	path := strings.TrimPrefix(r.URL.Path, "/changelogs/")
+
+	// Serve files from the changelogs directory
+	filePath := filepath.Join(s.config.RepoPath, changelog.Dir, path)
+
+	// Security check
+	absRepoPath, _ := filepath.Abs(s.config.RepoPath)
+	absFilePath, _ := filepath.Abs(filePath)
+
+	if !strings.HasPrefix(absFilePath, absRepoPath) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+
+		return
+	}
+
+	// Check if file exists
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, r, filePath)
 
 	return
 }
 
 func (s *RepositoryServer) handlePackagesFile(w http.ResponseWriter, r *http.Request) {
-	// Generate Packages file content on-demand
 	w.Header().Set("Content-Type", "text/plain")
 
+	// Prefer the generated index so its checksums match the Release file
+	indexPath := filepath.Join(repometa.BinaryPath(s.config.RepoPath, s.current().Distribution, s.current().Architecture), "Packages")
+
+	if _, err := os.Stat(indexPath); err == nil {
+		http.ServeFile(w, r, indexPath)
+
+		return
+	}
+
+	// Generate Packages file content on-demand for repositories without indexes
 	poolPath := filepath.Join(s.config.RepoPath, "pool")
+	mfest := s.current()
 
-	for _, pkg := range s.manifest.Packages {
-		if !pkg.Downloaded {
-			continue
-		}
+	if err := repometa.WritePackages(w, mfest.Packages, poolPath, mfest.RequireSHA256); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	return
+}
 
-		pkgPath := filepath.Join(poolPath, pkg.Filename)
+func (s *RepositoryServer) handleKeyring(w http.ResponseWriter, r *http.Request) {
+	if !s.isSigned() {
+		http.NotFound(w, r)
 
-		if _, err := os.Stat(pkgPath); os.IsNotExist(err) {
-			continue // Skip missing files
-		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pgp-keys")
+	http.ServeFile(w, r, filepath.Join(s.config.RepoPath, signing.PublicKeyringName))
+
+	return
+}
 
-		// Generate basic package entry
-		fmt.Fprintf(w, "Package: %s\n", pkg.Name)
-		fmt.Fprintf(w, "Version: %s\n", pkg.Version)
-		fmt.Fprintf(w, "Architecture: %s\n", pkg.Architecture)
-		fmt.Fprintf(w, "Filename: pool/%s\n", pkg.Filename)
-		fmt.Fprintf(w, "Size: %d\n", pkg.Size)
+func (s *RepositoryServer) handleSetupScript(w http.ResponseWriter, r *http.Request) {
+	if !s.isSigned() {
+		http.NotFound(w, r)
 
-		// TODO: Add MD5sum, SHA1, SHA256 checksums
-		// For now, apt will work without them if we use [trusted=yes]
-		fmt.Fprintf(w, "Description: Package downloaded by portaptable\n")
-		fmt.Fprintf(w, "\n") // Empty line separates packages
+		return
 	}
 
+	w.Header().Set("Content-Type", "text/x-shellscript")
+	fmt.Fprint(w, repometa.SetupScript("http://"+r.Host+s.prefix, s.current().Distribution, signing.PublicKeyringName, repometa.HasSources(s.current())))
+
+	return
+}
+
+func (s *RepositoryServer) handleManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, filepath.Join(s.config.RepoPath, manifest.FileName))
+
 	return
 }
 
@@ -234,11 +475,11 @@ func (s *RepositoryServer) handleHealth(w http.ResponseWriter, r *http.Request)
 
 	health := map[string]interface{}{
 		"status":         "ok",
-		"packages_total": len(s.manifest.Packages),
+		"packages_total": len(s.current().Packages),
 		"packages_downloaded": func() int {
 			count := 0
 
-			for _, pkg := range s.manifest.Packages {
+			for _, pkg := range s.current().Packages {
 				if pkg.Downloaded {
 					count++
 				}
@@ -247,9 +488,22 @@ func (s *RepositoryServer) handleHealth(w http.ResponseWriter, r *http.Request)
 			return count
 		}(),
 		"repository_path": s.config.RepoPath,
-		"distribution":    s.manifest.Distribution,
-		"architecture":    s.manifest.Architecture,
-		"created_at":      s.manifest.CreatedAt,
+		"distribution":    s.current().Distribution,
+		"architecture":    s.current().Architecture,
+		"created_at":      s.current().CreatedAt,
+	}
+
+	if s.refresh != nil {
+		health["refresh"] = s.refresh.snapshot()
+	}
+
+	if s.verifier != nil {
+		corrupt := s.verifier.corrupt()
+		health["corrupt_files"] = corrupt
+
+		if len(corrupt) > 0 {
+			health["status"] = "degraded"
+		}
 	}
 
 	json.NewEncoder(w).Encode(health)
@@ -263,16 +517,13 @@ func (s *RepositoryServer) handleInfo(w http.ResponseWriter, r *http.Request) {
 	info := map[string]interface{}{
 		"repository": map[string]interface{}{
 			"path":         s.config.RepoPath,
-			"distribution": s.manifest.Distribution,
-			"architecture": s.manifest.Architecture,
-			"created_at":   s.manifest.CreatedAt,
-		},
-		"packages": s.manifest.Packages,
-		"usage": map[string]string{
-			"add_repo": fmt.Sprintf("echo 'deb [trusted=yes] http://localhost:%s/ %s main' | sudo tee /etc/apt/sources.list.d/portaptable.list",
-				s.config.Port, s.manifest.Distribution),
-			"update": "sudo apt update",
+			"distribution": s.current().Distribution,
+			"architecture": s.current().Architecture,
+			"created_at":   s.current().CreatedAt,
 		},
+		"packages": s.current().Packages,
+		"signed":   s.isSigned(),
+		"usage":    s.setupInstructions(r.Host),
 	}
 
 	json.NewEncoder(w).Encode(info)
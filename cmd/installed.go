@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"portaptable/pkg/config"
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/output"
+)
+
+// dropInstalled drops the resolved packages the target's dpkg status (--status-file)
+// already has at the candidate version or a newer one, so only what it lacks is shipped
+func (run *downloadRun) dropInstalled(cfg *config.Config, packages []string) ([]string, error) {
+	if cfg.StatusFile == "" {
+		return packages, nil
+	}
+
+	installed, err := installedPackages(cfg.StatusFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Installed versions, keyed like the resolved package list
+	versions := make(map[string]string, len(installed))
+	qualified := qualifiedArchitectures(cfg)
+
+	for _, pkg := range installed {
+		key := pkg.Name
+
+		if contains(qualified, pkg.Architecture) {
+			key += ":" + pkg.Architecture
+		}
+
+		versions[key] = pkg.Version
+	}
+
+	records := run.candidateParagraphs(packages)
+	var kept []string
+	dropped := 0
+
+	for _, pkg := range packages {
+		version, ok := versions[pkg]
+		candidate := records[pkg]["Version"]
+
+		if ok && candidate != "" && debversion.Compare(version, candidate) >= 0 {
+			dropped++
+
+			continue
+		}
+
+		kept = append(kept, pkg)
+	}
+
+	output.Info("Target already has %d of the resolved packages; %d remain", dropped, len(kept))
+
+	return kept, nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/depgraph"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// recordInstallOrder stores in the manifest the batches in which tooling without apt
+// feeds the pool's packages to dpkg -i: Pre-Depends in earlier batches than the
+// packages needing them configured. Only the newest version of each package counts,
+// and packages of additional architectures are left out, being for other targets.
+func (run *downloadRun) recordInstallOrder(mfest *manifest.Manifest) {
+	newest := make(map[string]packageinfo.PackageInfo)
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded || pkg.Type != "" || contains(mfest.Additional, pkg.Architecture) {
+			continue
+		}
+
+		key := pkg.Name + ":" + pkg.Architecture
+
+		if current, ok := newest[key]; !ok || debversion.Compare(pkg.Version, current.Version) > 0 {
+			newest[key] = pkg
+		}
+	}
+
+	packages := make([]packageinfo.PackageInfo, 0, len(newest))
+
+	for _, pkg := range newest {
+		packages = append(packages, pkg)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name+":"+packages[i].Architecture < packages[j].Name+":"+packages[j].Architecture
+	})
+
+	graph := depgraph.New(packages, mfest.Architecture)
+	graph.PreferProviders(run.preferredProviders)
+	graph.PreferAlternatives(run.alternativeChoices)
+	batches, loops := graph.InstallOrder()
+
+	if len(loops) > 0 {
+		output.Warning("The Pre-Depends of %s form a loop; dpkg may refuse to install them", strings.Join(loops, ", "))
+	}
+
+	mfest.InstallOrder = make([][]string, 0, len(batches))
+
+	for _, batch := range batches {
+		files := make([]string, 0, len(batch))
+
+		for _, pkg := range batch {
+			files = append(files, pkg.Filename)
+		}
+
+		mfest.InstallOrder = append(mfest.InstallOrder, files)
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/ipfs"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/snapshot"
+)
+
+// ipfsOptions are the export flags controlling IPFS publication
+type ipfsOptions struct {
+	bundle     bool
+	repository bool
+	api        string
+}
+
+// addToIPFS adds the bundle and/or repository tree to IPFS and records the CIDs in the manifest
+func addToIPFS(cfg *config.Config, mfest *manifest.Manifest, bundlePath string, options *ipfsOptions) error {
+	client := &ipfs.Client{API: options.api}
+	record := &manifest.IPFSRecord{AddedAt: time.Now()}
+
+	if options.bundle {
+		fmt.Printf("Adding %s to IPFS via %s...\n", bundlePath, options.api)
+
+		cid, err := client.AddFile(bundlePath)
+
+		if err != nil {
+			return err
+		}
+
+		record.Bundle = filepath.Base(bundlePath)
+		record.BundleCID = cid
+		fmt.Printf("Pinned bundle as %s\n", cid)
+	}
+
+	if options.repository {
+		fmt.Printf("Adding %s to IPFS via %s...\n", cfg.RepoPath, options.api)
+
+		// Snapshots hard-link the pool, which IPFS would store once more for each
+		cid, err := client.AddDirectory(cfg.RepoPath, snapshot.Dir)
+
+		if err != nil {
+			return err
+		}
+
+		record.RepositoryCID = cid
+		fmt.Printf("Pinned repository as %s (apt source: <gateway>/ipfs/%s)\n", cid, cid)
+	}
+
+	mfest.IPFS = record
+
+	if err := manifest.Save(cfg.RepoPath, mfest); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	return nil
+}
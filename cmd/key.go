@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/signing"
+)
+
+// RunKeyCommand manages the repository signing key: generate, import, export, list,
+// and checks which keys a target trusts
+func RunKeyCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: key generate|import|export|list|token|target [OPTIONS]")
+	}
+
+	var cfg config.Config
+	var name, email, expire, output, keygrip, module, root, sshTarget string
+	var armor bool
+
+	fs := newFlagSet("key "+args[0], &cfg)
+
+	switch args[0] {
+	case "generate":
+		fs.StringVar(&name, "name", "Portaptable Repository", "Real name for the key user ID")
+		fs.StringVar(&email, "email", "", "Email address for the key user ID")
+		fs.StringVar(&expire, "expire", "never", "Key expiration (e.g., 2y, never)")
+		fs.StringVar(&keygrip, "keygrip", "", "Bind the key to this hardware token keygrip (see 'key token')")
+	case "token":
+		fs.StringVar(&module, "module", "", "PKCS#11 provider library (e.g., /usr/lib/x86_64-linux-gnu/libykcs11.so)")
+	case "export":
+		fs.BoolVar(&armor, "armor", false, "Export an ASCII-armored key (.asc) instead of a binary keyring")
+		fs.StringVar(&output, "output", "", "Output file (default: "+signing.PublicKeyringName+")")
+	case "target":
+		fs.StringVar(&root, "root", "", "Root filesystem of the target (a mounted image or copy of its /etc/apt)")
+		fs.StringVar(&sshTarget, "ssh", "", "Read the target's keyrings over SSH (USER@HOST)")
+	case "import", "list":
+	default:
+		return fmt.Errorf("unknown key command: %s", args[0])
+	}
+
+	fs.Parse(args[1:])
+
+	if args[0] == "target" {
+		return showTargetKeys(&cfg, root, sshTarget)
+	}
+
+	keyring, err := openKeyring(&cfg)
+
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "generate":
+		return generateKey(keyring, name, email, expire, keygrip)
+	case "token":
+		return showTokenKeys(keyring, module)
+	case "import":
+		return importKeys(keyring, fs.Args())
+	case "export":
+		return exportKey(keyring, fs.Arg(0), output, armor)
+	default:
+		return listKeys(keyring)
+	}
+}
+
+func generateKey(keyring *signing.Keyring, name, email, expire, keygrip string) error {
+	if _, err := keyring.SigningKey(); err == nil {
+		return fmt.Errorf("keyring %s already contains a signing key", keyring.Home)
+	}
+
+	if keygrip != "" {
+		fmt.Printf("Creating signing key for token keygrip %s in %s...\n", keygrip, keyring.Home)
+
+		if err := keyring.GenerateFromKeygrip(keygrip, name, email, expire); err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+	} else {
+		fmt.Printf("Generating signing key in %s...\n", keyring.Home)
+
+		if err := keyring.Generate(name, email, expire); err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+	}
+
+	key, err := keyring.SigningKey()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated key %s (%s)\n", key.Fingerprint, key.UserID)
+
+	return nil
+}
+
+func importKeys(keyring *signing.Keyring, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no key files specified")
+	}
+
+	for _, file := range files {
+		if err := keyring.Import(file); err != nil {
+			return fmt.Errorf("failed to import %s: %w", file, err)
+		}
+
+		fmt.Printf("Imported %s\n", file)
+	}
+
+	return nil
+}
+
+func exportKey(keyring *signing.Keyring, keyID, output string, armor bool) error {
+	data, err := keyring.ExportPublic(keyID, armor)
+
+	if err != nil {
+		return fmt.Errorf("failed to export key: %w", err)
+	}
+
+	if output == "" {
+		output = signing.PublicKeyringName
+
+		// apt only reads armored keys from files ending in .asc
+		if armor {
+			output = output[:len(output)-len(".gpg")] + ".asc"
+		}
+	}
+
+	if output == "-" {
+		_, err := os.Stdout.Write(data)
+
+		return err
+	}
+
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+
+	fmt.Printf("Exported public key to %s\n", output)
+	fmt.Printf("Install it on targets with:\n")
+	fmt.Printf("  sudo install -D -m 0644 %s /etc/apt/keyrings/%s\n", output, filepath.Base(output))
+
+	return nil
+}
+
+func listKeys(keyring *signing.Keyring) error {
+	keys, err := keyring.List()
+
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	if len(keys) == 0 {
+		fmt.Printf("No keys in %s\n", keyring.Home)
+
+		return nil
+	}
+
+	for _, key := range keys {
+		kind := "pub"
+
+		if key.HasSecret {
+			kind = "sec"
+		}
+
+		expires := "never"
+
+		if !key.Expires.IsZero() {
+			expires = key.Expires.Format("2006-01-02")
+		}
+
+		fmt.Printf("%s %s\n", kind, key.Fingerprint)
+		fmt.Printf("    %s (created %s, expires %s)\n", key.UserID, key.Created.Format("2006-01-02"), expires)
+	}
+
+	return nil
+}
+
+func showTokenKeys(keyring *signing.Keyring, module string) error {
+	if module != "" {
+		if err := keyring.ConfigurePKCS11(module); err != nil {
+			return err
+		}
+
+		fmt.Printf("Configured PKCS#11 module %s for %s\n", module, keyring.Home)
+	}
+
+	keys, err := keyring.TokenKeys()
+
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		fmt.Println("No keys found on the token")
+
+		return nil
+	}
+
+	fmt.Println("Keys on the token (use with 'key generate --keygrip'):")
+
+	for keygrip, id := range keys {
+		fmt.Printf("  %s  %s\n", keygrip, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+)
+
+// languagePackPatterns name the distribution-wide translation packages of a language
+// (%[2]s, formatted like the localization patterns without a package)
+var languagePackPatterns = []string{"language-pack-%[2]s", "language-pack-%[2]s-base"}
+
+// desktopLanguagePacks name the translation packages of a desktop environment, added
+// when the resolved set has the package the environment is known by
+var desktopLanguagePacks = map[string][]string{
+	"gnome-shell":    {"language-pack-gnome-%[2]s", "language-pack-gnome-%[2]s-base"},
+	"plasma-desktop": {"language-pack-kde-%[2]s"},
+}
+
+// localizationPatterns name an application's translation packages for a language
+var localizationPatterns = []string{"%[1]s-locale-%[2]s", "%[1]s-l10n-%[2]s", "%[1]s-i18n-%[2]s", "%[1]s-lang-%[2]s", "%[1]s-help-%[2]s"}
+
+// chineseScripts map Chinese locales to the script Debian names their packages by
+var chineseScripts = map[string]string{"zh-cn": "zh-hans", "zh-sg": "zh-hans", "zh-tw": "zh-hant", "zh-hk": "zh-hant"}
+
+// languageCodes returns the codes packages may name a language or locale by, most
+// specific first: de_DE.UTF-8 gives de-de and de, zh_TW gives zh-hant, zh-tw and zh
+func languageCodes(locale string) []string {
+	locale, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(locale)), ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	locale = strings.ReplaceAll(locale, "_", "-")
+
+	if locale == "" {
+		return nil
+	}
+
+	var codes []string
+
+	if script, ok := chineseScripts[locale]; ok {
+		codes = append(codes, script)
+	}
+
+	codes = append(codes, locale)
+
+	if language, _, found := strings.Cut(locale, "-"); found {
+		codes = append(codes, language)
+	}
+
+	return codes
+}
+
+// addLanguagePackages extends the resolved packages with the language packs of the
+// --languages locales and the localized companions of the packages, e.g.
+// firefox-locale-de for firefox, along with what those depend on. Each pattern takes
+// the most specific code the archive has: libreoffice-l10n-pt-br for pt_BR.
+func (run *downloadRun) addLanguagePackages(config *config.Config, packages []string) ([]string, error) {
+	available, err := run.availablePackageNames()
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(packages))
+
+	for _, pkg := range packages {
+		name, _, _ := strings.Cut(pkg, ":")
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	var companions []string
+
+	// add takes the first of pattern's names for the codes that the archive has
+	add := func(pattern, pkg string, codes []string) {
+		for _, code := range codes {
+			if name := fmt.Sprintf(pattern, pkg, code); available[name] {
+				if !seen[name] {
+					output.Info("Adding %s", name)
+					companions = append(companions, name)
+					seen[name] = true
+				}
+
+				return
+			}
+		}
+	}
+
+	for _, locale := range config.Languages {
+		codes := languageCodes(locale)
+
+		for _, pattern := range languagePackPatterns {
+			add(pattern, "", codes)
+		}
+
+		for desktop, patterns := range desktopLanguagePacks {
+			if seen[desktop] {
+				for _, pattern := range patterns {
+					add(pattern, "", codes)
+				}
+			}
+		}
+
+		for _, name := range names {
+			for _, pattern := range localizationPatterns {
+				add(pattern, name, codes)
+			}
+		}
+	}
+
+	if len(companions) == 0 {
+		return packages, nil
+	}
+
+	withDependencies, err := run.resolveAllDependencies(companions, config)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve language packages: %w", err)
+	}
+
+	return appendMissing(packages, withDependencies), nil
+}
+
+// availablePackageNames returns every package name known to apt
+func (run *downloadRun) availablePackageNames() (map[string]bool, error) {
+	if run.nativeIndex != nil {
+		return run.nativeIndex.names, nil
+	}
+
+	out, err := run.aptCommand("apt-cache", "pkgnames").Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("apt-cache pkgnames failed: %w", err)
+	}
+
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+
+	for scanner.Scan() {
+		names[scanner.Text()] = true
+	}
+
+	return names, nil
+}
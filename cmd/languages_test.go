@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLanguageCodes(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   []string
+	}{
+		{"de", []string{"de"}},
+		{"de_DE.UTF-8", []string{"de-de", "de"}},
+		{"pt_BR", []string{"pt-br", "pt"}},
+		{"ca_ES@valencia", []string{"ca-es", "ca"}},
+		{"zh_TW.UTF-8", []string{"zh-hant", "zh-tw", "zh"}},
+		{" ", nil},
+	}
+
+	for _, test := range tests {
+		if got := languageCodes(test.locale); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("languageCodes(%q) = %q, want %q", test.locale, got, test.want)
+		}
+	}
+}
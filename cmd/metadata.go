@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/history"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/repometa"
+	"portaptable/pkg/signing"
+)
+
+// setupScriptName is the target-side setup script written into the repository
+const setupScriptName = "setup-apt.sh"
+
+// publishMetadata regenerates the repository indexes and, when a signing key is
+// available, signs the Release file and places the public keyring next to it
+func publishMetadata(repoPath string, mfest *manifest.Manifest, cfg *config.Config) error {
+	if cfg.RequireSHA256 {
+		mfest.RequireSHA256 = true
+	}
+
+	if err := repometa.Generate(repoPath, mfest); err != nil {
+		return err
+	}
+
+	signed, err := signRepository(repoPath, mfest.Distribution, cfg)
+
+	if err != nil {
+		return err
+	}
+
+	if !signed {
+		output.Warning("No signing key found; targets will need [trusted=yes] (see 'key generate')")
+
+		return nil
+	}
+
+	script := repometa.SetupScript("file://$SCRIPT_DIR", mfest.Distribution, signing.PublicKeyringName, repometa.HasSources(mfest))
+
+	return repometa.WriteFile(filepath.Join(repoPath, setupScriptName), []byte(script), 0755)
+}
+
+// recordHistory commits the repository metadata after an operation when the
+// repository keeps a history (started with --git-history)
+func recordHistory(cfg *config.Config, format string, args ...interface{}) {
+	if cfg.GitHistory {
+		if err := history.Init(cfg.RepoPath); err != nil {
+			output.Warning("Failed to start metadata history: %v", err)
+
+			return
+		}
+	}
+
+	if err := history.Record(cfg.RepoPath, fmt.Sprintf(format, args...)); err != nil {
+		output.Warning("Failed to record metadata history: %v", err)
+	}
+}
+
+// openKeyring opens the configured signing keyring with the selected key and PIN file
+func openKeyring(cfg *config.Config) (*signing.Keyring, error) {
+	keyring, err := signing.Open(cfg.KeyringHome)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyring.KeyID = cfg.SigningKey
+	keyring.PINFile = cfg.PINFile
+
+	return keyring, nil
+}
+
+// signRepository signs the Release file of distribution and exports the public key.
+// It reports false when no secret key is available.
+func signRepository(repoPath, distribution string, cfg *config.Config) (bool, error) {
+	keyring, err := openKeyring(cfg)
+
+	if err != nil {
+		return false, err
+	}
+
+	key, err := keyring.SigningKey()
+
+	if err != nil {
+		// An explicitly selected key must exist
+		if cfg.SigningKey != "" {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	distPath := repometa.DistPath(repoPath, distribution)
+	releasePath := filepath.Join(distPath, "Release")
+
+	// Signatures are written aside and renamed into place, as a daemon may be serving
+	inRelease, releaseGPG := filepath.Join(distPath, "InRelease"), filepath.Join(distPath, "Release.gpg")
+
+	if err := keyring.ClearSign(key.Fingerprint, releasePath, inRelease+".tmp"); err != nil {
+		return false, fmt.Errorf("failed to write InRelease: %w", err)
+	}
+
+	if err := keyring.DetachSign(key.Fingerprint, releasePath, releaseGPG+".tmp"); err != nil {
+		return false, fmt.Errorf("failed to write Release.gpg: %w", err)
+	}
+
+	for _, path := range []string{inRelease, releaseGPG} {
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return false, fmt.Errorf("failed to install %s: %w", filepath.Base(path), err)
+		}
+	}
+
+	publicKey, err := keyring.ExportPublic(key.Fingerprint, false)
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := repometa.WriteFile(filepath.Join(repoPath, signing.PublicKeyringName), publicKey, 0644); err != nil {
+		return false, fmt.Errorf("failed to write public keyring: %w", err)
+	}
+
+	output.Info("Signed repository metadata with key %s", key.Fingerprint)
+
+	return true, nil
+}
+
+// isSigned reports whether the repository carries signed metadata and a public keyring
+func isSigned(repoPath, distribution string) bool {
+	for _, path := range []string{
+		filepath.Join(repometa.DistPath(repoPath, distribution), "InRelease"),
+		filepath.Join(repoPath, signing.PublicKeyringName),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// signFile writes a detached armored signature of path to path.asc when a signing key is available
+func signFile(path string, cfg *config.Config) error {
+	keyring, err := openKeyring(cfg)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := keyring.SigningKey()
+
+	if err != nil {
+		if cfg.SigningKey != "" {
+			return err
+		}
+
+		return nil
+	}
+
+	if err := keyring.DetachSign(key.Fingerprint, path, path+".asc"); err != nil {
+		return fmt.Errorf("failed to sign %s: %w", path, err)
+	}
+
+	output.Info("Signed %s with key %s", filepath.Base(path), key.Fingerprint)
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/namefilter"
+	"portaptable/pkg/output"
+	"portaptable/pkg/relation"
+)
+
+// minimalRules are the --minimal rules; each reports whether a resolved package is droppable
+var minimalRules = map[string]func(name string, record deb822.Paragraph) bool{
+	"doc":          isDocPackage,
+	"transitional": isTransitionalPackage,
+	"locale":       isLocalePackage,
+}
+
+// defaultMinimalRules apply with a bare --minimal; locale data is opt-in
+var defaultMinimalRules = []string{"doc", "transitional"}
+
+// DefaultMinimalRules returns the rules a bare --minimal applies
+func DefaultMinimalRules() []string {
+	return append([]string{}, defaultMinimalRules...)
+}
+
+// localePatterns name packages that only carry locale data or translations
+var localePatterns = []string{"locales", "locales-all", "language-pack-*", "*-l10n", "*-l10n-*", "*-locale-*", "*-i18n"}
+
+// isDocPackage matches documentation packages by name or section
+func isDocPackage(name string, record deb822.Paragraph) bool {
+	if strings.HasSuffix(name, "-doc") || strings.HasSuffix(name, "-docs") || strings.Contains(name, "-doc-") {
+		return true
+	}
+
+	return path.Base(record["Section"]) == "doc"
+}
+
+// isTransitionalPackage matches the dummy packages left behind by renames
+func isTransitionalPackage(name string, record deb822.Paragraph) bool {
+	summary, _, _ := strings.Cut(record["Description"], "\n")
+
+	return strings.Contains(strings.ToLower(summary), "transitional")
+}
+
+// isLocalePackage matches locale data and translation packages
+func isLocalePackage(name string, record deb822.Paragraph) bool {
+	if path.Base(record["Section"]) == "localization" {
+		return true
+	}
+
+	for _, pattern := range localePatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyMinimalProfile drops the resolved packages matched by the configured --minimal
+// rules, except those named by --minimal-keep. A dropped package that a kept one
+// still depends on (with no kept alternative) is restored, so the target can install.
+func (run *downloadRun) applyMinimalProfile(cfg *config.Config, packages []string) ([]string, error) {
+	if len(cfg.MinimalRules) == 0 {
+		return packages, nil
+	}
+
+	for _, rule := range cfg.MinimalRules {
+		if minimalRules[rule] == nil {
+			return nil, fmt.Errorf("invalid minimal rule %q (expected doc, transitional or locale)", rule)
+		}
+	}
+
+	keep, err := namefilter.New(cfg.MinimalKeep, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := run.candidateParagraphs(packages)
+	dropped := make(map[string]string)
+
+	for _, pkg := range packages {
+		name, _, _ := strings.Cut(pkg, ":")
+
+		if len(cfg.MinimalKeep) > 0 && keep.Match(name) {
+			continue
+		}
+
+		for _, rule := range cfg.MinimalRules {
+			if minimalRules[rule](name, records[pkg]) {
+				dropped[pkg] = rule
+
+				break
+			}
+		}
+	}
+
+	restoreDependencies(packages, records, dropped)
+
+	var kept []string
+	counts := make(map[string]int)
+
+	for _, pkg := range packages {
+		if rule, ok := dropped[pkg]; ok {
+			counts[rule]++
+		} else {
+			kept = append(kept, pkg)
+		}
+	}
+
+	if len(dropped) > 0 {
+		var summary []string
+
+		for rule, count := range counts {
+			summary = append(summary, fmt.Sprintf("%d %s", count, rule))
+		}
+
+		sort.Strings(summary)
+		output.Info("Minimal profile dropped %d packages (%s)", len(dropped), strings.Join(summary, ", "))
+	}
+
+	return kept, nil
+}
+
+// restoreDependencies removes packages from dropped until every Depends and Pre-Depends
+// of the kept packages is satisfied by a kept package or one outside the resolved set
+func restoreDependencies(packages []string, records map[string]deb822.Paragraph, dropped map[string]string) {
+	for changed := true; changed; {
+		changed = false
+
+		for _, pkg := range packages {
+			if _, ok := dropped[pkg]; ok {
+				continue
+			}
+
+			record := records[pkg]
+
+			for _, group := range relation.Parse(record["Pre-Depends"] + "," + record["Depends"]) {
+				if restored := restoreGroup(group, record["Architecture"], dropped); restored != "" {
+					output.Info("Keeping %s: %s depends on it", restored, pkg)
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// restoreGroup restores the first dropped alternative of a dependency group that no
+// kept package satisfies, returning its name. Dependencies of a foreign-architecture
+// package may be resolved as name:arch.
+func restoreGroup(group relation.Group, architecture string, dropped map[string]string) string {
+	var candidate string
+
+	for _, r := range group {
+		name := r.Name
+		key := name
+
+		if _, ok := dropped[key]; !ok {
+			key = name + ":" + architecture
+		}
+
+		if _, ok := dropped[key]; !ok {
+			return "" // Satisfied by a kept package, or not part of this decision
+		}
+
+		if candidate == "" {
+			candidate = key
+		}
+	}
+
+	delete(dropped, candidate)
+
+	return candidate
+}
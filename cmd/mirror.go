@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"portaptable/pkg/archive"
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/debfile"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/namefilter"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/repolock"
+	"portaptable/pkg/repometa"
+)
+
+// indexCacheDir is the repository subdirectory keeping upstream indexes for pdiff updates
+const indexCacheDir = ".cache/indexes"
+
+// newArchiveMirror returns a mirror whose indexes are cached in the repository, so
+// refreshes fetch only the pdiff patches published since the last run
+func (run *downloadRun) newArchiveMirror(url, repoPath string) archive.Mirror {
+	remote.ScopeHeaders(url)
+
+	return archive.Mirror{
+		URL:        url,
+		IndexCache: filepath.Join(repoPath, filepath.FromSlash(indexCacheDir)),
+		Logf:       output.Info,
+
+		RequireSHA256: run.requireSHA256,
+	}
+}
+
+// RunMirrorCommand mirrors every package of a suite's components, driven by the
+// archive's Packages indexes. Reruns only fetch what changed and prune what was dropped.
+func RunMirrorCommand(args []string) error {
+	var cfg config.Config
+	var components, mirrorURL, languages string
+	var include, exclude stringList
+
+	fs := newFlagSet("mirror", &cfg)
+	fs.StringVar(&components, "components", "main", "Comma-separated archive components to mirror")
+	fs.StringVar(&mirrorURL, "mirror", "", "Archive to mirror (default: the vendor archive of --dist)")
+	fs.Var(&include, "include", "Only mirror packages matching this glob or ^regex$ (repeatable)")
+	fs.Var(&exclude, "exclude", "Skip packages matching this glob or ^regex$ (repeatable)")
+	fs.StringVar(&languages, "index-languages", "", "Comma-separated Translation-* indexes to mirror (e.g. en,fr)")
+	registerLicenseFlags(fs, &cfg)
+	fs.Parse(args)
+
+	filter, err := namefilter.New(include, exclude)
+
+	if err != nil {
+		return err
+	}
+
+	lock, err := repolock.Acquire(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	run := newDownloadRun(&cfg)
+
+	if mirrorURL == "" {
+		if err := run.checkDistribution(cfg.Distribution); err != nil {
+			return err
+		}
+
+		mirrorURL = run.defaultMirror(cfg.Distribution, cfg.Architecture)
+	}
+
+	mirrorURL = normalizeMirror(mirrorURL)
+
+	run.applyHashPolicy(&cfg)
+	run.applyTrustPolicy(&cfg)
+	mirror := run.newArchiveMirror(mirrorURL, cfg.RepoPath)
+
+	if err := run.verifyMirrorRelease(&mirror, cfg.Distribution, cfg.RepoPath); err != nil {
+		return err
+	}
+
+	// The previous manifest lets a refresh skip files that are already current
+	previous := make(map[string]packageinfo.PackageInfo)
+
+	if old, err := manifest.Load(cfg.RepoPath); err == nil {
+		for _, pkg := range old.Packages {
+			if pkg.Downloaded {
+				previous[pkg.Filename] = pkg
+			}
+		}
+	}
+
+	var entries []deb822.Paragraph
+
+	for _, component := range strings.Split(components, ",") {
+		output.Info("Fetching %s/%s index from %s...", cfg.Distribution, component, mirror.URL)
+
+		componentEntries, err := mirror.FetchPackages(cfg.Distribution, component, cfg.Architecture, false)
+
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, componentEntries...)
+	}
+
+	mfest := manifest.Manifest{
+		CreatedAt:     time.Now(),
+		Architecture:  cfg.Architecture,
+		Distribution:  cfg.Distribution,
+		RequireSHA256: cfg.RequireSHA256,
+		Packages:      make([]packageinfo.PackageInfo, 0, len(entries)),
+	}
+
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	current := make(map[string]bool)
+	reused, failed := 0, 0
+
+	for i, entry := range entries {
+		arch := entry["Architecture"]
+
+		if (arch != cfg.Architecture && arch != "all") || !filter.Match(entry["Package"]) {
+			continue
+		}
+
+		filename := filepath.Base(entry["Filename"])
+		current[filename] = true
+
+		if pkg, ok := previous[filename]; ok && pkg.SHA256 == entry["SHA256"] && poolFileExists(poolPath, filename) {
+			mfest.Packages = append(mfest.Packages, pkg)
+			reused++
+
+			continue
+		}
+
+		output.Info("[%d/%d] Downloading %s...", i+1, len(entries), entry["Package"])
+
+		pkg, err := mirrorPackage(mirror, entry, poolPath)
+
+		if err != nil {
+			output.Failure("Failed to download %s: %v", entry["Package"], err)
+			failed++
+
+			continue
+		}
+
+		if err := checkLicenses(&cfg, &pkg, poolPath); err != nil {
+			output.Warning("Rejecting %s: %v", entry["Package"], err)
+			rejectPackage(&pkg, poolPath, err)
+		}
+
+		mfest.Packages = append(mfest.Packages, pkg)
+	}
+
+	if languages != "" {
+		mfest.IndexLanguages = strings.Split(languages, ",")
+
+		if err := mirrorTranslations(mirror, cfg.Distribution, strings.Split(components, ","), mfest.IndexLanguages, mfest.Packages); err != nil {
+			return err
+		}
+	}
+
+	// Drop pool files of packages that left the suite since the last run
+	for filename := range previous {
+		if !current[filename] {
+			output.Info("Removing %s (no longer in the suite)", filename)
+			os.Remove(filepath.Join(poolPath, filename))
+		}
+	}
+
+	// The suite's packages are the mirror's closure; evicting them would only make the
+	// next run download them again
+	if err := enforceQuota(&cfg, &mfest, current); err != nil {
+		return fmt.Errorf("failed to enforce repository quota: %w", err)
+	}
+
+	if err := manifest.Save(cfg.RepoPath, &mfest); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	if err := publishMetadata(cfg.RepoPath, &mfest, &cfg); err != nil {
+		return fmt.Errorf("failed to generate repository metadata: %w", err)
+	}
+
+	recordHistory(&cfg, "mirror: %s %s (%d packages)", cfg.Distribution, components, len(mfest.Packages))
+	output.Info("Mirrored %d packages (%d already current, %d failed)", len(mfest.Packages), reused, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d packages failed to download; rerun to resume", failed)
+	}
+
+	return nil
+}
+
+// mirrorPackage downloads the pool file of an index entry and records it for the manifest
+func mirrorPackage(mirror archive.Mirror, entry deb822.Paragraph, poolPath string) (packageinfo.PackageInfo, error) {
+	filename, sums, err := mirror.DownloadPackage(entry, poolPath)
+
+	if err != nil {
+		return packageinfo.PackageInfo{}, err
+	}
+
+	source, sourceVersion := debfile.SourceName(entry)
+
+	return packageinfo.PackageInfo{
+		Name:          entry["Package"],
+		Version:       entry["Version"],
+		Architecture:  entry["Architecture"],
+		Source:        source,
+		SourceVersion: sourceVersion,
+		Filename:      filename,
+		Size:          sums.Size,
+		MD5sum:        sums.MD5,
+		SHA1:          sums.SHA1,
+		SHA256:        sums.SHA256,
+		MTime:         sums.ModTime.UnixNano(),
+		Control:       repometa.IndexFields(entry),
+		Downloaded:    true,
+	}, nil
+}
+
+// mirrorTranslations attaches the long descriptions of each language to the packages,
+// matched by name and Description-md5, for repometa to write Translation-* indexes from.
+// A language the archive has no translations for is skipped with a warning.
+func mirrorTranslations(mirror archive.Mirror, dist string, components, languages []string, packages []packageinfo.PackageInfo) error {
+	for _, pkg := range packages {
+		for field := range pkg.Control {
+			if strings.HasPrefix(field, "Description-") && field != "Description-md5" {
+				delete(pkg.Control, field)
+			}
+		}
+	}
+
+	for _, language := range languages {
+		field := repometa.TranslationField(language)
+		descriptions := make(map[string]string)
+
+		for _, component := range components {
+			output.Info("Fetching %s/%s Translation-%s index from %s...", dist, component, language, mirror.URL)
+
+			entries, err := mirror.FetchTranslation(dist, component, language)
+
+			if errors.Is(err, remote.ErrNotFound) {
+				output.Warning("%s/%s has no Translation-%s index", dist, component, language)
+
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+				if entry[field] != "" {
+					descriptions[entry["Package"]+" "+entry["Description-md5"]] = entry[field]
+				}
+			}
+		}
+
+		for _, pkg := range packages {
+			md5 := pkg.Control["Description-md5"]
+
+			if description, ok := descriptions[pkg.Name+" "+md5]; ok && md5 != "" {
+				pkg.Control[field] = description
+			}
+		}
+	}
+
+	return nil
+}
+
+// poolFileExists reports whether filename is present in the pool
+func poolFileExists(poolPath, filename string) bool {
+	_, err := os.Stat(filepath.Join(poolPath, filename))
+
+	return err == nil
+}
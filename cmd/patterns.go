@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/namefilter"
+	"portaptable/pkg/output"
+)
+
+// isGlob reports whether a requested package is a shell glob such as php8.1-*
+func isGlob(pkg string) bool {
+	return strings.ContainsAny(pkg, "*?[")
+}
+
+// expandPatterns replaces each requested glob with the packages it matches and adds
+// those matching a --download-regex expression. Only names with a candidate version
+// count, so virtual packages apt knows by name are left out.
+func (run *downloadRun) expandPatterns(packages, regexes []string) ([]string, error) {
+	type pattern struct {
+		text string
+		re   *regexp.Regexp
+	}
+
+	var result []string
+	var patterns []pattern
+
+	for _, pkg := range packages {
+		if !isGlob(pkg) {
+			result = appendMissing(result, []string{pkg})
+
+			continue
+		}
+
+		re, err := namefilter.Compile(pkg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		patterns = append(patterns, pattern{pkg, re})
+	}
+
+	for _, expr := range regexes {
+		re, err := regexp.Compile(expr)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid --download-regex %q: %w", expr, err)
+		}
+
+		patterns = append(patterns, pattern{expr, re})
+	}
+
+	if len(patterns) == 0 {
+		return result, nil
+	}
+
+	available, err := run.availablePackageNames()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for name := range available {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, p := range patterns {
+		var matched []string
+
+		for _, name := range names {
+			if p.re.MatchString(name) {
+				matched = append(matched, name)
+			}
+		}
+
+		records := run.candidateParagraphs(matched)
+		var candidates []string
+
+		for _, name := range matched {
+			if _, ok := records[name]; ok {
+				candidates = append(candidates, name)
+			}
+		}
+
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("no package matches %s", p.text)
+		}
+
+		output.Info("%s matches %d packages", p.text, len(candidates))
+		result = appendMissing(result, candidates)
+	}
+
+	return result, nil
+}
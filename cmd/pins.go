@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// maxPinRounds bounds how often apt's simulation is repeated with more pins
+const maxPinRounds = 10
+
+// unmetExactDependency matches apt's report of an exact dependency the candidates miss,
+// e.g. "nginx : Depends: nginx-core (= 1.18.0-0ubuntu1.4) but 1.18.0-6ubuntu14 is to be installed"
+var unmetExactDependency = regexp.MustCompile(`Depends: (\S+) \(= ([^)]+)\)`)
+
+// parseVersionPins splits requested packages into their names and version pins
+func parseVersionPins(requested []string) ([]string, map[string]string, error) {
+	var names []string
+	pins := make(map[string]string)
+
+	for _, spec := range requested {
+		name, version, pinned := strings.Cut(spec, "=")
+
+		if !pinned {
+			names = append(names, spec)
+
+			continue
+		}
+
+		if name == "" || version == "" {
+			return nil, nil, fmt.Errorf("invalid version pin %q (expected name=version)", spec)
+		}
+
+		if earlier, ok := pins[name]; ok && earlier != version {
+			return nil, nil, fmt.Errorf("%s is pinned to both %s and %s", name, earlier, version)
+		}
+
+		pins[name] = version
+		names = append(names, name)
+	}
+
+	return names, pins, nil
+}
+
+// hasPin reports whether any architecture of a package is pinned
+func (run *downloadRun) hasPin(name string) bool {
+	for spec := range run.versionPins {
+		if strings.SplitN(spec, ":", 2)[0] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pinnedSpec returns how to ask apt for a package: name=version when it is pinned,
+// by its own spec or, for an additional architecture, by its bare name
+func (run *downloadRun) pinnedSpec(name string) string {
+	if version, ok := run.versionPins[name]; ok {
+		return name + "=" + version
+	}
+
+	if bare, architecture, found := strings.Cut(name, ":"); found && contains(run.additionalArchitectures, architecture) {
+		if version, ok := run.versionPins[bare]; ok {
+			return name + "=" + version
+		}
+	}
+
+	return name
+}
+
+// checkAptPins fails when apt has no pinned version, before any download is attempted
+func (run *downloadRun) checkAptPins() error {
+	for spec := range run.versionPins {
+		if _, err := run.aptPackageURI(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pinAptDependencies pins the dependencies of pinned packages to the versions apt
+// would install with them, which a versioned dependency can hold below the candidate.
+// apt never picks such a version on its own, so the exact versions it reports unmet
+// are pinned as well and the simulation repeated. Only the private apt configuration
+// simulates a bare target; the host's would skip whatever the host has installed.
+func (run *downloadRun) pinAptDependencies() {
+	if len(run.versionPins) == 0 || run.aptEnv == nil {
+		return
+	}
+
+	pins := make(map[string]string, len(run.versionPins))
+
+	for spec, version := range run.versionPins {
+		pins[spec] = version
+	}
+
+	for round := 0; round < maxPinRounds; round++ {
+		args := []string{"install", "--simulate", "--no-install-recommends"}
+
+		for spec, version := range pins {
+			args = append(args, spec+"="+version)
+		}
+
+		out, err := run.aptCommand("apt-get", args...).CombinedOutput()
+
+		if err == nil {
+			for _, step := range parseInstallSteps(string(out)) {
+				if _, pinned := run.versionPins[step.name]; step.action == "Inst" && !pinned {
+					run.versionPins[step.name] = step.version
+				}
+			}
+
+			return
+		}
+
+		added := false
+
+		for _, match := range unmetExactDependency.FindAllStringSubmatch(string(out), -1) {
+			if _, pinned := pins[match[1]]; !pinned {
+				pins[match[1]] = match[2]
+				added = true
+			}
+		}
+
+		if !added {
+			output.Warning("apt cannot install the pinned versions together, so their dependencies take the candidate versions:\n%s", unmetDependencies(string(out)))
+
+			return
+		}
+	}
+}
+
+// unmetDependencies returns the lines of apt output explaining why an install fails
+func unmetDependencies(out string) string {
+	var lines []string
+
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, " : ") || strings.HasPrefix(line, "E:") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// pinVersions drops the versions of pinned packages other than the pinned one from an
+// index, failing when the pinned version is not in it
+func (run *downloadRun) pinVersions(packages []packageinfo.PackageInfo, architecture string) ([]packageinfo.PackageInfo, error) {
+	if len(run.versionPins) == 0 {
+		return packages, nil
+	}
+
+	found := make(map[string]bool)
+	kept := packages[:0]
+
+	for _, pkg := range packages {
+		spec, version, pinned := run.pinFor(pkg, architecture)
+
+		if !pinned {
+			kept = append(kept, pkg)
+
+			continue
+		}
+
+		if debversion.Compare(pkg.Version, version) == 0 {
+			found[spec] = true
+			kept = append(kept, pkg)
+		}
+	}
+
+	for spec, version := range run.versionPins {
+		if !found[spec] {
+			return nil, fmt.Errorf("version %s of %s is not in the archive indexes", version, strings.SplitN(spec, ":", 2)[0])
+		}
+	}
+
+	return kept, nil
+}
+
+// pinFor returns the pin applying to an index entry: one naming its architecture, else
+// one on the bare name for packages of the primary or an additional architecture or "all"
+func (run *downloadRun) pinFor(pkg packageinfo.PackageInfo, architecture string) (string, string, bool) {
+	spec := pkg.Name + ":" + pkg.Architecture
+
+	if version, ok := run.versionPins[spec]; ok {
+		return spec, version, true
+	}
+
+	if pkg.Architecture != architecture && pkg.Architecture != "all" && !contains(run.additionalArchitectures, pkg.Architecture) {
+		return "", "", false
+	}
+
+	version, ok := run.versionPins[pkg.Name]
+
+	return pkg.Name, version, ok
+}
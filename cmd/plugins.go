@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/plugin"
+	"portaptable/pkg/remote"
+)
+
+// registerTransportPlugin routes URLs of a scheme to a transport plugin, from SCHEME=PLUGIN
+func registerTransportPlugin(value string) error {
+	scheme, name, ok := strings.Cut(value, "=")
+
+	if !ok || scheme == "" || name == "" {
+		return fmt.Errorf("expected SCHEME=PLUGIN, got %q", value)
+	}
+
+	transport, err := plugin.Find(plugin.KindTransport, name)
+
+	if err != nil {
+		return err
+	}
+
+	remote.RegisterScheme(scheme, func(url, target string) error {
+		var result plugin.TransportResult
+
+		if err := transport.Call(plugin.TransportParams{URL: url, Target: target}, &result); err != nil {
+			return err
+		}
+
+		if result.NotFound {
+			return remote.ErrNotFound
+		}
+
+		return nil
+	})
+
+	return nil
+}
+
+// applyFilterPlugins passes the resolved packages through every filter plugin in turn.
+// Filters may only drop packages; names they add are ignored.
+func applyFilterPlugins(config *config.Config, packages []string) ([]string, error) {
+	for _, name := range config.FilterPlugins {
+		filter, err := plugin.Find(plugin.KindFilter, name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var result plugin.FilterResult
+
+		params := plugin.FilterParams{
+			Distribution: config.Distribution,
+			Architecture: config.Architecture,
+			Requested:    config.Packages,
+			Packages:     packages,
+		}
+
+		if err := filter.Call(params, &result); err != nil {
+			return nil, err
+		}
+
+		for _, rejection := range result.Rejected {
+			output.Warning("Skipping %s: %s (filter %s)", rejection.Name, rejection.Reason, name)
+		}
+
+		kept := make([]string, 0, len(result.Packages))
+
+		for _, pkg := range result.Packages {
+			if contains(packages, pkg) {
+				kept = append(kept, pkg)
+			}
+		}
+
+		packages = kept
+	}
+
+	return packages, nil
+}
+
+// exportWithPlugin writes the repository to output through an export plugin
+func exportWithPlugin(cfg *config.Config, format, output string) error {
+	exporter, err := plugin.Find(plugin.KindExport, format)
+
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := filepath.Abs(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	outputPath, err := filepath.Abs(output)
+
+	if err != nil {
+		return err
+	}
+
+	params := plugin.ExportParams{
+		Repository: repoPath,
+		Manifest:   filepath.Join(repoPath, manifest.FileName),
+		Output:     outputPath,
+	}
+
+	return exporter.Call(params, nil)
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/debfile"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/namefilter"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// componentAllowed reports whether a package's archive component passes --components,
+// returning the component for messages
+func (run *downloadRun) componentAllowed(cfg *config.Config, name string) (bool, string) {
+	if len(cfg.Components) == 0 {
+		return true, ""
+	}
+
+	component := run.archiveComponent(name, "")
+
+	return contains(cfg.Components, component), component
+}
+
+// sourceComponents returns the archive components the private apt configuration uses
+func (run *downloadRun) sourceComponents(cfg *config.Config) []string {
+	components := run.archiveComponents(cfg.Distribution)
+
+	if len(cfg.Components) == 0 {
+		return components
+	}
+
+	var allowed []string
+
+	for _, component := range components {
+		if contains(cfg.Components, component) {
+			allowed = append(allowed, component)
+		}
+	}
+
+	return allowed
+}
+
+// applyHashPolicy turns on the SHA256 policy for this run when --require-sha256 was
+// given or the repository already requires SHA256
+func (run *downloadRun) applyHashPolicy(cfg *config.Config) {
+	if !cfg.RequireSHA256 {
+		if mfest, err := manifest.Load(cfg.RepoPath); err == nil {
+			cfg.RequireSHA256 = mfest.RequireSHA256
+		}
+	}
+
+	run.requireSHA256 = cfg.RequireSHA256
+}
+
+// applyTrustPolicy sets how this run verifies natively fetched Release files, from
+// --archive-keyring and --allow-unauthenticated
+func (run *downloadRun) applyTrustPolicy(cfg *config.Config) {
+	run.archiveKeyrings, run.allowUnauthenticated = cfg.ArchiveKeyrings, cfg.AllowUnauthenticated
+}
+
+// registerLicenseFlags adds the license policy options to a subcommand's flags
+func registerLicenseFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.Func("deny-licenses", "Comma-separated license globs to reject (e.g., 'AGPL-*,SSPL*')", listFlag(&cfg.DeniedLicenses))
+	fs.BoolVar(&cfg.StrictLicenses, "strict-licenses", false, "With --deny-licenses, also reject packages whose license cannot be determined")
+}
+
+// checkLicenses rejects a downloaded package whose DEP-5 copyright file declares a
+// license matching --deny-licenses. A package whose licenses cannot be determined,
+// such as one with a free-form copyright file, passes with a warning, or is rejected
+// under --strict-licenses.
+func checkLicenses(cfg *config.Config, pkg *packageinfo.PackageInfo, poolPath string) error {
+	if pkg.Type == packageinfo.TypeSource {
+		return nil
+	}
+
+	return checkLicenseFile(cfg, filepath.Join(poolPath, pkg.Filename), pkg.Name)
+}
+
+// checkLicenseFile applies the license policy of checkLicenses to the .deb at path
+func checkLicenseFile(cfg *config.Config, path, name string) error {
+	if len(cfg.DeniedLicenses) == 0 {
+		return nil
+	}
+
+	denied, err := namefilter.New(cfg.DeniedLicenses, nil)
+
+	if err != nil {
+		return err
+	}
+
+	licenses, err := debfile.Licenses(path, name)
+
+	if err == nil && len(licenses) == 0 {
+		err = fmt.Errorf("its copyright file is not machine-readable")
+	}
+
+	if err != nil {
+		if cfg.StrictLicenses {
+			return fmt.Errorf("license cannot be determined: %w", err)
+		}
+
+		output.Warning("Cannot determine the license of %s, allowing it: %v", name, err)
+
+		return nil
+	}
+
+	for _, license := range licenses {
+		if licenseDenied(license, denied) {
+			return fmt.Errorf("license %q is denied", license)
+		}
+	}
+
+	return nil
+}
+
+// licenseDenied evaluates a DEP-5 license expression: a choice ("or") is denied only
+// when every alternative is, a combination ("and", ",") when any part is
+func licenseDenied(expression string, denied *namefilter.Filter) bool {
+	for _, alternative := range strings.Split(expression, " or ") {
+		alternativeDenied := false
+
+		for _, part := range strings.FieldsFunc(strings.ReplaceAll(alternative, " and ", ","), func(r rune) bool { return r == ',' }) {
+			if denied.Match(strings.TrimSpace(part)) {
+				alternativeDenied = true
+			}
+		}
+
+		if !alternativeDenied {
+			return false
+		}
+	}
+
+	return true
+}
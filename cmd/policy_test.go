@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/namefilter"
+)
+
+func TestLicenseDenied(t *testing.T) {
+	denied, err := namefilter.New([]string{"AGPL-*", "SSPL*"}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"MIT":                         false,
+		"AGPL-3.0+":                   true,
+		"GPL-2+ or AGPL-3":            false,
+		"AGPL-3 or SSPL-1":            true,
+		"MIT and AGPL-3":              true,
+		"BSD-3-clause, SSPL-1 or MIT": false,
+	}
+
+	for expression, want := range tests {
+		if got := licenseDenied(expression, denied); got != want {
+			t.Errorf("licenseDenied(%q) = %v, want %v", expression, got, want)
+		}
+	}
+}
+
+// buildDeb builds a package named app whose copyright file holds copyright
+func buildDeb(t *testing.T, copyright string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("dpkg-deb"); err != nil {
+		t.Skip("dpkg-deb is not installed")
+	}
+
+	root := t.TempDir()
+	files := map[string]string{
+		"DEBIAN/control":              "Package: app\nVersion: 1.0\nArchitecture: all\nMaintainer: Test <test@example.org>\nDescription: test\n",
+		"usr/share/doc/app/copyright": copyright,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deb := filepath.Join(t.TempDir(), "app_1.0_all.deb")
+
+	if out, err := exec.Command("dpkg-deb", "--root-owner-group", "--build", root, deb).CombinedOutput(); err != nil {
+		t.Fatalf("dpkg-deb failed: %v: %s", err, out)
+	}
+
+	return deb
+}
+
+func TestCheckLicenseFile(t *testing.T) {
+	dep5 := "Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\n\nFiles: *\nCopyright: 2024 Test\nLicense: %s\n"
+	mit, agpl := buildDeb(t, fmt.Sprintf(dep5, "MIT")), buildDeb(t, fmt.Sprintf(dep5, "AGPL-3"))
+	freeForm := buildDeb(t, "This package is in the public domain.\n")
+
+	tests := []struct {
+		name    string
+		deb     string
+		strict  bool
+		wantErr bool
+	}{
+		{"MIT", mit, false, false},
+		{"MIT", mit, true, false},
+		{"AGPL-3", agpl, false, true},
+		{"free-form", freeForm, false, false},
+		{"free-form", freeForm, true, true},
+	}
+
+	for _, test := range tests {
+		cfg := &config.Config{DeniedLicenses: []string{"AGPL-*"}, StrictLicenses: test.strict}
+
+		if err := checkLicenseFile(cfg, test.deb, "app"); (err != nil) != test.wantErr {
+			t.Errorf("checkLicenseFile() of %s, strict %v = %v, want error %v", test.name, test.strict, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// poolVerifier checks pool files against the manifest before serve mode hands them
+// out. Results are cached until a file's size or modification time changes, so each
+// file is hashed once rather than on every request.
+type poolVerifier struct {
+	mu      sync.Mutex
+	results map[string]verifyResult
+}
+
+// verifyResult is the cached outcome of hashing a pool file
+type verifyResult struct {
+	size    int64
+	modTime time.Time
+	sha256  string // Expected checksum the file was compared with
+	err     error
+}
+
+func newPoolVerifier() *poolVerifier {
+	return &poolVerifier{results: make(map[string]verifyResult)}
+}
+
+// check returns an error unless pool file filename, found at path, has the SHA256
+// the manifest records for pkg
+func (v *poolVerifier) check(filename, path string, pkg *packageinfo.PackageInfo) error {
+	if pkg == nil || pkg.SHA256 == "" {
+		return fmt.Errorf("the manifest records no checksum for it")
+	}
+
+	expected := pkg.SHA256
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	cached, ok := v.results[filename]
+	v.mu.Unlock()
+
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) && cached.sha256 == expected {
+		return cached.err
+	}
+
+	sums, err := checksum.File(path)
+
+	if err != nil {
+		return err
+	}
+
+	result := verifyResult{size: info.Size(), modTime: info.ModTime(), sha256: expected}
+
+	if sums.SHA256 != expected {
+		result.err = fmt.Errorf("checksum mismatch: expected SHA256 %s, got %s", expected, sums.SHA256)
+		output.Warning("Refusing to serve corrupted %s: %v", filename, result.err)
+	}
+
+	v.mu.Lock()
+	v.results[filename] = result
+	v.mu.Unlock()
+
+	return result.err
+}
+
+// corrupt returns the files that last failed verification
+func (v *poolVerifier) corrupt() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	files := []string{}
+
+	for filename, result := range v.results {
+		if result.err != nil {
+			files = append(files, filename)
+		}
+	}
+
+	sort.Strings(files)
+
+	return files
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/archive"
+	"portaptable/pkg/config"
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/hostapt"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// preparePreferences reads the apt_preferences(5) records that decide which versions
+// resolve. The host's only describe the target when the host's sources carry it.
+func (run *downloadRun) preparePreferences(config *config.Config) error {
+	run.aptPreferences = nil
+
+	if config.PreferencesFile != "" {
+		preferences, err := hostapt.ReadPreferencesFile(config.PreferencesFile)
+
+		if err != nil {
+			return fmt.Errorf("failed to read preferences: %w", err)
+		}
+
+		run.aptPreferences = preferences
+		output.Info("Applying %d APT preferences from %s", len(preferences), config.PreferencesFile)
+
+		return nil
+	}
+
+	if run.hostMirror(config.Distribution, config.Architecture) == "" {
+		return nil
+	}
+
+	preferences, err := hostapt.ReadPreferences(hostAptRoot)
+
+	if err != nil {
+		output.Warning("Failed to read the host's apt preferences: %v", err)
+
+		return nil
+	}
+
+	if len(preferences) > 0 {
+		run.aptPreferences = preferences
+		output.Info("Applying %d APT preferences from the host's /etc/apt/preferences", len(preferences))
+	}
+
+	return nil
+}
+
+// addPreferences writes the preferences to the private apt configuration, replacing
+// those of an earlier run
+func (run *downloadRun) addPreferences(env *aptenv.Env) error {
+	var records []string
+
+	for _, preference := range run.aptPreferences {
+		records = append(records, preference.String())
+	}
+
+	return env.AddPreferences("preferences", strings.Join(records, "\n"))
+}
+
+// releaseOrigin describes the packages of a suite's component for matching pins,
+// from the fields of its verified Release file; without one, the suite names both
+// the archive and the codename
+func releaseOrigin(mirror archive.Mirror, suite, component string) hostapt.Origin {
+	origin := hostapt.Origin{Archive: suite, Codename: suite, Component: component}
+
+	if u, err := url.Parse(mirror.URL); err == nil && u.Scheme != "file" {
+		origin.Site = u.Hostname()
+	}
+
+	if release := mirror.Release; release != nil {
+		origin.Origin, origin.Label, origin.Version = release["Origin"], release["Label"], release["Version"]
+		origin.NotAutomatic = release["NotAutomatic"] == "yes"
+		origin.ButAutomaticUpgrades = release["ButAutomaticUpgrades"] == "yes"
+
+		if release["Suite"] != "" {
+			origin.Archive = release["Suite"]
+		}
+
+		if release["Codename"] != "" {
+			origin.Codename = release["Codename"]
+		}
+	}
+
+	return origin
+}
+
+// preferredVersions keeps only the candidate of each package, as apt chooses it from
+// the preferences: its version of the highest priority, the newest among equals, and
+// none when that priority is negative. Packages with a --pin or suite target keep the
+// versions those selected.
+func (run *downloadRun) preferredVersions(packages []packageinfo.PackageInfo, origins map[string]hostapt.Origin, architecture string) []packageinfo.PackageInfo {
+	if len(run.aptPreferences) == 0 {
+		return packages
+	}
+
+	type candidate struct {
+		pkg      packageinfo.PackageInfo
+		priority int
+	}
+
+	candidates := make(map[string]candidate)
+	newest := make(map[string]string)
+
+	for _, pkg := range packages {
+		key := pkg.Name + ":" + pkg.Architecture
+		priority := hostapt.Priority(run.aptPreferences, pkg.Name, pkg.Version, origins[indexKey(pkg)])
+
+		if current, ok := candidates[key]; !ok || priority > current.priority ||
+			(priority == current.priority && debversion.Compare(pkg.Version, current.pkg.Version) > 0) {
+			candidates[key] = candidate{pkg, priority}
+		}
+
+		if version, ok := newest[key]; !ok || debversion.Compare(pkg.Version, version) > 0 {
+			newest[key] = pkg.Version
+		}
+	}
+
+	held, ruledOut := 0, 0
+
+	for key, best := range candidates {
+		switch {
+		case best.priority < 0:
+			ruledOut++
+		case best.pkg.Version != newest[key]:
+			held++
+		}
+	}
+
+	if held > 0 || ruledOut > 0 {
+		output.Info("APT preferences hold back %d packages to older versions and rule out %d", held, ruledOut)
+	}
+
+	kept := packages[:0]
+
+	for _, pkg := range packages {
+		_, _, pinned := run.pinFor(pkg, architecture)
+		_, _, targeted := run.targetFor(pkg, architecture)
+		best := candidates[pkg.Name+":"+pkg.Architecture]
+
+		if pinned || targeted || (indexKey(best.pkg) == indexKey(pkg) && best.priority >= 0) {
+			kept = append(kept, pkg)
+		}
+	}
+
+	return kept
+}
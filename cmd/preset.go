@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+)
+
+// presetSource is one archive of a preset and the keyring signing it
+type presetSource struct {
+	URI        string
+	Suite      string
+	Components []string
+	Keyring    string // Keyring file name in /usr/share/keyrings, from the vendor's keyring package
+	KeyURL     string // Where to fetch the key when the host lacks the keyring; empty uses the host's trusted keys
+}
+
+// sourcePreset is a built-in set of sources for a distribution whose archive layout
+// differs from the Debian and Ubuntu defaults
+type sourcePreset struct {
+	Distribution string
+	Architecture string
+	Sources      []presetSource
+}
+
+// Raspberry Pi OS archives: 32-bit images use the Raspbian rebuild of Debian,
+// 64-bit images use Debian itself; both add the Raspberry Pi archive
+const (
+	raspbianURI     = "http://raspbian.raspberrypi.com/raspbian"
+	raspbianKeyURL  = "https://archive.raspbian.org/raspbian.public.key"
+	raspberryPiURI  = "http://archive.raspberrypi.com/debian"
+	raspberryKeyURL = "https://archive.raspberrypi.com/debian/raspberrypi.gpg.key"
+)
+
+// presets maps the names accepted by --preset to their sources
+var presets = map[string]sourcePreset{
+	"raspios-bookworm-armhf": raspiosArmhf("bookworm"),
+	"raspios-bookworm-arm64": raspiosArm64("bookworm", []string{"main", "contrib", "non-free", "non-free-firmware"}),
+	"raspios-bullseye-armhf": raspiosArmhf("bullseye"),
+	"raspios-bullseye-arm64": raspiosArm64("bullseye", []string{"main", "contrib", "non-free"}),
+}
+
+// raspiosArmhf returns the 32-bit Raspberry Pi OS sources of a release
+func raspiosArmhf(distribution string) sourcePreset {
+	return sourcePreset{
+		Distribution: distribution,
+		Architecture: "armhf",
+		Sources: []presetSource{
+			{URI: raspbianURI, Suite: distribution, Components: []string{"main", "contrib", "non-free", "rpi"},
+				Keyring: "raspbian-archive-keyring.gpg", KeyURL: raspbianKeyURL},
+			raspberryPiSource(distribution),
+		},
+	}
+}
+
+// raspiosArm64 returns the 64-bit Raspberry Pi OS sources of a release
+func raspiosArm64(distribution string, components []string) sourcePreset {
+	debian := debianArchive
+	keyring := "debian-archive-keyring.gpg"
+
+	return sourcePreset{
+		Distribution: distribution,
+		Architecture: "arm64",
+		Sources: []presetSource{
+			{URI: debian, Suite: distribution, Components: components, Keyring: keyring},
+			{URI: debian, Suite: distribution + "-updates", Components: components, Keyring: keyring},
+			{URI: debianSecurity, Suite: distribution + "-security", Components: components, Keyring: keyring},
+			raspberryPiSource(distribution),
+		},
+	}
+}
+
+// raspberryPiSource returns the Raspberry Pi archive (firmware, kernel, tools) of a release
+func raspberryPiSource(distribution string) presetSource {
+	return presetSource{URI: raspberryPiURI, Suite: distribution, Components: []string{"main"},
+		Keyring: "raspberrypi-archive-keyring.gpg", KeyURL: raspberryKeyURL}
+}
+
+// PresetNames returns the names accepted by --preset
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ApplyPreset sets the distribution and architecture of the named preset
+func ApplyPreset(config *config.Config) error {
+	preset, ok := presets[config.Preset]
+
+	if !ok {
+		return fmt.Errorf("unknown preset %q (available: %v)", config.Preset, PresetNames())
+	}
+
+	config.Distribution = preset.Distribution
+	config.Architecture = preset.Architecture
+
+	return nil
+}
+
+// presetSources returns the apt sources of the configured preset. Keyrings missing on
+// the host are fetched from the vendor into keyringDir.
+func presetSources(config *config.Config, keyringDir string) ([]aptenv.Source, error) {
+	preset, ok := presets[config.Preset]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q (available: %v)", config.Preset, PresetNames())
+	}
+
+	var sources []aptenv.Source
+
+	for _, source := range preset.Sources {
+		keyring, err := presetKeyring(source, keyringDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, aptenv.Source{URI: source.URI, Suite: source.Suite, Components: source.Components, SignedBy: keyring})
+	}
+
+	return sources, nil
+}
+
+// presetKeyring returns the host's copy of a preset source's keyring, else the key
+// downloaded from its vendor (kept as .asc, which apt reads as an armored keyring)
+func presetKeyring(source presetSource, keyringDir string) (string, error) {
+	hostKeyring := filepath.Join("/usr/share/keyrings", source.Keyring)
+
+	if _, err := os.Stat(hostKeyring); err == nil {
+		return hostKeyring, nil
+	}
+
+	if source.KeyURL == "" {
+		return "", nil
+	}
+
+	path, err := filepath.Abs(filepath.Join(keyringDir, strings.TrimSuffix(source.Keyring, ".gpg")+".asc"))
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(keyringDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+
+	if err := remote.Download(source.KeyURL, path); err != nil {
+		return "", fmt.Errorf("failed to fetch archive key %s: %w", source.KeyURL, err)
+	}
+
+	output.Warning("%s is not installed; fetched the archive key from %s", source.Keyring, source.KeyURL)
+
+	return path, nil
+}
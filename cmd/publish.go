@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/publish"
+)
+
+// RunPublishCommand uploads the repository to an object store for static hosting,
+// transferring only objects that changed
+func RunPublishCommand(args []string) error {
+	var cfg config.Config
+	var options publish.Options
+	var deleteStale bool
+
+	fs := newFlagSet("publish", &cfg)
+	fs.StringVar(&options.Endpoint, "endpoint", "", "Object store endpoint, e.g. of an S3-compatible store (default: the provider's)")
+	fs.StringVar(&options.Region, "region", "", "Storage region (default: $AWS_REGION or us-east-1)")
+	fs.StringVar(&options.Account, "account", "", "Azure storage account for az:// (default: $AZURE_STORAGE_ACCOUNT)")
+	fs.BoolVar(&deleteStale, "delete", false, "Delete objects the repository no longer has")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: publish [OPTIONS] s3://BUCKET/PREFIX|gs://BUCKET/PREFIX|az://CONTAINER/PREFIX")
+	}
+
+	store, err := publish.Open(fs.Arg(0), options)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := publish.Sync(store, cfg.RepoPath, deleteStale, output.Info)
+
+	if err != nil {
+		return err
+	}
+
+	output.Success("Published to %s: %d uploaded, %d unchanged, %d deleted", fs.Arg(0), result.Uploaded, result.Skipped, result.Deleted)
+
+	return nil
+}
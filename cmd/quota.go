@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/servestats"
+	"portaptable/pkg/snapshot"
+)
+
+// Eviction policies, applied in the order given to --evict
+const (
+	evictSuperseded = "superseded"
+	evictLRU        = "lru"
+)
+
+// sizeUnits maps size suffixes to their byte multipliers
+var sizeUnits = map[string]int64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+	"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+}
+
+// ParseSize parses a byte count with an optional binary unit, e.g. "50G" or "512MiB"
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	split := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+
+	if split < 0 {
+		split = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value[:split], 64)
+	multiplier, ok := sizeUnits[strings.TrimSpace(value[split:])]
+
+	if err != nil || !ok || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 500M, 50G)", value)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// poolFile is a file in the pool considered for eviction
+type poolFile struct {
+	name string
+	size int64
+}
+
+// checkProjectedQuota fails before anything is fetched when the packages of this run
+// alone exceed cfg.MaxSize, since eviction never removes them
+func (run *downloadRun) checkProjectedQuota(cfg *config.Config, packages []string) error {
+	if cfg.MaxSize <= 0 {
+		return nil
+	}
+
+	var needed int64
+
+	for _, size := range run.candidateSizes(packages) {
+		needed += size
+	}
+
+	if needed > cfg.MaxSize {
+		return fmt.Errorf("the %d packages to download need %s, over the %s quota of --max-size", len(packages), formatSize(needed), formatSize(cfg.MaxSize))
+	}
+
+	_, pooled, err := scanPool(filepath.Join(cfg.RepoPath, "pool"))
+
+	if err == nil && pooled+needed > cfg.MaxSize {
+		output.Info("The download may take the repository to %s, over its %s quota; packages outside this run will be evicted", formatSize(pooled+needed), formatSize(cfg.MaxSize))
+	}
+
+	return nil
+}
+
+// enforceQuota evicts pool files until the repository fits in cfg.MaxSize, following
+// cfg.Eviction: superseded removes older versions of packages the manifest still
+// carries, lru drops the packages served least recently (never served first). Files
+// in protected, the closure of the packages just requested, are never evicted.
+func enforceQuota(cfg *config.Config, mfest *manifest.Manifest, protected map[string]bool) error {
+	if cfg.MaxSize <= 0 {
+		return nil
+	}
+
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	files, total, err := scanPool(poolPath)
+
+	if err != nil {
+		return err
+	}
+
+	if total <= cfg.MaxSize {
+		return nil
+	}
+
+	output.Info("Repository uses %s of its %s quota; evicting...", formatSize(total), formatSize(cfg.MaxSize))
+	held := snapshotHeldFiles(cfg.RepoPath, files)
+
+	for _, policy := range strings.Split(cfg.Eviction, ",") {
+		var candidates []poolFile
+
+		switch policy {
+		case evictSuperseded:
+			candidates = supersededFiles(files, mfest)
+
+		case evictLRU:
+			candidates, err = leastRecentlyServed(cfg.RepoPath, files, mfest, protected)
+
+			if err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("invalid eviction policy %q (expected superseded or lru)", policy)
+		}
+
+		for _, file := range candidates {
+			if total <= cfg.MaxSize {
+				break
+			}
+
+			// A snapshot keeps the data; evicting the file would free nothing
+			if held[file.name] {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(poolPath, file.name)); err != nil {
+				return fmt.Errorf("failed to evict %s: %w", file.name, err)
+			}
+
+			output.Info("Evicted %s (%s, %s)", file.name, formatSize(file.size), policy)
+			dropPackage(mfest, file.name)
+			total -= file.size
+		}
+	}
+
+	if total > cfg.MaxSize {
+		output.Warning("Repository still uses %s, over its %s quota; the packages just requested and those snapshots hold are never evicted", formatSize(total), formatSize(cfg.MaxSize))
+	}
+
+	return nil
+}
+
+// snapshotHeldFiles returns the pool files a snapshot links to, which share their
+// data with the snapshot
+func snapshotHeldFiles(repoPath string, files map[string]int64) map[string]bool {
+	held := make(map[string]bool)
+	snapshots, err := os.ReadDir(filepath.Join(repoPath, snapshot.Dir))
+
+	if err != nil {
+		return held
+	}
+
+	for name := range files {
+		info, err := os.Stat(filepath.Join(repoPath, "pool", name))
+
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range snapshots {
+			linked, err := os.Stat(filepath.Join(snapshot.Path(repoPath, entry.Name()), "pool", name))
+
+			if err == nil && os.SameFile(info, linked) {
+				held[name] = true
+
+				break
+			}
+		}
+	}
+
+	return held
+}
+
+// scanPool returns the size of every pool file and their total
+func scanPool(poolPath string) (map[string]int64, int64, error) {
+	entries, err := os.ReadDir(poolPath)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan pool: %w", err)
+	}
+
+	files := make(map[string]int64, len(entries))
+	var total int64
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		files[entry.Name()] = info.Size()
+		total += info.Size()
+	}
+
+	return files, total, nil
+}
+
+// supersededFiles returns unreferenced pool files holding an older version of a
+// package in the manifest, oldest version first
+func supersededFiles(files map[string]int64, mfest *manifest.Manifest) []poolFile {
+	current := make(map[string]packageinfo.PackageInfo)
+	referenced := make(map[string]bool)
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			current[pkg.Name+"_"+pkg.Architecture] = pkg
+			referenced[pkg.Filename] = true
+		}
+	}
+
+	var candidates []poolFile
+	versions := make(map[string]string)
+
+	for name, size := range files {
+		parts := strings.Split(strings.TrimSuffix(name, filepath.Ext(name)), "_")
+
+		if referenced[name] || len(parts) != 3 {
+			continue
+		}
+
+		// Pool names escape the epoch separator: foo_1%3a2.0-1_amd64.deb
+		version, err := url.PathUnescape(parts[1])
+
+		if err != nil {
+			continue
+		}
+
+		pkg, ok := current[parts[0]+"_"+parts[2]]
+
+		if ok && debversion.Compare(version, pkg.Version) < 0 {
+			candidates = append(candidates, poolFile{name: name, size: size})
+			versions[name] = version
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return debversion.Compare(versions[candidates[i].name], versions[candidates[j].name]) < 0
+	})
+
+	return candidates
+}
+
+// leastRecentlyServed returns the manifest's pool files outside protected ordered by
+// serve-mode statistics, never served files first
+func leastRecentlyServed(repoPath string, files map[string]int64, mfest *manifest.Manifest, protected map[string]bool) ([]poolFile, error) {
+	served, err := servestats.Load(repoPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []poolFile
+
+	for _, pkg := range mfest.Packages {
+		if size, ok := files[pkg.Filename]; ok && pkg.Downloaded && !protected[pkg.Filename] {
+			candidates = append(candidates, poolFile{name: pkg.Filename, size: size})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return served[candidates[i].name].Before(served[candidates[j].name])
+	})
+
+	return candidates, nil
+}
+
+// dropPackage removes the manifest entry of an evicted pool file
+func dropPackage(mfest *manifest.Manifest, filename string) {
+	packages := mfest.Packages[:0]
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded || pkg.Filename != filename {
+			packages = append(packages, pkg)
+		}
+	}
+
+	mfest.Packages = packages
+}
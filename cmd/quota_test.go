@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/snapshot"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"512B", 512},
+		{"4k", 4 << 10},
+		{"500M", 500 << 20},
+		{"512MiB", 512 << 20},
+		{"50G", 50 << 30},
+		{"1.5GB", 3 << 29},
+		{" 2 T ", 2 << 40},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSize(test.value)
+
+		if err != nil {
+			t.Errorf("ParseSize(%q): %v", test.value, err)
+
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseSizeErrors(t *testing.T) {
+	for _, value := range []string{"", "G", "-5M", "10X", "1.2.3K", "50 GG"} {
+		if size, err := ParseSize(value); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", value, size)
+		}
+	}
+}
+
+func TestSupersededFiles(t *testing.T) {
+	files := map[string]int64{
+		"foo_1%3a2.0-1_amd64.deb": 10,
+		"foo_1%3a1.0-1_amd64.deb": 20,
+		"foo_3.0-1_amd64.deb":     30,
+		"bar_1.0_all.deb":         40,
+	}
+
+	mfest := &manifest.Manifest{Packages: []packageinfo.PackageInfo{
+		{Name: "foo", Version: "1:2.0-1", Architecture: "amd64", Filename: "foo_1%3a2.0-1_amd64.deb", Downloaded: true},
+	}}
+	candidates := supersededFiles(files, mfest)
+
+	// 3.0-1 has no epoch, so it is older than 1:1.0-1 and evicted first
+	if len(candidates) != 2 || candidates[0].name != "foo_3.0-1_amd64.deb" || candidates[1].name != "foo_1%3a1.0-1_amd64.deb" {
+		t.Errorf("supersededFiles() = %+v, want foo_3.0-1 then foo_1:1.0-1", candidates)
+	}
+}
+
+func TestSnapshotHeldFiles(t *testing.T) {
+	repo := t.TempDir()
+	files := map[string]int64{"app_1.0_amd64.deb": 3, "lib_1.0_amd64.deb": 3}
+
+	for name := range files {
+		path := filepath.Join(repo, "pool", name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte("deb"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One snapshot links app; the other holds a copy of lib, which shares nothing
+	linked := filepath.Join(snapshot.Path(repo, "linked"), "pool", "app_1.0_amd64.deb")
+	copied := filepath.Join(snapshot.Path(repo, "copied"), "pool", "lib_1.0_amd64.deb")
+
+	for _, path := range []string{linked, copied} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Link(filepath.Join(repo, "pool", "app_1.0_amd64.deb"), linked); err != nil {
+		t.Skipf("hard links unsupported: %v", err)
+	}
+
+	if err := os.WriteFile(copied, []byte("deb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	held := snapshotHeldFiles(repo, files)
+
+	if !held["app_1.0_amd64.deb"] || held["lib_1.0_amd64.deb"] {
+		t.Errorf("snapshotHeldFiles() = %v, want app only", held)
+	}
+}
+
+func TestEnforceQuotaProtected(t *testing.T) {
+	repo := t.TempDir()
+	mfest := &manifest.Manifest{}
+
+	for _, name := range []string{"app_1.0_amd64.deb", "lib_1.0_amd64.deb", "old_1.0_amd64.deb"} {
+		path := filepath.Join(repo, "pool", name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		mfest.Packages = append(mfest.Packages, packageinfo.PackageInfo{Name: name[:3], Version: "1.0", Architecture: "amd64", Filename: name, Downloaded: true})
+	}
+
+	// The quota cannot be met without the protected closure, so only old goes
+	cfg := &config.Config{RepoPath: repo, MaxSize: 5, Eviction: "lru"}
+	protected := map[string]bool{"app_1.0_amd64.deb": true, "lib_1.0_amd64.deb": true}
+
+	if err := enforceQuota(cfg, mfest, protected); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range protected {
+		if _, err := os.Stat(filepath.Join(repo, "pool", name)); err != nil {
+			t.Errorf("protected %s was evicted: %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(repo, "pool", "old_1.0_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("old_1.0_amd64.deb outside the closure was kept")
+	}
+
+	if len(mfest.Packages) != 2 {
+		t.Errorf("the manifest keeps %d packages, want 2", len(mfest.Packages))
+	}
+}
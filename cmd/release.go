@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/cosign"
+	"portaptable/pkg/output"
+	"portaptable/pkg/release"
+	"portaptable/pkg/torrent"
+	"portaptable/pkg/zsync"
+)
+
+// RunReleaseCommand attaches an export bundle, its checksum file and any signatures
+// written by export to a GitHub or GitLab release
+func RunReleaseCommand(args []string) error {
+	var cfg config.Config
+	var options release.Options
+	var githubRepo, gitlabProject, tag string
+
+	fs := newFlagSet("release", &cfg)
+	fs.StringVar(&githubRepo, "github", "", "GitHub repository (owner/repo); token from $GITHUB_TOKEN")
+	fs.StringVar(&gitlabProject, "gitlab", "", "GitLab project path (group/project); token from $GITLAB_TOKEN")
+	fs.StringVar(&tag, "tag", "", "Release tag to attach the bundle to (created if missing)")
+	fs.StringVar(&options.APIURL, "api-url", "", "API URL of a self-hosted GitHub Enterprise or GitLab instance")
+	fs.StringVar(&options.Ref, "ref", "", "GitLab: branch or commit a new tag is created from")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || tag == "" || (githubRepo == "") == (gitlabProject == "") {
+		return fmt.Errorf("usage: release --github OWNER/REPO|--gitlab GROUP/PROJECT --tag TAG BUNDLE")
+	}
+
+	var publisher release.Publisher
+	var err error
+
+	if githubRepo != "" {
+		publisher, err = release.NewGitHub(githubRepo, options)
+	} else {
+		publisher, err = release.NewGitLab(gitlabProject, options)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	bundlePath := fs.Arg(0)
+	assets, err := releaseAssets(bundlePath)
+
+	if err != nil {
+		return err
+	}
+
+	output.Info("Uploading %d assets to release %s...", len(assets), tag)
+
+	if err := publisher.Upload(tag, assets); err != nil {
+		return err
+	}
+
+	output.Success("Attached %d assets to release %s", len(assets), tag)
+
+	return nil
+}
+
+// releaseAssets returns the bundle, a freshly written SHA256 checksum file and the
+// GPG and cosign signatures, torrent and zsync control files left next to the bundle
+func releaseAssets(bundlePath string) ([]string, error) {
+	sums, err := checksum.File(bundlePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checksumPath := bundlePath + ".sha256"
+	line := fmt.Sprintf("%s  %s\n", sums.SHA256, filepath.Base(bundlePath))
+
+	if err := os.WriteFile(checksumPath, []byte(line), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write checksum file: %w", err)
+	}
+
+	assets := []string{bundlePath, checksumPath}
+
+	for _, extra := range []string{bundlePath + ".asc", cosign.SignatureFile(bundlePath), bundlePath + torrent.Extension, bundlePath + zsync.Extension} {
+		if _, err := os.Stat(extra); err == nil {
+			assets = append(assets, extra)
+		}
+	}
+
+	return assets, nil
+}
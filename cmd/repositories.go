@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+)
+
+// Launchpad serves PPAs and the fingerprints of their signing keys, which the Ubuntu
+// keyserver hands out
+const (
+	ppaArchiveURL   = "https://ppa.launchpadcontent.net/%s/%s/ubuntu"
+	ppaAPIURL       = "https://api.launchpad.net/1.0/~%s/+archive/ubuntu/%s"
+	ppaKeyServerURL = "https://keyserver.ubuntu.com/pks/lookup?op=get&options=mr&search=0x%s"
+)
+
+// repository is an additional archive from the --config file, such as a Launchpad PPA
+// or a vendor's repository, whose packages resolve and download along with the
+// distribution's
+type repository struct {
+	URL        string   `json:"url,omitempty"`
+	PPA        string   `json:"ppa,omitempty"`        // OWNER/NAME of a Launchpad PPA, instead of url
+	Suite      string   `json:"suite,omitempty"`      // Default: the distribution
+	Components []string `json:"components,omitempty"` // Default: main
+
+	// Key is the signing key as a file or URL, armored or binary; a PPA's is fetched
+	// from Launchpad, and without one the host's trusted keys apply
+	Key string `json:"key,omitempty"`
+
+	keyring string // Key as a keyring file apt and gpgv read, set by prepareRepositories
+}
+
+// configFile is the --config file: {"distro": VENDOR, "repositories": [{"url" or "ppa",
+// "suite", "components", "key"}]}
+type configFile struct {
+	Distro       string       `json:"distro,omitempty"` // debian, ubuntu, raspbian or linuxmint; default: by --dist
+	Repositories []repository `json:"repositories"`
+}
+
+// readConfigFile parses the --config file; without one it is empty
+func readConfigFile(config *config.Config) (configFile, error) {
+	var file configFile
+
+	if config.ConfigFile == "" {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(config.ConfigFile)
+
+	if err != nil {
+		return file, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&file); err != nil {
+		return file, fmt.Errorf("failed to parse config file %s: %w", config.ConfigFile, err)
+	}
+
+	return file, nil
+}
+
+// prepareRepositories reads the repositories of the --config file, after Linux Mint's
+// archive when --dist names a Mint release, filling in their defaults and storing
+// their signing keys in the repository's private apt directory
+func (run *downloadRun) prepareRepositories(config *config.Config) error {
+	run.extraRepositories = nil
+
+	file, err := readConfigFile(config)
+
+	if err != nil {
+		return err
+	}
+
+	keyringDir := filepath.Join(config.RepoPath, aptDir, "keyrings")
+
+	for _, repo := range append(run.linuxMintArchiveRepository(), file.Repositories...) {
+		if repo.PPA != "" {
+			owner, name, ok := strings.Cut(repo.PPA, "/")
+
+			if !ok || repo.URL != "" {
+				return fmt.Errorf("invalid repository in %s: ppa takes OWNER/NAME and no url, got %q", config.ConfigFile, repo.PPA)
+			}
+
+			repo.URL = fmt.Sprintf(ppaArchiveURL, owner, name)
+
+			if repo.Key == "" {
+				if repo.Key, err = ppaKeyURL(owner, name); err != nil {
+					return err
+				}
+			}
+		}
+
+		if repo.URL == "" {
+			return fmt.Errorf("invalid repository in %s: it needs a url or a ppa", config.ConfigFile)
+		}
+
+		repo.URL = strings.TrimSuffix(normalizeMirror(repo.URL), "/")
+
+		if repo.Suite == "" {
+			repo.Suite = config.Distribution
+		}
+
+		if len(repo.Components) == 0 {
+			repo.Components = []string{"main"}
+		}
+
+		if repo.Key != "" {
+			if repo.keyring, err = repositoryKeyring(repo.Key, keyringDir); err != nil {
+				return err
+			}
+		}
+
+		run.extraRepositories = append(run.extraRepositories, repo)
+	}
+
+	return nil
+}
+
+// ppaKeyURL asks Launchpad for the fingerprint of a PPA's signing key and returns
+// where the keyserver serves the key, as add-apt-repository does
+func ppaKeyURL(owner, name string) (string, error) {
+	body, err := remote.Get(fmt.Sprintf(ppaAPIURL, owner, name))
+
+	if err != nil {
+		return "", fmt.Errorf("failed to look up PPA %s/%s: %w", owner, name, err)
+	}
+
+	defer body.Close()
+
+	var archive struct {
+		Fingerprint string `json:"signing_key_fingerprint"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&archive); err != nil {
+		return "", fmt.Errorf("failed to parse Launchpad's answer for PPA %s/%s: %w", owner, name, err)
+	}
+
+	if archive.Fingerprint == "" {
+		return "", fmt.Errorf("PPA %s/%s has no signing key yet", owner, name)
+	}
+
+	return fmt.Sprintf(ppaKeyServerURL, archive.Fingerprint), nil
+}
+
+// repositoryKeyring returns a keyring file holding a repository's key: a local file
+// as it is, a fetched one stored in keyringDir under a name apt reads it by (.asc for
+// armored keys, .gpg for binary ones)
+func repositoryKeyring(key, keyringDir string) (string, error) {
+	if !strings.Contains(key, "://") {
+		return filepath.Abs(key)
+	}
+
+	body, err := remote.Get(key)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch repository key %s: %w", key, err)
+	}
+
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch repository key %s: %w", key, err)
+	}
+
+	extension := ".gpg"
+
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		extension = ".asc"
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	path, err := filepath.Abs(filepath.Join(keyringDir, "repository-"+hex.EncodeToString(sum[:6])+extension))
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(keyringDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write repository key: %w", err)
+	}
+
+	return path, nil
+}
+
+// addRepositorySources adds the --config file's repositories to the private apt configuration
+func (run *downloadRun) addRepositorySources(env *aptenv.Env) error {
+	if len(run.extraRepositories) == 0 {
+		return nil
+	}
+
+	var sources []aptenv.Source
+
+	for _, repo := range run.extraRepositories {
+		sources = append(sources, aptenv.Source{URI: repo.URL, Suite: repo.Suite, Components: repo.Components, SignedBy: repo.keyring})
+		output.Info("Adding repository %s %s %s", repo.URL, repo.Suite, strings.Join(repo.Components, " "))
+	}
+
+	return env.AddSources("repositories", sources)
+}
@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/archive"
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/depgraph"
+	"portaptable/pkg/hostapt"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/remote"
+)
+
+// Resolvers accepted by --resolver
+const (
+	resolverApt    = "apt"
+	resolverNative = "native"
+)
+
+// packageIndex holds every package of the configured suites, read straight from
+// the archive's Packages indexes, the way apt's cache would
+type packageIndex struct {
+	architecture string
+	foreign      []string
+
+	// graphs resolve relations per architecture: unqualified dependencies of a
+	// foreign package name packages of its own architecture
+	graphs  map[string]*depgraph.Graph
+	names   map[string]bool
+	mirrors map[string]archive.Mirror // Archive publishing each package, by indexKey
+
+	// selected holds the versions resolution chose, by qualified name, where a
+	// versioned dependency ruled out the candidate
+	selected map[string]packageinfo.PackageInfo
+}
+
+// indexKey identifies one version of a package in the index
+func indexKey(pkg packageinfo.PackageInfo) string {
+	return pkg.Name + "_" + pkg.Version + "_" + pkg.Architecture
+}
+
+// chooseResolver returns the --resolver to use: apt where it is installed, else native
+func chooseResolver(cfg *config.Config) (string, error) {
+	switch cfg.Resolver {
+	case resolverApt, resolverNative:
+		return cfg.Resolver, nil
+
+	case "":
+		if _, err := exec.LookPath("apt-get"); err != nil {
+			return resolverNative, nil
+		}
+
+		return resolverApt, nil
+	}
+
+	return "", fmt.Errorf("invalid --resolver %q (apt or native)", cfg.Resolver)
+}
+
+// indexPockets returns the pockets whose indexes the native resolver reads: the
+// configured ones, else the defaults, plus those backports and suite targets need
+func (run *downloadRun) indexPockets(config *config.Config) ([]string, error) {
+	pockets := config.Pockets
+
+	if len(pockets) == 0 {
+		pockets = run.distroPockets(config.Distribution)
+	}
+
+	if err := validatePockets(pockets); err != nil {
+		return nil, err
+	}
+
+	if config.Backports || len(config.BackportsPackages) > 0 {
+		pockets = appendMissing(pockets, []string{pocketBackports})
+	}
+
+	return appendMissing(pockets, run.targetPockets(config.Distribution)), nil
+}
+
+// setupNativeIndex fetches the Packages indexes of the configured pockets, components
+// and architectures and makes them the source of resolution and downloads. Backports
+// only supply the packages selected from them and those no other pocket has, as
+// apt's pinning would.
+func (run *downloadRun) setupNativeIndex(config *config.Config) error {
+	if config.Preset != "" || config.ESMTokenFile != "" {
+		return fmt.Errorf("the native resolver does not support --preset or --esm-token; use --resolver apt")
+	}
+
+	pockets, err := run.indexPockets(config)
+
+	if err != nil {
+		return err
+	}
+
+	mirrorURLs, byMirror := run.architectureMirrors(config)
+	components := run.sourceComponents(config)
+	architectures := targetArchitectures(config)
+
+	index := &packageIndex{
+		architecture: config.Architecture,
+		foreign:      qualifiedArchitectures(config),
+		graphs:       make(map[string]*depgraph.Graph),
+		names:        make(map[string]bool),
+		mirrors:      make(map[string]archive.Mirror),
+		selected:     make(map[string]packageinfo.PackageInfo),
+	}
+
+	var packages, backports []packageinfo.PackageInfo
+	var suites []string
+	entrySuites := make(map[string]string)          // Suite of each entry, by indexKey
+	entryOrigins := make(map[string]hostapt.Origin) // Where each entry comes from, for APT preferences
+
+	// addIndexes adds the packages of a suite's indexes, each from the first archive
+	// listing it
+	addIndexes := func(mirror archive.Mirror, suite string, components, architectures []string, backport bool) error {
+		for _, component := range components {
+			origin := releaseOrigin(mirror, suite, component)
+
+			for _, architecture := range architectures {
+				entries, err := mirror.FetchPackages(suite, component, architecture, false)
+
+				// Not every suite carries every component and architecture
+				if errors.Is(err, remote.ErrNotFound) {
+					continue
+				}
+
+				if err != nil {
+					return err
+				}
+
+				for _, entry := range entries {
+					pkg := packageinfo.PackageInfo{
+						Name:         entry["Package"],
+						Version:      entry["Version"],
+						Architecture: entry["Architecture"],
+						Control:      entry,
+					}
+
+					// Architecture-independent packages appear in every architecture's index
+					if _, seen := index.mirrors[indexKey(pkg)]; seen {
+						continue
+					}
+
+					index.mirrors[indexKey(pkg)] = mirror
+					entrySuites[indexKey(pkg)] = suite
+					entryOrigins[indexKey(pkg)] = origin
+
+					if backport {
+						backports = append(backports, pkg)
+					} else {
+						packages = append(packages, pkg)
+						index.names[pkg.Name] = true
+					}
+				}
+			}
+		}
+
+		return nil
+	}
+
+	// Ubuntu's ports archive may serve some of the architectures
+	for _, mirrorURL := range mirrorURLs {
+		for _, pocket := range pockets {
+			uri := mirrorURL
+
+			if pocket == pocketSecurity {
+				uri = run.securityMirror(config.Distribution, mirrorURL)
+			}
+
+			suite := run.pocketSuite(config.Distribution, pocket)
+
+			if !hasSuite(uri, suite) {
+				output.Warning("Local mirror %s has no %s suite; skipping the %s pocket", uri, suite, pocket)
+
+				continue
+			}
+
+			mirror := run.newArchiveMirror(uri, config.RepoPath)
+
+			if err := run.verifyMirrorRelease(&mirror, suite, config.RepoPath); err != nil {
+				return err
+			}
+
+			output.Info("Fetching %s indexes from %s...", suite, uri)
+			suites = appendMissing(suites, []string{suite})
+
+			if err := addIndexes(mirror, suite, components, byMirror[mirrorURL], pocket == pocketBackports); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The --config file's repositories, each signed with its own key where it names one
+	for _, repo := range run.extraRepositories {
+		mirror := run.newArchiveMirror(repo.URL, config.RepoPath)
+
+		if repo.keyring != "" {
+			err = run.verifyReleaseWith(&mirror, repo.Suite, []string{repo.keyring})
+		} else {
+			err = run.verifyMirrorRelease(&mirror, repo.Suite, config.RepoPath)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		output.Info("Fetching %s indexes from %s...", repo.Suite, repo.URL)
+		suites = appendMissing(suites, []string{repo.Suite})
+
+		if err := addIndexes(mirror, repo.Suite, repo.Components, architectures, false); err != nil {
+			return err
+		}
+	}
+
+	// Backports replace the dependencies of the selected packages too, as with -t <suite>-backports
+	selected := config.BackportsPackages
+
+	if len(selected) > 0 {
+		selected = backportsClosure(packages, backports, selected, architectures)
+	}
+
+	for _, pkg := range backports {
+		if contains(selected, pkg.Name) || !index.names[pkg.Name] || run.hasPin(pkg.Name) || run.hasTarget(pkg.Name, entrySuites[indexKey(pkg)]) {
+			packages = append(packages, pkg)
+		}
+	}
+
+	if len(packages) == 0 {
+		return fmt.Errorf("no packages found for %s in %s", config.Distribution, strings.Join(mirrorURLs, ", "))
+	}
+
+	// Selected backports replace the other pockets' versions entirely
+	if len(selected) > 0 {
+		packages = preferBackports(packages, backports, selected)
+	}
+
+	packages, err = run.pinVersions(packages, config.Architecture)
+
+	if err != nil {
+		return err
+	}
+
+	packages, err = run.targetVersions(packages, entrySuites, config.Architecture)
+
+	if err != nil {
+		return err
+	}
+
+	packages = run.preferredVersions(packages, entryOrigins, config.Architecture)
+
+	for _, pkg := range packages {
+		index.names[pkg.Name] = true
+	}
+
+	for _, architecture := range architectures {
+		index.graphs[architecture] = depgraph.New(packages, architecture)
+		index.graphs[architecture].PreferProviders(config.PreferredProviders)
+		index.graphs[architecture].PreferAlternatives(config.Alternatives)
+	}
+
+	output.Info("Indexed %d packages from %s", len(packages), strings.Join(suites, ", "))
+	run.nativeIndex = index
+
+	return nil
+}
+
+// backportsClosure returns the selected packages and the dependencies of theirs that
+// backports carry, resolved with every backport available, as apt resolves them for
+// apt-get install -t <suite>-backports
+func backportsClosure(packages, backports []packageinfo.PackageInfo, selected, architectures []string) []string {
+	available := append(append([]packageinfo.PackageInfo{}, packages...), backports...)
+	fromBackports := make(map[string]bool)
+
+	for _, pkg := range backports {
+		fromBackports[indexKey(pkg)] = true
+	}
+
+	closure := append([]string{}, selected...)
+
+	for _, architecture := range architectures {
+		graph := depgraph.New(available, architecture)
+
+		for _, name := range selected {
+			dependencies, _, ok := graph.Closure(name, depgraph.Hard)
+
+			if !ok {
+				continue
+			}
+
+			for _, pkg := range dependencies {
+				if fromBackports[indexKey(pkg)] {
+					closure = appendMissing(closure, []string{pkg.Name})
+				}
+			}
+		}
+	}
+
+	return closure
+}
+
+// preferBackports drops the non-backports versions of the selected packages when
+// backports carry them
+func preferBackports(packages, backports []packageinfo.PackageInfo, selected []string) []packageinfo.PackageInfo {
+	fromBackports := make(map[string]bool)
+
+	for _, pkg := range backports {
+		if contains(selected, pkg.Name) {
+			fromBackports[pkg.Name] = true
+		}
+	}
+
+	kept := packages[:0]
+
+	for _, pkg := range packages {
+		if !fromBackports[pkg.Name] || containsPackage(backports, pkg) {
+			kept = append(kept, pkg)
+		}
+	}
+
+	return kept
+}
+
+// containsPackage reports whether packages holds this version of pkg
+func containsPackage(packages []packageinfo.PackageInfo, pkg packageinfo.PackageInfo) bool {
+	for _, candidate := range packages {
+		if indexKey(candidate) == indexKey(pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// find returns the version of a package given as name or name:arch that resolution
+// selected, else its candidate
+func (idx *packageIndex) find(spec string) (packageinfo.PackageInfo, bool) {
+	if pkg, ok := idx.selected[spec]; ok {
+		return pkg, true
+	}
+
+	return idx.graph(spec).Find(strings.SplitN(spec, ":", 2)[0])
+}
+
+// graph returns the graph resolving a name:arch spec; plain names use the primary architecture
+func (idx *packageIndex) graph(spec string) *depgraph.Graph {
+	_, architecture, _ := strings.Cut(spec, ":")
+
+	if graph, ok := idx.graphs[architecture]; ok {
+		return graph
+	}
+
+	return idx.graphs[idx.architecture]
+}
+
+// qualify names a package as download mode does: name:arch for foreign architectures
+func (idx *packageIndex) qualify(pkg packageinfo.PackageInfo) string {
+	if contains(idx.foreign, pkg.Architecture) {
+		return pkg.Name + ":" + pkg.Architecture
+	}
+
+	return pkg.Name
+}
+
+// resolve returns the packages and their recursive Pre-Depends and Depends, as
+// apt-cache depends --recurse would, following the first satisfiable alternative
+func (idx *packageIndex) resolve(packages []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+
+	for _, spec := range packages {
+		closure, missing, ok := idx.graph(spec).Closure(strings.SplitN(spec, ":", 2)[0], depgraph.Hard)
+
+		if !ok {
+			return nil, fmt.Errorf("package %s is not in the archive indexes", spec)
+		}
+
+		for _, dependency := range missing {
+			output.Warning("%s depends on %s, which no indexed package satisfies", spec, dependency)
+		}
+
+		for _, pkg := range closure {
+			if name := idx.qualify(pkg); !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+
+				if candidate, _ := idx.find(name); candidate.Version != pkg.Version {
+					idx.selected[name] = pkg
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// record returns the index entry of a package's candidate version
+func (idx *packageIndex) record(spec string) (deb822.Paragraph, bool) {
+	pkg, ok := idx.find(spec)
+
+	return deb822.Paragraph(pkg.Control), ok
+}
+
+// download fetches the candidate version of a package into poolPath, verified against
+// the index, and returns the path of the file
+func (idx *packageIndex) download(spec, poolPath string) (string, error) {
+	pkg, ok := idx.find(spec)
+
+	if !ok {
+		return "", fmt.Errorf("package %s is not in the archive indexes", spec)
+	}
+
+	mirror := idx.mirrors[indexKey(pkg)]
+	filename, _, err := mirror.DownloadPackage(deb822.Paragraph(pkg.Control), poolPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(poolPath, filename), nil
+}
+
+// reverseDepends returns the names of packages that depend on name directly
+func (idx *packageIndex) reverseDepends(name string) []string {
+	var names []string
+
+	for _, pkg := range idx.graphs[idx.architecture].ReverseDepends(name, depgraph.Hard, false) {
+		names = append(names, pkg.Name)
+	}
+
+	return names
+}
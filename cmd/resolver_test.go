@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"portaptable/pkg/packageinfo"
+)
+
+func TestBackportsClosure(t *testing.T) {
+	entry := func(name, version, depends string) packageinfo.PackageInfo {
+		return packageinfo.PackageInfo{Name: name, Version: version, Architecture: "amd64",
+			Control: map[string]string{"Package": name, "Version": version, "Architecture": "amd64", "Depends": depends}}
+	}
+
+	packages := []packageinfo.PackageInfo{entry("app", "1.0", "lib"), entry("lib", "1.0", "libc"), entry("libc", "1.0", "")}
+	backports := []packageinfo.PackageInfo{entry("app", "2.0", "lib (>= 2.0), libc"), entry("lib", "2.0", "libc"), entry("other", "2.0", "")}
+
+	// libc only comes from the base suite, and other is no dependency of app
+	if got, want := backportsClosure(packages, backports, []string{"app"}, []string{"amd64"}), []string{"app", "lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("backportsClosure() = %v, want %v", got, want)
+	}
+
+	kept := preferBackports(append(append([]packageinfo.PackageInfo{}, packages...), backports[:2]...), backports, []string{"app", "lib"})
+
+	for _, pkg := range kept {
+		if (pkg.Name == "app" || pkg.Name == "lib") && pkg.Version != "2.0" {
+			t.Errorf("preferBackports() kept %s %s, want only the backport", pkg.Name, pkg.Version)
+		}
+	}
+
+	if len(kept) != 3 {
+		t.Errorf("preferBackports() kept %d packages, want app, lib and libc", len(kept))
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"time"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+	"portaptable/pkg/hostapt"
+)
+
+// downloadRun holds the selections and package sources of one run, built from its
+// config.Config; the daemon runs download mode again in-process, so none of this may
+// outlive the run
+type downloadRun struct {
+	// aptEnv is the private apt configuration in use; nil uses the host's sources
+	aptEnv *aptenv.Env
+
+	// requireSHA256 is the SHA256 policy of the run, set by applyHashPolicy
+	requireSHA256 bool
+
+	// archiveKeyrings replace the host's and the vendor's keys in verifying natively
+	// fetched Release files, and allowUnauthenticated lets a run go on when one fails
+	// to verify; both set by applyTrustPolicy
+	archiveKeyrings      []string
+	allowUnauthenticated bool
+
+	// versionPins maps the packages requested as name=version (name:arch=version for
+	// foreign architectures) to that version; resolution and downloads take it in
+	// place of the candidate
+	versionPins map[string]string
+
+	// suiteTargets maps the packages requested as name/suite (name:arch/suite for
+	// foreign architectures) to that suite; the rest of the closure resolves as usual
+	suiteTargets map[string]string
+
+	// additionalArchitectures are the further targets of --arch amd64,arm64; a package
+	// requested or pinned by its bare name applies to each of them as to the primary
+	additionalArchitectures []string
+
+	// archiveSnapshot is the --snapshot time the vendor archives are taken at; zero
+	// uses them as they are now
+	archiveSnapshot time.Time
+
+	// preferredProviders are the --prefer-providers packages chosen first to provide a
+	// virtual package
+	preferredProviders []string
+
+	// alternativeChoices map a name offered among a dependency's alternatives, e.g.
+	// default-mta, to the --alternative package chosen to satisfy it
+	alternativeChoices map[string]string
+
+	// aptPreferences are the pins of --preferences, else of the host's apt when its
+	// sources carry the target; set by preparePreferences
+	aptPreferences []hostapt.Preference
+
+	// nativeIndex answers what the run would otherwise ask apt when the native
+	// resolver is in use; nil leaves resolution and downloads to apt
+	nativeIndex *packageIndex
+
+	// extraRepositories are the --config file's repositories, set by
+	// prepareRepositories
+	extraRepositories []repository
+
+	// linuxMintRelease is the Linux Mint codename --dist named, whose base release
+	// replaced it; set by applyDistroProfile
+	linuxMintRelease string
+
+	// fetchedKeyrings hold the archive keys of vendors whose keyring package the host
+	// lacks, by vendor; set by prepareDistroKeyring
+	fetchedKeyrings map[string]string
+
+	// distroVendors maps a distribution to the vendor the --config file's "distro"
+	// names for it, e.g. bookworm to raspbian; set by applyDistroProfile
+	distroVendors map[string]string
+}
+
+// newDownloadRun starts a run with the selections config carries directly; the rest
+// are set as the run prepares its sources
+func newDownloadRun(config *config.Config) *downloadRun {
+	return &downloadRun{
+		additionalArchitectures: config.AdditionalArchitectures,
+		archiveSnapshot:         config.ArchiveSnapshot,
+		preferredProviders:      config.PreferredProviders,
+		alternativeChoices:      config.Alternatives,
+		fetchedKeyrings:         map[string]string{},
+		distroVendors:           map[string]string{},
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/debfile"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/sbom"
+)
+
+// RunSBOMCommand writes a software bill of materials for the repository contents
+func RunSBOMCommand(args []string) error {
+	var cfg config.Config
+	var format, output string
+
+	fs := newFlagSet("sbom", &cfg)
+	fs.StringVar(&format, "format", sbom.FormatCycloneDX, "SBOM format: cyclonedx or spdx")
+	fs.StringVar(&output, "output", "", "Output file (default: stdout)")
+	fs.Parse(args)
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	distro := newDownloadRun(&cfg).distroVendor(mfest.Distribution)
+
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	components := make([]sbom.Component, 0, len(mfest.Packages))
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded || pkg.Type == packageinfo.TypeSource {
+			continue
+		}
+
+		pkgPath := filepath.Join(poolPath, pkg.Filename)
+
+		// Older manifests carry no checksums; hash the pool file instead
+		if pkg.SHA256 == "" {
+			sums, err := checksum.File(pkgPath)
+
+			if err != nil {
+				return err
+			}
+
+			pkg.MD5sum, pkg.SHA1, pkg.SHA256 = sums.MD5, sums.SHA1, sums.SHA256
+		}
+
+		licenses, err := debfile.Licenses(pkgPath, pkg.Name)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: No license information for %s: %v\n", pkg.Name, err)
+		}
+
+		components = append(components, sbom.Component{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Architecture: pkg.Architecture,
+			Distro:       distro,
+			Release:      mfest.Distribution,
+			MD5:          pkg.MD5sum,
+			SHA1:         pkg.SHA1,
+			SHA256:       pkg.SHA256,
+			Licenses:     licenses,
+		})
+	}
+
+	name := fmt.Sprintf("portaptable-%s-%s", mfest.Distribution, mfest.Architecture)
+	document, err := sbom.Generate(format, name, components)
+
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetIndent("", "  ")
+
+	// Package URLs contain '&', which must stay readable
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("failed to encode SBOM: %w", err)
+	}
+
+	if output == "" {
+		_, err := os.Stdout.Write(data.Bytes())
+
+		return err
+	}
+
+	if err := os.WriteFile(output, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write SBOM: %w", err)
+	}
+
+	fmt.Printf("Wrote %s SBOM with %d components to %s\n", format, len(components), output)
+
+	return nil
+}
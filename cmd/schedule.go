@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/output"
+	"portaptable/pkg/relation"
+)
+
+// scheduleDownloads orders the resolved packages so an interrupted run leaves the most
+// useful repository: first what has to be unpacked before anything else installs
+// (Essential and required packages and the targets of Pre-Depends), then the rest
+// from the smallest up, so most packages are already complete early on. Without
+// index data for the set the order is left as it is.
+func (run *downloadRun) scheduleDownloads(packages []string) []string {
+	records := run.candidateParagraphs(packages)
+
+	if len(records) == 0 {
+		output.Warning("Cannot read package sizes, downloading in resolution order")
+
+		return packages
+	}
+
+	critical := make(map[string]bool)
+
+	for _, pkg := range packages {
+		record := records[pkg]
+
+		if record["Essential"] == "yes" || record["Priority"] == "required" {
+			critical[pkg] = true
+		}
+
+		for _, group := range relation.Parse(record["Pre-Depends"]) {
+			for _, alternative := range group {
+				critical[alternative.Name] = true
+			}
+		}
+	}
+
+	size := func(pkg string) int64 {
+		value, err := strconv.ParseInt(records[pkg]["Size"], 10, 64)
+
+		if err != nil {
+			return 1 << 62 // Unknown sizes go last
+		}
+
+		return value
+	}
+
+	isCritical := func(pkg string) bool {
+		name, _, _ := strings.Cut(pkg, ":")
+
+		return critical[pkg] || critical[name]
+	}
+
+	ordered := append([]string(nil), packages...)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+
+		if isCritical(a) != isCritical(b) {
+			return isCritical(a)
+		}
+
+		if size(a) != size(b) {
+			return size(a) < size(b)
+		}
+
+		return a < b
+	})
+
+	return ordered
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+)
+
+// addSectionPackages adds every package whose candidate is in one of the --section
+// sections and has one of the --priority priorities; either left empty allows all
+func (run *downloadRun) addSectionPackages(cfg *config.Config, packages []string) ([]string, error) {
+	if len(cfg.Sections) == 0 && len(cfg.Priorities) == 0 {
+		return packages, nil
+	}
+
+	records, err := run.candidateRecords()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []string
+	seen := make(map[string]bool)
+
+	for _, record := range records {
+		// Sections outside main carry their component, e.g. universe/editors
+		if len(cfg.Sections) > 0 && !contains(cfg.Sections, path.Base(record["Section"])) {
+			continue
+		}
+
+		if len(cfg.Priorities) > 0 && !contains(cfg.Priorities, record["Priority"]) {
+			continue
+		}
+
+		if !seen[record["Package"]] {
+			seen[record["Package"]] = true
+			selected = append(selected, record["Package"])
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no package is in the selected sections and priorities")
+	}
+
+	sort.Strings(selected)
+	output.Info("Selected %d packages by section and priority", len(selected))
+
+	return appendMissing(packages, selected), nil
+}
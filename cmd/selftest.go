@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+)
+
+// containerPlatforms maps Debian architectures to OCI platforms
+var containerPlatforms = map[string]string{
+	"amd64":    "linux/amd64",
+	"arm64":    "linux/arm64",
+	"armhf":    "linux/arm/v7",
+	"armel":    "linux/arm/v5",
+	"i386":     "linux/386",
+	"ppc64el":  "linux/ppc64le",
+	"s390x":    "linux/s390x",
+	"riscv64":  "linux/riscv64",
+	"mips64el": "linux/mips64le",
+}
+
+// RunSelftestCommand serves the repository on a random port and lets apt in a container
+// of the target distribution update from it and download the requested packages
+func RunSelftestCommand(args []string) error {
+	var cfg config.Config
+	var runtime, image string
+
+	fs := newFlagSet("selftest", &cfg)
+	fs.StringVar(&runtime, "runtime", "", "Container runtime: docker or podman (default: whichever is installed)")
+	fs.StringVar(&image, "image", "", "Container image (default: <vendor>:<dist>, e.g. ubuntu:jammy)")
+	fs.Parse(args)
+
+	if runtime == "" {
+		for _, candidate := range []string{"docker", "podman"} {
+			if _, err := exec.LookPath(candidate); err == nil {
+				runtime = candidate
+
+				break
+			}
+		}
+
+		if runtime == "" {
+			return fmt.Errorf("no container runtime found; install docker or podman or pass --runtime")
+		}
+	}
+
+	server, err := newRepositoryServer(&cfg)
+
+	if err != nil {
+		return err
+	}
+
+	mfest := server.current()
+
+	if image == "" {
+		image = newDownloadRun(&cfg).distroVendor(mfest.Distribution) + ":" + mfest.Distribution
+	}
+
+	packages := fs.Args()
+
+	if len(packages) == 0 {
+		packages = selftestPackages(mfest.Requested, mfest.Packages)
+	}
+
+	if len(packages) == 0 {
+		return fmt.Errorf("repository has no packages to test")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	defer listener.Close()
+
+	go http.Serve(listener, server.mux)
+
+	host := "127.0.0.1:" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	script := selftestScript(server.setupInstructions(host), packages)
+
+	runArgs := []string{"run", "--rm", "--network", "host"}
+
+	if platform, ok := containerPlatforms[mfest.Architecture]; ok {
+		runArgs = append(runArgs, "--platform", platform)
+	}
+
+	runArgs = append(runArgs, image, "sh", "-c", script)
+
+	output.Info("Testing %d packages with apt in %s (%s), repository at http://%s/...", len(packages), image, runtime, host)
+
+	out, runErr := exec.Command(runtime, runArgs...).CombinedOutput()
+	problems := aptProblems(string(out))
+
+	for _, problem := range problems {
+		output.Warning("%s", problem)
+	}
+
+	if runErr != nil {
+		return fmt.Errorf("apt failed in %s: %w, output: %s", image, runErr, lastLines(string(out), 20))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("apt reported %d problems", len(problems))
+	}
+
+	output.Success("apt updated from the repository and downloaded %d packages in %s", len(packages), image)
+
+	return nil
+}
+
+// selftestPackages returns the requested packages, or every downloaded .deb of an older manifest
+func selftestPackages(requested []string, packages []packageinfo.PackageInfo) []string {
+	if len(requested) > 0 {
+		return requested
+	}
+
+	var names []string
+
+	for _, pkg := range packages {
+		if pkg.Downloaded && pkg.Type == "" {
+			names = append(names, pkg.Name)
+		}
+	}
+
+	return names
+}
+
+// selftestScript replaces the container's sources with the repository and downloads packages
+func selftestScript(instructions, packages []string) string {
+	lines := []string{
+		"set -e",
+		"rm -f /etc/apt/sources.list /etc/apt/sources.list.d/*",
+	}
+
+	for _, instruction := range instructions {
+		lines = append(lines, strings.TrimPrefix(instruction, "sudo "))
+	}
+
+	lines = append(lines,
+		"apt-get update",
+		"apt-get install -y --download-only "+strings.Join(packages, " "))
+
+	return strings.Join(lines, "\n")
+}
+
+// aptProblems returns the distinct warning, error and failed-fetch lines of apt output
+func aptProblems(out string) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if seen[line] {
+			continue
+		}
+
+		if strings.HasPrefix(line, "W:") || strings.HasPrefix(line, "E:") || strings.HasPrefix(line, "Err:") {
+			problems = append(problems, line)
+			seen[line] = true
+		}
+	}
+
+	return problems
+}
+
+// lastLines returns at most n trailing lines of text
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+)
+
+// serviceStateDir is the name of the state directory holding the repository of a
+// service installed without --repo (below /var/lib, or %ProgramData% on Windows)
+const serviceStateDir = "portaptable"
+
+// serviceSpec describes the serve-mode service to register
+type serviceSpec struct {
+	Name       string
+	Executable string
+	RepoPath   string
+	Port       string
+	User       string
+	StateDir   bool // RepoPath is inside the service's own state directory
+}
+
+// serveArgs returns the command line the service runs
+func (s serviceSpec) serveArgs() []string {
+	return []string{"--serve", "--repo", s.RepoPath, "--port", s.Port, "--no-color"}
+}
+
+// serviceManager registers and controls the service with the host's init system
+type serviceManager interface {
+	Install(spec serviceSpec) error
+	Uninstall(name string) error
+	Start(name string) error
+	Stop(name string) error
+}
+
+// RunServiceCommand registers serve mode as a system service (a systemd unit, or a
+// scheduled task run at startup on Windows) and starts or stops it
+func RunServiceCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: service install|uninstall|start|stop [OPTIONS]")
+	}
+
+	var cfg config.Config
+	var name, user string
+	var printUnit bool
+
+	fs := newFlagSet("service "+args[0], &cfg)
+	fs.StringVar(&cfg.Port, "port", config.DefaultPort, "Port the service serves on")
+	fs.StringVar(&name, "name", "portaptable", "Service name")
+	fs.StringVar(&user, "user", "", "systemd: account the server runs as (default: root)")
+	fs.BoolVar(&printUnit, "print", false, "install: print the systemd unit instead of installing it")
+	fs.Parse(args[1:])
+
+	manager := hostServiceManager()
+
+	switch args[0] {
+	case "install":
+		spec, err := newServiceSpec(fs, &cfg, name, user)
+
+		if err != nil {
+			return err
+		}
+
+		if printUnit {
+			fmt.Print(systemdUnit(spec))
+
+			return nil
+		}
+
+		if err := os.MkdirAll(spec.RepoPath, 0755); err != nil {
+			return fmt.Errorf("failed to create repository directory: %w", err)
+		}
+
+		if err := manager.Install(spec); err != nil {
+			return err
+		}
+
+		output.Success("Installed service %s serving %s on port %s (start it with 'service start')", name, spec.RepoPath, spec.Port)
+
+		return nil
+
+	case "uninstall":
+		if err := manager.Uninstall(name); err != nil {
+			return err
+		}
+
+		output.Success("Removed service %s; the repository was left in place", name)
+
+		return nil
+
+	case "start":
+		if err := manager.Start(name); err != nil {
+			return err
+		}
+
+		output.Success("Started service %s", name)
+
+		return nil
+
+	case "stop":
+		if err := manager.Stop(name); err != nil {
+			return err
+		}
+
+		output.Success("Stopped service %s", name)
+
+		return nil
+
+	default:
+		return fmt.Errorf("unknown service command: %s", args[0])
+	}
+}
+
+// newServiceSpec builds the service description. Without --repo the repository lives
+// in the service's state directory, which survives reboots and package upgrades.
+func newServiceSpec(fs *flag.FlagSet, cfg *config.Config, name, user string) (serviceSpec, error) {
+	executable, err := os.Executable()
+
+	if err != nil {
+		return serviceSpec{}, fmt.Errorf("failed to locate the portaptable executable: %w", err)
+	}
+
+	spec := serviceSpec{Name: name, Executable: executable, Port: cfg.Port, User: user}
+	repoSet := false
+
+	fs.Visit(func(f *flag.Flag) {
+		repoSet = repoSet || f.Name == "repo"
+	})
+
+	if repoSet {
+		spec.RepoPath, err = filepath.Abs(cfg.RepoPath)
+	} else {
+		spec.RepoPath, spec.StateDir = filepath.Join(serviceStateRoot(), serviceStateDir, "repository"), true
+	}
+
+	return spec, err
+}
+
+// serviceStateRoot returns the system directory holding service state
+func serviceStateRoot() string {
+	if runtime.GOOS == "windows" {
+		if programData := os.Getenv("ProgramData"); programData != "" {
+			return programData
+		}
+
+		return `C:\ProgramData`
+	}
+
+	return "/var/lib"
+}
+
+// hostServiceManager returns the service manager of the host's init system
+func hostServiceManager() serviceManager {
+	if runtime.GOOS == "windows" {
+		return scheduledTask{}
+	}
+
+	return systemd{}
+}
+
+// systemd manages the service as /etc/systemd/system/<name>.service
+type systemd struct{}
+
+// systemdUnitDir is where locally installed units live
+const systemdUnitDir = "/etc/systemd/system"
+
+// systemdUnit returns the unit file of a service
+func systemdUnit(spec serviceSpec) string {
+	var unit strings.Builder
+
+	fmt.Fprintf(&unit, "# Generated by portaptable service install\n")
+	fmt.Fprintf(&unit, "[Unit]\n")
+	fmt.Fprintf(&unit, "Description=portaptable offline APT repository (%s)\n", strings.ReplaceAll(spec.RepoPath, "%", "%%"))
+	fmt.Fprintf(&unit, "After=network-online.target\n")
+	fmt.Fprintf(&unit, "Wants=network-online.target\n\n")
+	fmt.Fprintf(&unit, "[Service]\n")
+	fmt.Fprintf(&unit, "Type=simple\n")
+
+	var command []string
+
+	for _, arg := range append([]string{spec.Executable}, spec.serveArgs()...) {
+		command = append(command, systemdQuote(arg))
+	}
+
+	fmt.Fprintf(&unit, "ExecStart=%s\n", strings.Join(command, " "))
+	fmt.Fprintf(&unit, "WorkingDirectory=%s\n", strings.ReplaceAll(spec.RepoPath, "%", "%%"))
+
+	if spec.User != "" {
+		fmt.Fprintf(&unit, "User=%s\n", spec.User)
+	}
+
+	// systemd creates the state directory, owned by User, before starting the server
+	if spec.StateDir {
+		fmt.Fprintf(&unit, "StateDirectory=%s %s/repository\n", serviceStateDir, serviceStateDir)
+	}
+
+	fmt.Fprintf(&unit, "Restart=on-failure\n")
+	fmt.Fprintf(&unit, "RestartSec=5\n\n")
+	fmt.Fprintf(&unit, "[Install]\n")
+	fmt.Fprintf(&unit, "WantedBy=multi-user.target\n")
+
+	return unit.String()
+}
+
+// systemdQuote quotes a unit file command line word when it needs it
+func systemdQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\"'\\%$;") {
+		return word
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$")
+
+	return `"` + replacer.Replace(word) + `"`
+}
+
+// Install writes the unit and enables it at boot
+func (systemd) Install(spec serviceSpec) error {
+	path := filepath.Join(systemdUnitDir, spec.Name+".service")
+
+	if err := os.WriteFile(path, []byte(systemdUnit(spec)), 0644); err != nil {
+		return fmt.Errorf("failed to write unit file: %w", err)
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+
+	return systemctl("enable", spec.Name+".service")
+}
+
+// Uninstall stops and disables the unit and removes its file
+func (systemd) Uninstall(name string) error {
+	if err := systemctl("disable", "--now", name+".service"); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(systemdUnitDir, name+".service")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unit file: %w", err)
+	}
+
+	return systemctl("daemon-reload")
+}
+
+// Start starts the unit
+func (systemd) Start(name string) error {
+	return systemctl("start", name+".service")
+}
+
+// Stop stops the unit
+func (systemd) Stop(name string) error {
+	return systemctl("stop", name+".service")
+}
+
+// systemctl runs a systemctl command
+func systemctl(args ...string) error {
+	if out, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %s failed: %w, output: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// scheduledTask manages the service on Windows as a task run as SYSTEM at startup,
+// which needs no service control handler in the server
+type scheduledTask struct{}
+
+// Install registers the startup task, replacing an existing one of the same name
+func (scheduledTask) Install(spec serviceSpec) error {
+	command := []string{windowsQuote(spec.Executable)}
+
+	for _, arg := range spec.serveArgs() {
+		command = append(command, windowsQuote(arg))
+	}
+
+	return schtasks("/Create", "/TN", spec.Name, "/SC", "ONSTART", "/RU", "SYSTEM", "/RL", "HIGHEST", "/F",
+		"/TR", strings.Join(command, " "))
+}
+
+// Uninstall ends the task and deletes it
+func (t scheduledTask) Uninstall(name string) error {
+	t.Stop(name) // The task may not be running
+
+	return schtasks("/Delete", "/TN", name, "/F")
+}
+
+// Start runs the task now
+func (scheduledTask) Start(name string) error {
+	return schtasks("/Run", "/TN", name)
+}
+
+// Stop ends the running task
+func (scheduledTask) Stop(name string) error {
+	return schtasks("/End", "/TN", name)
+}
+
+// windowsQuote quotes a command line word for the task's command
+func windowsQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\"") {
+		return word
+	}
+
+	return `"` + strings.ReplaceAll(word, `"`, `\"`) + `"`
+}
+
+// schtasks runs a schtasks.exe command
+func schtasks(args ...string) error {
+	if out, err := exec.Command("schtasks", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("schtasks %s failed: %w, output: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
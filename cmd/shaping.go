@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"portaptable/pkg/output"
+)
+
+// shapingChunk is the most a shaped connection writes between throughput checks
+const shapingChunk = 16 << 10
+
+// shutdownTimeout is how long requests in flight may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// serveHTTP serves handler on addr, capping every connection at rate bytes per second
+// (0 is unlimited) so one client cannot saturate a thin link to remote sites. On
+// SIGINT or SIGTERM it lets the requests in flight finish and calls each of shutdown,
+// e.g. to flush the serve statistics.
+func serveHTTP(addr string, handler http.Handler, rate int64, shutdown ...func()) error {
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	if rate > 0 {
+		listener = shapedListener{Listener: listener, rate: rate}
+	}
+
+	server := &http.Server{Handler: handler}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-served:
+	case <-stop:
+		output.Info("Shutting down...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+			output.Warning("Requests still in flight after %s were cut off", shutdownTimeout)
+		}
+	}
+
+	for _, f := range shutdown {
+		f()
+	}
+
+	return err
+}
+
+// shapedListener hands out connections whose writes are throttled
+type shapedListener struct {
+	net.Listener
+	rate int64
+}
+
+func (l shapedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &shapedConn{Conn: conn, rate: l.rate, last: time.Now(), tokens: float64(l.rate)}, nil
+}
+
+// shapedConn is a token bucket over one connection's writes. The bucket holds up to a
+// second of throughput, so short responses after idle periods go out without delay.
+type shapedConn struct {
+	net.Conn
+	rate int64
+
+	mu     sync.Mutex
+	last   time.Time
+	tokens float64
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := min(len(p), shapingChunk)
+		c.wait(chunk)
+
+		n, err := c.Conn.Write(p[:chunk])
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+
+		p = p[chunk:]
+	}
+
+	return written, nil
+}
+
+// wait blocks until n more bytes fit the connection's rate
+func (c *shapedConn) wait(n int) {
+	c.mu.Lock()
+	now := time.Now()
+	c.tokens = min(float64(c.rate), c.tokens+now.Sub(c.last).Seconds()*float64(c.rate))
+	c.last = now
+	c.tokens -= float64(n)
+	deficit := -c.tokens
+	c.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / float64(c.rate) * float64(time.Second)))
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/repometa"
+	"portaptable/pkg/signing"
+)
+
+// RunSimulateCommand shows what 'apt-get install' on the target would do with only
+// the repository as a source, without network access or root
+func RunSimulateCommand(args []string) error {
+	var cfg config.Config
+	var statusFile string
+	var noRecommends bool
+
+	fs := newFlagSet("simulate", &cfg)
+	fs.StringVar(&statusFile, "status-file", "", "The target's /var/lib/dpkg/status (default: a bare system)")
+	fs.BoolVar(&noRecommends, "no-install-recommends", false, "Simulate without recommended packages")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: simulate [OPTIONS] PACKAGE...")
+	}
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	env, cleanup, err := repositoryAptEnv(cfg.RepoPath, mfest, statusFile)
+
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	installArgs := []string{"install", "--simulate", "-y"}
+
+	if noRecommends {
+		installArgs = append(installArgs, "--no-install-recommends")
+	}
+
+	out, err := env.Command("apt-get", append(installArgs, fs.Args()...)...).CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("apt cannot install %s from the repository: %s",
+			strings.Join(fs.Args(), " "), strings.TrimSpace(string(out)))
+	}
+
+	printSimulation(string(out))
+
+	return nil
+}
+
+// repositoryAptEnv creates a temporary apt configuration whose only source is the
+// repository itself, with statusFile (if any) as the installed package state
+func repositoryAptEnv(repoPath string, mfest *manifest.Manifest, statusFile string) (*aptenv.Env, func(), error) {
+	absRepo, err := filepath.Abs(repoPath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, err := os.MkdirTemp("", "portaptable-simulate-")
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	cleanup := func() { os.RemoveAll(root) }
+
+	if statusFile != "" {
+		status, err := os.ReadFile(statusFile)
+
+		if err != nil {
+			cleanup()
+
+			return nil, nil, fmt.Errorf("failed to read status file: %w", err)
+		}
+
+		// aptenv.Create keeps an existing status file
+		if err := os.MkdirAll(filepath.Join(root, "var/lib/dpkg"), 0755); err == nil {
+			err = os.WriteFile(filepath.Join(root, "var/lib/dpkg/status"), status, 0644)
+		}
+
+		if err != nil {
+			cleanup()
+
+			return nil, nil, fmt.Errorf("failed to write status file: %w", err)
+		}
+	}
+
+	source := aptenv.Source{
+		URI:        "file:" + absRepo,
+		Suite:      mfest.Distribution,
+		Components: []string{repometa.Component},
+	}
+
+	if isSigned(repoPath, mfest.Distribution) {
+		source.SignedBy = filepath.Join(absRepo, signing.PublicKeyringName)
+	} else {
+		source.Trusted = true
+	}
+
+	env, err := aptenv.Create(root, mfest.Architecture, []aptenv.Source{source}, nil)
+
+	if err != nil {
+		cleanup()
+
+		return nil, nil, err
+	}
+
+	if err := env.Update(); err != nil {
+		cleanup()
+
+		return nil, nil, err
+	}
+
+	return env, cleanup, nil
+}
+
+// printSimulation lists the actions of an 'apt-get --simulate' run and its summary line
+func printSimulation(out string) {
+	var installs, removals int
+	scanner := bufio.NewScanner(strings.NewReader(out))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "Inst "):
+			installs++
+			fmt.Println(line)
+		case strings.HasPrefix(line, "Remv "):
+			removals++
+			fmt.Println(line)
+		case strings.HasPrefix(line, "Conf "):
+			fmt.Println(line)
+		case strings.Contains(line, "newly installed"):
+			fmt.Println(line)
+		case strings.HasPrefix(line, "W:"):
+			output.Warning("%s", line)
+		}
+	}
+
+	if removals > 0 {
+		output.Warning("Installing would remove %d packages from the target", removals)
+	}
+
+	if installs == 0 {
+		output.Info("Nothing to install; the target already has everything requested")
+	}
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/output"
+)
+
+// Actions for packages over --max-package-size or --max-total-size
+const (
+	sizeLimitFail = "fail"
+	sizeLimitSkip = "skip"
+)
+
+// applySizeCaps checks the resolved packages against the configured size limits before
+// anything is downloaded. Depending on cfg.SizeLimitAction it fails the run or drops
+// the requested packages whose closures break the limits, each with all of its
+// dependencies no kept package needs, so what is downloaded still installs.
+func (run *downloadRun) applySizeCaps(cfg *config.Config, requested, packages []string) ([]string, error) {
+	if cfg.MaxPackageSize <= 0 && cfg.MaxTotalSize <= 0 {
+		return packages, nil
+	}
+
+	if cfg.SizeLimitAction != sizeLimitFail && cfg.SizeLimitAction != sizeLimitSkip {
+		return nil, fmt.Errorf("invalid size limit action %q (expected fail or skip)", cfg.SizeLimitAction)
+	}
+
+	sizes := run.candidateSizes(packages)
+
+	if cfg.SizeLimitAction == sizeLimitFail {
+		return packages, checkSizeCaps(cfg, packages, sizes)
+	}
+
+	closures, err := run.requestClosures(cfg, requested, packages)
+
+	if err != nil {
+		return nil, err
+	}
+
+	kept := skipOverSize(cfg, closures, sizes)
+	var total int64
+
+	for _, pkg := range kept {
+		total += sizes[pkg]
+	}
+
+	output.Info("Download size: %s for %d packages", formatSize(total), len(kept))
+
+	return kept, nil
+}
+
+// checkSizeCaps fails on the first package over the per-package limit, or when the
+// packages together are over the total limit
+func checkSizeCaps(cfg *config.Config, packages []string, sizes map[string]int64) error {
+	sorted := append([]string{}, packages...)
+	sort.Strings(sorted)
+
+	var total int64
+
+	for _, pkg := range sorted {
+		size := sizes[pkg]
+		total += size
+
+		if cfg.MaxPackageSize > 0 && size > cfg.MaxPackageSize {
+			return fmt.Errorf("size limit exceeded: %s is %s, over the %s package limit", pkg, formatSize(size), formatSize(cfg.MaxPackageSize))
+		}
+	}
+
+	if cfg.MaxTotalSize > 0 && total > cfg.MaxTotalSize {
+		return fmt.Errorf("size limit exceeded: %d packages take %s, over the %s total limit", len(packages), formatSize(total), formatSize(cfg.MaxTotalSize))
+	}
+
+	output.Info("Download size: %s for %d packages", formatSize(total), len(packages))
+
+	return nil
+}
+
+// packageClosure is a requested package and what of the resolved packages it pulls in
+type packageClosure struct {
+	root    string
+	members []string
+}
+
+// requestClosures returns the closure of each requested package within packages, in
+// request order, followed by those of packages no request pulls in, such as language packs
+func (run *downloadRun) requestClosures(cfg *config.Config, requested, packages []string) ([]packageClosure, error) {
+	resolved := make(map[string]bool, len(packages))
+
+	for _, pkg := range packages {
+		resolved[pkg] = true
+	}
+
+	covered := make(map[string]bool)
+	var closures []packageClosure
+
+	add := func(root string) error {
+		dependencies, err := run.resolveAllDependencies([]string{root}, cfg)
+
+		if err != nil {
+			return fmt.Errorf("failed to resolve dependencies of %s: %w", root, err)
+		}
+
+		closure := packageClosure{root: root}
+
+		for _, dependency := range dependencies {
+			if resolved[dependency] {
+				closure.members = append(closure.members, dependency)
+				covered[dependency] = true
+			}
+		}
+
+		closures = append(closures, closure)
+
+		return nil
+	}
+
+	for _, pkg := range requested {
+		if err := add(pkg); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pkg := range packages {
+		if !covered[pkg] {
+			if err := add(pkg); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return closures, nil
+}
+
+// skipOverSize keeps the closures in order while they fit the limits, skipping whole
+// closures with a package over the per-package limit or taking the total over its limit
+func skipOverSize(cfg *config.Config, closures []packageClosure, sizes map[string]int64) []string {
+	kept := make(map[string]bool)
+	var result []string
+	var total int64
+
+	for _, closure := range closures {
+		var added []string
+		var size int64
+		var problem string
+		seen := make(map[string]bool)
+
+		for _, pkg := range closure.members {
+			if cfg.MaxPackageSize > 0 && sizes[pkg] > cfg.MaxPackageSize {
+				problem = fmt.Sprintf("%s, which needs %s (%s), over the %s package limit", closure.root, pkg, formatSize(sizes[pkg]), formatSize(cfg.MaxPackageSize))
+
+				break
+			}
+
+			if !kept[pkg] && !seen[pkg] {
+				seen[pkg] = true
+				added = append(added, pkg)
+				size += sizes[pkg]
+			}
+		}
+
+		if problem == "" && cfg.MaxTotalSize > 0 && total+size > cfg.MaxTotalSize {
+			problem = fmt.Sprintf("%s and its dependencies (%s), which would exceed the %s total limit", closure.root, formatSize(size), formatSize(cfg.MaxTotalSize))
+		}
+
+		if problem != "" {
+			output.Warning("Skipping %s", problem)
+
+			continue
+		}
+
+		for _, pkg := range added {
+			kept[pkg] = true
+		}
+
+		result = append(result, added...)
+		total += size
+	}
+
+	return result
+}
+
+// candidateParagraphs returns the apt-cache record of the candidate version of each package,
+// keyed by name and by name:arch. Packages apt cannot show are missing.
+func (run *downloadRun) candidateParagraphs(packages []string) map[string]deb822.Paragraph {
+	if run.nativeIndex != nil {
+		records := make(map[string]deb822.Paragraph, len(packages))
+
+		for _, pkg := range packages {
+			if record, ok := run.nativeIndex.record(pkg); ok {
+				records[pkg] = record
+			}
+		}
+
+		return records
+	}
+
+	args := []string{"show", "--no-all-versions"}
+
+	for _, pkg := range packages {
+		args = append(args, run.pinnedSpec(pkg))
+	}
+
+	// apt-cache exits non-zero if any name is unknown but still shows the others
+	out, _ := run.aptCommand("apt-cache", args...).Output()
+	paragraphs, _ := deb822.Parse(bytes.NewReader(out))
+
+	records := make(map[string]deb822.Paragraph, len(paragraphs))
+
+	for _, paragraph := range paragraphs {
+		// Foreign-architecture packages are requested as name:arch
+		records[paragraph["Package"]+":"+paragraph["Architecture"]] = paragraph
+
+		if _, ok := records[paragraph["Package"]]; !ok {
+			records[paragraph["Package"]] = paragraph
+		}
+	}
+
+	return records
+}
+
+// candidateSizes returns the archive size of the candidate version of each package.
+// Packages apt cannot show count as zero; their download fails later with a clear error.
+func (run *downloadRun) candidateSizes(packages []string) map[string]int64 {
+	records := run.candidateParagraphs(packages)
+	sizes := make(map[string]int64, len(records))
+
+	for name, paragraph := range records {
+		if size, err := strconv.ParseInt(paragraph["Size"], 10, 64); err == nil {
+			sizes[name] = size
+		}
+	}
+
+	return sizes
+}
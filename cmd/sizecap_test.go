@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"portaptable/pkg/config"
+)
+
+func TestSkipOverSize(t *testing.T) {
+	sizes := map[string]int64{"app": 10, "lib": 50, "libc": 20, "tool": 10, "huge": 500, "viewer": 10}
+	closures := []packageClosure{
+		{root: "app", members: []string{"app", "lib", "libc"}},
+		{root: "viewer", members: []string{"viewer", "huge", "libc"}},
+		{root: "tool", members: []string{"tool", "libc"}},
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		// viewer goes with huge, while libc stays for app and tool
+		{"package limit", config.Config{MaxPackageSize: 100}, []string{"app", "lib", "libc", "tool"}},
+		// app's closure fills the total; tool only adds itself, as libc is already kept
+		{"total limit", config.Config{MaxTotalSize: 90}, []string{"app", "lib", "libc", "tool"}},
+		{"tight total", config.Config{MaxTotalSize: 40}, []string{"tool", "libc"}},
+	}
+
+	for _, test := range tests {
+		if got := skipOverSize(&test.cfg, closures, sizes); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: skipOverSize() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckSizeCaps(t *testing.T) {
+	sizes := map[string]int64{"app": 10, "lib": 50}
+
+	if err := checkSizeCaps(&config.Config{MaxPackageSize: 40}, []string{"app", "lib"}, sizes); err == nil {
+		t.Error("checkSizeCaps() accepted lib over the package limit")
+	}
+
+	if err := checkSizeCaps(&config.Config{MaxTotalSize: 50}, []string{"app", "lib"}, sizes); err == nil {
+		t.Error("checkSizeCaps() accepted 60 bytes over the total limit")
+	}
+
+	if err := checkSizeCaps(&config.Config{MaxPackageSize: 50, MaxTotalSize: 60}, []string{"app", "lib"}, sizes); err != nil {
+		t.Errorf("checkSizeCaps() = %v for packages within the limits", err)
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/repolock"
+	"portaptable/pkg/snapshot"
+)
+
+// RunSnapshotCommand manages repository snapshots: create, list, rollback
+func RunSnapshotCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: snapshot create|list|rollback [OPTIONS] [NAME]")
+	}
+
+	var cfg config.Config
+
+	fs := newFlagSet("snapshot "+args[0], &cfg)
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "create":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: snapshot create NAME")
+		}
+
+		// A snapshot taken during a download would capture a half-written repository
+		lock, err := repolock.Acquire(cfg.RepoPath)
+
+		if err != nil {
+			return err
+		}
+
+		defer lock.Unlock()
+
+		if err := snapshot.Create(cfg.RepoPath, fs.Arg(0)); err != nil {
+			return err
+		}
+
+		output.Success("Created snapshot %s (serve it with --serve --snapshot %s)", fs.Arg(0), fs.Arg(0))
+
+		return nil
+
+	case "rollback":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: snapshot rollback NAME")
+		}
+
+		lock, err := repolock.Acquire(cfg.RepoPath)
+
+		if err != nil {
+			return err
+		}
+
+		defer lock.Unlock()
+
+		if err := snapshot.Rollback(cfg.RepoPath, fs.Arg(0)); err != nil {
+			return err
+		}
+
+		recordHistory(&cfg, "rollback: to snapshot %s", fs.Arg(0))
+		output.Success("Rolled back to snapshot %s", fs.Arg(0))
+
+		return nil
+
+	case "list":
+		snapshots, err := snapshot.List(cfg.RepoPath)
+
+		if err != nil {
+			return err
+		}
+
+		for _, snap := range snapshots {
+			fmt.Printf("%-24s %s  %d packages\n", snap.Name, snap.Created.Format("2006-01-02 15:04"), snap.Packages)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unknown snapshot command: %s", args[0])
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/repometa"
+)
+
+// fetchSourcePackages downloads the source package (.dsc, .orig.tar.*, .debian.tar.*)
+// every downloaded package was built from, looking it up in the Sources indexes of
+// the pockets the native resolver reads, and adds its files to the manifest
+func (run *downloadRun) fetchSourcePackages(config *config.Config, mfest *manifest.Manifest) error {
+	// Source name and version of every downloaded binary, keyed as name_version
+	wanted := make(map[string]bool)
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded || pkg.Type != "" {
+			continue
+		}
+
+		source, version := pkg.Source, pkg.SourceVersion
+
+		if source == "" {
+			source = pkg.Name
+		}
+
+		if version == "" {
+			version = pkg.Version
+		}
+
+		wanted[source+"_"+version] = true
+	}
+
+	pockets, err := run.indexPockets(config)
+
+	if err != nil {
+		return err
+	}
+
+	mirrorURL := run.archiveMirror(config)
+	poolPath := filepath.Join(config.RepoPath, "pool")
+	found := make(map[string]bool)
+	failed := 0
+
+	for _, pocket := range pockets {
+		uri := mirrorURL
+
+		if pocket == pocketSecurity {
+			uri = run.securityMirror(config.Distribution, mirrorURL)
+		}
+
+		suite := run.pocketSuite(config.Distribution, pocket)
+
+		if !hasSuite(uri, suite) {
+			continue
+		}
+
+		mirror := run.newArchiveMirror(uri, config.RepoPath)
+
+		if err := run.verifyMirrorRelease(&mirror, suite, config.RepoPath); err != nil {
+			return err
+		}
+
+		for _, component := range run.sourceComponents(config) {
+			entries, err := mirror.FetchSources(suite, component)
+
+			// Not every suite carries every component
+			if errors.Is(err, remote.ErrNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+				key := entry["Package"] + "_" + entry["Version"]
+
+				if !wanted[key] || found[key] {
+					continue
+				}
+
+				found[key] = true
+
+				output.Info("Processing source %s %s...", entry["Package"], entry["Version"])
+
+				files, err := mirror.DownloadSource(entry, poolPath)
+
+				if err != nil {
+					output.Failure("Failed to download source %s: %v", entry["Package"], err)
+					failed++
+
+					continue
+				}
+
+				if err := addSourceFiles(mfest, entry, component, files, poolPath); err != nil {
+					output.Failure("Failed to record source %s: %v", entry["Package"], err)
+					failed++
+				}
+			}
+		}
+	}
+
+	var missing []string
+
+	for key := range wanted {
+		if !found[key] {
+			missing = append(missing, key)
+		}
+	}
+
+	sort.Strings(missing)
+
+	for _, key := range missing {
+		output.Warning("No Sources index lists %s", key)
+	}
+
+	output.Info("Fetched %d source packages", len(found)-failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d source packages failed to download", failed)
+	}
+
+	return nil
+}
+
+// addSourceFiles adds one manifest entry per file of a source package. The .dsc entry
+// carries the Sources index fields the generated deb-src index is written from.
+func addSourceFiles(mfest *manifest.Manifest, entry deb822.Paragraph, component string, files []string, poolPath string) error {
+	for _, filename := range files {
+		sums, err := checksum.File(filepath.Join(poolPath, filename))
+
+		if err != nil {
+			return err
+		}
+
+		pkg := packageinfo.PackageInfo{
+			Name:          entry["Package"],
+			Version:       entry["Version"],
+			Architecture:  "source",
+			Source:        entry["Package"],
+			SourceVersion: entry["Version"],
+			Component:     component,
+			Filename:      filename,
+			Size:          sums.Size,
+			MD5sum:        sums.MD5,
+			SHA1:          sums.SHA1,
+			SHA256:        sums.SHA256,
+			MTime:         sums.ModTime.UnixNano(),
+			Type:          packageinfo.TypeSource,
+			Downloaded:    true,
+		}
+
+		if filepath.Ext(filename) == ".dsc" {
+			pkg.Control = repometa.SourceIndexFields(entry)
+		}
+
+		mfest.Packages = append(mfest.Packages, pkg)
+	}
+
+	return nil
+}
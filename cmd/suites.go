@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/aptenv"
+	"portaptable/pkg/config"
+	"portaptable/pkg/packageinfo"
+)
+
+// targetSuitePriority makes a package requested as name/suite come from that suite,
+// as apt's -t does for a whole run
+const targetSuitePriority = 990
+
+// parseSuiteTargets splits requested packages into their names and suite targets. A
+// target must be one of the distribution's pockets, e.g. focal-backports or focal-updates.
+func (run *downloadRun) parseSuiteTargets(config *config.Config, requested []string) ([]string, map[string]string, error) {
+	var names []string
+	targets := make(map[string]string)
+
+	for _, spec := range requested {
+		name, suite, targeted := strings.Cut(spec, "/")
+
+		if !targeted {
+			names = append(names, spec)
+
+			continue
+		}
+
+		if config.Preset != "" {
+			return nil, nil, fmt.Errorf("%s: --preset sources have no pockets to target", spec)
+		}
+
+		if _, ok := run.suitePocket(config.Distribution, suite); !ok || name == "" {
+			return nil, nil, fmt.Errorf("invalid suite target %q (expected name/suite with a %s pocket, e.g. %s)",
+				spec, config.Distribution, run.pocketSuite(config.Distribution, pocketBackports))
+		}
+
+		if earlier, ok := targets[name]; ok && earlier != suite {
+			return nil, nil, fmt.Errorf("%s is targeted at both %s and %s", name, earlier, suite)
+		}
+
+		targets[name] = suite
+		names = append(names, name)
+	}
+
+	return names, targets, nil
+}
+
+// suitePocket returns the pocket of distribution published as suite
+func (run *downloadRun) suitePocket(distribution, suite string) (string, bool) {
+	for _, pocket := range []string{pocketRelease, pocketUpdates, pocketSecurity, pocketProposed, pocketBackports} {
+		if run.pocketSuite(distribution, pocket) == suite {
+			return pocket, true
+		}
+	}
+
+	return "", false
+}
+
+// targetPockets returns the pockets the suite targets need as sources
+func (run *downloadRun) targetPockets(distribution string) []string {
+	var pockets []string
+
+	for _, suite := range run.suiteTargets {
+		pocket, _ := run.suitePocket(distribution, suite)
+		pockets = appendMissing(pockets, []string{pocket})
+	}
+
+	return pockets
+}
+
+// targetPins returns the apt preferences making each targeted package come from its suite
+func (run *downloadRun) targetPins(distribution string) []aptenv.Pin {
+	bySuite := make(map[string][]string)
+
+	for spec, suite := range run.suiteTargets {
+		bySuite[suite] = appendMissing(bySuite[suite], []string{strings.SplitN(spec, ":", 2)[0]})
+	}
+
+	var pins []aptenv.Pin
+
+	for suite, names := range bySuite {
+		sort.Strings(names)
+		pins = append(pins, aptenv.Pin{Packages: names, Release: run.pinRelease(distribution, suite), Priority: targetSuitePriority})
+	}
+
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Release < pins[j].Release })
+
+	return pins
+}
+
+// pinRelease returns the "Pin: release" expression matching a suite. Ubuntu gives every
+// pocket the release's codename and tells them apart by suite; Debian's suite is the
+// release's role (stable) and its codenames name the pockets, so a distribution given
+// by its role is matched by suite.
+func (run *downloadRun) pinRelease(distribution, suite string) string {
+	if run.distroVendor(distribution) != "ubuntu" && !debianAliases[distribution] {
+		return "n=" + suite
+	}
+
+	return "a=" + suite
+}
+
+// targetVersions drops the versions of targeted packages that do not come from their
+// suite, given the suite of each index entry, failing when the suite lacks the package
+func (run *downloadRun) targetVersions(packages []packageinfo.PackageInfo, suites map[string]string, architecture string) ([]packageinfo.PackageInfo, error) {
+	if len(run.suiteTargets) == 0 {
+		return packages, nil
+	}
+
+	found := make(map[string]bool)
+	kept := packages[:0]
+
+	for _, pkg := range packages {
+		spec, suite, targeted := run.targetFor(pkg, architecture)
+
+		if !targeted {
+			kept = append(kept, pkg)
+
+			continue
+		}
+
+		if suites[indexKey(pkg)] == suite {
+			found[spec] = true
+			kept = append(kept, pkg)
+		}
+	}
+
+	for spec, suite := range run.suiteTargets {
+		if !found[spec] {
+			return nil, fmt.Errorf("%s is not in %s", strings.SplitN(spec, ":", 2)[0], suite)
+		}
+	}
+
+	return kept, nil
+}
+
+// targetFor returns the suite target applying to an index entry, as pinFor does for pins
+func (run *downloadRun) targetFor(pkg packageinfo.PackageInfo, architecture string) (string, string, bool) {
+	spec := pkg.Name + ":" + pkg.Architecture
+
+	if suite, ok := run.suiteTargets[spec]; ok {
+		return spec, suite, true
+	}
+
+	if pkg.Architecture != architecture && pkg.Architecture != "all" && !contains(run.additionalArchitectures, pkg.Architecture) {
+		return "", "", false
+	}
+
+	suite, ok := run.suiteTargets[pkg.Name]
+
+	return pkg.Name, suite, ok
+}
+
+// hasTarget reports whether any architecture of a package is targeted at suite
+func (run *downloadRun) hasTarget(name, suite string) bool {
+	for spec, target := range run.suiteTargets {
+		if strings.SplitN(spec, ":", 2)[0] == name && target == suite {
+			return true
+		}
+	}
+
+	return false
+}
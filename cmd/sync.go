@@ -0,0 +1,557 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/repolock"
+	"portaptable/pkg/repometa"
+	"portaptable/pkg/signing"
+)
+
+// syncSource reads files of a repository by their path relative to its root
+type syncSource interface {
+	Open(rel string) (io.ReadCloser, error)
+}
+
+// localSource is a repository directory on this machine
+type localSource string
+
+func (s localSource) Open(rel string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(string(s), filepath.FromSlash(rel)))
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", rel, remote.ErrNotFound)
+	}
+
+	return file, err
+}
+
+// serverSource is a repository published by 'portaptable --serve'
+type serverSource string
+
+func (s serverSource) Open(rel string) (io.ReadCloser, error) {
+	return remote.Get(strings.TrimSuffix(string(s), "/") + "/" + rel)
+}
+
+// RunSyncCommand brings a replica up to date with a source repository, transferring
+// only package files and metadata that are missing or changed. New metadata is staged
+// and verified first and replaces the replica's only once every file has arrived.
+func RunSyncCommand(args []string) error {
+	var cfg config.Config
+
+	fs := newFlagSet("sync", &cfg)
+	registerLicenseFlags(fs, &cfg)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: sync SOURCE DESTINATION (SOURCE is a repository path or server URL)")
+	}
+
+	var source syncSource = localSource(fs.Arg(0))
+
+	if strings.HasPrefix(fs.Arg(0), "http://") || strings.HasPrefix(fs.Arg(0), "https://") {
+		source = serverSource(fs.Arg(0))
+	}
+
+	destination := fs.Arg(1)
+
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return err
+	}
+
+	lock, err := repolock.Acquire(destination)
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	srcManifest, err := readSourceManifest(source)
+
+	if err != nil {
+		return err
+	}
+
+	poolPath := filepath.Join(destination, "pool")
+
+	if err := checkSourcePaths(srcManifest, destination, poolPath); err != nil {
+		return err
+	}
+
+	stage, err := os.MkdirTemp(destination, ".sync-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(stage)
+
+	if err := stageMetadata(source, destination, stage, srcManifest, cfg.ArchiveKeyrings); err != nil {
+		return err
+	}
+
+	// Files recorded in the replica's manifest with the same checksum are kept as they are
+	current := make(map[string]string)
+
+	if dstManifest, err := manifest.Load(destination); err == nil {
+		for _, pkg := range dstManifest.Packages {
+			if pkg.Downloaded {
+				current[pkg.Filename] = pkg.SHA256
+			}
+		}
+	}
+
+	wanted := make(map[string]bool)
+	transferred, failed := 0, 0
+
+	for _, pkg := range srcManifest.Packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		wanted[pkg.Filename] = true
+
+		if replicaCurrent(pkg, current[pkg.Filename], poolPath) {
+			continue
+		}
+
+		output.Info("Transferring %s...", pkg.Filename)
+
+		if err := syncPackage(&cfg, source, pkg, poolPath); err != nil {
+			output.Failure("Failed to transfer %s: %v", pkg.Filename, err)
+			failed++
+
+			continue
+		}
+
+		transferred++
+	}
+
+	// The replica keeps serving its old metadata until it has every new package
+	if failed > 0 {
+		return fmt.Errorf("%d packages failed to transfer; the replica is unchanged, rerun to retry", failed)
+	}
+
+	if err := installMetadata(destination, stage, srcManifest); err != nil {
+		return err
+	}
+
+	if err := manifest.Save(destination, srcManifest); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	for filename := range current {
+		if !wanted[filename] {
+			output.Info("Removing %s (no longer in the source)", filename)
+
+			if target, err := sourcePath(poolPath, filename); err == nil {
+				os.Remove(target)
+			}
+		}
+	}
+
+	syncCfg := cfg
+	syncCfg.RepoPath = destination
+	recordHistory(&syncCfg, "sync: from %s (%d transferred)", fs.Arg(0), transferred)
+	output.Info("Synced %d packages (%d transferred)", len(wanted), transferred)
+
+	return nil
+}
+
+// sourcePath joins rel, a slash-separated path named by the source, below dir. Absolute
+// paths and .. components are refused, so a hostile source cannot reach outside dir.
+func sourcePath(dir, rel string) (string, error) {
+	if rel == "" || path.IsAbs(rel) || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.Contains(rel, `\`) {
+		return "", fmt.Errorf("unsafe path %q in the source", rel)
+	}
+
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("unsafe path %q in the source", rel)
+		}
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+
+	if inside, err := filepath.Rel(dir, target); err != nil || inside == "." || strings.HasPrefix(inside, "..") {
+		return "", fmt.Errorf("unsafe path %q in the source", rel)
+	}
+
+	return target, nil
+}
+
+// checkSourcePaths refuses a source manifest naming files outside the replica's pool
+// or a distribution that is not a single directory name
+func checkSourcePaths(mfest *manifest.Manifest, destination, poolPath string) error {
+	if mfest.Distribution == "" || strings.Contains(mfest.Distribution, "/") {
+		return fmt.Errorf("unsafe distribution %q in the source manifest", mfest.Distribution)
+	}
+
+	if _, err := sourcePath(filepath.Join(destination, "dists"), mfest.Distribution); err != nil {
+		return err
+	}
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		if _, err := sourcePath(poolPath, pkg.Filename); err != nil {
+			return fmt.Errorf("refusing the source manifest: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// replicaCurrent reports whether the replica's pool file matches the source package.
+// Older manifests carry no checksums; their files are compared by size.
+func replicaCurrent(pkg packageinfo.PackageInfo, replicaSHA256, poolPath string) bool {
+	info, err := os.Stat(filepath.Join(poolPath, pkg.Filename))
+
+	if err != nil {
+		return false
+	}
+
+	if pkg.SHA256 == "" {
+		return pkg.Size > 0 && info.Size() == pkg.Size
+	}
+
+	return replicaSHA256 == pkg.SHA256
+}
+
+func readSourceManifest(source syncSource) (*manifest.Manifest, error) {
+	body, err := source.Open(manifest.FileName)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source manifest: %w", err)
+	}
+
+	defer body.Close()
+
+	var mfest manifest.Manifest
+
+	if err := json.NewDecoder(body).Decode(&mfest); err != nil {
+		return nil, fmt.Errorf("failed to parse source manifest: %w", err)
+	}
+
+	return &mfest, nil
+}
+
+// syncPackage copies a pool file and checks it against the source manifest and the
+// license policy before putting it in place
+func syncPackage(cfg *config.Config, source syncSource, pkg packageinfo.PackageInfo, poolPath string) error {
+	target, err := sourcePath(poolPath, pkg.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	staged := target + ".sync"
+
+	if err := copyFromSource(source, "pool/"+pkg.Filename, staged); err != nil {
+		return err
+	}
+
+	if pkg.SHA256 != "" {
+		sums, err := checksum.File(staged)
+
+		if err != nil {
+			os.Remove(staged)
+
+			return err
+		}
+
+		if sums.SHA256 != pkg.SHA256 {
+			os.Remove(staged)
+
+			return fmt.Errorf("checksum mismatch: expected %s, got %s", pkg.SHA256, sums.SHA256)
+		}
+	}
+
+	// The replica serves the source's signed indexes, which cannot leave a package out
+	if pkg.Type != packageinfo.TypeSource {
+		if err := checkLicenseFile(cfg, staged, pkg.Name); err != nil {
+			os.Remove(staged)
+
+			return err
+		}
+	}
+
+	return os.Rename(staged, target)
+}
+
+// stageMetadata copies the source's dists/<distribution> tree and public keyring into
+// stage. Signatures must verify against keyrings, else the replica's own keyring; a
+// signed source without either is refused rather than trusted on first use. Every
+// index must match the SHA256 the verified Release gives it.
+func stageMetadata(source syncSource, destination, stage string, srcManifest *manifest.Manifest, keyrings []string) error {
+	distribution := srcManifest.Distribution
+	distRel := path.Join("dists", distribution)
+	distStage := filepath.Join(stage, "dists")
+
+	if err := copyFromSource(source, path.Join(distRel, "Release"), filepath.Join(distStage, "Release")); err != nil {
+		return fmt.Errorf("failed to transfer Release: %w", err)
+	}
+
+	signed := false
+
+	for _, name := range []string{"InRelease", "Release.gpg"} {
+		err := copyFromSource(source, path.Join(distRel, name), filepath.Join(distStage, name))
+
+		if errors.Is(err, remote.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to transfer %s: %w", name, err)
+		}
+
+		signed = true
+	}
+
+	replicaKeyring := filepath.Join(destination, signing.PublicKeyringName)
+	stagedKeyring := filepath.Join(stage, signing.PublicKeyringName)
+	err := copyFromSource(source, signing.PublicKeyringName, stagedKeyring)
+
+	if err != nil && !errors.Is(err, remote.ErrNotFound) {
+		return fmt.Errorf("failed to transfer public keyring: %w", err)
+	}
+
+	sourceHasKeyring := err == nil
+
+	if len(keyrings) == 0 && fileExists(replicaKeyring) {
+		keyrings = []string{replicaKeyring}
+	}
+
+	var release deb822.Paragraph
+
+	switch {
+	case signed && len(keyrings) == 0:
+		return fmt.Errorf("the source is signed but nothing to verify it with; pass --archive-keyring with its public key (e.g. %s of the source)", signing.PublicKeyringName)
+
+	case !signed && len(keyrings) > 0:
+		return fmt.Errorf("the source is not signed, but a keyring to verify it was given or the replica's Release was signed; refusing to replace it")
+
+	case signed:
+		release, err = verifyStagedRelease(distStage, keyrings)
+
+		if err != nil {
+			return err
+		}
+
+		// The keyring the replica publishes must be the one that signs it
+		if sourceHasKeyring {
+			if _, err := verifyStagedRelease(distStage, []string{stagedKeyring}); err != nil {
+				return fmt.Errorf("the source's public keyring does not verify its Release: %w", err)
+			}
+		}
+
+	default:
+		if release, err = readReleaseFile(filepath.Join(distStage, "Release")); err != nil {
+			return err
+		}
+	}
+
+	distPath := repometa.DistPath(destination, distribution)
+
+	for _, line := range deb822.Lines(release["SHA256"]) {
+		fields := strings.Fields(line)
+
+		if len(fields) != 3 {
+			continue
+		}
+
+		target, err := sourcePath(distStage, fields[2])
+
+		if err != nil {
+			return fmt.Errorf("refusing the source's Release: %w", err)
+		}
+
+		// An unchanged index of the replica is linked into the stage instead of fetched
+		if existing, err := sourcePath(distPath, fields[2]); err == nil {
+			if sums, err := checksum.File(existing); err == nil && sums.SHA256 == fields[0] {
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					return err
+				}
+
+				if err := linkOrCopy(existing, target); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if err := copyFromSource(source, path.Join(distRel, fields[2]), target); err != nil {
+			return fmt.Errorf("failed to transfer %s: %w", fields[2], err)
+		}
+
+		if sums, err := checksum.File(target); err != nil || sums.SHA256 != fields[0] {
+			return fmt.Errorf("%s does not match the SHA256 of the source's Release", fields[2])
+		}
+	}
+
+	return nil
+}
+
+// verifyStagedRelease checks the signatures of a staged dists directory against
+// keyrings and returns the signed Release fields. InRelease and Release.gpg are both
+// checked when present, and must sign the same Release.
+func verifyStagedRelease(dir string, keyrings []string) (deb822.Paragraph, error) {
+	releasePath := filepath.Join(dir, "Release")
+	release, err := readReleaseFile(releasePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fileExists(filepath.Join(dir, "Release.gpg")) {
+		if err := signing.VerifyDetached(releasePath, filepath.Join(dir, "Release.gpg"), keyrings); err != nil {
+			return nil, fmt.Errorf("failed to verify Release.gpg: %w", err)
+		}
+	}
+
+	inRelease, err := os.ReadFile(filepath.Join(dir, "InRelease"))
+
+	if os.IsNotExist(err) {
+		return release, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := signing.VerifyClearsigned(inRelease, keyrings)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify InRelease: %w", err)
+	}
+
+	paragraphs, err := deb822.Parse(bytes.NewReader(content))
+
+	if err != nil || len(paragraphs) == 0 {
+		return nil, fmt.Errorf("failed to parse InRelease: %v", err)
+	}
+
+	if !maps.Equal(paragraphs[0], release) {
+		return nil, fmt.Errorf("InRelease and Release of the source differ")
+	}
+
+	return paragraphs[0], nil
+}
+
+// fileExists reports whether path names an existing file
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+// readReleaseFile parses the fields of a Release file
+func readReleaseFile(path string) (deb822.Paragraph, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	paragraphs, err := deb822.Parse(file)
+
+	if err != nil || len(paragraphs) == 0 {
+		return nil, fmt.Errorf("failed to parse Release: %v", err)
+	}
+
+	return paragraphs[0], nil
+}
+
+// installMetadata swaps the staged dists tree and keyring into the replica, each with
+// a rename, and writes the setup script for them
+func installMetadata(destination, stage string, srcManifest *manifest.Manifest) error {
+	distribution := srcManifest.Distribution
+	distPath := repometa.DistPath(destination, distribution)
+
+	if err := os.MkdirAll(filepath.Dir(distPath), 0755); err != nil {
+		return err
+	}
+
+	previous := filepath.Join(stage, "previous")
+
+	if err := os.Rename(distPath, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", distPath, err)
+	}
+
+	if err := os.Rename(filepath.Join(stage, "dists"), distPath); err != nil {
+		os.Rename(previous, distPath)
+
+		return fmt.Errorf("failed to replace %s: %w", distPath, err)
+	}
+
+	stagedKeyring := filepath.Join(stage, signing.PublicKeyringName)
+
+	if !fileExists(stagedKeyring) {
+		return nil
+	}
+
+	if err := os.Rename(stagedKeyring, filepath.Join(destination, signing.PublicKeyringName)); err != nil {
+		return fmt.Errorf("failed to install public keyring: %w", err)
+	}
+
+	script := repometa.SetupScript("file://$SCRIPT_DIR", distribution, signing.PublicKeyringName, repometa.HasSources(srcManifest))
+
+	return os.WriteFile(filepath.Join(destination, setupScriptName), []byte(script), 0755)
+}
+
+// copyFromSource writes a source file to target, replacing it only once complete
+func copyFromSource(source syncSource, rel, target string) error {
+	body, err := source.Open(rel)
+
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	tmp := target + ".tmp"
+	file, err := os.Create(tmp)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(tmp)
+
+		return fmt.Errorf("failed to copy %s: %w", rel, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, target)
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+)
+
+func TestSourcePath(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, rel := range []string{"app_1.0_amd64.deb", "main/binary-amd64/Packages.gz", "main/i18n/Translation-en"} {
+		target, err := sourcePath(dir, rel)
+
+		if err != nil {
+			t.Errorf("sourcePath(%q): %v", rel, err)
+		} else if target != filepath.Join(dir, filepath.FromSlash(rel)) {
+			t.Errorf("sourcePath(%q) = %q", rel, target)
+		}
+	}
+
+	for _, rel := range []string{"", ".", "../evil", "main/../../evil", "/etc/passwd", `..\evil`, "main/.."} {
+		if target, err := sourcePath(dir, rel); err == nil {
+			t.Errorf("sourcePath(%q) = %q, want an error", rel, target)
+		}
+	}
+}
+
+// writeSyncSource creates an unsigned repository holding one package and one index
+func writeSyncSource(t *testing.T, filename, distribution string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	deb := []byte("package contents")
+	index := []byte("Package: app\nFilename: pool/app_1.0_amd64.deb\n")
+	debSum, indexSum := sha256.Sum256(deb), sha256.Sum256(index)
+
+	files := map[string][]byte{
+		"pool/app_1.0_amd64.deb":                 deb,
+		"dists/jammy/main/binary-amd64/Packages": index,
+		"dists/jammy/Release": []byte(fmt.Sprintf("Suite: jammy\nSHA256:\n %s %d main/binary-amd64/Packages\n",
+			hex.EncodeToString(indexSum[:]), len(index))),
+	}
+
+	mfest := manifest.Manifest{Distribution: distribution, Architecture: "amd64", Packages: []packageinfo.PackageInfo{
+		{Name: "app", Version: "1.0", Architecture: "amd64", Filename: filename, Size: int64(len(deb)), SHA256: hex.EncodeToString(debSum[:]), Downloaded: true},
+	}}
+	files[manifest.FileName], _ = json.Marshal(mfest)
+
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestSync(t *testing.T) {
+	source := writeSyncSource(t, "app_1.0_amd64.deb", "jammy")
+	replica := filepath.Join(t.TempDir(), "replica")
+
+	if err := RunSyncCommand([]string{source, replica}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"pool/app_1.0_amd64.deb", "dists/jammy/Release", "dists/jammy/main/binary-amd64/Packages", manifest.FileName} {
+		if _, err := os.Stat(filepath.Join(replica, filepath.FromSlash(name))); err != nil {
+			t.Errorf("the replica lacks %s: %v", name, err)
+		}
+	}
+
+	if entries, _ := filepath.Glob(filepath.Join(replica, ".sync-*")); len(entries) > 0 {
+		t.Errorf("sync left its staging directory %v", entries)
+	}
+}
+
+func TestSyncRejectsUnsafePaths(t *testing.T) {
+	for _, test := range []struct{ filename, distribution string }{
+		{"../../escaped.deb", "jammy"},
+		{"/tmp/escaped.deb", "jammy"},
+		{"app_1.0_amd64.deb", "../escaped"},
+	} {
+		source := writeSyncSource(t, test.filename, test.distribution)
+		parent := t.TempDir()
+		replica := filepath.Join(parent, "a", "replica")
+
+		err := RunSyncCommand([]string{source, replica})
+
+		if err == nil || !strings.Contains(err.Error(), "unsafe") {
+			t.Errorf("syncing %s of %s: got %v, want an unsafe path error", test.filename, test.distribution, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(parent, "escaped.deb")); err == nil {
+			t.Errorf("syncing %s wrote outside the replica", test.filename)
+		}
+	}
+}
+
+func TestSyncRejectsCorruptIndexes(t *testing.T) {
+	source := writeSyncSource(t, "app_1.0_amd64.deb", "jammy")
+	replica := filepath.Join(t.TempDir(), "replica")
+
+	if err := os.WriteFile(filepath.Join(source, "dists/jammy/main/binary-amd64/Packages"), []byte("Package: evil\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RunSyncCommand([]string{source, replica}); err == nil || !strings.Contains(err.Error(), "SHA256") {
+		t.Fatalf("got %v, want a checksum error", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(replica, "dists")); !os.IsNotExist(err) {
+		t.Errorf("a failed sync installed metadata in the replica")
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"portaptable/pkg/bundle"
+	"portaptable/pkg/config"
+	"portaptable/pkg/hostapt"
+	"portaptable/pkg/output"
+	"portaptable/pkg/signing"
+)
+
+// targetAptFiles fetches the target's apt configuration and keyrings as a gzipped tar.
+// Keyring symlinks (common in trusted.gpg.d) are followed so the files arrive whole.
+const targetAptFiles = `cd / && tar -czhf - $(ls -d etc/apt usr/share/keyrings 2>/dev/null)`
+
+// fetchTargetApt copies the target's apt configuration and keyrings below root
+func fetchTargetApt(sshArgs []string, root string) error {
+	archive := root + ".tar.gz"
+	file, err := os.Create(archive)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(archive)
+
+	cmd := exec.Command("ssh", append(sshArgs, targetAptFiles)...)
+	cmd.Stdout = file
+	err = cmd.Run()
+	file.Close()
+
+	if err != nil {
+		return fmt.Errorf("failed to fetch the target's apt configuration: %w", err)
+	}
+
+	return bundle.Extract(archive, root)
+}
+
+// targetTrustedKeys maps the fingerprints of every key the apt configuration below root
+// trusts to the keyring holding it, as a path on the target
+func targetTrustedKeys(root string) (map[string]string, error) {
+	keyrings, err := hostapt.Keyrings(root)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the target's apt configuration: %w", err)
+	}
+
+	trusted := make(map[string]string)
+
+	for _, keyring := range keyrings {
+		keys, err := signing.ShowKeys(filepath.Join(root, keyring))
+
+		if err != nil {
+			output.Warning("Skipping unreadable keyring %s: %v", keyring, err)
+
+			continue
+		}
+
+		for _, key := range keys {
+			if _, ok := trusted[key.Fingerprint]; !ok {
+				trusted[key.Fingerprint] = keyring
+			}
+		}
+	}
+
+	return trusted, nil
+}
+
+// repositoryKeys returns the fingerprints of the repository's public signing keyring
+func repositoryKeys(repoPath string) ([]string, error) {
+	keys, err := signing.ShowKeys(filepath.Join(repoPath, signing.PublicKeyringName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprints := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		fingerprints = append(fingerprints, key.Fingerprint)
+	}
+
+	return fingerprints, nil
+}
+
+// trustedKeyring returns the target keyring that already holds all of fingerprints, or empty
+func trustedKeyring(trusted map[string]string, fingerprints []string) string {
+	keyring := ""
+
+	for _, fingerprint := range fingerprints {
+		path, ok := trusted[fingerprint]
+
+		if !ok || (keyring != "" && path != keyring) {
+			return ""
+		}
+
+		keyring = path
+	}
+
+	return keyring
+}
+
+// showTargetKeys lists the keys a target's apt trusts and whether they cover the repository key
+func showTargetKeys(cfg *config.Config, root, sshTarget string) error {
+	if (root == "") == (sshTarget == "") {
+		return fmt.Errorf("usage: key target --root DIR|--ssh USER@HOST")
+	}
+
+	if sshTarget != "" {
+		work, err := os.MkdirTemp("", "portaptable-target-")
+
+		if err != nil {
+			return err
+		}
+
+		defer os.RemoveAll(work)
+
+		root = filepath.Join(work, "root")
+
+		if err := fetchTargetApt([]string{sshTarget}, root); err != nil {
+			return err
+		}
+	}
+
+	trusted, err := targetTrustedKeys(root)
+
+	if err != nil {
+		return err
+	}
+
+	fingerprints := make([]string, 0, len(trusted))
+
+	for fingerprint := range trusted {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	sort.Strings(fingerprints)
+	fmt.Printf("Target trusts %d keys\n", len(trusted))
+
+	for _, fingerprint := range fingerprints {
+		fmt.Printf("  %s  %s\n", fingerprint, trusted[fingerprint])
+	}
+
+	repoKeys, err := repositoryKeys(cfg.RepoPath)
+
+	if err != nil {
+		output.Warning("Repository is not signed; targets need [trusted=yes]")
+
+		return nil
+	}
+
+	if keyring := trustedKeyring(trusted, repoKeys); keyring != "" {
+		output.Success("Repository key is trusted via %s", keyring)
+	} else {
+		output.Warning("Target does not trust the repository key; %s or deploy installs it", setupScriptName)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/output"
+)
+
+// taskPrefix marks a requested tasksel task, e.g. task:ssh-server
+const taskPrefix = "task:"
+
+// expandTasks replaces each requested task:NAME with the packages whose Task field
+// lists it (Ubuntu), else with Debian's task-NAME metapackage
+func (run *downloadRun) expandTasks(packages []string) ([]string, error) {
+	var result []string
+	var members map[string][]string
+
+	for _, pkg := range packages {
+		task, isTask := strings.CutPrefix(pkg, taskPrefix)
+
+		if !isTask {
+			result = appendMissing(result, []string{pkg})
+
+			continue
+		}
+
+		if members == nil {
+			var err error
+
+			if members, err = run.taskMembers(); err != nil {
+				return nil, err
+			}
+		}
+
+		names := members[task]
+
+		if len(names) == 0 {
+			available, err := run.availablePackageNames()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !available["task-"+task] {
+				return nil, fmt.Errorf("no package belongs to task %s", task)
+			}
+
+			names = []string{"task-" + task}
+		}
+
+		output.Info("Task %s: %s", task, strings.Join(names, " "))
+		result = appendMissing(result, names)
+	}
+
+	return result, nil
+}
+
+// taskMembers returns the candidate packages of each task named by their Task fields
+func (run *downloadRun) taskMembers() (map[string][]string, error) {
+	records, err := run.candidateRecords()
+
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]string)
+
+	for _, record := range records {
+		for _, task := range strings.Split(record["Task"], ",") {
+			if task = strings.TrimSpace(task); task != "" {
+				members[task] = appendMissing(members[task], []string{record["Package"]})
+			}
+		}
+	}
+
+	for _, names := range members {
+		sort.Strings(names)
+	}
+
+	return members, nil
+}
+
+// candidateRecords returns the index entry of every available package's candidate
+func (run *downloadRun) candidateRecords() ([]deb822.Paragraph, error) {
+	if run.nativeIndex != nil {
+		var records []deb822.Paragraph
+
+		for name := range run.nativeIndex.names {
+			if record, ok := run.nativeIndex.record(name); ok {
+				records = append(records, record)
+			}
+		}
+
+		return records, nil
+	}
+
+	out, err := run.aptCommand("apt-cache", "dumpavail").Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("apt-cache dumpavail failed: %w", err)
+	}
+
+	records, err := deb822.Parse(bytes.NewReader(out))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse apt-cache dumpavail: %w", err)
+	}
+
+	return records, nil
+}
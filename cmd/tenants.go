@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+)
+
+// tenant is one repository of a multi-tenant server and the secrets that may read it
+type tenant struct {
+	Name string `json:"name"`
+	Repo string `json:"repo"`
+
+	// Tokens are accepted as "Authorization: Bearer TOKEN", or as the password of the
+	// user "bearer", which is how apt's auth.conf sends them
+	Tokens []string `json:"tokens,omitempty"`
+
+	// Credentials map user names to passwords for HTTP basic authentication
+	Credentials map[string]string `json:"credentials,omitempty"`
+}
+
+// tenantsFile is the --tenants file: {"tenants": [{"name", "repo", "tokens", "credentials"}]}
+type tenantsFile struct {
+	Tenants []tenant `json:"tenants"`
+}
+
+// validTenantName matches names usable as the first URL path segment
+var validTenantName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// loadTenants reads and validates a tenants file
+func loadTenants(path string) ([]tenant, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	// Windows has no permission bits to check
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		output.Warning("%s holds access tokens but is readable by other users (chmod 600 it)", path)
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	var file tenantsFile
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %w", err)
+	}
+
+	if len(file.Tenants) == 0 {
+		return nil, fmt.Errorf("tenants file %s lists no tenants", path)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, t := range file.Tenants {
+		switch {
+		case !validTenantName.MatchString(t.Name):
+			return nil, fmt.Errorf("invalid tenant name %q", t.Name)
+		case seen[t.Name]:
+			return nil, fmt.Errorf("duplicate tenant %q", t.Name)
+		case t.Repo == "":
+			return nil, fmt.Errorf("tenant %s has no repo", t.Name)
+		case len(t.Tokens) == 0 && len(t.Credentials) == 0:
+			return nil, fmt.Errorf("tenant %s has neither tokens nor credentials", t.Name)
+		}
+
+		// An empty secret would let in every request sending an empty one
+		for _, token := range t.Tokens {
+			if token == "" {
+				return nil, fmt.Errorf("tenant %s has an empty token", t.Name)
+			}
+		}
+
+		for user, password := range t.Credentials {
+			if user == "" || password == "" {
+				return nil, fmt.Errorf("tenant %s has credentials with an empty user or password", t.Name)
+			}
+		}
+
+		seen[t.Name] = true
+	}
+
+	return file.Tenants, nil
+}
+
+// authorized reports whether a request carries one of the tenant's tokens or credentials
+func (t *tenant) authorized(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return t.validToken(token)
+	}
+
+	user, password, ok := r.BasicAuth()
+
+	if !ok {
+		return false
+	}
+
+	if user == "bearer" && t.validToken(password) {
+		return true
+	}
+
+	expected, known := t.Credentials[user]
+
+	return known && expected != "" && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// validToken compares token with every tenant token in constant time
+func (t *tenant) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	valid := false
+
+	for _, candidate := range t.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
+
+// protect rejects requests without the tenant's tokens or credentials
+func (t *tenant) protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.authorized(r) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=\"portaptable %s\"", t.Name))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authInstruction returns the command storing the tenant's secret in the target's
+// auth.conf.d; apt only sends credentials over plain http to machines written with the scheme
+func (t *tenant) authInstruction(machine string) string {
+	login := "login bearer password TOKEN"
+
+	if len(t.Tokens) == 0 {
+		login = "login USER password PASSWORD"
+	}
+
+	path := fmt.Sprintf("/etc/apt/auth.conf.d/portaptable-%s.conf", t.Name)
+
+	return fmt.Sprintf("echo 'machine http://%s/ %s' | sudo tee %s >/dev/null && sudo chmod 600 %s", machine, login, path, path)
+}
+
+// serveTenants serves every repository of the tenants file below /NAME/, each behind
+// its own tokens or credentials, so teams sharing the server only see their packages
+func serveTenants(cfg *config.Config) error {
+	if cfg.Snapshot != "" || cfg.EmitConfig != "" {
+		return fmt.Errorf("--tenants cannot be combined with --snapshot or --emit-config")
+	}
+
+	tenants, err := loadTenants(cfg.TenantsFile)
+
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	var flushes []func()
+
+	for i := range tenants {
+		t := &tenants[i]
+		tenantConfig := *cfg
+		tenantConfig.RepoPath = t.Repo
+
+		server, err := newRepositoryServer(&tenantConfig)
+
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+
+		server.prefix, server.tenant = "/"+t.Name, t
+		flushes = append(flushes, server.saveStats)
+		mux.Handle(server.prefix+"/", t.protect(http.StripPrefix(server.prefix, server.mux)))
+
+		fmt.Printf("Tenant %s: %s (%d packages) at http://localhost:%s%s/\n",
+			t.Name, t.Repo, len(server.current().Packages), cfg.Port, server.prefix)
+	}
+
+	// The overall health check names no tenants; each tenant's own is behind its credentials
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "tenants": len(tenants)})
+	})
+
+	fmt.Printf("Starting multi-tenant repository server on http://localhost:%s\n", cfg.Port)
+	fmt.Println("Targets store their tenant's token in /etc/apt/auth.conf.d; see http://HOST/NAME/ for setup")
+
+	return serveHTTP(":"+cfg.Port, mux, cfg.ConnectionRate, flushes...)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"tenants": [{"name": "team-a", "repo": "/srv/a", "tokens": ["t0ken"]}, {"name": "team-b", "repo": "/srv/b", "credentials": {"ci": "pw"}}]}`, ""},
+		{"no tenants", `{"tenants": []}`, "lists no tenants"},
+		{"invalid name", `{"tenants": [{"name": "../a", "repo": "/srv/a", "tokens": ["t"]}]}`, "invalid tenant name"},
+		{"duplicate", `{"tenants": [{"name": "a", "repo": "/srv/a", "tokens": ["t"]}, {"name": "a", "repo": "/srv/b", "tokens": ["u"]}]}`, "duplicate tenant"},
+		{"no repo", `{"tenants": [{"name": "a", "tokens": ["t"]}]}`, "has no repo"},
+		{"no secrets", `{"tenants": [{"name": "a", "repo": "/srv/a"}]}`, "neither tokens nor credentials"},
+		{"empty token", `{"tenants": [{"name": "a", "repo": "/srv/a", "tokens": [""]}]}`, "empty token"},
+		{"empty password", `{"tenants": [{"name": "a", "repo": "/srv/a", "credentials": {"ci": ""}}]}`, "empty user or password"},
+		{"empty user", `{"tenants": [{"name": "a", "repo": "/srv/a", "credentials": {"": "pw"}}]}`, "empty user or password"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "tenants.json")
+
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadTenants(path)
+
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: loadTenants() error = %v", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: loadTenants() error = %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestTenantAuthorized(t *testing.T) {
+	tenant := &tenant{Name: "a", Tokens: []string{"t0ken"}, Credentials: map[string]string{"ci": "pw"}}
+
+	tests := []struct {
+		name          string
+		authorization string
+		user          string
+		password      string
+		want          bool
+	}{
+		{"bearer token", "Bearer t0ken", "", "", true},
+		{"wrong token", "Bearer other", "", "", false},
+		{"empty token", "Bearer ", "", "", false},
+		{"token as password", "", "bearer", "t0ken", true},
+		{"credentials", "", "ci", "pw", true},
+		{"wrong password", "", "ci", "nope", false},
+		{"empty password", "", "ci", "", false},
+		{"unknown user", "", "other", "pw", false},
+		{"no authorization", "", "", "", false},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/a/", nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		} else if test.user != "" {
+			req.SetBasicAuth(test.user, test.password)
+		}
+
+		if got := tenant.authorized(req); got != test.want {
+			t.Errorf("%s: authorized() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
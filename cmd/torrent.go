@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/torrent"
+)
+
+// torrentOptions are the export flags controlling .torrent generation
+type torrentOptions struct {
+	enabled  bool
+	trackers stringList
+	webSeeds stringList
+	seedPort int
+}
+
+// writeTorrent creates BUNDLE.torrent and, when a seed port is set, seeds the bundle until interrupted
+func writeTorrent(bundlePath string, options *torrentOptions) error {
+	info, err := torrent.Create(bundlePath, 0, options.trackers, options.webSeeds)
+
+	if err != nil {
+		return fmt.Errorf("failed to create torrent: %w", err)
+	}
+
+	torrentPath := bundlePath + torrent.Extension
+
+	if err := info.Write(torrentPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s (%d pieces of %s, info hash %x)\n",
+		torrentPath, info.NumPieces(), formatSize(info.PieceLength), info.InfoHash)
+
+	if len(options.trackers) == 0 && len(options.webSeeds) == 0 {
+		output.Warning("Torrent lists no --tracker or --web-seed; peers must be added manually")
+	}
+
+	if options.seedPort == 0 {
+		return nil
+	}
+
+	return seedTorrent(info, bundlePath, options.seedPort)
+}
+
+// RunSeedCommand seeds a bundle described by a .torrent written by export --torrent
+func RunSeedCommand(args []string) error {
+	var cfg config.Config
+	var port int
+
+	fs := newFlagSet("seed", &cfg)
+	fs.IntVar(&port, "port", 6881, "Port to accept BitTorrent peers on")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: seed [OPTIONS] BUNDLE.torrent [BUNDLE]")
+	}
+
+	info, err := torrent.Load(fs.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	// The bundle is expected next to its torrent unless given explicitly
+	bundlePath := filepath.Join(filepath.Dir(fs.Arg(0)), info.Name)
+
+	if fs.NArg() == 2 {
+		bundlePath = fs.Arg(1)
+	}
+
+	if _, err := os.Stat(bundlePath); err != nil {
+		return fmt.Errorf("bundle not found: %w", err)
+	}
+
+	output.Info("Verifying %s against %s...", bundlePath, fs.Arg(0))
+
+	if err := info.Verify(bundlePath); err != nil {
+		return err
+	}
+
+	return seedTorrent(info, bundlePath, port)
+}
+
+func seedTorrent(info *torrent.MetaInfo, bundlePath string, port int) error {
+	seeder := &torrent.Seeder{
+		Info: info,
+		Path: bundlePath,
+		Port: port,
+		Logf: output.Info,
+	}
+
+	output.Info("Seeding %s on port %d (info hash %x)...", filepath.Base(bundlePath), port, info.InfoHash)
+
+	return seeder.Seed()
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/repometa"
+)
+
+// fetchInstallerPackages downloads every udeb of the distribution's debian-installer
+// index so the repository can serve fully offline installer runs
+func (run *downloadRun) fetchInstallerPackages(config *config.Config, mfest *manifest.Manifest) error {
+	mirror := run.newArchiveMirror(run.archiveMirror(config), config.RepoPath)
+
+	if err := run.verifyMirrorRelease(&mirror, config.Distribution, config.RepoPath); err != nil {
+		return err
+	}
+
+	output.Info("Fetching debian-installer index from %s...", mirror.URL)
+
+	entries, err := mirror.FetchPackages(config.Distribution, "main", config.Architecture, true)
+
+	if err != nil {
+		return err
+	}
+
+	poolPath := filepath.Join(config.RepoPath, "pool")
+	failed := 0
+
+	for i, entry := range entries {
+		arch := entry["Architecture"]
+
+		if arch != config.Architecture && arch != "all" {
+			continue
+		}
+
+		output.Info("[%d/%d] Processing udeb %s...", i+1, len(entries), entry["Package"])
+
+		filename, sums, err := mirror.DownloadPackage(entry, poolPath)
+
+		if err != nil {
+			output.Failure("Failed to download udeb %s: %v", entry["Package"], err)
+			failed++
+
+			continue
+		}
+
+		mfest.Packages = append(mfest.Packages, packageinfo.PackageInfo{
+			Name:         entry["Package"],
+			Version:      entry["Version"],
+			Architecture: arch,
+			Filename:     filename,
+			Size:         sums.Size,
+			MD5sum:       sums.MD5,
+			SHA1:         sums.SHA1,
+			SHA256:       sums.SHA256,
+			MTime:        sums.ModTime.UnixNano(),
+			Type:         packageinfo.TypeUdeb,
+			Control:      repometa.IndexFields(entry),
+			Downloaded:   true,
+		})
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d udebs failed to download", failed)
+	}
+
+	return nil
+}
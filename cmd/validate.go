@@ -0,0 +1,512 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"portaptable/pkg/bundle"
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/cosign"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/depgraph"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/relation"
+	"portaptable/pkg/repometa"
+	"portaptable/pkg/signing"
+)
+
+// validationCheck is one part of a validation report
+type validationCheck struct {
+	Name     string   `json:"name"`
+	Passed   bool     `json:"passed"`
+	Problems []string `json:"problems,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// validationReport is the result of validate, as printed with --json
+type validationReport struct {
+	Bundle       string            `json:"bundle"`
+	Distribution string            `json:"distribution,omitempty"`
+	Architecture string            `json:"architecture,omitempty"`
+	Packages     int               `json:"packages"`
+	Valid        bool              `json:"valid"`
+	Checks       []validationCheck `json:"checks"`
+}
+
+// validationOptions select what validate checks signatures and dependencies against
+type validationOptions struct {
+	statusFile    string
+	trustedKeys   []string
+	requireSigned bool
+	cosignKey     string
+}
+
+// RunValidateCommand checks that a bundle (or repository directory) is usable offline
+// on its own: the manifest's pool files are present and intact, every dependency is
+// shipped or installed on the target, the indexes agree with the pool and the Release
+// file, and the signatures verify. The exit status gates release processes.
+func RunValidateCommand(args []string) error {
+	var cfg config.Config
+	var options validationOptions
+	var trustedKeys stringList
+	var jsonOutput bool
+
+	fs := newFlagSet("validate", &cfg)
+	fs.StringVar(&options.statusFile, "status-file", "", "The target's /var/lib/dpkg/status, whose packages need not be shipped (default: a bare system)")
+	fs.Var(&trustedKeys, "trusted-key", "Keyring the signatures must verify against (repeatable; default: the keyring in the bundle)")
+	fs.BoolVar(&options.requireSigned, "require-signed", false, "Fail unsigned bundles instead of warning about them")
+	fs.StringVar(&options.cosignKey, "cosign-pub", "", "Cosign public key the bundle signature must verify against")
+	fs.BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: validate [OPTIONS] BUNDLE|REPOSITORY")
+	}
+
+	options.trustedKeys = trustedKeys
+	target := fs.Arg(0)
+	root := target
+
+	if info, err := os.Stat(target); err != nil {
+		return err
+	} else if !info.IsDir() {
+		if delta, err := readDeltaInfo(target); err != nil {
+			return err
+		} else if delta != nil {
+			return fmt.Errorf("%s only holds the changes since snapshot %s; import it and validate the repository instead", target, delta.Base)
+		}
+
+		if root, err = os.MkdirTemp("", "portaptable-validate-"); err != nil {
+			return err
+		}
+
+		defer os.RemoveAll(root)
+
+		if !jsonOutput {
+			output.Info("Unpacking %s...", target)
+		}
+
+		if err := bundle.Extract(target, root); err != nil {
+			return err
+		}
+	}
+
+	report := validate(target, root, &options)
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printValidation(report)
+	}
+
+	if !report.Valid {
+		return fmt.Errorf("%s failed validation", target)
+	}
+
+	return nil
+}
+
+// validate runs every check on the repository unpacked at root
+func validate(target, root string, options *validationOptions) *validationReport {
+	report := &validationReport{Bundle: target}
+	mfest, err := manifest.Load(root)
+
+	if err != nil {
+		report.Checks = []validationCheck{{Name: "manifest", Problems: []string{err.Error()}}}
+
+		return report
+	}
+
+	report.Distribution, report.Architecture = mfest.Distribution, mfest.Architecture
+	report.Checks = []validationCheck{
+		{Name: "manifest"},
+		checkBundlePool(root, mfest),
+		checkBundleDependencies(mfest, options.statusFile),
+		checkBundleMetadata(root, mfest),
+		checkBundleSignatures(target, root, mfest, options),
+	}
+
+	report.Valid = true
+
+	for i := range report.Checks {
+		check := &report.Checks[i]
+		check.Passed = len(check.Problems) == 0
+		report.Valid = report.Valid && check.Passed
+	}
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			report.Packages++
+		}
+	}
+
+	return report
+}
+
+// checkBundlePool verifies every downloaded manifest entry against its pool file
+// and warns about pool files the manifest does not know
+func checkBundlePool(root string, mfest *manifest.Manifest) validationCheck {
+	check := validationCheck{Name: "pool"}
+	poolPath := filepath.Join(root, "pool")
+	known := make(map[string]bool)
+	var hashed atomic.Int64
+
+	for i := range mfest.Packages {
+		pkg := &mfest.Packages[i]
+
+		if !pkg.Downloaded {
+			continue
+		}
+
+		known[pkg.Filename] = true
+
+		if _, err := verifyPoolFile(poolPath, pkg, &hashed); os.IsNotExist(err) {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s is missing from the pool", pkg.Filename))
+		} else if err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s is corrupted: %v", pkg.Filename, err))
+		}
+	}
+
+	entries, _ := os.ReadDir(poolPath)
+
+	for _, entry := range entries {
+		if !entry.IsDir() && !known[entry.Name()] {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("%s is in the pool but not in the manifest", entry.Name()))
+		}
+	}
+
+	return check
+}
+
+// checkBundleDependencies reports hard dependencies that neither the bundle nor the
+// packages installed on the target satisfy
+func checkBundleDependencies(mfest *manifest.Manifest, statusFile string) validationCheck {
+	check := validationCheck{Name: "dependencies"}
+	var shipped []packageinfo.PackageInfo
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			shipped = append(shipped, pkg)
+		}
+	}
+
+	available := shipped
+
+	if statusFile != "" {
+		installed, err := installedPackages(statusFile)
+
+		if err != nil {
+			check.Problems = append(check.Problems, err.Error())
+
+			return check
+		}
+
+		available = append(append([]packageinfo.PackageInfo{}, shipped...), installed...)
+	}
+
+	graph := depgraph.New(available, mfest.Architecture)
+
+	for _, pkg := range shipped {
+		for _, field := range depgraph.Hard {
+			for _, group := range relation.Parse(pkg.Control[field]) {
+				if !groupSatisfied(graph, group) {
+					check.Problems = append(check.Problems, fmt.Sprintf("%s %s: %s %s is not in the bundle or installed on the target",
+						pkg.Name, pkg.Version, field, group))
+				}
+			}
+		}
+	}
+
+	return check
+}
+
+// groupSatisfied reports whether any alternative of a dependency group resolves
+func groupSatisfied(graph *depgraph.Graph, group relation.Group) bool {
+	for _, r := range group {
+		if _, ok := graph.Resolve(r); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// installedPackages reads the packages a dpkg status file records as installed
+func installedPackages(statusFile string) ([]packageinfo.PackageInfo, error) {
+	file, err := os.Open(statusFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	defer file.Close()
+
+	paragraphs, err := deb822.Parse(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse status file: %w", err)
+	}
+
+	var installed []packageinfo.PackageInfo
+
+	for _, paragraph := range paragraphs {
+		if !strings.HasSuffix(paragraph["Status"], " installed") {
+			continue
+		}
+
+		installed = append(installed, packageinfo.PackageInfo{
+			Name:         paragraph["Package"],
+			Version:      paragraph["Version"],
+			Architecture: paragraph["Architecture"],
+			Control:      map[string]string{"Provides": paragraph["Provides"]},
+		})
+	}
+
+	return installed, nil
+}
+
+// checkBundleMetadata checks the Release file's checksums against the index files and
+// the Packages indexes against the manifest
+func checkBundleMetadata(root string, mfest *manifest.Manifest) validationCheck {
+	check := validationCheck{Name: "metadata"}
+	distPath := repometa.DistPath(root, mfest.Distribution)
+	data, err := os.ReadFile(filepath.Join(distPath, "Release"))
+
+	if err != nil {
+		check.Problems = append(check.Problems, fmt.Sprintf("no Release file for %s", mfest.Distribution))
+
+		return check
+	}
+
+	paragraphs, err := deb822.Parse(bytes.NewReader(data))
+
+	if err != nil || len(paragraphs) == 0 {
+		check.Problems = append(check.Problems, fmt.Sprintf("invalid Release file: %v", err))
+
+		return check
+	}
+
+	var indexes []string
+
+	for _, line := range deb822.Lines(paragraphs[0]["SHA256"]) {
+		fields := strings.Fields(line)
+
+		if len(fields) != 3 {
+			continue
+		}
+
+		if problem := checkListedFile(distPath, fields[2], fields[0], fields[1]); problem != "" {
+			check.Problems = append(check.Problems, problem)
+		}
+
+		if base := path.Base(fields[2]); base == "Packages" || base == "Sources" {
+			indexes = append(indexes, fields[2])
+		}
+	}
+
+	if len(indexes) == 0 {
+		check.Problems = append(check.Problems, "the Release file lists no Packages index with a SHA256 checksum")
+
+		return check
+	}
+
+	check.Problems = append(check.Problems, checkIndexedPackages(distPath, indexes, mfest)...)
+
+	return check
+}
+
+// checkListedFile compares a file below distPath with its Release entry
+func checkListedFile(distPath, name, sha256, size string) string {
+	sums, err := checksum.File(filepath.Join(distPath, filepath.FromSlash(name)))
+
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s is listed in the Release file but missing", name)
+	case strconv.FormatInt(sums.Size, 10) != size:
+		return fmt.Sprintf("%s is %d bytes, the Release file says %s", name, sums.Size, size)
+	case sums.SHA256 != sha256:
+		return fmt.Sprintf("%s does not match its SHA256 in the Release file", name)
+	}
+
+	return ""
+}
+
+// checkIndexedPackages reports index entries the manifest lacks or disagrees with, and
+// downloaded files no index lists
+func checkIndexedPackages(distPath string, indexes []string, mfest *manifest.Manifest) []string {
+	var problems []string
+	manifestSums := make(map[string]string)
+
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded {
+			manifestSums["pool/"+pkg.Filename] = pkg.SHA256
+		}
+	}
+
+	indexed := make(map[string]bool)
+
+	for _, index := range indexes {
+		file, err := os.Open(filepath.Join(distPath, filepath.FromSlash(index)))
+
+		if err != nil {
+			continue // Already reported as missing
+		}
+
+		entries, err := deb822.Parse(file)
+		file.Close()
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s cannot be parsed: %v", index, err))
+
+			continue
+		}
+
+		for _, entry := range entries {
+			for filename, listedSum := range indexedFiles(entry) {
+				indexed[filename] = true
+				sum, ok := manifestSums[filename]
+
+				switch {
+				case !ok:
+					problems = append(problems, fmt.Sprintf("%s lists %s, which the manifest does not ship", index, filename))
+				case sum != "" && listedSum != sum:
+					problems = append(problems, fmt.Sprintf("%s gives %s a different SHA256 than the manifest", index, filename))
+				}
+			}
+		}
+	}
+
+	var unlisted []string
+
+	for filename := range manifestSums {
+		if !indexed[filename] {
+			unlisted = append(unlisted, fmt.Sprintf("%s is in the manifest but no Packages or Sources index lists it", filename))
+		}
+	}
+
+	sort.Strings(unlisted)
+
+	return append(problems, unlisted...)
+}
+
+// indexedFiles returns the SHA256 of every file an index entry lists: the Filename of
+// a Packages entry, or the files below Directory of a Sources entry
+func indexedFiles(entry deb822.Paragraph) map[string]string {
+	if filename, ok := entry["Filename"]; ok {
+		return map[string]string{filename: entry["SHA256"]}
+	}
+
+	files := make(map[string]string)
+
+	for _, line := range deb822.Lines(entry["Checksums-Sha256"]) {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			files[path.Join(entry["Directory"], fields[2])] = fields[0]
+		}
+	}
+
+	return files
+}
+
+// checkBundleSignatures verifies the Release signatures and, when present, the
+// signatures of the bundle file itself
+func checkBundleSignatures(target, root string, mfest *manifest.Manifest, options *validationOptions) validationCheck {
+	check := validationCheck{Name: "signatures"}
+	keyrings := options.trustedKeys
+	shippedKeyring := filepath.Join(root, signing.PublicKeyringName)
+
+	if len(keyrings) == 0 {
+		if _, err := os.Stat(shippedKeyring); err == nil {
+			keyrings = []string{shippedKeyring}
+			check.Warnings = append(check.Warnings, "verified against the keyring shipped in the bundle; pass --trusted-key to pin the expected key")
+		}
+	}
+
+	distPath := repometa.DistPath(root, mfest.Distribution)
+	releasePath := filepath.Join(distPath, "Release")
+	inRelease, inReleaseErr := os.ReadFile(filepath.Join(distPath, "InRelease"))
+	releaseGPG := filepath.Join(distPath, "Release.gpg")
+	_, releaseGPGErr := os.Stat(releaseGPG)
+
+	if inReleaseErr != nil && releaseGPGErr != nil {
+		message := "the Release file is unsigned; targets need [trusted=yes]"
+
+		if options.requireSigned {
+			check.Problems = append(check.Problems, message)
+		} else {
+			check.Warnings = append(check.Warnings, message)
+		}
+	} else if len(keyrings) == 0 {
+		check.Problems = append(check.Problems, "the Release file is signed but there is no keyring to verify it with")
+	}
+
+	if inReleaseErr == nil && len(keyrings) > 0 {
+		content, err := signing.VerifyClearsigned(inRelease, keyrings)
+		release, _ := os.ReadFile(releasePath)
+
+		if err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("InRelease: %v", err))
+		} else if !bytes.Equal(bytes.TrimSpace(content), bytes.TrimSpace(release)) {
+			check.Problems = append(check.Problems, "InRelease does not sign the Release file next to it")
+		}
+	}
+
+	if releaseGPGErr == nil && len(keyrings) > 0 {
+		if err := signing.VerifyDetached(releasePath, releaseGPG, keyrings); err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("Release.gpg: %v", err))
+		}
+	}
+
+	// export signs the bundle file itself when a key is available
+	if _, err := os.Stat(target + ".asc"); err == nil && target != root && len(keyrings) > 0 {
+		if err := signing.VerifyDetached(target, target+".asc", keyrings); err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s.asc: %v", filepath.Base(target), err))
+		}
+	}
+
+	if options.cosignKey != "" {
+		if err := cosign.VerifyBlob(options.cosignKey, target); err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("cosign: %v", err))
+		}
+	}
+
+	return check
+}
+
+// printValidation prints the report for people
+func printValidation(report *validationReport) {
+	for _, check := range report.Checks {
+		for _, warning := range check.Warnings {
+			output.Warning("%s: %s", check.Name, warning)
+		}
+
+		if check.Passed {
+			output.Success("%s: ok", check.Name)
+
+			continue
+		}
+
+		for _, problem := range check.Problems {
+			output.Failure("%s: %s", check.Name, problem)
+		}
+	}
+
+	if report.Valid {
+		output.Success("%s is self-contained: %d packages for %s %s", report.Bundle, report.Packages, report.Distribution, report.Architecture)
+	}
+}
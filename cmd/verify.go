@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/repolock"
+)
+
+// verifyProgressInterval is how often the verify progress line is redrawn
+const verifyProgressInterval = 200 * time.Millisecond
+
+// verifyOutcome is the outcome of hashing one pool file
+type verifyOutcome struct {
+	index int // Into the manifest's packages
+	mtime int64
+	err   error
+}
+
+// RunVerifyCommand checks every pool file against the checksums in the manifest.
+// Files whose size and modification time match the last verification are trusted
+// without re-hashing unless --deep is given; the rest are hashed by --jobs workers.
+func RunVerifyCommand(args []string) error {
+	var cfg config.Config
+	var deep bool
+	var jobs int
+
+	fs := newFlagSet("verify", &cfg)
+	fs.BoolVar(&deep, "deep", false, "Re-hash every file, even those unchanged since the last verification")
+	fs.IntVar(&jobs, "jobs", runtime.NumCPU(), "Files to hash in parallel")
+	fs.Parse(args)
+
+	if jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+
+	lock, err := repolock.Acquire(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer lock.Unlock()
+
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	poolPath := filepath.Join(cfg.RepoPath, "pool")
+	var total, unchanged, failed int
+	var pending []int
+	var pendingBytes int64
+
+	for i := range mfest.Packages {
+		pkg := &mfest.Packages[i]
+
+		if !pkg.Downloaded {
+			continue
+		}
+
+		total++
+		info, err := os.Stat(filepath.Join(poolPath, pkg.Filename))
+
+		if err != nil {
+			output.Failure("Missing %s", pkg.Filename)
+			failed++
+
+			continue
+		}
+
+		if !deep && pkg.MTime != 0 && pkg.MTime == info.ModTime().UnixNano() && pkg.Size == info.Size() {
+			unchanged++
+
+			continue
+		}
+
+		pending = append(pending, i)
+		pendingBytes += info.Size()
+	}
+
+	hashed, corrupted, recorded := hashPoolFiles(poolPath, mfest.Packages, pending, pendingBytes, jobs)
+	failed += corrupted
+
+	if recorded {
+		if err := manifest.Save(cfg.RepoPath, mfest); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pool files are missing or corrupted", failed, total)
+	}
+
+	output.Success("Verified %d pool files (%d hashed, %d unchanged since the last verification)", hashed+unchanged, hashed, unchanged)
+
+	return nil
+}
+
+// hashPoolFiles verifies the pending packages with jobs workers, drawing a combined
+// progress line, and records the modification time of every intact file. It returns
+// how many files passed and failed and whether any recorded time changed.
+func hashPoolFiles(poolPath string, packages []packageinfo.PackageInfo, pending []int, totalBytes int64, jobs int) (int, int, bool) {
+	queue := make(chan int)
+	results := make(chan verifyOutcome)
+	var hashedBytes atomic.Int64
+	var wg sync.WaitGroup
+
+	for w := 0; w < min(jobs, max(len(pending), 1)); w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				mtime, err := verifyPoolFile(poolPath, &packages[i], &hashedBytes)
+				results <- verifyOutcome{index: i, mtime: mtime, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, i := range pending {
+			queue <- i
+		}
+
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	ticker := time.NewTicker(verifyProgressInterval)
+	defer ticker.Stop()
+
+	var passed, failed, done int
+	recorded := false
+
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				output.ProgressDone()
+
+				return passed, failed, recorded
+			}
+
+			done++
+			pkg := &packages[result.index]
+
+			if result.err != nil {
+				output.ProgressDone()
+				output.Failure("Corrupted %s: %v", pkg.Filename, result.err)
+				failed++
+
+				continue
+			}
+
+			passed++
+
+			if pkg.MTime != result.mtime {
+				pkg.MTime = result.mtime
+				recorded = true
+			}
+
+		case <-ticker.C:
+			percent := 100.0
+
+			if totalBytes > 0 {
+				percent = float64(hashedBytes.Load()) / float64(totalBytes) * 100
+			}
+
+			output.Progress("Verifying %d/%d files, %s of %s (%.0f%%)",
+				done, len(pending), formatSize(hashedBytes.Load()), formatSize(totalBytes), percent)
+		}
+	}
+}
+
+// verifyPoolFile hashes a pool file and compares it with the manifest, returning the
+// modification time to record when it matches. Bytes read are added to progress.
+func verifyPoolFile(poolPath string, pkg *packageinfo.PackageInfo, progress *atomic.Int64) (int64, error) {
+	file, err := os.Open(filepath.Join(poolPath, pkg.Filename))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	sums, err := checksum.Reader(&countingReader{r: file, count: progress})
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case pkg.SHA256 != "" && sums.SHA256 != pkg.SHA256:
+		return 0, fmt.Errorf("expected SHA256 %s, got %s", pkg.SHA256, sums.SHA256)
+	case pkg.SHA256 == "" && pkg.MD5sum != "" && sums.MD5 != pkg.MD5sum:
+		return 0, fmt.Errorf("expected MD5 %s, got %s", pkg.MD5sum, sums.MD5)
+	case pkg.Size != 0 && sums.Size != pkg.Size:
+		return 0, fmt.Errorf("expected %d bytes, got %d", pkg.Size, sums.Size)
+	}
+
+	return info.ModTime().UnixNano(), nil
+}
+
+// countingReader adds the number of bytes read to a shared counter
+type countingReader struct {
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count.Add(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+)
+
+// outdatedPackage is a manifest package with a newer version upstream
+type outdatedPackage struct {
+	Name         string `json:"name"`
+	Architecture string `json:"architecture"`
+	Current      string `json:"current"`
+	Available    string `json:"available"`
+	Suite        string `json:"suite"`
+}
+
+// watchReport is the result of one upstream check, as printed with --json and sent to webhooks
+type watchReport struct {
+	CheckedAt    time.Time         `json:"checked_at"`
+	Distribution string            `json:"distribution"`
+	Outdated     []outdatedPackage `json:"outdated"`
+}
+
+// Exit statuses of watch --once
+const (
+	watchOutOfDate   = 1
+	watchCheckFailed = 2
+)
+
+// ExitError is a subcommand failure asking for a specific exit status
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// RunWatchCommand checks upstream for newer versions of the repository's packages
+// without downloading them. With --once the exit status tells whether the repository
+// is current; otherwise it keeps checking and reports whenever the outdated set changes.
+func RunWatchCommand(args []string) error {
+	var cfg config.Config
+	var interval time.Duration
+	var once, jsonOutput bool
+	var webhook, pockets string
+
+	fs := newFlagSet("watch", &cfg)
+	fs.DurationVar(&interval, "interval", 6*time.Hour, "Time between checks")
+	fs.BoolVar(&once, "once", false, "Check once and exit 1 when the repository is out of date, 2 when the check fails")
+	fs.BoolVar(&jsonOutput, "json", false, "Print reports as JSON")
+	fs.StringVar(&webhook, "webhook", "", "POST the JSON report to this URL when the repository is out of date")
+	fs.StringVar(&cfg.Mirror, "mirror", "", "Archive to check (default: that of the repository's last download)")
+	fs.StringVar(&pockets, "pockets", "", "Comma-separated pockets to check (default: those of the repository's last download)")
+	fs.Parse(args)
+
+	set := make(map[string]bool)
+
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if pockets != "" {
+		cfg.Pockets = strings.Split(pockets, ",")
+	}
+
+	run := newDownloadRun(&cfg)
+	run.applyHashPolicy(&cfg)
+	run.applyTrustPolicy(&cfg)
+	previous := ""
+
+	for {
+		report, err := run.checkUpstream(&cfg, set)
+
+		if err != nil {
+			if once {
+				return &ExitError{Code: watchCheckFailed, Err: err}
+			}
+
+			output.Failure("Upstream check failed: %v", err)
+		} else if key := outdatedKey(report); once || key != previous {
+			previous = key
+
+			if err := printWatchReport(report, jsonOutput); err != nil {
+				return err
+			}
+
+			if webhook != "" && len(report.Outdated) > 0 {
+				if err := postReport(webhook, report); err != nil {
+					output.Failure("Failed to notify %s: %v", webhook, err)
+				}
+			}
+		}
+
+		if once {
+			if len(report.Outdated) > 0 {
+				return &ExitError{Code: watchOutOfDate, Err: fmt.Errorf("repository is out of date: %d packages have newer versions", len(report.Outdated))}
+			}
+
+			return nil
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// checkUpstream compares the manifest with the newest versions in the upstream indexes
+// of the archives, pockets, components, architectures and snapshot of the repository's
+// last download, as far as the command line leaves them unset
+func (run *downloadRun) checkUpstream(cfg *config.Config, set map[string]bool) (*watchReport, error) {
+	mfest, err := manifest.Load(cfg.RepoPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target := *cfg
+
+	if err := replayDownloadOptions(&target, mfest, set); err != nil {
+		return nil, err
+	}
+
+	if err := run.checkDistribution(target.Distribution); err != nil {
+		return nil, err
+	}
+
+	run.archiveSnapshot = target.ArchiveSnapshot
+	pockets := target.Pockets
+
+	if len(pockets) == 0 {
+		pockets = run.distroPockets(target.Distribution)
+	}
+
+	if err := validatePockets(pockets); err != nil {
+		return nil, err
+	}
+
+	if target.Backports || len(target.BackportsPackages) > 0 {
+		pockets = appendMissing(pockets, []string{pocketBackports})
+	}
+
+	type candidate struct{ version, suite string }
+	newest := make(map[string]candidate)
+	mirrors, byMirror := run.architectureMirrors(&target)
+	components := run.sourceComponents(&target)
+
+	for _, mirrorURL := range mirrors {
+		for _, pocket := range pockets {
+			uri := mirrorURL
+
+			if pocket == pocketSecurity {
+				uri = run.securityMirror(target.Distribution, mirrorURL)
+			}
+
+			suite := run.pocketSuite(target.Distribution, pocket)
+
+			// A local mirror holds only the suites it was synced with
+			if !hasSuite(uri, suite) {
+				continue
+			}
+
+			mirror := run.newArchiveMirror(uri, cfg.RepoPath)
+			mirror.Logf = nil // Keep --json output parseable
+
+			if err := run.verifyMirrorRelease(&mirror, suite, cfg.RepoPath); err != nil {
+				return nil, err
+			}
+
+			for _, architecture := range byMirror[mirrorURL] {
+				for _, component := range components {
+					entries, err := mirror.FetchPackages(suite, component, architecture, false)
+
+					// Not every mirror carries every component
+					if errors.Is(err, remote.ErrNotFound) {
+						continue
+					}
+
+					if err != nil {
+						return nil, err
+					}
+
+					for _, entry := range entries {
+						key := entry["Package"] + ":" + entry["Architecture"]
+
+						if best, ok := newest[key]; !ok || debversion.Compare(entry["Version"], best.version) > 0 {
+							newest[key] = candidate{version: entry["Version"], suite: suite}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	report := &watchReport{CheckedAt: time.Now().UTC(), Distribution: mfest.Distribution}
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		best, ok := newest[pkg.Name+":"+pkg.Architecture]
+
+		if ok && debversion.Compare(best.version, pkg.Version) > 0 {
+			report.Outdated = append(report.Outdated, outdatedPackage{
+				Name:         pkg.Name,
+				Architecture: pkg.Architecture,
+				Current:      pkg.Version,
+				Available:    best.version,
+				Suite:        best.suite,
+			})
+		}
+	}
+
+	sort.Slice(report.Outdated, func(i, j int) bool { return report.Outdated[i].Name < report.Outdated[j].Name })
+
+	return report, nil
+}
+
+// outdatedKey identifies the outdated set, so unchanged results are not reported again
+func outdatedKey(report *watchReport) string {
+	var key strings.Builder
+
+	for _, pkg := range report.Outdated {
+		fmt.Fprintf(&key, "%s:%s=%s;", pkg.Name, pkg.Architecture, pkg.Available)
+	}
+
+	return key.String()
+}
+
+func printWatchReport(report *watchReport, jsonOutput bool) error {
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(report)
+	}
+
+	if len(report.Outdated) == 0 {
+		output.Success("Repository is up to date with %s", report.Distribution)
+
+		return nil
+	}
+
+	output.Warning("%d packages have newer versions upstream:", len(report.Outdated))
+
+	for _, pkg := range report.Outdated {
+		fmt.Printf("  %s (%s): %s -> %s [%s]\n", pkg.Name, pkg.Architecture, pkg.Current, pkg.Available, pkg.Suite)
+	}
+
+	return nil
+}
+
+// postReport sends the report as JSON to a webhook, giving up after remote.PostTimeout
+func postReport(url string, report *watchReport) error {
+	data, err := json.Marshal(report)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := remote.Post(url, "application/json", bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
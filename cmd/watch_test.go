@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+)
+
+// watchArchive serves an unsigned jammy release pocket with newer packages for two architectures
+func watchArchive() *httptest.Server {
+	files := map[string]string{
+		"/dists/jammy/Release":                    "Suite: jammy\n",
+		"/dists/jammy/main/binary-amd64/Packages": "Package: app\nArchitecture: amd64\nVersion: 1.1\n\nPackage: lib\nArchitecture: amd64\nVersion: 1.0\n",
+		"/dists/jammy/main/binary-arm64/Packages": "Package: tool\nArchitecture: arm64\nVersion: 2.0\n",
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Write([]byte(content))
+	}))
+}
+
+func TestCheckUpstream(t *testing.T) {
+	ts := watchArchive()
+	defer ts.Close()
+
+	repo := t.TempDir()
+
+	// Only the release pocket was downloaded, so the missing jammy-updates is never asked for
+	mfest := &manifest.Manifest{
+		Distribution: "jammy",
+		Architecture: "amd64",
+		Additional:   []string{"arm64"},
+		Options:      &manifest.DownloadOptions{Mirror: ts.URL, Pockets: []string{pocketRelease}, Components: []string{"main"}},
+		Packages: []packageinfo.PackageInfo{
+			{Name: "app", Version: "1.0", Architecture: "amd64", Downloaded: true},
+			{Name: "lib", Version: "1.0", Architecture: "amd64", Downloaded: true},
+			{Name: "tool", Version: "1.0", Architecture: "arm64", Downloaded: true},
+		},
+	}
+
+	if err := manifest.Save(repo, mfest); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{RepoPath: repo, AllowUnauthenticated: true}
+	run := newDownloadRun(&cfg)
+	run.applyTrustPolicy(&cfg)
+
+	report, err := run.checkUpstream(&cfg, map[string]bool{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Outdated) != 2 || report.Outdated[0].Name != "app" || report.Outdated[1].Name != "tool" || report.Outdated[1].Available != "2.0" {
+		t.Errorf("checkUpstream() outdated = %+v, want app and tool", report.Outdated)
+	}
+}
+
+func TestWatchExitStatus(t *testing.T) {
+	ts := watchArchive()
+	defer ts.Close()
+
+	repo := t.TempDir()
+	mfest := &manifest.Manifest{
+		Distribution: "jammy",
+		Architecture: "amd64",
+		Options:      &manifest.DownloadOptions{Mirror: ts.URL, Pockets: []string{pocketRelease}, Components: []string{"main"}},
+		Packages:     []packageinfo.PackageInfo{{Name: "app", Version: "1.0", Architecture: "amd64", Downloaded: true}},
+	}
+
+	if err := manifest.Save(repo, mfest); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"out of date", []string{"--repo", repo, "--allow-unauthenticated", "--once"}, watchOutOfDate},
+		{"unverified release", []string{"--repo", repo, "--once"}, watchCheckFailed},
+		{"no repository", []string{"--repo", t.TempDir(), "--once"}, watchCheckFailed},
+	}
+
+	for _, test := range tests {
+		var exit *ExitError
+
+		if err := RunWatchCommand(test.args); !errors.As(err, &exit) || exit.Code != test.want {
+			t.Errorf("%s: RunWatchCommand() error = %v, want exit status %d", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/manifest"
+)
+
+// webServerConfigs are configuration templates for serving the repository statically.
+// Every template keeps hidden working state (.apt, .lock, .git, ...) private.
+var webServerConfigs = map[string]string{
+	"nginx": `# Generated by portaptable: serve {{root}} statically with nginx
+server {
+    listen {{port}};
+    server_name _;
+    root {{root}};
+
+    location ~ /\. {
+        deny all;
+    }
+
+    location ~ \.(deb|udeb|ddeb)$ {
+        types { }
+        default_type application/vnd.debian.binary-package;
+    }
+
+    location = /setup-apt.sh {
+        types { }
+        default_type text/x-shellscript;
+    }
+
+    location / {
+        autoindex on;
+    }
+}
+`,
+	"apache": `# Generated by portaptable: serve {{root}} statically with Apache httpd
+Listen {{port}}
+
+<VirtualHost *:{{port}}>
+    DocumentRoot "{{root}}"
+
+    <Directory "{{root}}">
+        Options +Indexes -ExecCGI -Includes
+        AllowOverride None
+        Require all granted
+    </Directory>
+
+    <LocationMatch "/\.">
+        Require all denied
+    </LocationMatch>
+
+    AddType application/vnd.debian.binary-package .deb .udeb .ddeb
+    AddType text/x-shellscript .sh
+</VirtualHost>
+`,
+	"caddy": `# Generated by portaptable: serve {{root}} statically with Caddy
+:{{port}} {
+    root * {{root}}
+
+    @hidden path */.*
+    respond @hidden 403
+
+    @packages path *.deb *.udeb *.ddeb
+    header @packages Content-Type application/vnd.debian.binary-package
+
+    file_server browse
+}
+`,
+}
+
+// emitWebServerConfig prints a configuration for an existing web server that serves
+// the repository directory as it is, with the apt source line targets need
+func emitWebServerConfig(cfg *config.Config, kind string) error {
+	template, ok := webServerConfigs[kind]
+
+	if !ok {
+		return fmt.Errorf("unknown web server %q (expected nginx, apache or caddy)", kind)
+	}
+
+	root, err := filepath.Abs(cfg.RepoPath)
+
+	if err != nil {
+		return err
+	}
+
+	mfest, err := manifest.Load(root)
+
+	if err != nil {
+		return err
+	}
+
+	replacer := strings.NewReplacer("{{root}}", root, "{{port}}", cfg.Port)
+	fmt.Fprint(os.Stdout, replacer.Replace(template))
+
+	fmt.Printf("\n# Targets: sudo sh setup-apt.sh http://HOST:%s (from the repository), or\n", cfg.Port)
+	fmt.Printf("# deb [signed-by=/etc/apt/keyrings/portaptable-archive-keyring.gpg] http://HOST:%s %s main\n", cfg.Port, mfest.Distribution)
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"portaptable/pkg/config"
+	"portaptable/pkg/output"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/zsync"
+)
+
+// zsyncOptions are the export flags controlling .zsync generation
+type zsyncOptions struct {
+	enabled bool
+	url     string
+}
+
+// writeZsync creates BUNDLE.zsync, pointing clients at url or else at the bundle's
+// name next to the control file
+func writeZsync(bundlePath string, options *zsyncOptions) error {
+	bundleURL := options.url
+
+	if bundleURL == "" {
+		bundleURL = filepath.Base(bundlePath)
+	}
+
+	control, err := zsync.Create(bundlePath, bundleURL)
+
+	if err != nil {
+		return fmt.Errorf("failed to create zsync control file: %w", err)
+	}
+
+	controlPath := bundlePath + zsync.Extension
+
+	if err := control.Write(controlPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s (%d blocks of %s)\n", controlPath, len(control.Blocks), formatSize(int64(control.BlockSize)))
+
+	return nil
+}
+
+// RunFetchBundleCommand downloads a bundle described by a .zsync control file,
+// reusing every block an existing local bundle already has
+func RunFetchBundleCommand(args []string) error {
+	var cfg config.Config
+	var seeds stringList
+
+	fs := newFlagSet("fetch-bundle", &cfg)
+	fs.Var(&seeds, "seed", "Older bundle to reuse blocks from besides BUNDLE (repeatable)")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: fetch-bundle [OPTIONS] URL.zsync [BUNDLE]")
+	}
+
+	controlURL := fs.Arg(0)
+	body, err := remote.Get(controlURL)
+
+	if err != nil {
+		return err
+	}
+
+	control, err := zsync.Parse(body)
+	body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	bundleURL, err := resolveURL(controlURL, control.URL)
+
+	if err != nil {
+		return err
+	}
+
+	// The bundle is updated in place unless another file is named
+	target := filepath.Base(control.Filename)
+
+	if fs.NArg() == 2 {
+		target = fs.Arg(1)
+	}
+
+	var existing []string
+
+	for _, seed := range append([]string{target}, seeds...) {
+		if _, err := os.Stat(seed); err == nil {
+			existing = append(existing, seed)
+		}
+	}
+
+	if len(existing) == 0 {
+		output.Warning("No local bundle to reuse; fetching all of %s", bundleURL)
+	}
+
+	output.Info("Updating %s from %s...", target, bundleURL)
+
+	stats, err := control.Update(bundleURL, target, existing)
+
+	if err != nil {
+		return err
+	}
+
+	reused := 0.0
+
+	if control.Length > 0 {
+		reused = float64(stats.Reused) / float64(control.Length) * 100
+	}
+
+	output.Success("Wrote %s: reused %s (%.1f%%) from local data, fetched %s in %d requests",
+		target, formatSize(stats.Reused), reused, formatSize(stats.Fetched), stats.Ranges)
+
+	return nil
+}
+
+// resolveURL resolves ref, which may be relative, against base
+func resolveURL(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", base, err)
+	}
+
+	refURL, err := url.Parse(ref)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", ref, err)
+	}
+
+	return baseURL.ResolveReference(refURL).String(), nil
+}
@@ -0,0 +1,249 @@
+package advisory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/packageinfo"
+)
+
+// Supported dataset formats
+const (
+	FormatDebianTracker = "debian-tracker" // security-tracker.debian.org/tracker/data/json
+	FormatUSN           = "usn"            // Ubuntu usn-db/database.json
+)
+
+// Advisory states that a package is vulnerable in a release below FixedVersion
+type Advisory struct {
+	ID           string `json:"id"`
+	Package      string `json:"package"`
+	Binary       bool   `json:"binary"` // Package names a binary rather than a source package
+	Release      string `json:"release"`
+	FixedVersion string `json:"fixed_version,omitempty"` // Empty when no fix is available yet
+	Severity     string `json:"severity,omitempty"`
+	Summary      string `json:"summary,omitempty"`
+}
+
+// Finding pairs a repository package with an advisory affecting its version
+type Finding struct {
+	Package  string   `json:"package"`
+	Version  string   `json:"version"`
+	Advisory Advisory `json:"advisory"`
+}
+
+// Load reads an advisory dataset, detecting its format from the JSON structure
+func Load(path string) ([]Advisory, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisory data: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse advisory data %s: %w", path, err)
+	}
+
+	format, err := detectFormat(raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if format == FormatUSN {
+		return parseUSN(raw)
+	}
+
+	return parseDebianTracker(raw)
+}
+
+// detectFormat inspects the first entry: USN entries carry a "releases" object
+// directly, tracker entries are keyed by CVE below the source package name
+func detectFormat(raw map[string]json.RawMessage) (string, error) {
+	for _, entry := range raw {
+		var probe map[string]json.RawMessage
+
+		if err := json.Unmarshal(entry, &probe); err != nil {
+			continue
+		}
+
+		if _, ok := probe["releases"]; ok {
+			return FormatUSN, nil
+		}
+
+		return FormatDebianTracker, nil
+	}
+
+	return "", fmt.Errorf("empty or unrecognized advisory dataset")
+}
+
+type trackerIssue struct {
+	Description string `json:"description"`
+	Releases    map[string]struct {
+		Status       string `json:"status"`
+		FixedVersion string `json:"fixed_version"`
+		Urgency      string `json:"urgency"`
+	} `json:"releases"`
+}
+
+func parseDebianTracker(raw map[string]json.RawMessage) ([]Advisory, error) {
+	var advisories []Advisory
+
+	for source, entry := range raw {
+		var issues map[string]trackerIssue
+
+		if err := json.Unmarshal(entry, &issues); err != nil {
+			return nil, fmt.Errorf("invalid tracker entry for %s: %w", source, err)
+		}
+
+		for id, issue := range issues {
+			for release, state := range issue.Releases {
+				// A fixed version of "0" marks releases that were never affected
+				if state.FixedVersion == "0" {
+					continue
+				}
+
+				if state.Status != "open" && state.FixedVersion == "" {
+					continue
+				}
+
+				advisories = append(advisories, Advisory{
+					ID:           id,
+					Package:      source,
+					Release:      release,
+					FixedVersion: state.FixedVersion,
+					Severity:     state.Urgency,
+					Summary:      issue.Description,
+				})
+			}
+		}
+	}
+
+	return advisories, nil
+}
+
+type usnEntry struct {
+	ID       string   `json:"id"`
+	Title    string   `json:"title"`
+	CVEs     []string `json:"cves"`
+	Releases map[string]struct {
+		Sources  map[string]struct{ Version string } `json:"sources"`
+		Binaries map[string]struct{ Version string } `json:"binaries"`
+	} `json:"releases"`
+}
+
+func parseUSN(raw map[string]json.RawMessage) ([]Advisory, error) {
+	var advisories []Advisory
+
+	for key, entry := range raw {
+		var usn usnEntry
+
+		if err := json.Unmarshal(entry, &usn); err != nil {
+			return nil, fmt.Errorf("invalid USN entry %s: %w", key, err)
+		}
+
+		id := usn.ID
+
+		if id == "" {
+			id = key
+		}
+
+		if !strings.HasPrefix(id, "USN-") {
+			id = "USN-" + id
+		}
+
+		summary := usn.Title
+
+		if len(usn.CVEs) > 0 {
+			summary = fmt.Sprintf("%s (%s)", usn.Title, strings.Join(usn.CVEs, ", "))
+		}
+
+		for release, fixes := range usn.Releases {
+			// Binary versions are the most precise; fall back to sources when absent
+			for name, fix := range fixes.Binaries {
+				advisories = append(advisories, Advisory{
+					ID: id, Package: name, Binary: true, Release: release, FixedVersion: fix.Version, Summary: summary,
+				})
+			}
+
+			if len(fixes.Binaries) > 0 {
+				continue
+			}
+
+			for name, fix := range fixes.Sources {
+				advisories = append(advisories, Advisory{
+					ID: id, Package: name, Release: release, FixedVersion: fix.Version, Summary: summary,
+				})
+			}
+		}
+	}
+
+	return advisories, nil
+}
+
+// Audit returns the advisories for release that affect the given packages
+func Audit(advisories []Advisory, release string, packages []packageinfo.PackageInfo) []Finding {
+	bySource := make(map[string][]Advisory)
+	byBinary := make(map[string][]Advisory)
+
+	for _, adv := range advisories {
+		if adv.Release != release {
+			continue
+		}
+
+		if adv.Binary {
+			byBinary[adv.Package] = append(byBinary[adv.Package], adv)
+		} else {
+			bySource[adv.Package] = append(bySource[adv.Package], adv)
+		}
+	}
+
+	var findings []Finding
+
+	for _, pkg := range packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		source, sourceVersion := pkg.Source, pkg.SourceVersion
+
+		if source == "" {
+			source = pkg.Name
+		}
+
+		if sourceVersion == "" {
+			sourceVersion = pkg.Version
+		}
+
+		for _, adv := range byBinary[pkg.Name] {
+			if affected(pkg.Version, adv.FixedVersion) {
+				findings = append(findings, Finding{Package: pkg.Name, Version: pkg.Version, Advisory: adv})
+			}
+		}
+
+		for _, adv := range bySource[source] {
+			if affected(sourceVersion, adv.FixedVersion) {
+				findings = append(findings, Finding{Package: pkg.Name, Version: pkg.Version, Advisory: adv})
+			}
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Package != findings[j].Package {
+			return findings[i].Package < findings[j].Package
+		}
+
+		return findings[i].Advisory.ID < findings[j].Advisory.ID
+	})
+
+	return findings
+}
+
+func affected(version, fixedVersion string) bool {
+	return fixedVersion == "" || debversion.Compare(version, fixedVersion) < 0
+}
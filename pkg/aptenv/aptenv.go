@@ -0,0 +1,216 @@
+package aptenv
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Source is one deb line of the private apt configuration
+type Source struct {
+	URI        string
+	Suite      string
+	Components []string
+	SignedBy   string // Keyring path; empty falls back to the host's trusted keys
+	Trusted    bool   // Accept the source without a signature
+
+	// Architectures restricts the source to these architectures; empty serves them all
+	Architectures []string
+}
+
+// Line returns the one-line sources.list form of the source
+func (s Source) Line() string {
+	var options []string
+
+	if len(s.Architectures) > 0 {
+		options = append(options, "arch="+strings.Join(s.Architectures, ","))
+	}
+
+	if s.SignedBy != "" {
+		options = append(options, "signed-by="+s.SignedBy)
+	}
+
+	if s.Trusted {
+		options = append(options, "trusted=yes")
+	}
+
+	prefix := ""
+
+	if len(options) > 0 {
+		prefix = "[" + strings.Join(options, " ") + "] "
+	}
+
+	return fmt.Sprintf("deb %s%s %s %s", prefix, s.URI, s.Suite, strings.Join(s.Components, " "))
+}
+
+// Pin raises or lowers the priority of packages from a release, as in apt_preferences(5)
+type Pin struct {
+	Packages []string // Package names or "*"
+	Release  string   // Value for "Pin: release", e.g. "n=jammy-backports"
+	Priority int
+}
+
+// Env is a private apt configuration rooted in its own directory, so resolution and
+// downloads use exactly the configured sources instead of whatever the host has enabled
+type Env struct {
+	Root    string
+	options []string
+}
+
+// Create writes the sources and preferences of a private apt configuration below root
+func Create(root, architecture string, sources []Source, pins []Pin) (*Env, error) {
+	absRoot, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{
+		"etc/apt/sources.list.d", "etc/apt/preferences.d", "etc/apt/apt.conf.d", "etc/apt/auth.conf.d",
+		"var/lib/apt/lists/partial", "var/cache/apt/archives/partial", "var/lib/dpkg",
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(absRoot, dir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create apt directory: %w", err)
+		}
+	}
+
+	var sourcesList strings.Builder
+
+	for _, source := range sources {
+		sourcesList.WriteString(source.Line() + "\n")
+	}
+
+	if err := os.WriteFile(filepath.Join(absRoot, "etc/apt/sources.list"), []byte(sourcesList.String()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write sources.list: %w", err)
+	}
+
+	var preferences strings.Builder
+
+	for _, pin := range pins {
+		fmt.Fprintf(&preferences, "Package: %s\nPin: release %s\nPin-Priority: %d\n\n",
+			strings.Join(pin.Packages, " "), pin.Release, pin.Priority)
+	}
+
+	if err := os.WriteFile(filepath.Join(absRoot, "etc/apt/preferences"), []byte(preferences.String()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write preferences: %w", err)
+	}
+
+	// An empty status file makes apt treat the target as a bare system
+	statusPath := filepath.Join(absRoot, "var/lib/dpkg/status")
+
+	if _, err := os.Stat(statusPath); os.IsNotExist(err) {
+		if err := os.WriteFile(statusPath, nil, 0644); err != nil {
+			return nil, fmt.Errorf("failed to create dpkg status: %w", err)
+		}
+	}
+
+	env := &Env{Root: absRoot}
+	env.options = []string{
+		"Dir::Etc::SourceList=" + filepath.Join(absRoot, "etc/apt/sources.list"),
+		"Dir::Etc::SourceParts=" + filepath.Join(absRoot, "etc/apt/sources.list.d"),
+		"Dir::Etc::Preferences=" + filepath.Join(absRoot, "etc/apt/preferences"),
+		"Dir::Etc::PreferencesParts=" + filepath.Join(absRoot, "etc/apt/preferences.d"),
+		"Dir::Etc::Parts=" + filepath.Join(absRoot, "etc/apt/apt.conf.d"),
+		"Dir::Etc::netrcparts=" + filepath.Join(absRoot, "etc/apt/auth.conf.d"),
+		"Dir::State=" + filepath.Join(absRoot, "var/lib/apt"),
+		"Dir::State::status=" + statusPath,
+		"Dir::Cache=" + filepath.Join(absRoot, "var/cache/apt"),
+		"APT::Architecture=" + architecture,
+		"APT::Architectures::=" + architecture,
+		"Debug::NoLocking=1",
+	}
+
+	// Downloads run as the invoking user rather than apt's _apt sandbox user,
+	// which cannot write into the private directories
+	if current, err := user.Current(); err == nil {
+		env.options = append(env.options, "APT::Sandbox::User="+current.Username)
+	}
+
+	return env, nil
+}
+
+// AddArchitectures enables foreign architectures (multiarch) alongside the primary one
+func (e *Env) AddArchitectures(architectures ...string) {
+	for _, architecture := range architectures {
+		e.options = append(e.options, "APT::Architectures::="+architecture)
+	}
+}
+
+// WeakHashOptions make apt refuse Release files and packages that only MD5 or SHA1 vouch for
+var WeakHashOptions = []string{"APT::Hashes::MD5Sum::Untrusted=yes", "APT::Hashes::SHA1::Untrusted=yes"}
+
+// RequireSHA256 applies WeakHashOptions to every apt invocation
+func (e *Env) RequireSHA256() {
+	e.options = append(e.options, WeakHashOptions...)
+}
+
+// AllowExpired makes apt accept Release files past their Valid-Until, as a snapshot
+// of the archive at an earlier time has
+func (e *Env) AllowExpired() {
+	e.options = append(e.options, "Acquire::Check-Valid-Until=false")
+}
+
+// AddSources writes additional sources to sources.list.d/<name>.list
+func (e *Env) AddSources(name string, sources []Source) error {
+	var list strings.Builder
+
+	for _, source := range sources {
+		list.WriteString(source.Line() + "\n")
+	}
+
+	if err := os.WriteFile(filepath.Join(e.Root, "etc/apt/sources.list.d", name+".list"), []byte(list.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s sources: %w", name, err)
+	}
+
+	return nil
+}
+
+// AddPreferences writes apt_preferences(5) records to preferences.d/<name>.pref
+func (e *Env) AddPreferences(name, records string) error {
+	if err := os.WriteFile(filepath.Join(e.Root, "etc/apt/preferences.d", name+".pref"), []byte(records), 0644); err != nil {
+		return fmt.Errorf("failed to write %s preferences: %w", name, err)
+	}
+
+	return nil
+}
+
+// AddCredentials stores a login for machine (host and path prefix) in the private
+// auth.conf.d, e.g. a bearer token for an authenticated archive
+func (e *Env) AddCredentials(name, machine, login, password string) error {
+	entry := fmt.Sprintf("machine %s login %s password %s\n", machine, login, password)
+	path := filepath.Join(e.Root, "etc/apt/auth.conf.d", name+".conf")
+
+	// apt reads credentials only from files not readable by others
+	if err := os.WriteFile(path, []byte(entry), 0600); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+
+	return nil
+}
+
+// Command returns an apt tool invocation (apt-get, apt-cache) bound to the private configuration
+func (e *Env) Command(name string, args ...string) *exec.Cmd {
+	full := make([]string, 0, 2*len(e.options)+len(args))
+
+	for _, option := range e.options {
+		full = append(full, "-o", option)
+	}
+
+	return exec.Command(name, append(full, args...)...)
+}
+
+// Update downloads the package indexes of the configured sources
+func (e *Env) Update() error {
+	output, err := e.Command("apt-get", "update").CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("apt-get update failed: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
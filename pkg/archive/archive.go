@@ -0,0 +1,517 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/fetch"
+	"portaptable/pkg/remote"
+	"portaptable/pkg/signing"
+)
+
+// Mirror is a remote Debian-style archive such as http://archive.ubuntu.com/ubuntu
+type Mirror struct {
+	URL string
+
+	// IndexCache keeps the last fetched indexes, so refreshes can download only the
+	// pdiff patches since then; empty always fetches whole indexes
+	IndexCache string
+
+	// Logf reports how indexes were brought up to date; nil discards the messages
+	Logf func(format string, args ...interface{})
+
+	// Checksums holds the SHA256 of index files, relative to the archive root, from a
+	// Release whose signature was verified; when set, every fetched index must match
+	Checksums map[string]string
+
+	// Release holds the fields of that verified Release file, such as Origin and Suite
+	Release deb822.Paragraph
+
+	// RequireSHA256 refuses indexes without signed SHA256 Checksums and packages whose
+	// index entry carries only MD5 or SHA1
+	RequireSHA256 bool
+}
+
+// indexCompressions lists the Packages index variants tried in order
+var indexCompressions = []string{".gz", ".xz", ""}
+
+// PoolPrefix returns the pool directory prefix of a source package (e.g. "n", "libs")
+func PoolPrefix(source string) string {
+	if strings.HasPrefix(source, "lib") && len(source) > 3 {
+		return source[:4]
+	}
+
+	return source[:1]
+}
+
+// PoolDir returns the archive directory of a source package, e.g. pool/main/n/nginx
+func PoolDir(component, source string) string {
+	return path.Join("pool", component, PoolPrefix(source), source)
+}
+
+// StripEpoch removes the epoch, which never appears in archive file names
+func StripEpoch(version string) string {
+	if i := strings.Index(version, ":"); i >= 0 {
+		return version[i+1:]
+	}
+
+	return version
+}
+
+// PackagesPath returns the path of a Packages index relative to the archive root.
+// Installer indexes list the udebs used by debian-installer.
+func PackagesPath(dist, component, arch string, installer bool) string {
+	if installer {
+		return path.Join("dists", dist, component, "debian-installer", "binary-"+arch, "Packages")
+	}
+
+	return path.Join("dists", dist, component, "binary-"+arch, "Packages")
+}
+
+// TranslationPath returns the path of a component's Translation-<language> index,
+// which holds the long package descriptions keyed by Description-md5
+func TranslationPath(dist, component, language string) string {
+	return path.Join("dists", dist, component, "i18n", "Translation-"+language)
+}
+
+// SourcesPath returns the path of a component's Sources index relative to the archive root
+func SourcesPath(dist, component string) string {
+	return path.Join("dists", dist, component, "source", "Sources")
+}
+
+// FetchVerifiedRelease downloads a suite's InRelease, or Release with its detached
+// Release.gpg where the archive has no InRelease, checks its signature against keyrings
+// and returns its fields and the SHA256 of every file it lists, relative to the archive root
+func (m Mirror) FetchVerifiedRelease(dist string, keyrings []string) (deb822.Paragraph, map[string]string, error) {
+	releasePath := path.Join("dists", dist, "InRelease")
+	content, err := m.fetchInRelease(releasePath, keyrings)
+
+	if errors.Is(err, remote.ErrNotFound) {
+		releasePath = path.Join("dists", dist, "Release")
+		content, err = m.fetchDetachedRelease(releasePath, keyrings)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paragraphs, err := deb822.Parse(bytes.NewReader(content))
+
+	if err != nil || len(paragraphs) == 0 {
+		return nil, nil, fmt.Errorf("invalid %s: %v", releasePath, err)
+	}
+
+	checksums := make(map[string]string)
+
+	for _, line := range deb822.Lines(paragraphs[0]["SHA256"]) {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			checksums[path.Join("dists", dist, fields[2])] = fields[0]
+		}
+	}
+
+	if len(checksums) == 0 {
+		return nil, nil, fmt.Errorf("%s lists no SHA256 checksums", releasePath)
+	}
+
+	return paragraphs[0], checksums, nil
+}
+
+// fetchInRelease downloads a clearsigned InRelease and returns its verified content
+func (m Mirror) fetchInRelease(releasePath string, keyrings []string) ([]byte, error) {
+	signed, err := m.fetchFile(releasePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := signing.VerifyClearsigned(signed, keyrings)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.FileURL(releasePath), err)
+	}
+
+	return content, nil
+}
+
+// fetchDetachedRelease downloads a Release file and its Release.gpg signature and
+// returns the Release content once the signature verifies
+func (m Mirror) fetchDetachedRelease(releasePath string, keyrings []string) ([]byte, error) {
+	content, err := m.fetchFile(releasePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := m.fetchFile(releasePath + ".gpg")
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "portaptable-release-")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	releaseFile, signatureFile := filepath.Join(dir, "Release"), filepath.Join(dir, "Release.gpg")
+
+	if err := os.WriteFile(releaseFile, content, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(signatureFile, signature, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := signing.VerifyDetached(releaseFile, signatureFile, keyrings); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.FileURL(releasePath+".gpg"), err)
+	}
+
+	return content, nil
+}
+
+// fetchFile downloads one file of the archive into memory
+func (m Mirror) fetchFile(filePath string) ([]byte, error) {
+	body, err := remote.Get(m.FileURL(filePath))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", filePath, err)
+	}
+
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", filePath, err)
+	}
+
+	return data, nil
+}
+
+// checkIndex verifies uncompressed index data against the signed Checksums, if any.
+// Data whose compressed download was already verified needs no uncompressed entry.
+func (m Mirror) checkIndex(indexPath string, data []byte, verified bool) error {
+	if m.Checksums == nil && m.RequireSHA256 {
+		return fmt.Errorf("%s fails the SHA256 policy: no verified Release file lists its SHA256", m.FileURL(indexPath))
+	}
+
+	if m.Checksums == nil {
+		return nil
+	}
+
+	expected, ok := m.Checksums[indexPath]
+
+	if !ok && verified {
+		return nil
+	}
+
+	if !ok {
+		return fmt.Errorf("%s is not listed in the signed Release file", indexPath)
+	}
+
+	if actual := sha256Hex(data); actual != expected {
+		return fmt.Errorf("%s does not match the signed Release file: expected SHA256 %s, got %s", indexPath, expected, actual)
+	}
+
+	return nil
+}
+
+// FileURL returns the absolute URL of a path inside the archive
+func (m Mirror) FileURL(rel string) string {
+	return strings.TrimSuffix(m.URL, "/") + "/" + rel
+}
+
+// FetchPackages downloads and parses a Packages index, trying each compression the archive
+// may offer. With an IndexCache, a cached index is patched forward when pdiffs allow.
+func (m Mirror) FetchPackages(dist, component, arch string, installer bool) ([]deb822.Paragraph, error) {
+	indexPath := PackagesPath(dist, component, arch, installer)
+
+	data, err := m.fetchCachedIndex(indexPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deb822.Parse(bytes.NewReader(data))
+}
+
+// FetchTranslation downloads and parses a Translation-<language> index. The error
+// wraps remote.ErrNotFound when the archive has no translations for the language.
+func (m Mirror) FetchTranslation(dist, component, language string) ([]deb822.Paragraph, error) {
+	data, err := m.fetchCachedIndex(TranslationPath(dist, component, language))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deb822.Parse(bytes.NewReader(data))
+}
+
+// FetchSources downloads and parses a component's Sources index, which lists the
+// files of every source package. The error wraps remote.ErrNotFound when the archive
+// publishes no sources for the component.
+func (m Mirror) FetchSources(dist, component string) ([]deb822.Paragraph, error) {
+	data, err := m.fetchCachedIndex(SourcesPath(dist, component))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deb822.Parse(bytes.NewReader(data))
+}
+
+// fetchCachedIndex returns the uncompressed index, from patches to the cached copy
+// when possible and otherwise downloaded whole, refreshing the cache either way
+func (m Mirror) fetchCachedIndex(indexPath string) ([]byte, error) {
+	cachePath := ""
+
+	if m.IndexCache != "" {
+		cachePath = filepath.Join(m.IndexCache, strings.NewReplacer("://", "_", "/", "_").Replace(m.FileURL(indexPath)))
+
+		if cached, err := os.ReadFile(cachePath); err == nil {
+			data, patches, err := m.patchIndex(indexPath, cached)
+
+			if err == nil {
+				err = m.checkIndex(indexPath, data, false)
+			}
+
+			if err == nil {
+				if patches > 0 {
+					m.logf("Updated %s with %d pdiff patches", indexPath, patches)
+				}
+
+				return data, m.cacheIndex(cachePath, data, patches > 0)
+			}
+
+			m.logf("Fetching all of %s: %v", indexPath, err)
+		}
+	}
+
+	data, verified, err := m.fetchWholeIndex(indexPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.checkIndex(indexPath, data, verified); err != nil {
+		return nil, err
+	}
+
+	return data, m.cacheIndex(cachePath, data, true)
+}
+
+// cacheIndex stores a changed index in the cache
+func (m Mirror) cacheIndex(cachePath string, data []byte, changed bool) error {
+	if cachePath == "" || !changed {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return fmt.Errorf("failed to create index cache: %w", err)
+	}
+
+	partial := cachePath + ".partial"
+
+	if err := os.WriteFile(partial, data, 0644); err != nil {
+		return fmt.Errorf("failed to cache index: %w", err)
+	}
+
+	return os.Rename(partial, cachePath)
+}
+
+func (m Mirror) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// fetchWholeIndex downloads an index, trying each compression the archive may offer,
+// and reports whether the compressed file was checked against the signed Checksums
+func (m Mirror) fetchWholeIndex(indexPath string) ([]byte, bool, error) {
+	var lastErr error
+
+	for _, ext := range indexCompressions {
+		data, verified, err := m.fetchIndex(indexPath + ext)
+
+		if err == nil {
+			return data, verified, nil
+		}
+
+		lastErr = err
+
+		if !errors.Is(err, remote.ErrNotFound) {
+			break
+		}
+	}
+
+	return nil, false, fmt.Errorf("failed to fetch %s: %w", indexPath, lastErr)
+}
+
+// fetchIndex downloads and decompresses an index file. It reports whether the
+// download itself matched the signed Checksums, which may list only compressed files.
+func (m Mirror) fetchIndex(rel string) ([]byte, bool, error) {
+	body, err := remote.Get(m.FileURL(rel))
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer body.Close()
+
+	hash := sha256.New()
+	raw := io.TeeReader(body, hash)
+	reader, wait, err := decompress(raw, path.Ext(rel))
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := io.ReadAll(reader)
+
+	if waitErr := wait(); err == nil {
+		err = waitErr
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	expected, listed := m.Checksums[rel]
+
+	if !listed {
+		return data, false, nil
+	}
+
+	// Decompressors may stop short of trailing bytes
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return nil, false, err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return nil, false, fmt.Errorf("%s does not match the signed Release file: expected SHA256 %s, got %s", rel, expected, actual)
+	}
+
+	return data, true, nil
+}
+
+// decompress wraps r according to the file extension. The returned wait function
+// releases resources and reports decompression errors.
+func decompress(r io.Reader, ext string) (io.Reader, func() error, error) {
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decompress index: %w", err)
+		}
+
+		return gz, gz.Close, nil
+
+	case ".xz":
+		// The standard library has no xz support; use the system decompressor
+		cmd := exec.Command("xz", "--decompress", "--stdout")
+		cmd.Stdin = r
+		stdout, err := cmd.StdoutPipe()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := cmd.Start(); err != nil {
+			return nil, nil, fmt.Errorf("failed to run xz: %w", err)
+		}
+
+		return stdout, cmd.Wait, nil
+	}
+
+	return r, func() error { return nil }, nil
+}
+
+// DownloadPackage fetches the pool file of a Packages index entry into dir and
+// verifies it against the SHA256 published in the index
+func (m Mirror) DownloadPackage(entry deb822.Paragraph, dir string) (string, checksum.Sums, error) {
+	filename := entry["Filename"]
+
+	if filename == "" {
+		return "", checksum.Sums{}, fmt.Errorf("index entry for %s has no Filename", entry["Package"])
+	}
+
+	if m.RequireSHA256 && entry["SHA256"] == "" {
+		return "", checksum.Sums{}, fmt.Errorf("%s fails the SHA256 policy: its index entry has only MD5 or SHA1", m.FileURL(filename))
+	}
+
+	size, _ := strconv.ParseInt(entry["Size"], 10, 64)
+	target, err := fetch.Download(fetch.File{URL: m.FileURL(filename), Size: size, SHA256: entry["SHA256"]}, dir)
+
+	if err != nil {
+		return "", checksum.Sums{}, err
+	}
+
+	sums, err := checksum.File(target)
+
+	if err != nil {
+		return "", checksum.Sums{}, err
+	}
+
+	return path.Base(filename), sums, nil
+}
+
+// DownloadSource fetches the files of a Sources index entry (.dsc, .orig.tar.*,
+// .debian.tar.*) into dir, verifying each against its SHA256, and returns their names
+func (m Mirror) DownloadSource(entry deb822.Paragraph, dir string) ([]string, error) {
+	listing, digested := entry["Checksums-Sha256"], true
+
+	if listing == "" {
+		if m.RequireSHA256 {
+			return nil, fmt.Errorf("source %s fails the SHA256 policy: its index entry has only MD5 or SHA1", entry["Package"])
+		}
+
+		listing, digested = entry["Files"], false
+	}
+
+	var names []string
+
+	for _, line := range deb822.Lines(listing) {
+		fields := strings.Fields(line)
+
+		if len(fields) != 3 || strings.Contains(fields[2], "/") {
+			return nil, fmt.Errorf("invalid file list entry for source %s: %q", entry["Package"], line)
+		}
+
+		file := fetch.File{URL: m.FileURL(path.Join(entry["Directory"], fields[2]))}
+		file.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+
+		if digested {
+			file.SHA256 = fields[0]
+		}
+
+		if _, err := fetch.Download(file, dir); err != nil {
+			return nil, err
+		}
+
+		names = append(names, fields[2])
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("index entry for source %s lists no files", entry["Package"])
+	}
+
+	return names, nil
+}
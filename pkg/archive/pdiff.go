@@ -0,0 +1,245 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/deb822"
+	"portaptable/pkg/remote"
+)
+
+// errPdiffUnusable means the cached index cannot be brought up to date with patches
+var errPdiffUnusable = errors.New("no usable index patches")
+
+// pdiffEntry is a line of a Packages.diff/Index hash list: "<sha256> <size> <name>"
+type pdiffEntry struct {
+	hash string
+	name string
+}
+
+// patchIndex brings a cached index up to date with the ed-script patches published
+// in <index>.diff/ (pdiffs), as apt does, and returns the current index. Archives
+// with "X-Patch-Precedence: merged" publish one patch per old state straight to the
+// current one; others publish a chain applied in order.
+func (m Mirror) patchIndex(indexPath string, cached []byte) ([]byte, int, error) {
+	body, err := remote.Get(m.FileURL(indexPath + ".diff/Index"))
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errPdiffUnusable, err)
+	}
+
+	paragraphs, err := deb822.Parse(body)
+	body.Close()
+
+	if err != nil || len(paragraphs) == 0 {
+		return nil, 0, fmt.Errorf("%w: unreadable diff index", errPdiffUnusable)
+	}
+
+	index := paragraphs[0]
+	current := strings.Fields(index["SHA256-Current"])
+
+	if len(current) == 0 {
+		return nil, 0, fmt.Errorf("%w: diff index lacks SHA256-Current", errPdiffUnusable)
+	}
+
+	cachedHash := sha256Hex(cached)
+
+	if cachedHash == current[0] {
+		return cached, 0, nil
+	}
+
+	history := pdiffEntries(index["SHA256-History"])
+	patches := make(map[string]string)
+
+	for _, entry := range pdiffEntries(index["SHA256-Patches"]) {
+		patches[entry.name] = entry.hash
+	}
+
+	start := -1
+
+	for i, entry := range history {
+		if entry.hash == cachedHash {
+			start = i
+
+			break
+		}
+	}
+
+	if start < 0 {
+		return nil, 0, fmt.Errorf("%w: the cached index matches no state the patches start from", errPdiffUnusable)
+	}
+
+	chain := history[start:]
+
+	if index["X-Patch-Precedence"] == "merged" {
+		chain = chain[:1]
+	}
+
+	data := cached
+
+	for _, entry := range chain {
+		patch, err := m.fetchPatch(indexPath, entry.name, patches[entry.name])
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if data, err = applyEdScript(data, patch); err != nil {
+			return nil, 0, fmt.Errorf("failed to apply patch %s: %w", entry.name, err)
+		}
+	}
+
+	if sha256Hex(data) != current[0] {
+		return nil, 0, fmt.Errorf("%w: patched index does not match SHA256-Current", errPdiffUnusable)
+	}
+
+	return data, len(chain), nil
+}
+
+// fetchPatch downloads and decompresses a patch, verifying its SHA256-Patches hash
+func (m Mirror) fetchPatch(indexPath, name, expected string) ([]byte, error) {
+	body, err := remote.Get(m.FileURL(indexPath + ".diff/" + name + ".gz"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress patch %s: %w", name, err)
+	}
+
+	defer gz.Close()
+
+	patch, err := io.ReadAll(gz)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch %s: %w", name, err)
+	}
+
+	if expected == "" || sha256Hex(patch) != expected {
+		return nil, fmt.Errorf("%w: checksum mismatch for patch %s", errPdiffUnusable, name)
+	}
+
+	return patch, nil
+}
+
+// pdiffEntries parses a Packages.diff/Index hash list
+func pdiffEntries(value string) []pdiffEntry {
+	var entries []pdiffEntry
+
+	for _, line := range deb822.Lines(value) {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			entries = append(entries, pdiffEntry{hash: fields[0], name: fields[2]})
+		}
+	}
+
+	return entries
+}
+
+// applyEdScript applies a "diff --ed" script: a, c and d commands in descending
+// line order, each a or c followed by its text and a "." line
+func applyEdScript(data []byte, script []byte) ([]byte, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(script))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		command := scanner.Text()
+
+		if command == "" {
+			continue
+		}
+
+		action := command[len(command)-1]
+		first, last, err := edRange(command[:len(command)-1], len(lines))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if action != 'a' && first < 1 {
+			return nil, fmt.Errorf("ed command address %q outside the file", command)
+		}
+
+		var text []string
+
+		if action == 'a' || action == 'c' {
+			for scanner.Scan() && scanner.Text() != "." {
+				text = append(text, scanner.Text()+"\n")
+			}
+		}
+
+		switch action {
+		case 'a':
+			lines = splice(lines, first, first, text) // Append after line first
+		case 'c':
+			lines = splice(lines, first-1, last, text)
+		case 'd':
+			lines = splice(lines, first-1, last, nil)
+		default:
+			return nil, fmt.Errorf("unsupported ed command %q", command)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// edRange parses "N" or "N,M" into 1-based line numbers within a file of count lines
+func edRange(spec string, count int) (int, int, error) {
+	firstText, lastText, isRange := strings.Cut(spec, ",")
+	first, err := strconv.Atoi(firstText)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid ed command address %q", spec)
+	}
+
+	last := first
+
+	if isRange {
+		if last, err = strconv.Atoi(lastText); err != nil {
+			return 0, 0, fmt.Errorf("invalid ed command address %q", spec)
+		}
+	}
+
+	if first < 0 || last < first || last > count {
+		return 0, 0, fmt.Errorf("ed command address %q outside the file", spec)
+	}
+
+	return first, last, nil
+}
+
+// splice replaces lines[from:to] with text
+func splice(lines []string, from, to int, text []string) []string {
+	result := make([]string, 0, len(lines)-(to-from)+len(text))
+	result = append(result, lines[:from]...)
+	result = append(result, text...)
+
+	return append(result, lines[to:]...)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
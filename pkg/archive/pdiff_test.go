@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyEdScript(t *testing.T) {
+	original := "one\ntwo\nthree\nfour\nfive\n"
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"empty", "", original},
+		{"delete", "2,3d\n", "one\nfour\nfive\n"},
+		{"change", "4c\nFOUR\n4b\n.\n", "one\ntwo\nthree\nFOUR\n4b\nfive\n"},
+		{"append", "5a\nsix\n.\n0a\nzero\n.\n", "zero\none\ntwo\nthree\nfour\nfive\nsix\n"},
+		{"descending", "5d\n3c\nTHREE\n.\n1a\none and a half\n.\n", "one\none and a half\ntwo\nTHREE\nfour\n"},
+	}
+
+	for _, test := range tests {
+		got, err := applyEdScript([]byte(original), []byte(test.script))
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+
+			continue
+		}
+
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestApplyEdScriptErrors(t *testing.T) {
+	for _, script := range []string{"7d\n", "0d\n", "3,2d\n", "xd\n", "2p\n"} {
+		if _, err := applyEdScript([]byte("one\ntwo\nthree\n"), []byte(script)); err == nil {
+			t.Errorf("applyEdScript(%q) succeeded, want an error", script)
+		}
+	}
+}
+
+// pdiffStates are successive versions of an index with the ed scripts between them
+var pdiffStates = []string{
+	"Package: a\nVersion: 1\n\nPackage: b\nVersion: 1\n",
+	"Package: a\nVersion: 2\n\nPackage: b\nVersion: 1\n",
+	"Package: a\nVersion: 2\n\nPackage: b\nVersion: 1\n\nPackage: c\nVersion: 1\n",
+}
+
+var pdiffScripts = []string{
+	"2c\nVersion: 2\n.\n",
+	"5a\n\nPackage: c\nVersion: 1\n.\n",
+}
+
+// writePdiffMirror publishes the patches between pdiffStates on a test server and
+// returns it as a mirror
+func writePdiffMirror(t *testing.T, merged bool) Mirror {
+	t.Helper()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "dists/test/main/binary-amd64/Packages.diff")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	scripts := pdiffScripts
+
+	if merged {
+		// Merged patches each lead straight to the current state
+		scripts = []string{"2c\nVersion: 2\n.\n5a\n\nPackage: c\nVersion: 1\n.\n", pdiffScripts[1]}
+	}
+
+	var history, patches strings.Builder
+
+	for i, script := range scripts {
+		name := fmt.Sprintf("T-%d", i)
+		fmt.Fprintf(&history, "\n %s %d %s", sha256Hex([]byte(pdiffStates[i])), len(pdiffStates[i]), name)
+		fmt.Fprintf(&patches, "\n %s %d %s", sha256Hex([]byte(script)), len(script), name)
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write([]byte(script))
+		gz.Close()
+
+		if err := os.WriteFile(filepath.Join(dir, name+".gz"), compressed.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current := pdiffStates[len(pdiffStates)-1]
+	index := fmt.Sprintf("SHA256-Current: %s %d\nSHA256-History:%s\nSHA256-Patches:%s\n", sha256Hex([]byte(current)), len(current), history.String(), patches.String())
+
+	if merged {
+		index += "X-Patch-Precedence: merged\n"
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "Index"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	t.Cleanup(server.Close)
+
+	return Mirror{URL: server.URL}
+}
+
+func TestPatchIndex(t *testing.T) {
+	const indexPath = "dists/test/main/binary-amd64/Packages"
+	current := pdiffStates[len(pdiffStates)-1]
+
+	tests := []struct {
+		name    string
+		merged  bool
+		cached  string
+		patches int
+	}{
+		{"chain from the oldest state", false, pdiffStates[0], 2},
+		{"chain from a later state", false, pdiffStates[1], 1},
+		{"already current", false, current, 0},
+		{"merged patch", true, pdiffStates[0], 1},
+	}
+
+	for _, test := range tests {
+		mirror := writePdiffMirror(t, test.merged)
+		data, applied, err := mirror.patchIndex(indexPath, []byte(test.cached))
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+
+			continue
+		}
+
+		if string(data) != current || applied != test.patches {
+			t.Errorf("%s: got %q after %d patches, want %q after %d", test.name, data, applied, current, test.patches)
+		}
+	}
+}
+
+func TestPatchIndexUnusable(t *testing.T) {
+	const indexPath = "dists/test/main/binary-amd64/Packages"
+	mirror := writePdiffMirror(t, false)
+
+	if _, _, err := mirror.patchIndex(indexPath, []byte("Package: unknown\n")); !errors.Is(err, errPdiffUnusable) {
+		t.Errorf("patching an unknown state: got %v, want errPdiffUnusable", err)
+	}
+
+	if _, _, err := mirror.patchIndex("dists/other/main/binary-amd64/Packages", []byte(pdiffStates[0])); !errors.Is(err, errPdiffUnusable) {
+		t.Errorf("patching without a diff index: got %v, want errPdiffUnusable", err)
+	}
+}
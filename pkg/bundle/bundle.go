@@ -0,0 +1,309 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options adjust how CreateWith writes a bundle
+type Options struct {
+	// Compression is CompressionGzip (the default when empty), CompressionZstd or
+	// CompressionNone
+	Compression string
+
+	// Rsyncable compresses every file as its own gzip member (or zstd in rsyncable mode).
+	// An unchanged file then compresses to the same bytes in every bundle, which lets
+	// block-matching transfers such as zsync reuse it from an older bundle.
+	Rsyncable bool
+
+	// Exclude leaves out the entries it returns true for (rel uses slashes); a
+	// directory is left out with everything below it
+	Exclude func(rel string, info os.FileInfo) bool
+
+	// Files are extra entries, by slash-separated name, written ahead of the repository
+	Files map[string][]byte
+}
+
+// Create writes a gzip-compressed tarball of every file below repoPath to output.
+// Entries are stored relative to repoPath so bundles extract into any directory.
+func Create(repoPath, output string) error {
+	return CreateWith(repoPath, output, Options{})
+}
+
+// CreateWith writes a bundle like Create, adjusted by options
+func CreateWith(repoPath, output string, options Options) error {
+	file, err := os.Create(output)
+
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	defer file.Close()
+
+	var gz *gzip.Writer
+	var compressed io.WriteCloser
+
+	switch options.Compression {
+	case "", CompressionGzip:
+		gz = gzip.NewWriter(file)
+		compressed = gz
+
+	case CompressionZstd:
+		if compressed, err = newZstdWriter(file, options.Rsyncable); err != nil {
+			return fmt.Errorf("failed to create bundle: %w", err)
+		}
+
+	case CompressionNone:
+		compressed = nopCloser{file}
+
+	default:
+		return fmt.Errorf("unknown bundle compression %q", options.Compression)
+	}
+
+	tw := tar.NewWriter(compressed)
+
+	absOutput, _ := filepath.Abs(output)
+
+	for _, name := range sortedNames(options.Files) {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(options.Files[name])), ModTime: time.Now()}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+
+		if _, err := tw.Write(options.Files[name]); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+	}
+
+	err = filepath.Walk(repoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(repoPath, path)
+
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// Hidden files hold working state (e.g. the private apt configuration), not repository content
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// Never include the bundle itself when it is written inside the repository
+		if absPath, _ := filepath.Abs(path); absPath == absOutput {
+			return nil
+		}
+
+		if options.Exclude != nil && options.Exclude(filepath.ToSlash(rel), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+
+		// Windows reports every file as world-writable and none as executable;
+		// targets get the modes an export on a Unix host would give them
+		header.Mode &^= 0022
+
+		if runtime.GOOS == "windows" && strings.HasSuffix(header.Name, ".sh") {
+			header.Mode |= 0111
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		defer src.Close()
+
+		if _, err := io.Copy(tw, src); err != nil || !options.Rsyncable || gz == nil {
+			return err
+		}
+
+		// Gzip readers read concatenated members as one stream
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if err := gz.Close(); err != nil {
+			return err
+		}
+
+		gz.Reset(file)
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to archive repository: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+
+	if err := compressed.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+
+	return file.Close()
+}
+
+// nopCloser leaves closing an uncompressed bundle's file to CreateWith
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ReadFile returns the content of the bundle entry name, or an error wrapping
+// os.ErrNotExist when the bundle has no such entry
+func ReadFile(input, name string) ([]byte, error) {
+	stream, closeStream, err := open(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeStream()
+
+	tr := tar.NewReader(stream)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			return nil, fmt.Errorf("bundle has no %s: %w", name, os.ErrNotExist)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		if header.Name == name && header.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// Extract unpacks the bundle at input into repoPath, whichever compression it was written with
+func Extract(input, repoPath string) error {
+	stream, closeStream, err := open(input)
+
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(stream)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			closeStream()
+
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		if err := extractEntry(tr, header, repoPath); err != nil {
+			closeStream()
+
+			return err
+		}
+	}
+
+	return closeStream()
+}
+
+// extractEntry writes one bundle entry below repoPath
+func extractEntry(tr *tar.Reader, header *tar.Header, repoPath string) error {
+	target := filepath.Join(repoPath, filepath.FromSlash(header.Name))
+
+	// Security check - refuse entries escaping the repository directory
+	if rel, err := filepath.Rel(repoPath, target); err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("bundle entry outside repository: %s", header.Name)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0755)
+
+	case tar.TypeReg:
+		if err := extractFile(tr, target, os.FileMode(header.Mode)); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+
+		return err
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,149 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Compressions a bundle can be written with
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// Extension returns the file name extension of bundles written with compression
+func Extension(compression string) string {
+	switch compression {
+	case CompressionZstd:
+		return "tar.zst"
+	case CompressionNone:
+		return "tar"
+	}
+
+	return "tar.gz"
+}
+
+// ZstdAvailable reports whether the zstd program needed for zstd bundles is installed
+func ZstdAvailable() bool {
+	_, err := exec.LookPath("zstd")
+
+	return err == nil
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// zstdWriter streams a tarball through zstd, which the standard library lacks
+type zstdWriter struct {
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+// newZstdWriter compresses into file on every core; rsyncable output lets block-matching
+// transfers reuse the unchanged parts of an older bundle
+func newZstdWriter(file *os.File, rsyncable bool) (*zstdWriter, error) {
+	args := []string{"--quiet", "--threads=0", "--stdout"}
+
+	if rsyncable {
+		args = append(args, "--rsyncable")
+	}
+
+	cmd := exec.Command("zstd", args...)
+	cmd.Stdout = file
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run zstd: %w", err)
+	}
+
+	return &zstdWriter{stdin: stdin, cmd: cmd}, nil
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	return z.stdin.Write(p)
+}
+
+func (z *zstdWriter) Close() error {
+	err := z.stdin.Close()
+
+	if waitErr := z.cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("zstd failed: %w", waitErr)
+	}
+
+	return err
+}
+
+// open returns the tarball stream of the bundle at input, whatever its compression.
+// The close function reports decompression errors once the stream was read to the end.
+func open(input string) (io.Reader, func() error, error) {
+	file, err := os.Open(input)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+
+		if err != nil {
+			file.Close()
+
+			return nil, nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		return gz, func() error {
+			gz.Close()
+
+			return file.Close()
+		}, nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		cmd := exec.Command("zstd", "--decompress", "--quiet", "--stdout")
+		cmd.Stdin = buffered
+		stdout, err := cmd.StdoutPipe()
+
+		if err != nil {
+			file.Close()
+
+			return nil, nil, err
+		}
+
+		if err := cmd.Start(); err != nil {
+			file.Close()
+
+			return nil, nil, fmt.Errorf("failed to run zstd, which zstd bundles need: %w", err)
+		}
+
+		return stdout, func() error {
+			// Drain the pipe so zstd can exit when the reader stopped early
+			io.Copy(io.Discard, stdout)
+			err := cmd.Wait()
+			file.Close()
+
+			if err != nil {
+				return fmt.Errorf("failed to decompress bundle: %w", err)
+			}
+
+			return nil
+		}, nil
+	}
+
+	return buffered, file.Close, nil
+}
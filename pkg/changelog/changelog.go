@@ -0,0 +1,31 @@
+package changelog
+
+import (
+	"path"
+	"path/filepath"
+
+	"portaptable/pkg/archive"
+)
+
+// Dir is the repository directory holding downloaded changelogs
+const Dir = "changelogs"
+
+// ChangePath returns apt's @CHANGEPATH@ for a source package: component/prefix/src/src_version
+func ChangePath(component, source, version string) string {
+	return path.Join(component, archive.PoolPrefix(source), source, source+"_"+archive.StripEpoch(version))
+}
+
+// UpstreamURL returns the changelog URL published by the distribution vendor
+func UpstreamURL(vendor, component, source, version string) string {
+	if vendor == "debian" {
+		return "https://metadata.ftp-master.debian.org/changelogs/" + ChangePath(component, source, version) + "_changelog"
+	}
+
+	return "https://changelogs.ubuntu.com/changelogs/pool/" + ChangePath(component, source, version) + "/changelog"
+}
+
+// LocalPath returns where a changelog is stored in the repository, laid out so the
+// server can answer apt's @CHANGEPATH@_changelog requests directly
+func LocalPath(repoPath, component, source, version string) string {
+	return filepath.Join(repoPath, Dir, filepath.FromSlash(ChangePath(component, source, version))+"_changelog")
+}
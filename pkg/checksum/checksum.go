@@ -0,0 +1,65 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Sums holds the digests apt expects for a file
+type Sums struct {
+	Size   int64
+	MD5    string
+	SHA1   string
+	SHA256 string
+
+	// ModTime is the file's modification time when it was hashed; zero for readers
+	ModTime time.Time
+}
+
+// File computes the size and digests of the file at path
+func File(path string) (Sums, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return Sums{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return Sums{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	sums, err := Reader(file)
+	sums.ModTime = info.ModTime()
+
+	return sums, err
+}
+
+// Reader computes the size and digests of everything read from r
+func Reader(r io.Reader) (Sums, error) {
+	md5Hash := md5.New()
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), r)
+
+	if err != nil {
+		return Sums{}, fmt.Errorf("failed to hash data: %w", err)
+	}
+
+	return Sums{
+		Size:   size,
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
@@ -1,5 +1,12 @@
 package config
 
+import "time"
+
+const (
+	DefaultRepoPath = "./repository"
+	DefaultPort     = "8080"
+)
+
 // Config holds the application configuration
 type Config struct {
 	RepoPath     string
@@ -8,4 +15,190 @@ type Config struct {
 	ConfigFile   string
 	Architecture string
 	Distribution string
+	KeyringHome  string
+	SigningKey   string
+	PINFile      string
+
+	// Snapshot selects the snapshot serve mode publishes; empty serves the current state
+	Snapshot string
+
+	// ArchiveSnapshot takes the vendor archives from their snapshot service at this time
+	// (download mode's --snapshot); zero uses the current archives
+	ArchiveSnapshot time.Time
+
+	// Components limits packages to these archive components (e.g. main); empty allows all
+	Components []string
+
+	// DeniedLicenses rejects packages whose copyright declares a matching license (globs)
+	DeniedLicenses []string
+
+	// StrictLicenses also rejects, under DeniedLicenses, packages whose licenses cannot
+	// be determined, such as those with a free-form copyright file
+	StrictLicenses bool
+
+	// MinimalRules drop matching packages from the resolved set: doc, transitional, locale
+	MinimalRules []string
+
+	// MinimalKeep exempts packages (globs) from the minimal rules
+	MinimalKeep []string
+
+	// PackageRegexes request every package whose name matches one (--download-regex)
+	PackageRegexes []string
+
+	// Sections and Priorities request every package in one of the sections (e.g.
+	// editors) with one of the priorities (e.g. required); an empty list allows all
+	Sections   []string
+	Priorities []string
+
+	// BaseSystem requests the Essential and required packages and apt, which
+	// debootstrap's minbase variant installs on a new machine
+	BaseSystem bool
+
+	// PreferredProviders are chosen, in order, to provide a virtual package that
+	// several packages provide
+	PreferredProviders []string
+
+	// Alternatives map a name offered among a dependency's alternatives to the package
+	// satisfying it instead of the first alternative (--alternative default-mta=postfix)
+	Alternatives map[string]string
+
+	// PreferencesFile holds apt_preferences(5) pins deciding which versions resolve,
+	// in place of the host's /etc/apt/preferences and preferences.d
+	PreferencesFile string
+
+	// AllowConflicts downloads resolved packages that Conflict with or Break each other
+	// with a warning instead of failing
+	AllowConflicts bool
+
+	// Exclude drops matching packages (globs or ^regex$) from the resolved set
+	Exclude []string
+
+	// StatusFile is the target's dpkg status; packages it has installed are not downloaded
+	StatusFile string
+
+	// FilterPlugins are resolver filter plugins consulted, in order, before downloading
+	FilterPlugins []string
+
+	// TenantsFile lists the repositories a multi-tenant server mounts below /NAME/,
+	// each readable only with its own tokens or credentials
+	TenantsFile string
+
+	// VerifyPool makes serve mode check each pool file against the manifest's SHA256
+	// before serving it, answering 503 instead of handing out a corrupted file
+	VerifyPool bool
+
+	// ConnectionRate caps the throughput of each connection serve mode accepts, in bytes
+	// per second; 0 is unlimited
+	ConnectionRate int64
+
+	// EmitConfig makes serve mode print a nginx, apache or caddy configuration instead of serving
+	EmitConfig string
+
+	// Languages selects the language packs and application translations to include,
+	// as languages (de) or locales (pt_BR.UTF-8)
+	Languages []string
+
+	// ForeignArchitectures are multiarch architectures (e.g. i386 on amd64) whose
+	// packages may be requested or depended on as name:arch
+	ForeignArchitectures []string
+
+	// AdditionalArchitectures follow Architecture in --arch amd64,arm64: each is resolved
+	// on its own, as for a target of that architecture, into the same pool
+	AdditionalArchitectures []string
+
+	// Resolver is apt or native: who resolves dependencies and downloads packages.
+	// Empty uses apt where it is installed and the native resolver elsewhere.
+	Resolver string
+
+	// GraphFile receives the resolved dependency graph: Graphviz DOT, or JSON for .json
+	GraphFile string
+
+	// DryRun stops download mode after resolution, printing what would be downloaded
+	DryRun bool
+
+	// ArchiveKeyrings verify the Release files the native resolver fetches, in place of
+	// the host's Signed-By and trusted keys; AllowUnauthenticated proceeds without a
+	// verified Release file instead of failing
+	ArchiveKeyrings      []string
+	AllowUnauthenticated bool
+
+	// Fresh replaces the repository's packages with this run's instead of merging into them
+	Fresh bool
+
+	// Concurrency is how many packages download mode fetches at once
+	Concurrency int
+
+	// LimitRate caps the combined throughput of package downloads in bytes per second; 0 is unlimited
+	LimitRate int64
+
+	// Retries is how often a failed package download is retried, waiting RetryDelay
+	// before the first retry and twice as long before each further one
+	Retries    int
+	RetryDelay time.Duration
+
+	// Mirror replaces the vendor archive the download resolves from; empty uses the
+	// host's mirror for the distribution, else the Debian or Ubuntu default
+	Mirror string
+
+	// Preset selects built-in sources (e.g. raspios-bookworm-armhf) in place of the vendor archive
+	Preset string
+
+	// Pockets selects the archive pockets consulted (release, updates, security,
+	// proposed, backports) instead of the host's sources
+	Pockets []string
+
+	// ESMTokenFile holds the Ubuntu Pro ESM bearer token; setting it adds the ESM archives
+	ESMTokenFile string
+
+	// ESMServices selects the ESM archives to mirror: infra, apps (default both)
+	ESMServices []string
+
+	// Backports adds <dist>-backports as a source, pinned below the base suite
+	Backports bool
+
+	// BackportsPackages are taken from backports deliberately; they imply Backports
+	BackportsPackages []string
+
+	// DevPackages adds the -dev companion of every requested library package
+	DevPackages bool
+
+	// InstallerPackages enables mirroring the debian-installer udebs
+	InstallerPackages bool
+
+	// DebugSymbols enables downloading matching -dbgsym packages
+	DebugSymbols bool
+
+	// Sources enables downloading the source packages of downloaded packages
+	Sources bool
+
+	// Changelogs enables fetching upstream changelogs for downloaded packages
+	Changelogs bool
+
+	// Cosign keys for signing export bundles and verifying them on import
+	CosignKey       string
+	CosignPublicKey string
+
+	// GitHistory starts a git history of the manifest and indexes in the repository;
+	// once started, every operation commits to it
+	GitHistory bool
+
+	// MaxPackageSize and MaxTotalSize limit the resolved download in bytes; 0 disables a limit
+	MaxPackageSize int64
+	MaxTotalSize   int64
+
+	// SizeLimitAction is fail or skip: what happens to packages over the size limits
+	SizeLimitAction string
+
+	// MaxSize caps the pool size in bytes after refresh and mirror runs; 0 disables the quota
+	MaxSize int64
+
+	// Eviction lists the eviction policies applied over quota: superseded, lru
+	Eviction string
+
+	// DebSignatures selects embedded .deb signature verification: off, record or require
+	DebSignatures string
+
+	// RequireSHA256 refuses upstream files and generates metadata not covered by SHA256,
+	// rather than trusting MD5 or SHA1 alone; once set, the repository keeps the policy
+	RequireSHA256 bool
 }
@@ -0,0 +1,108 @@
+package contents
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/debfile"
+	"portaptable/pkg/packageinfo"
+)
+
+// CacheDir is the repository subdirectory keeping the file list of each pool file,
+// which never changes once downloaded
+const CacheDir = ".cache/contents"
+
+// Files returns the paths a package installs, without the leading "/". The list is
+// read from the pool file once and then kept in the cache.
+func Files(repoPath string, pkg packageinfo.PackageInfo) ([]string, error) {
+	cachePath := filepath.Join(repoPath, filepath.FromSlash(CacheDir), pkg.Filename+".list")
+
+	if data, err := os.ReadFile(cachePath); err == nil {
+		if len(data) == 0 {
+			return nil, nil
+		}
+
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+	}
+
+	files, err := debfile.Files(filepath.Join(repoPath, "pool", pkg.Filename))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create contents cache: %w", err)
+	}
+
+	var list strings.Builder
+
+	for _, file := range files {
+		list.WriteString(file + "\n")
+	}
+
+	if err := os.WriteFile(cachePath, []byte(list.String()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to cache contents: %w", err)
+	}
+
+	return files, nil
+}
+
+// Match reports whether an installed path (without the leading "/") matches pattern:
+// a glob against the absolute path when it has wildcards, else the absolute path
+// itself, or the file name when pattern has no "/"
+func Match(pattern, file string) bool {
+	file = "/" + file
+
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, file)
+
+		return matched
+	}
+
+	if !strings.Contains(pattern, "/") {
+		return path.Base(file) == pattern
+	}
+
+	return path.Clean("/"+pattern) == file
+}
+
+// Qualified returns the name a Contents index lists a package under: section/name,
+// as apt-file expects
+func Qualified(pkg packageinfo.PackageInfo) string {
+	section := pkg.Control["Section"]
+
+	if section == "" {
+		section = "unknown"
+	}
+
+	return section + "/" + pkg.Name
+}
+
+// Write writes a Contents index (as published in dists/<dist>/<component>/Contents-<arch>)
+// mapping each path to the comma-separated packages shipping it
+func Write(w io.Writer, owners map[string][]string) error {
+	files := make([]string, 0, len(owners))
+
+	for file := range owners {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+	bw := bufio.NewWriter(w)
+
+	for _, file := range files {
+		packages := owners[file]
+		sort.Strings(packages)
+
+		fmt.Fprintf(bw, "%-55s %s\n", file, strings.Join(packages, ","))
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,49 @@
+package cosign
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// BundleMediaType is the OCI media type used when pushing export bundles
+const BundleMediaType = "application/vnd.portaptable.bundle.v1.tar+gzip"
+
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w, output: %s", name, args[0], err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// SignatureFile returns the path of the cosign signature written next to a blob
+func SignatureFile(path string) string {
+	return path + ".sig"
+}
+
+// SignBlob signs the file at path with a cosign private key, writing path.sig.
+// Signatures are never uploaded to a transparency log since bundles stay offline.
+// The key password is read by cosign from COSIGN_PASSWORD.
+func SignBlob(keyPath, path string) error {
+	return run("cosign", "sign-blob", "--yes", "--key", keyPath, "--tlog-upload=false",
+		"--output-signature", SignatureFile(path), path)
+}
+
+// VerifyBlob checks path.sig against the file at path using a cosign public key
+func VerifyBlob(publicKeyPath, path string) error {
+	return run("cosign", "verify-blob", "--key", publicKeyPath, "--insecure-ignore-tlog=true",
+		"--signature", SignatureFile(path), path)
+}
+
+// PushAndSign uploads the bundle at path to an OCI registry as an artifact and
+// signs the pushed reference with a cosign private key
+func PushAndSign(keyPath, ref, path string) error {
+	if err := run("oras", "push", ref, path+":"+BundleMediaType); err != nil {
+		return err
+	}
+
+	return run("cosign", "sign", "--yes", "--key", keyPath, "--tlog-upload=false", ref)
+}
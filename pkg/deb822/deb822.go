@@ -0,0 +1,89 @@
+package deb822
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Paragraph is a single stanza of a control file. Continuation lines are
+// joined to their field value with newlines.
+type Paragraph map[string]string
+
+// Parse reads every paragraph from r. Comment lines starting with '#' are ignored.
+func Parse(r io.Reader) ([]Paragraph, error) {
+	var paragraphs []Paragraph
+	var current Paragraph
+	var lastField string
+
+	scanner := bufio.NewScanner(r)
+
+	// Some Description fields in Packages indexes exceed bufio's default line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			if current != nil {
+				paragraphs = append(paragraphs, current)
+				current = nil
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == nil || lastField == "" {
+				return nil, fmt.Errorf("continuation line without a field: %q", line)
+			}
+
+			// Keep indentation beyond the first character; it is significant in descriptions
+			current[lastField] += "\n" + strings.TrimRight(line[1:], " \t")
+
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+
+		if !found {
+			return nil, fmt.Errorf("malformed field line: %q", line)
+		}
+
+		if current == nil {
+			current = make(Paragraph)
+		}
+
+		lastField = strings.TrimSpace(name)
+		current[lastField] = strings.TrimSpace(value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read control data: %w", err)
+	}
+
+	if current != nil {
+		paragraphs = append(paragraphs, current)
+	}
+
+	return paragraphs, nil
+}
+
+// Lines splits a multi-line field value (e.g. SHA256 in a Release file) into its
+// non-empty lines, dropping the (usually empty) first line
+func Lines(value string) []string {
+	var lines []string
+
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
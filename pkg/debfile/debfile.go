@@ -0,0 +1,210 @@
+package debfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/deb822"
+)
+
+// Control returns the control fields of the .deb at debPath
+func Control(debPath string) (deb822.Paragraph, error) {
+	output, err := exec.Command("dpkg-deb", "--field", debPath).Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("dpkg-deb --field failed for %s: %w", debPath, err)
+	}
+
+	paragraphs, err := deb822.Parse(bytes.NewReader(output))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paragraphs) == 0 {
+		return nil, fmt.Errorf("no control fields in %s", debPath)
+	}
+
+	return paragraphs[0], nil
+}
+
+// SourceName returns the source package name and version of a binary package's control fields.
+// The Source field is optional and may carry a version in parentheses.
+func SourceName(control deb822.Paragraph) (string, string) {
+	name := control["Package"]
+	version := control["Version"]
+
+	if source := control["Source"]; source != "" {
+		name = source
+
+		if open := strings.Index(source, "("); open >= 0 {
+			name = strings.TrimSpace(source[:open])
+			version = strings.Trim(source[open:], "() ")
+		}
+	}
+
+	return name, version
+}
+
+// ReadFile returns the contents of member (e.g. usr/share/doc/pkg/copyright) in the
+// data archive of the .deb at debPath, following symlinks within the package
+func ReadFile(debPath, member string) ([]byte, error) {
+	for hops := 0; hops < 5; hops++ {
+		data, target, err := readMember(debPath, member)
+
+		if err != nil || target == "" {
+			return data, err
+		}
+
+		member = target
+	}
+
+	return nil, fmt.Errorf("too many symlinks resolving %s in %s", member, debPath)
+}
+
+// readMember returns the member contents, or its link target when it is a symlink
+func readMember(debPath, member string) ([]byte, string, error) {
+	cmd := exec.Command("dpkg-deb", "--fsys-tarfile", debPath)
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("failed to run dpkg-deb: %w", err)
+	}
+
+	defer cmd.Wait()
+
+	// Drain the pipe so dpkg-deb can exit when we stop reading early
+	defer io.Copy(io.Discard, stdout)
+
+	want := path.Clean("/" + member)
+	tr := tar.NewReader(stdout)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read data archive of %s: %w", debPath, err)
+		}
+
+		if path.Clean("/"+header.Name) != want {
+			continue
+		}
+
+		if header.Typeflag == tar.TypeSymlink {
+			target := header.Linkname
+
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(want), target)
+			}
+
+			return nil, path.Clean(target), nil
+		}
+
+		data, err := io.ReadAll(tr)
+
+		return data, "", err
+	}
+
+	return nil, "", fmt.Errorf("%s not found in %s", member, debPath)
+}
+
+// Files lists the paths a package installs, as dpkg -c shows them but without
+// directories and without the leading "./"
+func Files(debPath string) ([]string, error) {
+	cmd := exec.Command("dpkg-deb", "--fsys-tarfile", debPath)
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run dpkg-deb: %w", err)
+	}
+
+	var files []string
+	tr := tar.NewReader(stdout)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			io.Copy(io.Discard, stdout)
+			cmd.Wait()
+
+			return nil, fmt.Errorf("failed to read data archive of %s: %w", debPath, err)
+		}
+
+		if header.Typeflag != tar.TypeDir {
+			files = append(files, strings.TrimPrefix(path.Clean("/"+header.Name), "/"))
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("dpkg-deb --fsys-tarfile failed for %s: %w", debPath, err)
+	}
+
+	return files, nil
+}
+
+// Licenses returns the license short names declared in the machine-readable
+// copyright file of a package (DEP-5), or nil when the file is free-form
+func Licenses(debPath, packageName string) ([]string, error) {
+	data, err := ReadFile(debPath, path.Join("usr/share/doc", packageName, "copyright"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	paragraphs, err := deb822.Parse(bytes.NewReader(data))
+
+	// Free-form copyright files are not valid control data
+	if err != nil || len(paragraphs) == 0 || paragraphs[0]["Format"] == "" {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+
+	for _, paragraph := range paragraphs {
+		license, ok := paragraph["License"]
+
+		if !ok {
+			continue
+		}
+
+		// Only the first line names the license; the rest is its text
+		name, _, _ := strings.Cut(license, "\n")
+
+		if name = strings.TrimSpace(name); name != "" {
+			seen[name] = true
+		}
+	}
+
+	licenses := make([]string, 0, len(seen))
+
+	for name := range seen {
+		licenses = append(licenses, name)
+	}
+
+	sort.Strings(licenses)
+
+	return licenses, nil
+}
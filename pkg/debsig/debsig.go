@@ -0,0 +1,154 @@
+package debsig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Verification results recorded in the manifest
+const (
+	StatusValid    = "valid"
+	StatusInvalid  = "invalid"
+	StatusUnsigned = "unsigned"
+	StatusUnknown  = "unknown" // Signed, but the signer or a verification tool is unavailable
+)
+
+// Verification modes selectable on the command line
+const (
+	ModeOff     = "off"
+	ModeRecord  = "record"
+	ModeRequire = "require"
+)
+
+// ValidMode reports whether mode is one of the supported verification modes
+func ValidMode(mode string) bool {
+	return mode == ModeOff || mode == ModeRecord || mode == ModeRequire
+}
+
+// Members returns the names of the ar members of a .deb file
+func Members(path string) ([]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic := make([]byte, 8)
+
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != "!<arch>\n" {
+		return nil, fmt.Errorf("%s is not an ar archive", path)
+	}
+
+	var members []string
+	header := make([]byte, 60)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read ar header: %w", err)
+		}
+
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid ar member size: %w", err)
+		}
+
+		members = append(members, strings.TrimRight(strings.TrimSpace(string(header[0:16])), "/"))
+
+		// Member data is padded to an even length
+		if _, err := reader.Discard(int(size + size%2)); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to skip ar member: %w", err)
+		}
+	}
+
+	return members, nil
+}
+
+// Verify checks the signatures embedded in a .deb (debsigs or dpkg-sig style)
+// and returns the resulting status together with the verifier's detail message
+func Verify(path string) (string, string, error) {
+	members, err := Members(path)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	signed := false
+
+	for _, member := range members {
+		if strings.HasPrefix(member, "_gpg") {
+			signed = true
+		}
+	}
+
+	if !signed {
+		return StatusUnsigned, "no embedded signature", nil
+	}
+
+	if _, err := exec.LookPath("debsig-verify"); err == nil {
+		return verifyDebsig(path)
+	}
+
+	if _, err := exec.LookPath("dpkg-sig"); err == nil {
+		return verifyDpkgSig(path)
+	}
+
+	return StatusUnknown, "neither debsig-verify nor dpkg-sig is installed", nil
+}
+
+func verifyDebsig(path string) (string, string, error) {
+	output, err := exec.Command("debsig-verify", path).CombinedOutput()
+	detail := strings.TrimSpace(string(output))
+
+	if err == nil {
+		return StatusValid, detail, nil
+	}
+
+	var exitErr *exec.ExitError
+
+	if !errors.As(err, &exitErr) {
+		return "", "", fmt.Errorf("debsig-verify failed: %w", err)
+	}
+
+	// Exit codes as documented in debsig-verify(1)
+	switch exitErr.ExitCode() {
+	case 10:
+		return StatusUnsigned, detail, nil
+	case 11, 12:
+		return StatusUnknown, detail, nil
+	case 13:
+		return StatusInvalid, detail, nil
+	}
+
+	return "", "", fmt.Errorf("debsig-verify failed: %w, output: %s", err, detail)
+}
+
+func verifyDpkgSig(path string) (string, string, error) {
+	// dpkg-sig reports through its output rather than its exit code
+	output, _ := exec.Command("dpkg-sig", "--verify", path).CombinedOutput()
+	detail := strings.TrimSpace(string(output))
+
+	switch {
+	case strings.Contains(detail, "BADSIG"):
+		return StatusInvalid, detail, nil
+	case strings.Contains(detail, "UNKNOWNSIG"):
+		return StatusUnknown, detail, nil
+	case strings.Contains(detail, "GOODSIG"):
+		return StatusValid, detail, nil
+	case strings.Contains(detail, "NOSIG"):
+		return StatusUnsigned, detail, nil
+	}
+
+	return "", "", fmt.Errorf("dpkg-sig failed, output: %s", detail)
+}
@@ -0,0 +1,149 @@
+package debversion
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed Debian package version: [epoch:]upstream[-revision]
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+// Parse splits a version string into its epoch, upstream and revision parts
+func Parse(version string) Version {
+	var v Version
+
+	version = strings.TrimSpace(version)
+
+	if i := strings.Index(version, ":"); i >= 0 {
+		if epoch, err := strconv.Atoi(version[:i]); err == nil {
+			v.Epoch = epoch
+			version = version[i+1:]
+		}
+	}
+
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		v.Revision = version[i+1:]
+		version = version[:i]
+	}
+
+	v.Upstream = version
+
+	return v
+}
+
+// Compare returns -1, 0 or 1 when version a sorts before, equal to or after b,
+// following the algorithm in deb-version(7)
+func Compare(a, b string) int {
+	va := Parse(a)
+	vb := Parse(b)
+
+	if va.Epoch != vb.Epoch {
+		if va.Epoch < vb.Epoch {
+			return -1
+		}
+
+		return 1
+	}
+
+	if result := compareFragment(va.Upstream, vb.Upstream); result != 0 {
+		return result
+	}
+
+	return compareFragment(va.Revision, vb.Revision)
+}
+
+// order returns the sort weight of a non-digit character; '~' sorts before everything
+func order(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == 0:
+		return 0
+	}
+
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func compareFragment(a, b string) int {
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		// Compare the non-digit prefixes character by character
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			var ca, cb byte
+
+			if i < len(a) && !isDigit(a[i]) {
+				ca = a[i]
+			}
+
+			if j < len(b) && !isDigit(b[j]) {
+				cb = b[j]
+			}
+
+			if order(ca) != order(cb) {
+				if order(ca) < order(cb) {
+					return -1
+				}
+
+				return 1
+			}
+
+			if ca != 0 {
+				i++
+			}
+
+			if cb != 0 {
+				j++
+			}
+		}
+
+		// Compare the digit runs numerically, ignoring leading zeros
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+
+		startA, startB := i, j
+
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+
+		numA, numB := a[startA:i], b[startB:j]
+
+		if len(numA) != len(numB) {
+			if len(numA) < len(numB) {
+				return -1
+			}
+
+			return 1
+		}
+
+		if numA != numB {
+			if numA < numB {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
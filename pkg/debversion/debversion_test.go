@@ -0,0 +1,55 @@
+package debversion
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		version string
+		want    Version
+	}{
+		{"1.0", Version{Upstream: "1.0"}},
+		{"1.0-1", Version{Upstream: "1.0", Revision: "1"}},
+		{"2:1.2-3-4ubuntu1", Version{Epoch: 2, Upstream: "1.2-3", Revision: "4ubuntu1"}},
+		{" 1:0.9 ", Version{Epoch: 1, Upstream: "0.9"}},
+	}
+
+	for _, test := range tests {
+		if got := Parse(test.version); got != test.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.version, got, test.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.00", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1:0.9", "2.0", 1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0", "1.0-1", -1},
+		{"2.35-0ubuntu3", "2.35-0ubuntu10", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0+", -1},
+		{"1.0+dfsg", "1.0", 1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+	}
+
+	for _, test := range tests {
+		if got := Compare(test.a, test.b); got != test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+
+		if got := Compare(test.b, test.a); got != -test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.b, test.a, got, -test.want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package depgraph
+
+import (
+	"sort"
+
+	"portaptable/pkg/debversion"
+	"portaptable/pkg/packageinfo"
+	"portaptable/pkg/relation"
+)
+
+// Hard dependency fields; installing a package needs all of them satisfied
+var Hard = []string{"Pre-Depends", "Depends"}
+
+// provider is a package offering a virtual package name through Provides
+type provider struct {
+	index   int
+	version string // Provided version; empty for an unversioned Provides
+}
+
+// Graph answers dependency questions about a fixed set of packages, such as a repository
+type Graph struct {
+	packages     []packageinfo.PackageInfo
+	architecture string
+	byName       map[string][]int
+	provides     map[string][]provider
+	preferred    map[string]int    // Rank of each preferred provider, lowest first
+	alternatives map[string]string // Package chosen for a group offering the name
+}
+
+// New indexes binary packages by name and by the virtual names they provide. Relations
+// without an architecture qualifier resolve to architecture or "all" packages first.
+func New(packages []packageinfo.PackageInfo, architecture string) *Graph {
+	g := &Graph{
+		packages:     packages,
+		architecture: architecture,
+		byName:       make(map[string][]int),
+		provides:     make(map[string][]provider),
+	}
+
+	for i, pkg := range packages {
+		// Source packages take no part in binary dependencies
+		if pkg.Type == packageinfo.TypeSource {
+			continue
+		}
+
+		g.byName[pkg.Name] = append(g.byName[pkg.Name], i)
+
+		for _, group := range relation.Parse(pkg.Control["Provides"]) {
+			for _, r := range group {
+				g.provides[r.Name] = append(g.provides[r.Name], provider{index: i, version: r.Version})
+			}
+		}
+	}
+
+	return g
+}
+
+// PreferProviders makes Resolve choose, in the given order, among the packages
+// providing a virtual name before comparing versions
+func (g *Graph) PreferProviders(names []string) {
+	g.preferred = make(map[string]int)
+
+	for i, name := range names {
+		if _, ok := g.preferred[name]; !ok {
+			g.preferred[name] = i
+		}
+	}
+}
+
+// PreferAlternatives makes Closure satisfy a dependency group offering one of the
+// choices' names, e.g. default-mta, with the package chosen for it (postfix) when that
+// package satisfies the group
+func (g *Graph) PreferAlternatives(choices map[string]string) {
+	g.alternatives = choices
+}
+
+// Find returns the highest version of a package of the graph's architecture (or "all"),
+// else of any architecture
+func (g *Graph) Find(name string) (packageinfo.PackageInfo, bool) {
+	return g.Resolve(relation.Relation{Name: name})
+}
+
+// Resolve returns the best package satisfying a relation: the highest matching version
+// of the named package, else a package providing the name
+func (g *Graph) Resolve(r relation.Relation) (packageinfo.PackageInfo, bool) {
+	best := -1
+
+	for _, i := range g.byName[r.Name] {
+		if r.SatisfiedBy(g.packages[i].Version) && g.better(i, best, r.Architecture) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		for _, p := range g.provides[r.Name] {
+			if r.SatisfiedBy(p.version) && g.betterProvider(p.index, best, r.Architecture) {
+				best = p.index
+			}
+		}
+	}
+
+	if best < 0 {
+		return packageinfo.PackageInfo{}, false
+	}
+
+	return g.packages[best], true
+}
+
+// better reports whether package i is a better candidate than current (-1 for none)
+// for a relation with the given architecture qualifier
+func (g *Graph) better(i, current int, qualifier string) bool {
+	rank := g.archRank(g.packages[i].Architecture, qualifier)
+
+	if rank < 0 {
+		return false
+	}
+
+	if current < 0 {
+		return true
+	}
+
+	if currentRank := g.archRank(g.packages[current].Architecture, qualifier); rank != currentRank {
+		return rank > currentRank
+	}
+
+	return debversion.Compare(g.packages[i].Version, g.packages[current].Version) > 0
+}
+
+// betterProvider is better for providers of a virtual name, ranking the preferred
+// ones (see PreferProviders) first among those fitting the architecture equally
+func (g *Graph) betterProvider(i, current int, qualifier string) bool {
+	if current < 0 || g.archRank(g.packages[i].Architecture, qualifier) != g.archRank(g.packages[current].Architecture, qualifier) {
+		return g.better(i, current, qualifier)
+	}
+
+	if rank, currentRank := g.preference(i), g.preference(current); rank != currentRank {
+		return rank < currentRank
+	}
+
+	return g.better(i, current, qualifier)
+}
+
+// preference returns the rank of package i among the preferred providers, after
+// all of them when it is not one
+func (g *Graph) preference(i int) int {
+	if rank, ok := g.preferred[g.packages[i].Name]; ok {
+		return rank
+	}
+
+	return len(g.preferred)
+}
+
+// archRank orders how well a package architecture fits a relation: 1 for the wanted
+// architecture or "all", 0 for another one, -1 when excluded by a qualifier
+func (g *Graph) archRank(architecture, qualifier string) int {
+	wanted := g.architecture
+
+	switch qualifier {
+	case "", "any", "native":
+	default:
+		wanted = qualifier
+	}
+
+	switch {
+	case architecture == wanted || architecture == "all":
+		return 1
+	case qualifier == "" || qualifier == "any":
+		return 0
+	default:
+		return -1
+	}
+}
+
+// Closure returns the packages installing name pulls in through fields (itself first,
+// then in breadth-first order) and the dependencies no package of the graph satisfies.
+// The first satisfiable alternative of each group is followed, as apt would, unless
+// PreferAlternatives chose another.
+func (g *Graph) Closure(name string, fields []string) ([]packageinfo.PackageInfo, []string, bool) {
+	root, ok := g.Find(name)
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	closure := []packageinfo.PackageInfo{root}
+	seen := map[string]bool{root.Name + ":" + root.Architecture: true}
+	missing := make(map[string]bool)
+
+	for next := 0; next < len(closure); next++ {
+		pkg := closure[next]
+
+		for _, field := range fields {
+			for _, group := range relation.Parse(pkg.Control[field]) {
+				dep, ok := g.resolveGroup(group)
+
+				if !ok {
+					missing[group.String()] = true
+
+					continue
+				}
+
+				if key := dep.Name + ":" + dep.Architecture; !seen[key] {
+					seen[key] = true
+					closure = append(closure, dep)
+				}
+			}
+		}
+	}
+
+	return closure, sortedKeys(missing), true
+}
+
+// Depends returns the packages satisfying the relation groups of pkg's field, one per
+// group as Closure follows them; groups nothing satisfies are left out
+func (g *Graph) Depends(pkg packageinfo.PackageInfo, field string) []packageinfo.PackageInfo {
+	var deps []packageinfo.PackageInfo
+
+	for _, group := range relation.Parse(pkg.Control[field]) {
+		if dep, ok := g.resolveGroup(group); ok {
+			deps = append(deps, dep)
+		}
+	}
+
+	return deps
+}
+
+// resolveGroup returns the package chosen for an alternative of group (see
+// PreferAlternatives), else the one satisfying its first satisfiable alternative
+func (g *Graph) resolveGroup(group relation.Group) (packageinfo.PackageInfo, bool) {
+	for _, r := range group {
+		choice, ok := g.alternatives[r.Name]
+
+		if !ok {
+			continue
+		}
+
+		if pkg, ok := g.Find(choice); ok {
+			for _, alternative := range group {
+				if satisfies(pkg, alternative) {
+					return pkg, true
+				}
+			}
+		}
+	}
+
+	for _, r := range group {
+		if pkg, ok := g.Resolve(r); ok {
+			return pkg, true
+		}
+	}
+
+	return packageinfo.PackageInfo{}, false
+}
+
+// ReverseDepends returns the packages whose fields name a relation satisfied by name,
+// directly or (with recursive) through other reverse dependencies
+func (g *Graph) ReverseDepends(name string, fields []string, recursive bool) []packageinfo.PackageInfo {
+	target, ok := g.Find(name)
+
+	if !ok {
+		return nil
+	}
+
+	var result []packageinfo.PackageInfo
+	seen := map[string]bool{target.Name + ":" + target.Architecture: true}
+	queue := []packageinfo.PackageInfo{target}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, pkg := range g.packages {
+			key := pkg.Name + ":" + pkg.Architecture
+
+			if seen[key] || !g.dependsOn(pkg, current, fields) {
+				continue
+			}
+
+			seen[key] = true
+			result = append(result, pkg)
+
+			if recursive {
+				queue = append(queue, pkg)
+			}
+		}
+	}
+
+	return result
+}
+
+// dependsOn reports whether any alternative in pkg's fields is satisfied by target
+func (g *Graph) dependsOn(pkg, target packageinfo.PackageInfo, fields []string) bool {
+	for _, field := range fields {
+		for _, group := range relation.Parse(pkg.Control[field]) {
+			for _, r := range group {
+				if satisfies(target, r) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// satisfies reports whether pkg satisfies r by name or through its Provides
+func satisfies(pkg packageinfo.PackageInfo, r relation.Relation) bool {
+	if pkg.Name == r.Name {
+		return r.SatisfiedBy(pkg.Version)
+	}
+
+	for _, group := range relation.Parse(pkg.Control["Provides"]) {
+		for _, provided := range group {
+			if provided.Name == r.Name && r.SatisfiedBy(provided.Version) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,163 @@
+package depgraph
+
+import (
+	"sort"
+
+	"portaptable/pkg/packageinfo"
+)
+
+// dependency is an edge of the install order: the package at index must be unpacked
+// first, and configured first too when pre is set (Pre-Depends)
+type dependency struct {
+	index int
+	pre   bool
+}
+
+// InstallOrder groups the graph's binary packages into batches to install with dpkg
+// in turn. The Pre-Depends of a package are in earlier batches, so they are configured
+// before it is unpacked; its Depends are in the same batch or an earlier one, which
+// dpkg orders itself. Packages whose Pre-Depends form a loop cannot be ordered; they
+// share a batch and are also returned as name:architecture.
+func (g *Graph) InstallOrder() ([][]packageinfo.PackageInfo, []string) {
+	indexes := make(map[string]int)
+
+	for i, pkg := range g.packages {
+		if pkg.Type != packageinfo.TypeSource {
+			indexes[pkg.Name+":"+pkg.Architecture+"="+pkg.Version] = i
+		}
+	}
+
+	edges := make(map[int][]dependency)
+
+	for _, i := range indexes {
+		// Dependencies outside the graph are left to the target to satisfy
+		for _, field := range Hard {
+			for _, dep := range g.Depends(g.packages[i], field) {
+				if j := indexes[dep.Name+":"+dep.Architecture+"="+dep.Version]; j != i {
+					edges[i] = append(edges[i], dependency{index: j, pre: field == "Pre-Depends"})
+				}
+			}
+		}
+	}
+
+	components := stronglyConnected(indexes, edges)
+	component := make(map[int]int)
+
+	for c, members := range components {
+		for _, i := range members {
+			component[i] = c
+		}
+	}
+
+	// Components come dependencies first, so each one's batch follows theirs
+	batchOf := make([]int, len(components))
+	loops := make(map[string]bool)
+	batches := 0
+
+	for c, members := range components {
+		for _, i := range members {
+			for _, dep := range edges[i] {
+				if component[dep.index] == c {
+					if dep.pre {
+						loops[g.packages[i].Name+":"+g.packages[i].Architecture] = true
+					}
+
+					continue
+				}
+
+				batch := batchOf[component[dep.index]]
+
+				if dep.pre {
+					batch++
+				}
+
+				batchOf[c] = max(batchOf[c], batch)
+			}
+		}
+
+		batches = max(batches, batchOf[c]+1)
+	}
+
+	order := make([][]packageinfo.PackageInfo, batches)
+
+	for c, members := range components {
+		for _, i := range members {
+			order[batchOf[c]] = append(order[batchOf[c]], g.packages[i])
+		}
+	}
+
+	for _, batch := range order {
+		sort.Slice(batch, func(a, b int) bool {
+			if batch[a].Name != batch[b].Name {
+				return batch[a].Name < batch[b].Name
+			}
+
+			return batch[a].Architecture < batch[b].Architecture
+		})
+	}
+
+	return order, sortedKeys(loops)
+}
+
+// stronglyConnected returns the strongly connected components of the nodes (Tarjan's
+// algorithm), each after every component it has edges to
+func stronglyConnected(nodes map[string]int, edges map[int][]dependency) [][]int {
+	var components [][]int
+	var stack []int
+	index := make(map[int]int)
+	low := make(map[int]int)
+	onStack := make(map[int]bool)
+
+	var visit func(i int)
+	visit = func(i int) {
+		index[i] = len(index)
+		low[i] = index[i]
+		stack = append(stack, i)
+		onStack[i] = true
+
+		for _, dep := range edges[i] {
+			if _, visited := index[dep.index]; !visited {
+				visit(dep.index)
+				low[i] = min(low[i], low[dep.index])
+			} else if onStack[dep.index] {
+				low[i] = min(low[i], index[dep.index])
+			}
+		}
+
+		if low[i] != index[i] {
+			return
+		}
+
+		var members []int
+
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			members = append(members, top)
+
+			if top == i {
+				break
+			}
+		}
+
+		components = append(components, members)
+	}
+
+	// Visit in a fixed order so the batches do not depend on map iteration
+	sorted := make([]int, 0, len(nodes))
+
+	for _, i := range nodes {
+		sorted = append(sorted, i)
+	}
+
+	sort.Ints(sorted)
+
+	for _, i := range sorted {
+		if _, visited := index[i]; !visited {
+			visit(i)
+		}
+	}
+
+	return components
+}
@@ -0,0 +1,265 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/remote"
+)
+
+// ErrUnauthorized is returned when the server wants credentials, which only apt's
+// auth.conf holds
+var ErrUnauthorized = errors.New("authentication required")
+
+// errRangeNotSatisfiable is returned when the server refuses to resume from the size
+// of the .part file without confirming that the file is complete
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// File is a pool file to download, with what its index entry says about it
+type File struct {
+	URL string
+
+	// Filename is the name to store the file under; empty uses the URL's base name
+	Filename string
+
+	Size   int64  // Expected size in bytes; 0 when unknown
+	SHA256 string // Expected digest; empty when unknown
+}
+
+// name returns the file name the download is stored under
+func (f File) name() string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+
+	if parsed, err := url.Parse(f.URL); err == nil {
+		return path.Base(parsed.Path)
+	}
+
+	return path.Base(f.URL)
+}
+
+// Download fetches file straight into dir and returns its path. A file already there
+// that matches the expected SHA256 is kept; a corrupt one is fetched again. Transfers
+// land in a .part file first, which a later attempt resumes from its last byte.
+func Download(file File, dir string) (string, error) {
+	target := filepath.Join(dir, file.name())
+
+	if _, err := os.Stat(target); err == nil {
+		if file.SHA256 == "" {
+			return target, nil
+		}
+
+		if sums, err := checksum.File(target); err == nil && sums.SHA256 == file.SHA256 {
+			return target, nil
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	part := target + ".part"
+	offset := partialSize(part, file.Size)
+	body, resumed, err := open(file.URL, offset)
+
+	// A .part file the server does not recognize is kept only when its digest proves
+	// it complete; otherwise it is stale or corrupt and the transfer starts over
+	if errors.Is(err, errRangeNotSatisfiable) {
+		if sums, sumErr := checksum.File(part); sumErr == nil && file.SHA256 != "" && sums.SHA256 == file.SHA256 {
+			body, resumed, err = http.NoBody, true, nil
+		} else {
+			os.Remove(part)
+			offset = 0
+			body, resumed, err = open(file.URL, 0)
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	defer body.Close()
+
+	hash := sha256.New()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	if resumed {
+		// The digest covers the bytes an earlier attempt already wrote
+		if err := hashFile(hash, part); err != nil {
+			return "", err
+		}
+
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+
+	out, err := os.OpenFile(part, flags, 0644)
+
+	if err != nil {
+		return "", err
+	}
+
+	copied, err := io.Copy(io.MultiWriter(out, hash), limitedReader{body})
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	// The partial file stays for the next attempt to resume
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s after %d bytes: %w", file.URL, offset+copied, err)
+	}
+
+	if size := offset + copied; file.Size > 0 && size != file.Size {
+		os.Remove(part)
+
+		return "", fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", file.URL, file.Size, size)
+	}
+
+	if digest := hex.EncodeToString(hash.Sum(nil)); file.SHA256 != "" && digest != file.SHA256 {
+		os.Remove(part)
+
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", file.URL, file.SHA256, digest)
+	}
+
+	if err := os.Rename(part, target); err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// partialSize returns how many bytes of a download to resume: the size of its .part
+// file, or 0 when there is none or it is already larger than the expected size
+func partialSize(part string, expected int64) int64 {
+	info, err := os.Stat(part)
+
+	if err != nil || (expected > 0 && info.Size() > expected) {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// hashFile feeds the contents of path to hash
+func hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// open starts the transfer of rawURL from offset and reports whether it resumed there;
+// otherwise it starts from the beginning. http and https are fetched directly, file
+// URLs in apt's file:/path form too; other schemes go through the remote package.
+func open(rawURL string, offset int64) (io.ReadCloser, bool, error) {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+		return get(rawURL, offset)
+
+	case "file":
+		file, err := os.Open(parsed.Path)
+
+		if os.IsNotExist(err) {
+			return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, remote.ErrNotFound)
+		}
+
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+
+			return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+		}
+
+		return file, offset > 0, nil
+	}
+
+	body, err := remote.Get(rawURL)
+
+	return body, false, err
+}
+
+// get issues an HTTP GET with the configured headers, asking for the bytes from offset on
+func get(rawURL string, offset int64) (io.ReadCloser, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+
+	remote.AddHeaders(req)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := remote.Do(req)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, false, nil
+
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+
+	// The partial file already holds every byte when the size the server reports,
+	// as "Content-Range: bytes */SIZE", is where the transfer would resume
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+
+		if total, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes */"); ok && total == strconv.FormatInt(offset, 10) {
+			return http.NoBody, true, nil
+		}
+
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, errRangeNotSatisfiable)
+
+	case http.StatusNotFound:
+		resp.Body.Close()
+
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, remote.ErrNotFound)
+
+	case http.StatusUnauthorized, http.StatusForbidden:
+		resp.Body.Close()
+
+		return nil, false, fmt.Errorf("failed to fetch %s: %w", rawURL, ErrUnauthorized)
+	}
+
+	resp.Body.Close()
+
+	return nil, false, fmt.Errorf("failed to fetch %s: %s", rawURL, resp.Status)
+}
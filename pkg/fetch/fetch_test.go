@@ -0,0 +1,183 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testContent = "Package: hello\nVersion: 2.10-2\nArchitecture: amd64\n"
+
+// rangeServer serves testContent with HTTP range requests and records the Range
+// header of each request
+type rangeServer struct {
+	ranges []string
+
+	// refuse answers every range request with 416 and this Content-Range; empty serves ranges
+	refuse string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Range")
+	s.ranges = append(s.ranges, header)
+
+	if header == "" {
+		w.Write([]byte(testContent))
+
+		return
+	}
+
+	if s.refuse != "" {
+		w.Header().Set("Content-Range", s.refuse)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+
+		return
+	}
+
+	offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, "bytes="), "-"))
+
+	if err != nil || offset > len(testContent) {
+		http.Error(w, "bad range", http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(testContent)-1, len(testContent)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write([]byte(testContent[offset:]))
+}
+
+func testFile(url string) File {
+	sum := sha256.Sum256([]byte(testContent))
+
+	return File{URL: url + "/pool/hello_2.10-2_amd64.deb", Size: int64(len(testContent)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// download fetches testContent into a directory holding part as the .part file of an
+// earlier attempt, and checks the result
+func download(t *testing.T, server *rangeServer, part string) {
+	t.Helper()
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	file := testFile(ts.URL)
+
+	if part != "" {
+		if err := os.WriteFile(filepath.Join(dir, file.name()+".part"), []byte(part), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := Download(file, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != testContent {
+		t.Errorf("downloaded %q, want %q", data, testContent)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, file.name()+".part")); !os.IsNotExist(err) {
+		t.Errorf("the .part file remains after the download")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server := &rangeServer{}
+	download(t, server, "")
+
+	if len(server.ranges) != 1 || server.ranges[0] != "" {
+		t.Errorf("requested ranges %q, want one full request", server.ranges)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	server := &rangeServer{}
+	download(t, server, testContent[:10])
+
+	if len(server.ranges) != 1 || server.ranges[0] != "bytes=10-" {
+		t.Errorf("requested ranges %q, want bytes=10-", server.ranges)
+	}
+}
+
+func TestDownloadCompletePart(t *testing.T) {
+	// The server confirms the .part file holds every byte
+	server := &rangeServer{refuse: fmt.Sprintf("bytes */%d", len(testContent))}
+	download(t, server, testContent)
+
+	if len(server.ranges) != 1 {
+		t.Errorf("requested ranges %q, want only the refused resume", server.ranges)
+	}
+}
+
+func TestDownloadStalePart(t *testing.T) {
+	// The file changed on the server since the .part file was written
+	server := &rangeServer{refuse: "bytes */20"}
+	download(t, server, strings.Repeat("x", 30))
+
+	if len(server.ranges) != 2 || server.ranges[1] != "" {
+		t.Errorf("requested ranges %q, want a refused resume and a full request", server.ranges)
+	}
+}
+
+func TestDownloadRefusedCompletePart(t *testing.T) {
+	// Without a Content-Range, a .part file matching the digest is complete
+	server := &rangeServer{refuse: "none"}
+	download(t, server, testContent)
+
+	if len(server.ranges) != 1 {
+		t.Errorf("requested ranges %q, want only the refused resume", server.ranges)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	ts := httptest.NewServer(&rangeServer{})
+	defer ts.Close()
+
+	dir := t.TempDir()
+	file := testFile(ts.URL)
+	file.SHA256 = strings.Repeat("0", 64)
+
+	if _, err := Download(file, dir); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Download() error = %v, want a checksum mismatch", err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("a corrupt download left %d files behind", len(entries))
+	}
+}
+
+func TestPartialSize(t *testing.T) {
+	dir := t.TempDir()
+	part := filepath.Join(dir, "file.part")
+
+	if got := partialSize(part, 100); got != 0 {
+		t.Errorf("partialSize() without a .part file = %d, want 0", got)
+	}
+
+	if err := os.WriteFile(part, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for expected, want := range map[int64]int64{0: 10, 100: 10, 10: 10, 5: 0} {
+		if got := partialSize(part, expected); got != want {
+			t.Errorf("partialSize() of 10 bytes expecting %d = %d, want %d", expected, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package fetch
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// limitChunk is the most a transfer reads between throughput checks
+const limitChunk = 16 << 10
+
+// limit is shared by every download, so concurrent transfers split the rate between them
+var limit = &limiter{}
+
+// SetRateLimit caps the combined throughput of all downloads at rate bytes per second;
+// 0 removes the cap
+func SetRateLimit(rate int64) {
+	limit.mu.Lock()
+	defer limit.mu.Unlock()
+
+	limit.rate = rate
+	limit.tokens = 0
+	limit.last = time.Now()
+}
+
+// limiter is a token bucket holding at most a second of throughput
+type limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// wait blocks until n more bytes fit the rate
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+
+	if l.rate <= 0 {
+		l.mu.Unlock()
+
+		return
+	}
+
+	now := time.Now()
+	l.tokens = min(float64(l.rate), l.tokens+now.Sub(l.last).Seconds()*float64(l.rate))
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	rate := l.rate
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / float64(rate) * float64(time.Second)))
+	}
+}
+
+// limitedReader draws every read from the shared limiter
+type limitedReader struct {
+	r io.Reader
+}
+
+func (lr limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+
+	n, err := lr.r.Read(p)
+
+	if n > 0 {
+		limit.wait(n)
+	}
+
+	return n, err
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ignored keeps package files and working state out of the history; only the
+// manifest and generated metadata are tracked
+const ignored = `/pool/
+/snapshots/
+/.apt/
+/.cache/
+/.delta/
+/.sync-*/
+/.rollback-*/
+/.lock
+/.served.json
+/.manifest.journal
+*.tmp
+`
+
+// Enabled reports whether the repository keeps a metadata history
+func Enabled(repoPath string) bool {
+	_, err := os.Stat(filepath.Join(repoPath, ".git"))
+
+	return err == nil
+}
+
+// Init starts a metadata history in the repository directory
+func Init(repoPath string) error {
+	if Enabled(repoPath) {
+		return nil
+	}
+
+	if _, err := git(repoPath, "init", "--quiet"); err != nil {
+		return err
+	}
+
+	return updateIgnored(repoPath)
+}
+
+// updateIgnored adds the patterns of ignored that the repository's .gitignore lacks,
+// keeping any the user added, and stops tracking what they cover. Histories started
+// by older versions thereby learn about working state added since.
+func updateIgnored(repoPath string) error {
+	path := filepath.Join(repoPath, ".gitignore")
+	data, err := os.ReadFile(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+
+	present := make(map[string]bool)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+
+	var missing []string
+
+	for _, pattern := range strings.Fields(ignored) {
+		if !present[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+
+	data = append(data, strings.Join(missing, "\n")+"\n"...)
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write .gitignore: %w", err)
+	}
+
+	// Files committed before their pattern was ignored would otherwise stay tracked
+	args := []string{"rm", "-r", "--cached", "--quiet", "--ignore-unmatch", "--"}
+
+	for _, pattern := range missing {
+		args = append(args, strings.TrimSuffix(strings.TrimPrefix(pattern, "/"), "/"))
+	}
+
+	_, err = git(repoPath, args...)
+
+	return err
+}
+
+// Record commits the current metadata with message when the repository keeps a
+// history. Operations that changed nothing leave no commit.
+func Record(repoPath, message string) error {
+	if !Enabled(repoPath) {
+		return nil
+	}
+
+	if err := updateIgnored(repoPath); err != nil {
+		return err
+	}
+
+	if _, err := git(repoPath, "add", "--all"); err != nil {
+		return err
+	}
+
+	if _, err := git(repoPath, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+
+	args := []string{"commit", "--quiet", "--message", message}
+
+	// Fall back to a tool identity on hosts without a git identity (e.g. cron jobs)
+	if out, _ := git(repoPath, "config", "user.email"); strings.TrimSpace(out) == "" {
+		args = append([]string{"-c", "user.name=portaptable", "-c", "user.email=portaptable@localhost"}, args...)
+	}
+
+	_, err := git(repoPath, args...)
+
+	return err
+}
+
+func git(repoPath string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", repoPath}, args...)...).CombinedOutput()
+
+	if err != nil {
+		return string(out), fmt.Errorf("git %s failed: %w, output: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
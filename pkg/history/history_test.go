@@ -0,0 +1,120 @@
+package history
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// write creates the files of a repository, by slash-separated name
+func write(t *testing.T, repo string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tracked returns the files the history of repo tracks
+func tracked(t *testing.T, repo string) string {
+	t.Helper()
+
+	out, err := git(repo, "ls-files")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+func TestRecord(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+
+	if err := Init(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, repo, map[string]string{
+		"manifest.json":            "{}",
+		"dists/jammy/Release":      "Suite: jammy",
+		"pool/app_1.0_amd64.deb":   "deb",
+		".cache/indexes/Packages":  "cache",
+		".manifest.journal":        "journal",
+		".delta/pool/lib.deb":      "delta",
+		"dists/jammy/Release.tmp":  "partial",
+		".rollback-123/next/x.txt": "stage",
+	})
+
+	if err := Record(repo, "download"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tracked(t, repo), ".gitignore\ndists/jammy/Release\nmanifest.json\n"; got != want {
+		t.Errorf("history tracks %q, want %q", got, want)
+	}
+}
+
+func TestRecordUpdatesStaleIgnore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// A history started before the journal and index cache were ignored
+	repo := t.TempDir()
+
+	if _, err := git(repo, "init", "--quiet"); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, repo, map[string]string{
+		".gitignore":              "/pool/\n/.lock\n/custom/",
+		"manifest.json":           "{}",
+		".cache/indexes/Packages": "cache",
+		".manifest.journal":       "journal",
+	})
+
+	if _, err := git(repo, "add", "--all"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := git(repo, "-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "--message", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, repo, map[string]string{"manifest.json": `{"distribution":"jammy"}`})
+
+	if err := Record(repo, "download"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tracked(t, repo), ".gitignore\nmanifest.json\n"; got != want {
+		t.Errorf("history tracks %q, want %q", got, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(repo, ".gitignore"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pattern := range []string{"/custom/", "/.cache/", "/.manifest.journal"} {
+		if !strings.Contains(string(data), pattern+"\n") {
+			t.Errorf(".gitignore lacks %s:\n%s", pattern, data)
+		}
+	}
+}
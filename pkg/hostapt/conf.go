@@ -0,0 +1,146 @@
+package hostapt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Config holds the scalar options of the host's apt.conf, keyed by their
+// lower-cased full names (e.g. "acquire::http::proxy")
+type Config map[string]string
+
+// Get returns the value of an option; names are case-insensitive
+func (c Config) Get(name string) string {
+	return c[strings.ToLower(name)]
+}
+
+// Proxy returns the proxy apt uses for scheme ("http" or "https"), or empty when none
+// is configured or it is disabled with DIRECT
+func (c Config) Proxy(scheme string) string {
+	proxy := c.Get("Acquire::" + scheme + "::Proxy")
+
+	if strings.EqualFold(proxy, "DIRECT") || strings.EqualFold(proxy, "false") {
+		return ""
+	}
+
+	return proxy
+}
+
+// ReadConfig parses apt.conf.d in alphabetical order and then apt.conf below root,
+// matching apt's precedence. Lists and #include/#clear directives are ignored.
+func ReadConfig(root string) (Config, error) {
+	etc := filepath.Join(root, "etc/apt")
+	config := make(Config)
+
+	entries, err := os.ReadDir(filepath.Join(etc, "apt.conf.d"))
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var files []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// apt only reads parts without an extension or ending in .conf, skipping
+		// leftovers such as foo.dpkg-old
+		if entry.IsDir() || (strings.Contains(name, ".") && !strings.HasSuffix(name, ".conf")) {
+			continue
+		}
+
+		files = append(files, filepath.Join(etc, "apt.conf.d", name))
+	}
+
+	sort.Strings(files)
+	files = append(files, filepath.Join(etc, "apt.conf"))
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := parseConfig(string(data), config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	return config, nil
+}
+
+// parseConfig reads apt.conf syntax into config: "A::B "value";" statements, nested
+// "A { B "value"; };" scopes and //, /* */ and # comments
+func parseConfig(data string, config Config) error {
+	var scopes, words []string
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		case c == '#' || strings.HasPrefix(data[i:], "//"):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(data[i:], "/*"):
+			end := strings.Index(data[i+2:], "*/")
+
+			if end < 0 {
+				return fmt.Errorf("unterminated comment")
+			}
+
+			i += end + 3
+		case c == '"':
+			end := strings.IndexByte(data[i+1:], '"')
+
+			if end < 0 {
+				return fmt.Errorf("unterminated string")
+			}
+
+			words = append(words, data[i+1:i+1+end])
+			i += end + 1
+		case c == '{':
+			if len(words) == 0 {
+				return fmt.Errorf("scope without a name")
+			}
+
+			scopes = append(scopes, words[0])
+			words = nil
+		case c == '}':
+			if len(scopes) == 0 {
+				return fmt.Errorf("unbalanced '}'")
+			}
+
+			scopes = scopes[:len(scopes)-1]
+			words = nil
+		case c == ';':
+			// A lone value inside a scope is a list item
+			if len(words) >= 2 {
+				name := strings.Join(append(append([]string{}, scopes...), words[0]), "::")
+				config[strings.ToLower(name)] = words[1]
+			}
+
+			words = nil
+		default:
+			start := i
+
+			for i < len(data) && !strings.ContainsRune(" \t\r\n{};\"", rune(data[i])) {
+				i++
+			}
+
+			words = append(words, data[start:i])
+			i--
+		}
+	}
+
+	return nil
+}
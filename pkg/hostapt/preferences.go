@@ -0,0 +1,274 @@
+package hostapt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"portaptable/pkg/deb822"
+)
+
+// Default priorities of apt_preferences(5) for versions no record matches: archives
+// whose Release says NotAutomatic only supply what is asked of them, and with
+// ButAutomaticUpgrades also upgrade what came from them
+const (
+	DefaultPriority              = 500
+	NotAutomaticPriority         = 1
+	ButAutomaticUpgradesPriority = 100
+)
+
+// Preference is a record of an apt_preferences(5) file, setting the priority of the
+// versions of some packages
+type Preference struct {
+	Packages []string // Names, glob patterns or /regular expressions/; "*" alone makes a general record
+	Pin      string   // "release a=stable,c=main", "version 1.2*" or "origin example.org"
+	Priority int
+}
+
+// General reports whether the record applies to every package ("Package: *"); apt
+// ranks it below the records naming packages
+func (p Preference) General() bool {
+	return len(p.Packages) == 1 && p.Packages[0] == "*"
+}
+
+// String renders the record in apt_preferences(5) syntax
+func (p Preference) String() string {
+	return fmt.Sprintf("Package: %s\nPin: %s\nPin-Priority: %d\n", strings.Join(p.Packages, " "), p.Pin, p.Priority)
+}
+
+// Origin describes where a package version comes from, for the records pinning by
+// release or origin: the archive's host and the fields of the suite's Release file
+type Origin struct {
+	Site      string // Host of the archive URI; empty for local archives
+	Archive   string // Release Suite, e.g. stable or jammy-updates
+	Codename  string // Release Codename, e.g. bookworm
+	Version   string // Release Version, e.g. 12.5
+	Origin    string
+	Label     string
+	Component string
+
+	NotAutomatic, ButAutomaticUpgrades bool
+}
+
+// Matches reports whether the record applies to a version of a package from origin
+func (p Preference) Matches(name, version string, origin Origin) bool {
+	if !p.General() && !matchAnyPattern(p.Packages, name) {
+		return false
+	}
+
+	kind, value, _ := strings.Cut(p.Pin, " ")
+	value = strings.TrimSpace(value)
+
+	switch kind {
+	case "version":
+		return matchPattern(value, version)
+	case "origin":
+		return strings.Trim(value, `"`) == origin.Site
+	case "release":
+		return matchRelease(value, origin)
+	}
+
+	return false
+}
+
+// matchRelease checks the comma-separated conditions of a release pin against origin,
+// e.g. "o=Debian,a=stable"; a bare value names the archive or the codename
+func matchRelease(conditions string, origin Origin) bool {
+	if !strings.Contains(conditions, "=") {
+		return matchPattern(conditions, origin.Archive) || matchPattern(conditions, origin.Codename)
+	}
+
+	fields := map[string]string{
+		"a": origin.Archive, "n": origin.Codename, "v": origin.Version,
+		"o": origin.Origin, "l": origin.Label, "c": origin.Component,
+	}
+
+	for _, condition := range strings.Split(conditions, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(condition), "=")
+		field, known := fields[key]
+
+		// Architecture conditions (b=) hold for the indexes of the target's architectures
+		if key == "b" {
+			continue
+		}
+
+		if !known || !matchPattern(strings.Trim(value, `"`), field) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchAnyPattern reports whether value matches one of patterns
+func matchAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern matches value against a literal, a glob or a /regular expression/, as
+// apt does in the Package and Pin fields
+func matchPattern(pattern, value string) bool {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+
+		return err == nil && re.MatchString(value)
+	}
+
+	matched, _ := path.Match(pattern, value)
+
+	return matched || pattern == value
+}
+
+// Priority returns the priority apt gives a version of a package from origin: that
+// of the first record naming the package to match, else that of the first matching
+// general record, else the default of its archive
+func Priority(preferences []Preference, name, version string, origin Origin) int {
+	priority, general := 0, false
+
+	for _, preference := range preferences {
+		if !preference.Matches(name, version, origin) {
+			continue
+		}
+
+		if !preference.General() {
+			return preference.Priority
+		}
+
+		if !general {
+			priority, general = preference.Priority, true
+		}
+	}
+
+	switch {
+	case general:
+		return priority
+	case origin.NotAutomatic && origin.ButAutomaticUpgrades:
+		return ButAutomaticUpgradesPriority
+	case origin.NotAutomatic:
+		return NotAutomaticPriority
+	}
+
+	return DefaultPriority
+}
+
+// ParsePreferences reads the records of an apt_preferences(5) file; Explanation
+// fields and comments are ignored
+func ParsePreferences(r io.Reader) ([]Preference, error) {
+	paragraphs, err := deb822.Parse(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var preferences []Preference
+
+	for _, paragraph := range paragraphs {
+		fields := make(map[string]string)
+
+		for key, value := range paragraph {
+			fields[strings.ToLower(key)] = strings.TrimSpace(value)
+		}
+
+		if fields["package"] == "" || fields["pin"] == "" || fields["pin-priority"] == "" {
+			// A record holding only explanations is a comment
+			if fields["package"] == "" && fields["pin"] == "" && fields["pin-priority"] == "" {
+				continue
+			}
+
+			return nil, fmt.Errorf("record for %q needs Package, Pin and Pin-Priority", fields["package"])
+		}
+
+		priority, err := strconv.Atoi(fields["pin-priority"])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid Pin-Priority %q", fields["pin-priority"])
+		}
+
+		kind, _, _ := strings.Cut(fields["pin"], " ")
+
+		if kind != "release" && kind != "version" && kind != "origin" {
+			return nil, fmt.Errorf("invalid Pin %q (expected release, version or origin)", fields["pin"])
+		}
+
+		preferences = append(preferences, Preference{Packages: strings.Fields(fields["package"]), Pin: fields["pin"], Priority: priority})
+	}
+
+	return preferences, nil
+}
+
+// ReadPreferencesFile parses one preferences file
+func ReadPreferencesFile(path string) ([]Preference, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	preferences, err := ParsePreferences(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return preferences, nil
+}
+
+// ReadPreferences parses preferences and then the preferences.d parts in alphabetical
+// order below root, as apt reads them
+func ReadPreferences(root string) ([]Preference, error) {
+	etc := filepath.Join(root, "etc/apt")
+	files := []string{filepath.Join(etc, "preferences")}
+
+	entries, err := os.ReadDir(filepath.Join(etc, "preferences.d"))
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var parts []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// apt only reads parts without an extension or ending in .pref
+		if entry.IsDir() || (strings.Contains(name, ".") && !strings.HasSuffix(name, ".pref")) {
+			continue
+		}
+
+		parts = append(parts, filepath.Join(etc, "preferences.d", name))
+	}
+
+	sort.Strings(parts)
+
+	var preferences []Preference
+
+	for _, path := range append(files, parts...) {
+		parsed, err := ReadPreferencesFile(path)
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		preferences = append(preferences, parsed...)
+	}
+
+	return preferences, nil
+}
@@ -0,0 +1,178 @@
+package hostapt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPreferences = `Explanation: hold the kernel
+Package: linux-image-*
+Pin: version 6.1.*
+Pin-Priority: 1001
+
+Explanation: only a comment
+
+Package: /^python3-/ nginx
+Pin: release a=bookworm-backports
+Pin-Priority: 600
+
+Package: *
+Pin: origin "deb.example.org"
+Pin-Priority: -1
+
+Package: *
+Pin: release o=Debian,a=stable,c=main
+Pin-Priority: 700
+
+Package: *
+Pin: release n=bookworm
+Pin-Priority: 900
+`
+
+func TestParsePreferences(t *testing.T) {
+	preferences, err := ParsePreferences(strings.NewReader(testPreferences))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Preference{
+		{Packages: []string{"linux-image-*"}, Pin: "version 6.1.*", Priority: 1001},
+		{Packages: []string{"/^python3-/", "nginx"}, Pin: "release a=bookworm-backports", Priority: 600},
+		{Packages: []string{"*"}, Pin: `origin "deb.example.org"`, Priority: -1},
+		{Packages: []string{"*"}, Pin: "release o=Debian,a=stable,c=main", Priority: 700},
+		{Packages: []string{"*"}, Pin: "release n=bookworm", Priority: 900},
+	}
+
+	if !reflect.DeepEqual(preferences, want) {
+		t.Errorf("ParsePreferences() = %+v, want %+v", preferences, want)
+	}
+}
+
+func TestParsePreferencesErrors(t *testing.T) {
+	for _, text := range []string{
+		"Package: foo\nPin: release a=stable\n",
+		"Package: foo\nPin: release a=stable\nPin-Priority: high\n",
+		"Package: foo\nPin: label stable\nPin-Priority: 500\n",
+	} {
+		if _, err := ParsePreferences(strings.NewReader(text)); err == nil {
+			t.Errorf("ParsePreferences(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	preferences, err := ParsePreferences(strings.NewReader(testPreferences))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stable := Origin{Site: "deb.debian.org", Archive: "stable", Codename: "bookworm", Origin: "Debian", Component: "main"}
+	backports := Origin{Site: "deb.debian.org", Archive: "bookworm-backports", Codename: "bookworm-backports", Origin: "Debian", Component: "main",
+		NotAutomatic: true, ButAutomaticUpgrades: true}
+	experimental := Origin{Site: "deb.debian.org", Archive: "experimental", Codename: "rc-buggy", Origin: "Debian", Component: "main", NotAutomatic: true}
+	thirdParty := Origin{Site: "deb.example.org", Archive: "stable", Codename: "bookworm", Origin: "Example", Component: "main"}
+	contrib := stable
+	contrib.Component = "contrib"
+
+	tests := []struct {
+		name, version string
+		origin        Origin
+		want          int
+	}{
+		// Records naming the package come first, whatever their priority
+		{"linux-image-amd64", "6.1.0-18", backports, 1001},
+		{"nginx", "1.24.0-1", backports, 600},
+		{"python3-yaml", "6.0-1", backports, 600},
+
+		// The first matching general record wins, not the highest
+		{"curl", "7.88.1-10", stable, 700},
+		{"curl", "7.88.1-10", contrib, 900},
+		{"curl", "8.0.0-1", thirdParty, -1},
+
+		// A specific record that does not match leaves the general ones
+		{"linux-image-amd64", "6.6.0-1", stable, 700},
+		{"nginx", "1.22.1-9", stable, 700},
+
+		// Without a matching record the archive's default applies
+		{"curl", "8.5.0-2", backports, ButAutomaticUpgradesPriority},
+		{"curl", "8.6.0-1", experimental, NotAutomaticPriority},
+		{"curl", "8.0.0-1", Origin{Archive: "unstable", Codename: "sid"}, DefaultPriority},
+	}
+
+	for _, test := range tests {
+		if got := Priority(preferences, test.name, test.version, test.origin); got != test.want {
+			t.Errorf("Priority(%s %s from %s/%s) = %d, want %d", test.name, test.version, test.origin.Archive, test.origin.Component, got, test.want)
+		}
+	}
+}
+
+func TestMatchRelease(t *testing.T) {
+	origin := Origin{Archive: "jammy-updates", Codename: "jammy", Version: "22.04", Origin: "Ubuntu", Label: "Ubuntu", Component: "main"}
+
+	tests := []struct {
+		conditions string
+		want       bool
+	}{
+		{"jammy-updates", true},
+		{"jammy", true},
+		{"jammy-*", true},
+		{"focal", false},
+		{"a=jammy-updates,c=main", true},
+		{"o=Ubuntu,l=Ubuntu,v=22.04", true},
+		{`o="Ubuntu"`, true},
+		{"a=jammy-updates,c=universe", false},
+		{"a=jammy-updates,b=amd64", true},
+		{"x=unknown", false},
+	}
+
+	for _, test := range tests {
+		if got := matchRelease(test.conditions, origin); got != test.want {
+			t.Errorf("matchRelease(%q) = %v, want %v", test.conditions, got, test.want)
+		}
+	}
+}
+
+func TestReadPreferences(t *testing.T) {
+	root := t.TempDir()
+	etc := filepath.Join(root, "etc/apt")
+
+	files := map[string]string{
+		"preferences":                 "Package: a\nPin: release a=main\nPin-Priority: 100\n",
+		"preferences.d/20-second":     "Package: c\nPin: release a=main\nPin-Priority: 300\n",
+		"preferences.d/10-first.pref": "Package: b\nPin: release a=main\nPin-Priority: 200\n",
+		"preferences.d/ignored.bak":   "Package: d\nPin: release a=main\nPin-Priority: 400\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(etc, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	preferences, err := ReadPreferences(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, preference := range preferences {
+		names = append(names, preference.Packages...)
+	}
+
+	if got := strings.Join(names, " "); got != "a b c" {
+		t.Errorf("ReadPreferences() read packages %q, want %q", got, "a b c")
+	}
+}
@@ -0,0 +1,259 @@
+package hostapt
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/deb822"
+)
+
+// Source is one enabled source of the host's apt configuration; a deb822 stanza
+// may list several URIs and suites
+type Source struct {
+	Type       string // "deb" or "deb-src"
+	URIs       []string
+	Suites     []string
+	Components []string
+	SignedBy   string // Keyring paths or fingerprints, comma- or space-separated
+	SignedKey  string // Armored key embedded in a deb822 Signed-By field
+}
+
+// Keyrings returns the keyring paths of Signed-By; fingerprints are left out
+func (s Source) Keyrings() []string {
+	var keyrings []string
+
+	for _, keyring := range strings.FieldsFunc(s.SignedBy, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if strings.HasPrefix(keyring, "/") {
+			keyrings = append(keyrings, keyring)
+		}
+	}
+
+	return keyrings
+}
+
+// ReadSources parses sources.list and sources.list.d (one-line and deb822 formats)
+// below root, skipping disabled entries
+func ReadSources(root string) ([]Source, error) {
+	etc := filepath.Join(root, "etc/apt")
+	var sources []Source
+
+	if list, err := readListFile(filepath.Join(etc, "sources.list")); err == nil {
+		sources = append(sources, list...)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(etc, "sources.list.d"))
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	// apt reads the parts in alphabetical order
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(etc, "sources.list.d", name)
+		var parsed []Source
+
+		switch filepath.Ext(name) {
+		case ".list":
+			parsed, err = readListFile(path)
+		case ".sources":
+			parsed, err = readDeb822File(path)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, parsed...)
+	}
+
+	return sources, nil
+}
+
+// readListFile parses one-line style entries: deb [options] uri suite [component...]
+func readListFile(path string) ([]Source, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var sources []Source
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) < 3 || (fields[0] != "deb" && fields[0] != "deb-src") {
+			continue
+		}
+
+		source := Source{Type: fields[0]}
+		rest := fields[1:]
+
+		// Options are bracketed and may contain spaces: [arch=amd64 signed-by=/path]
+		if strings.HasPrefix(rest[0], "[") {
+			var options []string
+
+			for len(rest) > 0 {
+				option := rest[0]
+				rest = rest[1:]
+				options = append(options, strings.Trim(option, "[]"))
+
+				if strings.HasSuffix(option, "]") {
+					break
+				}
+			}
+
+			for _, option := range options {
+				if key, value, ok := strings.Cut(option, "="); ok && key == "signed-by" {
+					source.SignedBy = value
+				}
+			}
+		}
+
+		if len(rest) < 2 {
+			continue
+		}
+
+		source.URIs = []string{rest[0]}
+		source.Suites = []string{rest[1]}
+		source.Components = rest[2:]
+		sources = append(sources, source)
+	}
+
+	return sources, scanner.Err()
+}
+
+// readDeb822File parses a .sources file
+func readDeb822File(path string) ([]Source, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	paragraphs, err := deb822.Parse(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []Source
+
+	for _, paragraph := range paragraphs {
+		fields := make(map[string]string)
+
+		// Field names are case-insensitive
+		for key, value := range paragraph {
+			fields[strings.ToLower(key)] = value
+		}
+
+		if enabled := strings.ToLower(fields["enabled"]); enabled == "no" || enabled == "false" {
+			continue
+		}
+
+		// Signed-By holds either keyring paths or an embedded key block, whose empty
+		// lines are written as " ." continuation lines
+		signedBy, signedKey := strings.TrimSpace(fields["signed-by"]), ""
+
+		if strings.Contains(signedBy, "\n") {
+			lines := strings.Split(signedBy, "\n")
+
+			for i, line := range lines {
+				if line = strings.TrimSpace(line); line == "." {
+					line = ""
+				}
+
+				lines[i] = line
+			}
+
+			signedBy, signedKey = "", strings.Join(lines, "\n")+"\n"
+		}
+
+		for _, sourceType := range strings.Fields(fields["types"]) {
+			sources = append(sources, Source{
+				Type:       sourceType,
+				URIs:       strings.Fields(fields["uris"]),
+				Suites:     strings.Fields(fields["suites"]),
+				Components: strings.Fields(fields["components"]),
+				SignedBy:   signedBy,
+				SignedKey:  signedKey,
+			})
+		}
+	}
+
+	return sources, nil
+}
+
+// Keyrings returns the keyrings apt below root trusts, as paths on that system: the
+// legacy trusted.gpg, the trusted.gpg.d parts and every Signed-By of its sources
+func Keyrings(root string) ([]string, error) {
+	var keyrings []string
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		if seen[path] {
+			return
+		}
+
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			keyrings = append(keyrings, path)
+			seen[path] = true
+		}
+	}
+
+	add("/etc/apt/trusted.gpg")
+
+	parts, err := filepath.Glob(filepath.Join(root, "etc/apt/trusted.gpg.d/*"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(parts)
+
+	for _, part := range parts {
+		if ext := filepath.Ext(part); ext == ".gpg" || ext == ".asc" {
+			add("/etc/apt/trusted.gpg.d/" + filepath.Base(part))
+		}
+	}
+
+	sources, err := ReadSources(root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, source := range sources {
+		for _, keyring := range source.Keyrings() {
+			add(keyring)
+		}
+	}
+
+	return keyrings, nil
+}
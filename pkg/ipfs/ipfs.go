@@ -0,0 +1,185 @@
+package ipfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultAPI is the RPC address of a local Kubo node
+const DefaultAPI = "http://127.0.0.1:5001"
+
+// Client adds content through the Kubo RPC API; an IPFS Cluster proxy endpoint
+// speaks the same API and pins across the cluster
+type Client struct {
+	API string
+}
+
+// addEntry is one line of the newline-delimited JSON the add call returns
+type addEntry struct {
+	Name string
+	Hash string
+}
+
+// part is a file or directory sent to the add call
+type part struct {
+	name string // Slash-separated path inside the upload
+	path string // Local file; empty for directories
+}
+
+// AddFile adds and pins a single file, returning its CID
+func (c *Client) AddFile(path string) (string, error) {
+	name := filepath.Base(path)
+
+	return c.add([]part{{name: name, path: path}}, name)
+}
+
+// AddDirectory adds and pins the tree below root, skipping hidden entries and the
+// top-level ones in exclude, and returns the CID of the root directory
+func (c *Client) AddDirectory(root string, exclude ...string) (string, error) {
+	name := filepath.Base(filepath.Clean(root))
+	parts := []part{{name: name}}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") || slices.Contains(exclude, filepath.ToSlash(rel)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		entry := part{name: name + "/" + filepath.ToSlash(rel)}
+
+		if !info.IsDir() {
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			entry.path = path
+		}
+
+		parts = append(parts, entry)
+
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+
+	return c.add(parts, name)
+}
+
+// add streams parts as a multipart upload and returns the CID reported for rootName
+func (c *Client) add(parts []part, rootName string) (string, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	go func() {
+		writer.CloseWithError(writeParts(form, parts))
+	}()
+
+	query := url.Values{}
+	query.Set("pin", "true")
+	query.Set("cid-version", "1")
+
+	endpoint := strings.TrimSuffix(c.API, "/") + "/api/v0/add?" + query.Encode()
+	resp, err := http.Post(endpoint, form.FormDataContentType(), body)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to reach IPFS API %s: %w", c.API, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return "", fmt.Errorf("IPFS add failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	cid := ""
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+		var entry addEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", fmt.Errorf("invalid IPFS add response: %w", err)
+		}
+
+		if entry.Name == rootName {
+			cid = entry.Hash
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read IPFS add response: %w", err)
+	}
+
+	if cid == "" {
+		return "", fmt.Errorf("IPFS add response did not include %s", rootName)
+	}
+
+	return cid, nil
+}
+
+// writeParts writes directories as application/x-directory parts and files with their content
+func writeParts(form *multipart.Writer, parts []part) error {
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, url.QueryEscape(p.name)))
+
+		if p.path == "" {
+			header.Set("Content-Type", "application/x-directory")
+
+			if _, err := form.CreatePart(header); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		header.Set("Content-Type", "application/octet-stream")
+		w, err := form.CreatePart(header)
+
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(p.path)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, file)
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", p.path, err)
+		}
+	}
+
+	return form.Close()
+}
@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"portaptable/pkg/packageinfo"
+)
+
+// JournalName is the file recording packages as they complete during a download run,
+// until the run saves the manifest. Hidden, like all working state, so bundles skip it.
+const JournalName = ".manifest.journal"
+
+// ErrJournalTarget reports a journal left by a run of another distribution or set of
+// architectures, whose packages do not belong to this run
+var ErrJournalTarget = errors.New("the journal belongs to a run of another distribution or architecture")
+
+// JournalTarget heads a journal with the distribution and architectures of its run
+type JournalTarget struct {
+	Distribution  string   `json:"distribution"`
+	Architectures []string `json:"architectures"`
+}
+
+// Journal appends completed packages, one JSON line each, so an interrupted run
+// keeps the record of everything it fetched
+type Journal struct {
+	file *os.File
+}
+
+// OpenJournal opens the repository's journal for appending. Entries of an earlier run
+// of the same target are kept, and a line that run cut short is truncated; a journal
+// of another target is started afresh.
+func OpenJournal(repoPath string, target JournalTarget) (*Journal, error) {
+	file, err := os.OpenFile(filepath.Join(repoPath, JournalName), os.O_CREATE|os.O_RDWR, 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest journal: %w", err)
+	}
+
+	if err := resumeJournal(file, target); err != nil {
+		file.Close()
+
+		return nil, fmt.Errorf("failed to open manifest journal: %w", err)
+	}
+
+	return &Journal{file: file}, nil
+}
+
+// resumeJournal positions file after its last complete entry, writing the target
+// header first when the journal is new or belongs to another target
+func resumeJournal(file *os.File, target JournalTarget) error {
+	data, err := io.ReadAll(file)
+
+	if err != nil {
+		return err
+	}
+
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	var current JournalTarget
+
+	if json.Unmarshal(header, &current) != nil || !sameTarget(current, target) {
+		line, err := json.Marshal(target)
+
+		if err != nil {
+			return err
+		}
+
+		data = append(line, '\n')
+
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+
+		if _, err := file.WriteAt(data, 0); err != nil {
+			return err
+		}
+	}
+
+	// Entries only ever end in a newline; anything after the last one is torn
+	end := int64(bytes.LastIndexByte(data, '\n') + 1)
+
+	if err := file.Truncate(end); err != nil {
+		return err
+	}
+
+	_, err = file.Seek(end, io.SeekStart)
+
+	return err
+}
+
+// sameTarget reports whether two journal targets name the same run
+func sameTarget(a, b JournalTarget) bool {
+	return a.Distribution == b.Distribution && slices.Equal(a.Architectures, b.Architectures)
+}
+
+// Append records pkg and syncs it to disk before returning
+func (j *Journal) Append(pkg packageinfo.PackageInfo) error {
+	line, err := json.Marshal(pkg)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write manifest journal: %w", err)
+	}
+
+	return j.file.Sync()
+}
+
+// Close closes the journal, leaving the file in place
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// ReadJournal returns the packages recorded by an interrupted run of target, or none
+// when the last run finished. A line cut short by the interruption is ignored, and a
+// journal of another target yields ErrJournalTarget.
+func ReadJournal(repoPath string, target JournalTarget) ([]packageinfo.PackageInfo, error) {
+	file, err := os.Open(filepath.Join(repoPath, JournalName))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest journal: %w", err)
+	}
+
+	defer file.Close()
+
+	var packages []packageinfo.PackageInfo
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	if scanner.Scan() {
+		var current JournalTarget
+
+		if json.Unmarshal(scanner.Bytes(), &current) != nil || !sameTarget(current, target) {
+			return nil, ErrJournalTarget
+		}
+	}
+
+	for scanner.Scan() {
+		var pkg packageinfo.PackageInfo
+
+		if err := json.Unmarshal(scanner.Bytes(), &pkg); err == nil {
+			packages = append(packages, pkg)
+		}
+	}
+
+	return packages, scanner.Err()
+}
+
+// RemoveJournal deletes the journal once the manifest holds its entries
+func RemoveJournal(repoPath string) error {
+	if err := os.Remove(filepath.Join(repoPath, JournalName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove manifest journal: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"portaptable/pkg/packageinfo"
+)
+
+var jammy = JournalTarget{Distribution: "jammy", Architectures: []string{"amd64"}}
+
+// journal writes pkgs to a new journal of target in repo
+func journal(t *testing.T, repo string, target JournalTarget, pkgs ...string) {
+	t.Helper()
+
+	j, err := OpenJournal(repo, target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer j.Close()
+
+	for _, name := range pkgs {
+		if err := j.Append(packageinfo.PackageInfo{Name: name, Version: "1.0", Architecture: "amd64"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// names returns the names of the packages the journal of repo records for target
+func names(t *testing.T, repo string, target JournalTarget) []string {
+	t.Helper()
+
+	pkgs, err := ReadJournal(repo, target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+
+	return names
+}
+
+func TestJournalResume(t *testing.T) {
+	repo := t.TempDir()
+	journal(t, repo, jammy, "app", "lib")
+	journal(t, repo, jammy, "data")
+
+	if got := names(t, repo, jammy); len(got) != 3 || got[0] != "app" || got[2] != "data" {
+		t.Errorf("journal holds %q, want app, lib and data", got)
+	}
+}
+
+func TestJournalTornLine(t *testing.T) {
+	repo := t.TempDir()
+	journal(t, repo, jammy, "app")
+
+	// An interruption cut the second entry short
+	file, err := os.OpenFile(filepath.Join(repo, JournalName), os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.WriteString(`{"name":"li`)
+	file.Close()
+
+	journal(t, repo, jammy, "data")
+
+	if got := names(t, repo, jammy); len(got) != 2 || got[0] != "app" || got[1] != "data" {
+		t.Errorf("journal holds %q, want app and data", got)
+	}
+}
+
+func TestJournalOtherTarget(t *testing.T) {
+	repo := t.TempDir()
+	journal(t, repo, jammy, "app")
+
+	noble := JournalTarget{Distribution: "noble", Architectures: []string{"amd64"}}
+	arm := JournalTarget{Distribution: "jammy", Architectures: []string{"amd64", "arm64"}}
+
+	for _, target := range []JournalTarget{noble, arm} {
+		if _, err := ReadJournal(repo, target); !errors.Is(err, ErrJournalTarget) {
+			t.Errorf("ReadJournal() for %+v = %v, want %v", target, err, ErrJournalTarget)
+		}
+	}
+
+	// Opened for another target, the journal starts afresh
+	journal(t, repo, noble, "lib")
+
+	if got := names(t, repo, noble); len(got) != 1 || got[0] != "lib" {
+		t.Errorf("journal holds %q, want lib only", got)
+	}
+}
+
+func TestReadJournalMissing(t *testing.T) {
+	if got := names(t, t.TempDir(), jammy); len(got) != 0 {
+		t.Errorf("ReadJournal() without a journal = %q, want none", got)
+	}
+}
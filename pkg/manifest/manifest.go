@@ -1,13 +1,123 @@
 package manifest
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"portaptable/pkg/packageinfo"
 	"time"
 )
 
+// FileName is the name of the manifest inside a repository directory
+const FileName = "manifest.json"
+
 type Manifest struct {
-	CreatedAt    time.Time                 `json:"created_at"`
-	Architecture string                    `json:"architecture"`
-	Distribution string                    `json:"distribution"`
-	Packages     []packageinfo.PackageInfo `json:"packages"`
+	CreatedAt      time.Time                 `json:"created_at"`
+	Architecture   string                    `json:"architecture"`
+	Foreign        []string                  `json:"foreign_architectures,omitempty"`    // Multiarch trees served next to Architecture
+	Additional     []string                  `json:"additional_architectures,omitempty"` // Targets of other architectures, resolved independently
+	Distribution   string                    `json:"distribution"`
+	Requested      []string                  `json:"requested,omitempty"`        // Packages asked for, before dependency resolution
+	IndexLanguages []string                  `json:"index_languages,omitempty"`  // Translation-<language> indexes generated
+	RequireSHA256  bool                      `json:"require_sha256,omitempty"`   // Metadata carries SHA256 only; sources must provide it
+	Snapshot       *time.Time                `json:"archive_snapshot,omitempty"` // Time of the vendor archive snapshot downloaded from
+	Options        *DownloadOptions          `json:"download_options,omitempty"` // Options of the download, which daemon refreshes repeat
+	Packages       []packageinfo.PackageInfo `json:"packages"`
+	InstallOrder   [][]string                `json:"install_order,omitempty"` // Batches of pool files to install with dpkg -i in turn
+	IPFS           *IPFSRecord               `json:"ipfs,omitempty"`
+}
+
+// DownloadOptions are the source and selection options of a download; files are
+// recorded by absolute path
+type DownloadOptions struct {
+	Mirror            string   `json:"mirror,omitempty"`
+	Resolver          string   `json:"resolver,omitempty"`
+	Preset            string   `json:"preset,omitempty"`
+	Pockets           []string `json:"pockets,omitempty"`
+	Components        []string `json:"components,omitempty"`
+	Exclude           []string `json:"exclude,omitempty"`
+	ConfigFile        string   `json:"config_file,omitempty"`
+	ESMTokenFile      string   `json:"esm_token_file,omitempty"`
+	ESMServices       []string `json:"esm_services,omitempty"`
+	Backports         bool     `json:"backports,omitempty"`
+	BackportsPackages []string `json:"backports_packages,omitempty"`
+	PreferencesFile   string   `json:"preferences_file,omitempty"`
+	StatusFile        string   `json:"status_file,omitempty"`
+
+	// Selections beyond the requested packages
+	PackageRegexes     []string          `json:"package_regexes,omitempty"`
+	Sections           []string          `json:"sections,omitempty"`
+	Priorities         []string          `json:"priorities,omitempty"`
+	BaseSystem         bool              `json:"base_system,omitempty"`
+	Languages          []string          `json:"languages,omitempty"`
+	DevPackages        bool              `json:"dev_packages,omitempty"`
+	InstallerPackages  bool              `json:"installer_packages,omitempty"`
+	DebugSymbols       bool              `json:"debug_symbols,omitempty"`
+	Sources            bool              `json:"sources,omitempty"`
+	Changelogs         bool              `json:"changelogs,omitempty"`
+	DeniedLicenses     []string          `json:"denied_licenses,omitempty"`
+	StrictLicenses     bool              `json:"strict_licenses,omitempty"`
+	MinimalRules       []string          `json:"minimal_rules,omitempty"`
+	MinimalKeep        []string          `json:"minimal_keep,omitempty"`
+	PreferredProviders []string          `json:"preferred_providers,omitempty"`
+	Alternatives       map[string]string `json:"alternatives,omitempty"`
+	AllowConflicts     bool              `json:"allow_conflicts,omitempty"`
+	FilterPlugins      []string          `json:"filter_plugins,omitempty"`
+	MaxPackageSize     int64             `json:"max_package_size,omitempty"`
+	MaxTotalSize       int64             `json:"max_total_size,omitempty"`
+	SizeLimitAction    string            `json:"size_limit_action,omitempty"`
+}
+
+// IPFSRecord holds the content identifiers of the last export added to IPFS
+type IPFSRecord struct {
+	Bundle        string    `json:"bundle,omitempty"`
+	BundleCID     string    `json:"bundle_cid,omitempty"`
+	RepositoryCID string    `json:"repository_cid,omitempty"` // Excludes this record, which is written afterwards
+	AddedAt       time.Time `json:"added_at"`
+}
+
+// Architectures returns the primary architecture followed by the foreign and the
+// additional ones, each of which has an index tree
+func (m *Manifest) Architectures() []string {
+	return append(append([]string{m.Architecture}, m.Foreign...), m.Additional...)
+}
+
+// Load reads the manifest of the repository at repoPath
+func Load(repoPath string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, FileName))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	mfest := &Manifest{}
+
+	if err := json.Unmarshal(data, mfest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return mfest, nil
+}
+
+// Save writes the manifest into the repository at repoPath
+func Save(repoPath string, mfest *Manifest) error {
+	data, err := json.MarshalIndent(mfest, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	// Readers, such as a serving daemon, never see a partly written manifest
+	path := filepath.Join(repoPath, FileName)
+
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,117 @@
+package namefilter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter selects package names by include and exclude patterns. A name matches
+// when it matches any include pattern (or there are none) and no exclude pattern.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// New compiles include and exclude patterns into a Filter
+func New(include, exclude []string) (*Filter, error) {
+	var f Filter
+	var err error
+
+	if f.include, err = compileAll(include); err != nil {
+		return nil, err
+	}
+
+	if f.exclude, err = compileAll(exclude); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Empty reports whether the filter lets every name through
+func (f *Filter) Empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// Match reports whether name is selected by the filter
+func (f *Filter) Match(name string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+
+	return !matchAny(f.exclude, name)
+}
+
+func matchAny(patterns []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		re, err := Compile(pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// Compile turns a pattern into a regular expression. Patterns anchored with ^ or $
+// are regular expressions; anything else is a shell glob matched against the whole name.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "^") || strings.HasSuffix(pattern, "$") {
+		re, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+
+		return re, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: unterminated character class", pattern)
+			}
+
+			class := pattern[i+1 : i+end]
+
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,125 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// quiet suppresses informational and warning lines, keeping failures and summaries
+var quiet bool
+
+// redraw reports whether stdout is a terminal, where progress lines are redrawn in place
+var redraw = isTerminal(os.Stdout)
+
+// colorEnabled is decided once from the terminal and NO_COLOR; Configure can turn it off
+var colorEnabled = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+
+// Configure applies the --no-color flag
+func Configure(noColor bool) {
+	if noColor {
+		colorEnabled = false
+	}
+
+	return
+}
+
+// SetQuiet applies the --quiet flag
+func SetQuiet(enabled bool) {
+	quiet = enabled
+
+	return
+}
+
+// Quiet reports whether only failures and final summaries should be printed
+func Quiet() bool {
+	return quiet
+}
+
+// isTerminal reports whether f is attached to a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+func printColored(w io.Writer, color, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	if colorEnabled {
+		message = color + message + colorReset
+	}
+
+	fmt.Fprintln(w, message)
+
+	return
+}
+
+// Info prints an uncolored progress line unless quiet
+func Info(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, format+"\n", args...)
+}
+
+// Success prints a green status line, e.g. for a downloaded package
+func Success(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+
+	printColored(os.Stdout, colorGreen, format, args...)
+}
+
+// Warning prints a yellow status line, e.g. for a skipped package
+func Warning(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+
+	printColored(os.Stdout, colorYellow, format, args...)
+}
+
+// Failure prints a red status line, e.g. for a failed package. Failures are
+// written to stderr when quiet so cron mails only contain errors and the summary.
+func Failure(format string, args ...interface{}) {
+	if quiet {
+		printColored(os.Stderr, colorRed, format, args...)
+
+		return
+	}
+
+	printColored(os.Stdout, colorRed, format, args...)
+}
+
+// Progress redraws a one-line status, e.g. a running count, on a terminal. Nothing is
+// printed when quiet or when stdout is not a terminal, keeping logs free of it.
+func Progress(format string, args ...interface{}) {
+	if quiet || !redraw {
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "\r\033[K"+format, args...)
+}
+
+// ProgressDone clears the progress line so regular output can follow
+func ProgressDone() {
+	if quiet || !redraw {
+		return
+	}
+
+	fmt.Fprint(os.Stdout, "\r\033[K")
+}
@@ -1,10 +1,38 @@
 package packageinfo
 
+// Package types other than regular binary packages
+const (
+	TypeUdeb   = "udeb"
+	TypeSource = "source"
+)
+
 type PackageInfo struct {
-	Name         string `json:"name"`
-	Version      string `json:"version"`
-	Architecture string `json:"architecture"`
-	Filename     string `json:"filename"`
-	Size         int64  `json:"size"`
-	Downloaded   bool   `json:"downloaded"`
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Architecture  string `json:"architecture"`
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"source_version,omitempty"`
+	Component     string `json:"component,omitempty"`
+	Filename      string `json:"filename"`
+	Size          int64  `json:"size"`
+	MD5sum        string `json:"md5sum,omitempty"`
+	SHA1          string `json:"sha1,omitempty"`
+	SHA256        string `json:"sha256,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+	Downloaded    bool   `json:"downloaded"`
+
+	// Failure is why a package is not downloaded: the error of its last download
+	// attempt, or why it was skipped or rejected
+	Failure string `json:"failure,omitempty"`
+
+	// MTime is the pool file's modification time (Unix nanoseconds) when its checksums
+	// were last computed; verify trusts an unchanged size and MTime without re-hashing
+	MTime int64 `json:"mtime,omitempty"`
+
+	// Type is "udeb" for debian-installer packages, "source" for the files of source
+	// packages and empty for regular packages
+	Type string `json:"type,omitempty"`
+
+	// Control holds the package's index fields (Depends, Section, Description, ...)
+	Control map[string]string `json:"control,omitempty"`
 }
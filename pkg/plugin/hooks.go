@@ -0,0 +1,44 @@
+package plugin
+
+// FilterParams asks a resolver filter which of the resolved packages to keep
+type FilterParams struct {
+	Distribution string   `json:"distribution"`
+	Architecture string   `json:"architecture"`
+	Requested    []string `json:"requested"`
+	Packages     []string `json:"packages"`
+}
+
+// FilterResult lists the packages to keep; rejected packages are reported with a reason
+type FilterResult struct {
+	Packages []string    `json:"packages"`
+	Rejected []Rejection `json:"rejected,omitempty"`
+}
+
+// Rejection explains why a filter dropped a package
+type Rejection struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// TransportParams asks a transport to fetch URL into the local file Target
+type TransportParams struct {
+	URL    string `json:"url"`
+	Target string `json:"target"`
+}
+
+// TransportResult reports whether the URL exists; a missing file is not an error
+type TransportResult struct {
+	NotFound bool `json:"not_found,omitempty"`
+}
+
+// ExportParams asks an exporter to write the repository in its own format to Output
+type ExportParams struct {
+	Repository string `json:"repository"`
+	Manifest   string `json:"manifest"`
+	Output     string `json:"output"`
+}
+
+// ExportResult lists the files the exporter wrote
+type ExportResult struct {
+	Files []string `json:"files"`
+}
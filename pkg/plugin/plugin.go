@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Version is the protocol version sent with every request
+const Version = 1
+
+// Prefix starts the executable name of every plugin: portaptable-<kind>-<name>
+const Prefix = "portaptable-"
+
+// Extension points
+const (
+	KindFilter    = "filter"
+	KindTransport = "transport"
+	KindExport    = "export"
+)
+
+// Plugin is an external executable that receives one JSON request on stdin and
+// answers with one JSON response on stdout; its stderr is passed through
+type Plugin struct {
+	Kind string
+	Name string
+	Path string
+}
+
+// request is the envelope written to the plugin
+type request struct {
+	Version int         `json:"version"`
+	Hook    string      `json:"hook"`
+	Params  interface{} `json:"params"`
+}
+
+// response is the envelope read back; a non-empty Error fails the call
+type response struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Find locates a plugin by name, which is either a path or the <name> part of
+// portaptable-<kind>-<name> on PATH
+func Find(kind, name string) (*Plugin, error) {
+	path := name
+
+	if !strings.Contains(name, "/") {
+		found, err := exec.LookPath(Prefix + kind + "-" + name)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s plugin %q not found: %w", kind, name, err)
+		}
+
+		path = found
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%s plugin %q not found: %w", kind, name, err)
+	}
+
+	return &Plugin{Kind: kind, Name: name, Path: path}, nil
+}
+
+// Call runs the plugin with params and decodes its result into result (which may be nil)
+func (p *Plugin) Call(params, result interface{}) error {
+	input, err := json.Marshal(request{Version: Version, Hook: p.Kind, Params: params})
+
+	if err != nil {
+		return fmt.Errorf("failed to encode %s plugin request: %w", p.Kind, err)
+	}
+
+	var stdout bytes.Buffer
+
+	cmd := exec.Command(p.Path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s plugin %s failed: %w", p.Kind, p.Name, err)
+	}
+
+	var resp response
+
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return fmt.Errorf("%s plugin %s returned invalid JSON: %w", p.Kind, p.Name, err)
+	}
+
+	if resp.Error != "" {
+		return fmt.Errorf("%s plugin %s: %s", p.Kind, p.Name, resp.Error)
+	}
+
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("%s plugin %s returned an invalid result: %w", p.Kind, p.Name, err)
+	}
+
+	return nil
+}
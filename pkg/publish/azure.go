@@ -0,0 +1,174 @@
+package publish
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"portaptable/pkg/checksum"
+)
+
+// azureAPIVersion is the Blob service REST API version requests are made against
+const azureAPIVersion = "2021-08-06"
+
+// azureStore uploads block blobs to an Azure Storage container authorized by a SAS token
+type azureStore struct {
+	endpoint  string
+	container string
+	prefix    string
+	sas       url.Values
+}
+
+func newAzureStore(container, prefix string, options Options) (*azureStore, error) {
+	store := &azureStore{endpoint: options.Endpoint, container: container, prefix: prefix}
+
+	if store.endpoint == "" {
+		account := options.Account
+
+		if account == "" {
+			account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+		}
+
+		if account == "" {
+			return nil, fmt.Errorf("set --account or AZURE_STORAGE_ACCOUNT for az:// destinations")
+		}
+
+		store.endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+
+	store.endpoint = strings.TrimSuffix(store.endpoint, "/")
+
+	sas, err := url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+
+	if err != nil || sas.Get("sig") == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_SAS_TOKEN must hold a container SAS token with read, write, list and delete permissions")
+	}
+
+	store.sas = sas
+
+	return store, nil
+}
+
+func (s *azureStore) blobURL(key string, query url.Values) string {
+	name := key
+
+	if s.prefix != "" {
+		name = s.prefix + "/" + key
+	}
+
+	path := "/" + url.PathEscape(s.container)
+
+	if key != "" {
+		path += "/" + escapePath(name)
+	}
+
+	for name, values := range s.sas {
+		query[name] = values
+	}
+
+	return s.endpoint + path + "?" + query.Encode()
+}
+
+type azureBlobList struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string
+			Properties struct {
+				ContentMD5 string `xml:"Content-MD5"`
+			}
+		}
+	}
+	NextMarker string
+}
+
+func (s *azureStore) List() (map[string]string, error) {
+	objects := make(map[string]string)
+	marker := ""
+
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+
+		if s.prefix != "" {
+			query.Set("prefix", s.prefix+"/")
+		}
+
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := s.do(http.MethodGet, s.blobURL("", query), nil, 0, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var list azureBlobList
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse container listing: %w", err)
+		}
+
+		for _, blob := range list.Blobs.Blob {
+			objects[strings.TrimPrefix(blob.Name, s.prefix+"/")] = base64ToHex(blob.Properties.ContentMD5)
+		}
+
+		if list.NextMarker == "" {
+			return objects, nil
+		}
+
+		marker = list.NextMarker
+	}
+}
+
+func (s *azureStore) Put(key string, body io.Reader, sums checksum.Sums, contentType string) error {
+	headers := map[string]string{
+		"x-ms-blob-type":         "BlockBlob",
+		"x-ms-blob-content-type": contentType,
+		"Content-MD5":            hexToBase64(sums.MD5),
+	}
+
+	resp, err := s.do(http.MethodPut, s.blobURL(key, url.Values{}), body, sums.Size, headers)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *azureStore) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.blobURL(key, url.Values{}), nil, 0, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *azureStore) do(method, target string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("x-ms-version", azureAPIVersion)
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return checkResponse(http.DefaultClient.Do(req))
+}
@@ -0,0 +1,196 @@
+package publish
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"portaptable/pkg/checksum"
+)
+
+// gcsStore uploads through the Google Cloud Storage JSON API with an OAuth2 access token
+type gcsStore struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	token    string
+}
+
+func newGCSStore(bucket, prefix string, options Options) (*gcsStore, error) {
+	store := &gcsStore{endpoint: options.Endpoint, bucket: bucket, prefix: prefix}
+
+	if store.endpoint == "" {
+		store.endpoint = "https://storage.googleapis.com"
+	}
+
+	store.endpoint = strings.TrimSuffix(store.endpoint, "/")
+
+	// Prefer an explicit token; otherwise ask the gcloud CLI for the active account's
+	store.token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+
+	if store.token == "" {
+		out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+
+		if err != nil {
+			return nil, fmt.Errorf("set GOOGLE_OAUTH_ACCESS_TOKEN or log in with gcloud: %w", err)
+		}
+
+		store.token = strings.TrimSpace(string(out))
+	}
+
+	return store, nil
+}
+
+func (s *gcsStore) objectName(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+
+	return s.prefix + "/" + key
+}
+
+type gcsObjectList struct {
+	Items []struct {
+		Name    string `json:"name"`
+		MD5Hash string `json:"md5Hash"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (s *gcsStore) List() (map[string]string, error) {
+	objects := make(map[string]string)
+	query := url.Values{"fields": {"items(name,md5Hash),nextPageToken"}}
+
+	if s.prefix != "" {
+		query.Set("prefix", s.prefix+"/")
+	}
+
+	for {
+		resp, err := s.do(http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode()), nil, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var list gcsObjectList
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+
+		for _, item := range list.Items {
+			objects[strings.TrimPrefix(item.Name, s.prefix+"/")] = base64ToHex(item.MD5Hash)
+		}
+
+		if list.NextPageToken == "" {
+			return objects, nil
+		}
+
+		query.Set("pageToken", list.NextPageToken)
+	}
+}
+
+func (s *gcsStore) Put(key string, body io.Reader, sums checksum.Sums, contentType string) error {
+	query := url.Values{"uploadType": {"media"}, "name": {s.objectName(key)}}
+	target := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode())
+
+	req, err := http.NewRequest(http.MethodPost, target, body)
+
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = sums.Size
+	req.Header.Set("Content-Type", contentType)
+
+	// The service rejects the upload if the content arrives corrupted
+	req.Header.Set("X-Goog-Hash", "md5="+hexToBase64(sums.MD5))
+
+	resp, err := s.send(req)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *gcsStore) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.endpoint, url.PathEscape(s.bucket), url.PathEscape(s.objectName(key))), nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *gcsStore) do(method, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return s.send(req)
+}
+
+func (s *gcsStore) send(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	return checkResponse(http.DefaultClient.Do(req))
+}
+
+// checkResponse turns HTTP error statuses into errors carrying the service's message
+func checkResponse(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp, nil
+}
+
+// base64ToHex converts the base64 MD5 digests of GCS and Azure to the hex form used locally
+func base64ToHex(value string) string {
+	digest, err := base64.StdEncoding.DecodeString(value)
+
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(digest)
+}
+
+func hexToBase64(value string) string {
+	digest, err := hex.DecodeString(value)
+
+	if err != nil {
+		return ""
+	}
+
+	return base64.StdEncoding.EncodeToString(digest)
+}
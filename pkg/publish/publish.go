@@ -0,0 +1,229 @@
+package publish
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/snapshot"
+)
+
+// Store is an object store a repository can be published to. Keys are relative to
+// the store's prefix and use forward slashes.
+type Store interface {
+	// List returns the MD5 (hex) of every object below the prefix, keyed by object key
+	List() (map[string]string, error)
+
+	// Put uploads an object; sums describe body so the store can verify the upload
+	Put(key string, body io.Reader, sums checksum.Sums, contentType string) error
+
+	// Delete removes an object
+	Delete(key string) error
+}
+
+// Result counts what a Sync did
+type Result struct {
+	Uploaded int
+	Skipped  int
+	Deleted  int
+}
+
+// contentTypes maps file extensions to the content types apt and browsers expect
+var contentTypes = map[string]string{
+	".deb":  "application/vnd.debian.binary-package",
+	".udeb": "application/vnd.debian.binary-package",
+	".ddeb": "application/vnd.debian.binary-package",
+	".gz":   "application/gzip",
+	".xz":   "application/x-xz",
+	".json": "application/json",
+	".sh":   "text/x-shellscript",
+	".asc":  "application/pgp-signature",
+}
+
+// ContentType returns the content type an object is served with
+func ContentType(key string) string {
+	switch base := path.Base(key); {
+	case base == "Release.gpg":
+		return "application/pgp-signature"
+	case strings.HasSuffix(base, "-keyring.gpg"):
+		return "application/pgp-keys"
+	}
+
+	if contentType, ok := contentTypes[path.Ext(key)]; ok {
+		return contentType
+	}
+
+	// Release, InRelease, Packages and changelogs are plain text
+	return "text/plain; charset=utf-8"
+}
+
+// Sync uploads the repository files that are missing or differ in the store, and
+// with deleteStale removes objects the repository no longer has. Package files go
+// first and Release files last, so clients never see metadata referencing missing files.
+func Sync(store Store, repoPath string, deleteStale bool, progress func(format string, args ...interface{})) (Result, error) {
+	var result Result
+
+	remote, err := store.List()
+
+	if err != nil {
+		return result, err
+	}
+
+	keys, err := localFiles(repoPath)
+
+	if err != nil {
+		return result, err
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool { return uploadRank(keys[i]) < uploadRank(keys[j]) })
+
+	local := make(map[string]bool, len(keys))
+
+	for _, key := range keys {
+		local[key] = true
+		filePath := filepath.Join(repoPath, filepath.FromSlash(key))
+
+		sums, err := checksum.File(filePath)
+
+		if err != nil {
+			return result, err
+		}
+
+		if remote[key] == sums.MD5 {
+			result.Skipped++
+
+			continue
+		}
+
+		progress("Uploading %s", key)
+
+		if err := putFile(store, key, filePath, sums); err != nil {
+			return result, fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+
+		result.Uploaded++
+	}
+
+	if !deleteStale {
+		return result, nil
+	}
+
+	for key := range remote {
+		if local[key] {
+			continue
+		}
+
+		progress("Deleting %s", key)
+
+		if err := store.Delete(key); err != nil {
+			return result, fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+
+		result.Deleted++
+	}
+
+	return result, nil
+}
+
+func putFile(store Store, key, filePath string, sums checksum.Sums) error {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return store.Put(key, file, sums, ContentType(key))
+}
+
+// uploadRank orders uploads: pool files, then indexes, then the Release files
+func uploadRank(key string) int {
+	switch base := path.Base(key); {
+	case strings.HasPrefix(key, "pool/"):
+		return 0
+	case strings.HasPrefix(key, "dists/") && (base == "Release" || base == "InRelease" || base == "Release.gpg"):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// localFiles lists the repository files to publish; hidden working state stays local
+func localFiles(repoPath string) ([]string, error) {
+	var keys []string
+
+	err := filepath.Walk(repoPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(repoPath, filePath)
+
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// Snapshots hard-link the pool; uploaded, each would store another copy of it
+		if strings.HasPrefix(info.Name(), ".") || strings.HasSuffix(info.Name(), ".tmp") || rel == snapshot.Dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			keys = append(keys, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repository files: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Open returns the store for a destination URL: s3://bucket/prefix, gs://bucket/prefix
+// or az://container/prefix
+func Open(destination string, options Options) (Store, error) {
+	scheme, rest, found := strings.Cut(destination, "://")
+
+	if !found {
+		return nil, fmt.Errorf("invalid destination %q (expected e.g. s3://bucket/prefix)", destination)
+	}
+
+	bucket, prefix, _ := strings.Cut(rest, "/")
+
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid destination %q: missing bucket", destination)
+	}
+
+	prefix = strings.Trim(prefix, "/")
+
+	switch scheme {
+	case "s3":
+		return newS3Store(bucket, prefix, options)
+	case "gs":
+		return newGCSStore(bucket, prefix, options)
+	case "az":
+		return newAzureStore(bucket, prefix, options)
+	}
+
+	return nil, fmt.Errorf("unsupported destination scheme %q", scheme)
+}
+
+// Options configure store access; empty values fall back to the provider's environment variables
+type Options struct {
+	Endpoint string
+	Region   string // S3 only
+	Account  string // Azure storage account
+}
@@ -0,0 +1,43 @@
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalFiles(t *testing.T) {
+	repo := t.TempDir()
+
+	for _, name := range []string{
+		"manifest.json",
+		"pool/app_1.0_amd64.deb",
+		"dists/jammy/Release",
+		"dists/jammy/Release.tmp",
+		".cache/indexes/Packages",
+		"snapshots/before/pool/app_1.0_amd64.deb",
+	} {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := localFiles(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"dists/jammy/Release", "manifest.json", "pool/app_1.0_amd64.deb"}
+
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("localFiles() = %q, want %q", keys, want)
+	}
+}
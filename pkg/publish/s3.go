@@ -0,0 +1,283 @@
+package publish
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"portaptable/pkg/checksum"
+)
+
+// emptySHA256 is the payload hash of requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// now is the clock requests are signed with
+var now = time.Now
+
+// s3Store talks to S3 or an S3-compatible store (MinIO, Ceph RGW, ...) with
+// path-style requests signed with AWS Signature Version 4
+type s3Store struct {
+	endpoint     string
+	region       string
+	bucket       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func newS3Store(bucket, prefix string, options Options) (*s3Store, error) {
+	store := &s3Store{
+		endpoint:     options.Endpoint,
+		region:       options.Region,
+		bucket:       bucket,
+		prefix:       prefix,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if store.accessKey == "" || store.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	if store.region == "" {
+		store.region = os.Getenv("AWS_REGION")
+	}
+
+	if store.region == "" {
+		store.region = "us-east-1"
+	}
+
+	if store.endpoint == "" {
+		store.endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	if store.endpoint == "" {
+		store.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", store.region)
+	}
+
+	store.endpoint = strings.TrimSuffix(store.endpoint, "/")
+
+	return store, nil
+}
+
+// objectKey returns the full key of a repository path
+func (s *s3Store) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+
+	return s.prefix + "/" + key
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string
+		ETag string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) List() (map[string]string, error) {
+	objects := make(map[string]string)
+	query := url.Values{"list-type": {"2"}}
+
+	if s.prefix != "" {
+		query.Set("prefix", s.prefix+"/")
+	}
+
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, emptySHA256, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			key := strings.TrimPrefix(object.Key, s.prefix+"/")
+
+			// Single-part uploads have the MD5 of the content as ETag
+			objects[key] = strings.Trim(object.ETag, `"`)
+		}
+
+		if !result.IsTruncated {
+			return objects, nil
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *s3Store) Put(key string, body io.Reader, sums checksum.Sums, contentType string) error {
+	headers := map[string]string{"Content-Type": contentType}
+	resp, err := s.doSized(http.MethodPut, s.objectKey(key), nil, body, sums.Size, sums.SHA256, headers)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *s3Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.objectKey(key), nil, nil, emptySHA256, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *s3Store) do(method, key string, query url.Values, body io.Reader, payloadHash string, headers map[string]string) (*http.Response, error) {
+	return s.doSized(method, key, query, body, 0, payloadHash, headers)
+}
+
+// doSized sends a signed request for an object key (or the bucket when key is empty)
+func (s *s3Store) doSized(method, key string, query url.Values, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	canonicalURI := "/" + escapePath(s.bucket)
+
+	if key != "" {
+		canonicalURI += "/" + escapePath(key)
+	}
+
+	rawQuery := canonicalQuery(query)
+	req, err := http.NewRequest(method, s.endpoint+canonicalURI+queryPrefix(rawQuery), body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = size
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	s.sign(req, canonicalURI, rawQuery, payloadHash)
+
+	return checkResponse(http.DefaultClient.Do(req))
+}
+
+// sign adds the AWS Signature Version 4 headers to req
+func (s *s3Store) sign(req *http.Request, canonicalURI, rawQuery, payloadHash string) {
+	signedAt := now().UTC()
+	amzDate := signedAt.Format("20060102T150405Z")
+	date := signedAt.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		signed[strings.ToLower(name)] = strings.TrimSpace(values[0])
+	}
+
+	names := make([]string, 0, len(signed))
+
+	for name := range signed {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// escapePath URI-encodes every byte of a key except unreserved characters and slashes
+func escapePath(key string) string {
+	var escaped strings.Builder
+
+	for _, b := range []byte(key) {
+		if b == '/' || b == '-' || b == '_' || b == '.' || b == '~' ||
+			('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+
+	for name := range query {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+
+	for _, name := range names {
+		parts = append(parts, escapePath(name)+"="+strings.ReplaceAll(escapePath(query.Get(name)), "/", "%2F"))
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func queryPrefix(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	return "?" + rawQuery
+}
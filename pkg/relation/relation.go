@@ -0,0 +1,136 @@
+package relation
+
+import (
+	"strings"
+
+	"portaptable/pkg/debversion"
+)
+
+// Relation is one alternative of a dependency: a package name with an optional
+// architecture qualifier and version constraint
+type Relation struct {
+	Name         string
+	Architecture string // Qualifier after ':' (any, native or an architecture); empty when absent
+	Operator     string // <<, <=, =, >= or >>; empty allows any version
+	Version      string
+}
+
+// Group is one comma-separated dependency, satisfied by any of its alternatives
+type Group []Relation
+
+// operators lists the relation operators, longest first so "<<" is not read as "<"
+var operators = []string{"<<", "<=", ">=", ">>", "=", "<", ">"}
+
+// Parse parses a Depends-style field value into its groups. Architecture
+// restrictions ([amd64]) and build profiles (<!nocheck>) are dropped.
+func Parse(value string) []Group {
+	var groups []Group
+
+	for _, field := range strings.Split(value, ",") {
+		var group Group
+
+		for _, alternative := range strings.Split(field, "|") {
+			if r, ok := parseRelation(alternative); ok {
+				group = append(group, r)
+			}
+		}
+
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// parseRelation parses "name[:arch] [(op version)] [[archs]] [<profiles>]"
+func parseRelation(text string) (Relation, bool) {
+	if i := strings.IndexAny(text, "[<"); i >= 0 && !strings.Contains(text[:i], "(") {
+		text = text[:i]
+	} else if i := strings.Index(text, ")"); i >= 0 {
+		text = text[:i+1]
+	}
+
+	var r Relation
+
+	name, constraint, _ := strings.Cut(text, "(")
+	r.Name, r.Architecture, _ = strings.Cut(strings.TrimSpace(name), ":")
+
+	if r.Name == "" {
+		return r, false
+	}
+
+	constraint = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(constraint), ")"))
+
+	for _, op := range operators {
+		if version, ok := strings.CutPrefix(constraint, op); ok {
+			r.Operator, r.Version = op, strings.TrimSpace(version)
+
+			break
+		}
+	}
+
+	// The deprecated "<" and ">" mean "<=" and ">="
+	switch r.Operator {
+	case "<":
+		r.Operator = "<="
+	case ">":
+		r.Operator = ">="
+	}
+
+	return r, true
+}
+
+// String returns the relation in control file syntax
+func (r Relation) String() string {
+	text := r.Name
+
+	if r.Architecture != "" {
+		text += ":" + r.Architecture
+	}
+
+	if r.Operator != "" {
+		text += " (" + r.Operator + " " + r.Version + ")"
+	}
+
+	return text
+}
+
+// String returns the group in control file syntax
+func (g Group) String() string {
+	alternatives := make([]string, len(g))
+
+	for i, r := range g {
+		alternatives[i] = r.String()
+	}
+
+	return strings.Join(alternatives, " | ")
+}
+
+// SatisfiedBy reports whether version meets the relation's version constraint
+func (r Relation) SatisfiedBy(version string) bool {
+	if r.Operator == "" {
+		return true
+	}
+
+	if version == "" {
+		return false
+	}
+
+	result := debversion.Compare(version, r.Version)
+
+	switch r.Operator {
+	case "<<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case "=":
+		return result == 0
+	case ">=":
+		return result >= 0
+	case ">>":
+		return result > 0
+	}
+
+	return false
+}
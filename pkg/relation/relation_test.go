@@ -0,0 +1,81 @@
+package relation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []Group
+	}{
+		{"", nil},
+		{"libc6", []Group{{{Name: "libc6"}}}},
+		{"libc6 (>= 2.34), zlib1g", []Group{
+			{{Name: "libc6", Operator: ">=", Version: "2.34"}},
+			{{Name: "zlib1g"}},
+		}},
+		{"default-mta | mail-transport-agent", []Group{
+			{{Name: "default-mta"}, {Name: "mail-transport-agent"}},
+		}},
+		{"python3:any (>= 3.10~)", []Group{
+			{{Name: "python3", Architecture: "any", Operator: ">=", Version: "3.10~"}},
+		}},
+		{"libfoo (<< 2.0) [amd64 arm64] <!nocheck>", []Group{
+			{{Name: "libfoo", Operator: "<<", Version: "2.0"}},
+		}},
+		{"debhelper-compat (= 13) <!stage1>, gcc [!armel]", []Group{
+			{{Name: "debhelper-compat", Operator: "=", Version: "13"}},
+			{{Name: "gcc"}},
+		}},
+		{"old (< 1.0), older (> 2.0)", []Group{
+			{{Name: "old", Operator: "<=", Version: "1.0"}},
+			{{Name: "older", Operator: ">=", Version: "2.0"}},
+		}},
+		{"foo(>=1.0)", []Group{{{Name: "foo", Operator: ">=", Version: "1.0"}}}},
+	}
+
+	for _, test := range tests {
+		if got := Parse(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestGroupString(t *testing.T) {
+	value := "libc6:amd64 (>= 2.34) | libc6.1"
+
+	if got := Parse(value)[0].String(); got != value {
+		t.Errorf("String() = %q, want %q", got, value)
+	}
+}
+
+func TestSatisfiedBy(t *testing.T) {
+	tests := []struct {
+		relation string
+		version  string
+		want     bool
+	}{
+		{"foo", "", true},
+		{"foo", "1.0", true},
+		{"foo (>= 1.0)", "", false},
+		{"foo (>= 1.0)", "1.0", true},
+		{"foo (>= 1.0)", "1.0~rc1", false},
+		{"foo (<< 2.0)", "1.9", true},
+		{"foo (<< 2.0)", "2.0", false},
+		{"foo (<= 2.0)", "2.0", true},
+		{"foo (= 1:1.0-1)", "1:1.0-1", true},
+		{"foo (= 1:1.0-1)", "1.0-1", false},
+		{"foo (>> 1.0)", "1.0", false},
+		{"foo (>> 1.0)", "1.0+b1", true},
+	}
+
+	for _, test := range tests {
+		r := Parse(test.relation)[0][0]
+
+		if got := r.SatisfiedBy(test.version); got != test.want {
+			t.Errorf("%s satisfied by %q = %v, want %v", test.relation, test.version, got, test.want)
+		}
+	}
+}
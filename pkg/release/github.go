@@ -0,0 +1,71 @@
+package release
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+type github struct {
+	api        string
+	repository string
+	token      string
+}
+
+type githubRelease struct {
+	ID        int64  `json:"id"`
+	UploadURL string `json:"upload_url"`
+	Assets    []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+func (g *github) headers() map[string]string {
+	return map[string]string{
+		"Authorization": "Bearer " + g.token,
+		"Accept":        "application/vnd.github+json",
+	}
+}
+
+func (g *github) Upload(tag string, assets []string) error {
+	var rel githubRelease
+	base := fmt.Sprintf("%s/repos/%s/releases", g.api, g.repository)
+
+	status, err := request(http.MethodGet, base+"/tags/"+url.PathEscape(tag), g.headers(), nil, &rel)
+
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusNotFound {
+		payload := map[string]string{"tag_name": tag, "name": tag}
+
+		if _, err := request(http.MethodPost, base, g.headers(), payload, &rel); err != nil {
+			return fmt.Errorf("failed to create release %s: %w", tag, err)
+		}
+	}
+
+	// upload_url is a URI template such as .../assets{?name,label}
+	uploadURL, _, _ := strings.Cut(rel.UploadURL, "{")
+
+	for _, asset := range assets {
+		name := filepath.Base(asset)
+
+		for _, existing := range rel.Assets {
+			if existing.Name == name {
+				if _, err := request(http.MethodDelete, fmt.Sprintf("%s/assets/%d", base, existing.ID), g.headers(), nil, nil); err != nil {
+					return fmt.Errorf("failed to replace asset %s: %w", name, err)
+				}
+			}
+		}
+
+		if err := uploadFile(http.MethodPost, uploadURL+"?name="+url.QueryEscape(name), asset, g.headers(), nil); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package release
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+)
+
+// gitlabPackage is the generic package registry entry holding uploaded assets
+const gitlabPackage = "portaptable"
+
+type gitlab struct {
+	api     string
+	project string
+	token   string
+	ref     string
+}
+
+type gitlabLink struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (g *gitlab) headers() map[string]string {
+	return map[string]string{"PRIVATE-TOKEN": g.token}
+}
+
+// Upload stores assets in the project's generic package registry and links them
+// from the release, since GitLab releases do not hold files themselves
+func (g *gitlab) Upload(tag string, assets []string) error {
+	project := fmt.Sprintf("%s/projects/%s", g.api, url.PathEscape(g.project))
+	releaseURL := fmt.Sprintf("%s/releases/%s", project, url.PathEscape(tag))
+
+	status, err := request(http.MethodGet, releaseURL, g.headers(), nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusNotFound {
+		payload := map[string]string{"tag_name": tag, "name": tag}
+
+		if g.ref != "" {
+			payload["ref"] = g.ref
+		}
+
+		if _, err := request(http.MethodPost, project+"/releases", g.headers(), payload, nil); err != nil {
+			return fmt.Errorf("failed to create release %s: %w", tag, err)
+		}
+	}
+
+	var links []gitlabLink
+
+	if _, err := request(http.MethodGet, releaseURL+"/assets/links", g.headers(), nil, &links); err != nil {
+		return err
+	}
+
+	for _, asset := range assets {
+		name := filepath.Base(asset)
+		packageURL := fmt.Sprintf("%s/packages/generic/%s/%s/%s", project, gitlabPackage, url.PathEscape(tag), url.PathEscape(name))
+
+		if err := uploadFile(http.MethodPut, packageURL, asset, g.headers(), nil); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+
+		for _, link := range links {
+			if link.Name == name {
+				if _, err := request(http.MethodDelete, fmt.Sprintf("%s/assets/links/%d", releaseURL, link.ID), g.headers(), nil, nil); err != nil {
+					return fmt.Errorf("failed to replace asset %s: %w", name, err)
+				}
+			}
+		}
+
+		payload := map[string]string{"name": name, "url": packageURL, "link_type": "package"}
+
+		if _, err := request(http.MethodPost, releaseURL+"/assets/links", g.headers(), payload, nil); err != nil {
+			return fmt.Errorf("failed to link %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"portaptable/pkg/remote"
+)
+
+// Publisher attaches files as assets to a release of a hosted project
+type Publisher interface {
+	// Upload attaches assets to the release for tag, creating the release if needed.
+	// Assets already attached under the same name are replaced.
+	Upload(tag string, assets []string) error
+}
+
+// Options configure the hosting service; empty values select the public service
+type Options struct {
+	APIURL string
+	Ref    string // GitLab: commit or branch a new tag is created from
+}
+
+// NewGitHub returns a publisher for owner/repo authorized by $GITHUB_TOKEN
+func NewGitHub(repository string, options Options) (Publisher, error) {
+	token := os.Getenv("GITHUB_TOKEN")
+
+	if token == "" {
+		return nil, fmt.Errorf("GITHUB_TOKEN must be set")
+	}
+
+	if strings.Count(repository, "/") != 1 {
+		return nil, fmt.Errorf("invalid GitHub repository %q (expected owner/repo)", repository)
+	}
+
+	apiURL := options.APIURL
+
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+	}
+
+	return &github{api: strings.TrimSuffix(apiURL, "/"), repository: repository, token: token}, nil
+}
+
+// NewGitLab returns a publisher for a group/project path authorized by $GITLAB_TOKEN
+func NewGitLab(project string, options Options) (Publisher, error) {
+	token := os.Getenv("GITLAB_TOKEN")
+
+	if token == "" {
+		return nil, fmt.Errorf("GITLAB_TOKEN must be set")
+	}
+
+	apiURL := options.APIURL
+
+	if apiURL == "" {
+		apiURL = "https://gitlab.com/api/v4"
+	}
+
+	return &gitlab{api: strings.TrimSuffix(apiURL, "/"), project: project, token: token, ref: options.Ref}, nil
+}
+
+// request sends an API request, encoding payload as JSON and decoding the response into result
+func request(method, url string, headers map[string]string, payload, result interface{}) (int, error) {
+	var body io.Reader
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+
+		if err != nil {
+			return 0, err
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return send(req, result)
+}
+
+// send performs req and decodes a JSON response into result; 404 is returned as a status, not an error
+func send(req *http.Request, result interface{}) (int, error) {
+	resp, err := remote.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to parse response of %s: %w", req.URL.Path, err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// uploadFile sends a file as the request body
+func uploadFile(method, url, path string, headers map[string]string, result interface{}) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, url, file)
+
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	status, err := send(req, result)
+
+	if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("%s %s: not found", method, req.URL.Path)
+	}
+
+	return err
+}
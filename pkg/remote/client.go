@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Limits on a stalled server: connecting, waiting for the response headers, and going
+// without a byte of the body. A transfer that keeps receiving data is never cut short.
+const (
+	DialTimeout           = 30 * time.Second
+	ResponseHeaderTimeout = 60 * time.Second
+	IdleReadTimeout       = 60 * time.Second
+)
+
+// PostTimeout bounds a whole POST, such as a webhook notification
+const PostTimeout = 30 * time.Second
+
+// ErrStalled is returned by reads from a body that received nothing for IdleReadTimeout
+var ErrStalled = errors.New("connection stalled")
+
+// transport is shared by every HTTP client, so connections to a mirror are reused
+var transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: DialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+	ForceAttemptHTTP2:     true,
+	TLSHandshakeTimeout:   DialTimeout,
+	ResponseHeaderTimeout: ResponseHeaderTimeout,
+	ExpectContinueTimeout: time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   16,
+}
+
+// client sends the requests of Do
+var client = &http.Client{Transport: transport, CheckRedirect: scopeRedirect}
+
+// postClient sends the requests of Post
+var postClient = &http.Client{Transport: transport, Timeout: PostTimeout}
+
+// Post sends body to url through the shared transport, giving up after PostTimeout.
+// No configured headers are added, as url is not a mirror.
+func Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	return postClient.Post(url, contentType, body)
+}
+
+// Do sends req through the shared transport. The response body fails with ErrStalled
+// when no data arrives for IdleReadTimeout, so a hung transfer returns an error that
+// the caller can retry instead of blocking forever.
+func Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	body := &idleBody{body: resp.Body, cancel: cancel}
+	body.timer = time.AfterFunc(IdleReadTimeout, body.stall)
+	resp.Body = body
+
+	return resp, nil
+}
+
+// idleBody cancels its request when the timer, reset by every read, runs out
+type idleBody struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func (b *idleBody) stall() {
+	b.stalled.Store(true)
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	if b.stalled.Load() {
+		return n, ErrStalled
+	}
+
+	if n > 0 {
+		b.timer.Reset(IdleReadTimeout)
+	}
+
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	defer b.cancel()
+
+	return b.body.Close()
+}
@@ -0,0 +1,326 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when the server has no file at the requested URL
+var ErrNotFound = errors.New("not found")
+
+// headers are the configured User-Agent, sent to every host, and the extra headers,
+// sent only to the mirror hosts in headerHosts
+var headers = make(http.Header)
+
+// userAgent is the one header not scoped to the mirror hosts
+const userAgent = "User-Agent"
+
+var (
+	headerHostsMu sync.RWMutex
+	headerHosts   = make(map[string]bool)
+)
+
+// SetHeader sends a header with HTTP requests, replacing an earlier value
+func SetHeader(name, value string) {
+	headers.Set(name, value)
+}
+
+// UserAgent returns the configured User-Agent, or empty for Go's default
+func UserAgent() string {
+	return headers.Get(userAgent)
+}
+
+// Headers returns the extra headers, without the User-Agent
+func Headers() http.Header {
+	extra := headers.Clone()
+	extra.Del(userAgent)
+
+	return extra
+}
+
+// ScopeHeaders sends the extra headers to the host of rawURL, a configured mirror;
+// requests to any other host, redirects included, carry only the User-Agent
+func ScopeHeaders(rawURL string) {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil || parsed.Host == "" {
+		return
+	}
+
+	headerHostsMu.Lock()
+	defer headerHostsMu.Unlock()
+
+	headerHosts[strings.ToLower(parsed.Host)] = true
+}
+
+// HeaderHosts returns the sorted hosts the extra headers are sent to
+func HeaderHosts() []string {
+	headerHostsMu.RLock()
+	defer headerHostsMu.RUnlock()
+
+	hosts := make([]string, 0, len(headerHosts))
+
+	for host := range headerHosts {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// inScope reports whether the extra headers may be sent to the host of target
+func inScope(target *url.URL) bool {
+	headerHostsMu.RLock()
+	defer headerHostsMu.RUnlock()
+
+	return headerHosts[strings.ToLower(target.Host)]
+}
+
+// AddHeaders sets the configured headers on a request made by another HTTP client,
+// leaving out the extra headers when the request goes to a host other than a mirror's
+func AddHeaders(req *http.Request) {
+	scoped := inScope(req.URL)
+
+	for name, values := range headers {
+		if name == userAgent || scoped {
+			req.Header[name] = values
+		}
+	}
+}
+
+// scopeRedirect drops the extra headers, which the client copies onto redirects, when
+// a redirect leaves the mirror hosts
+func scopeRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if !inScope(req.URL) {
+		for name := range headers {
+			if name != userAgent {
+				req.Header.Del(name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Fetcher downloads url into the local file target, returning an error wrapping
+// ErrNotFound when the file does not exist
+type Fetcher func(url, target string) error
+
+// schemes routes URL schemes other than http and https to their fetchers
+var schemes = make(map[string]Fetcher)
+
+// RegisterScheme makes Get and Download fetch URLs of scheme (e.g. "smb") with fetch
+func RegisterScheme(scheme string, fetch Fetcher) {
+	schemes[scheme] = fetch
+}
+
+// tempFile is a downloaded file that is deleted when closed
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+
+	return err
+}
+
+// localPath returns the path of a file:// URL, such as a mounted mirror disk
+func localPath(rawURL string) (string, bool) {
+	if !strings.HasPrefix(rawURL, "file://") {
+		return "", false
+	}
+
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return "", false
+	}
+
+	return parsed.Path, true
+}
+
+// openLocal opens a file:// URL, mapping a missing file to ErrNotFound
+func openLocal(rawURL, path string) (*os.File, error) {
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+
+	return file, nil
+}
+
+// Get opens url for reading; the caller must close the returned body
+func Get(url string) (io.ReadCloser, error) {
+	if path, ok := localPath(url); ok {
+		return openLocal(url, path)
+	}
+
+	if scheme, _, ok := strings.Cut(url, "://"); ok && schemes[scheme] != nil {
+		return getWith(schemes[scheme], url)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+
+	AddHeaders(req)
+
+	resp, err := Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, ErrNotFound)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// GetRange opens length bytes of url starting at offset; the server must honour
+// HTTP range requests. The caller must close the returned body.
+func GetRange(url string, offset, length int64) (io.ReadCloser, error) {
+	if path, ok := localPath(url); ok {
+		file, err := openLocal(url, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(file, offset, length), file}, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+
+	AddHeaders(req)
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("failed to fetch %s: the server does not support range requests", url)
+	case http.StatusNotFound:
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, ErrNotFound)
+	}
+
+	resp.Body.Close()
+
+	return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+}
+
+// getWith fetches url through a registered fetcher into a temporary file
+func getWith(fetch Fetcher, url string) (io.ReadCloser, error) {
+	file, err := os.CreateTemp("", "portaptable-fetch-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	file.Close()
+
+	if err := fetch(url, file.Name()); err != nil {
+		os.Remove(file.Name())
+
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+
+	opened, err := os.Open(file.Name())
+
+	if err != nil {
+		os.Remove(file.Name())
+
+		return nil, err
+	}
+
+	return tempFile{opened}, nil
+}
+
+// Download fetches url into target unless target already exists
+func Download(url, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	body, err := Get(url)
+
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file so interrupted downloads never look complete
+	tmp := target + ".tmp"
+	file, err := os.Create(tmp)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(tmp)
+
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, target)
+}
@@ -0,0 +1,66 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScopedHeaders(t *testing.T) {
+	defer func(saved http.Header) { headers = saved }(headers)
+	defer func(saved map[string]bool) { headerHosts = saved }(headerHosts)
+
+	headers = make(http.Header)
+	headerHosts = make(map[string]bool)
+
+	SetHeader("User-Agent", "portaptable-test")
+	SetHeader("X-Token", "secret")
+
+	// received records the headers of each request by the server's role
+	received := make(map[string]http.Header)
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["other"] = r.Header.Clone()
+	}))
+	defer other.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["mirror"] = r.Header.Clone()
+		http.Redirect(w, r, other.URL+"/pool/app.deb", http.StatusFound)
+	}))
+	defer mirror.Close()
+
+	ScopeHeaders(mirror.URL + "/ubuntu")
+
+	body, err := Get(mirror.URL + "/ubuntu/pool/app.deb")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body.Close()
+
+	if got := received["mirror"].Get("X-Token"); got != "secret" {
+		t.Errorf("the mirror received X-Token %q, want secret", got)
+	}
+
+	if got := received["other"].Get("X-Token"); got != "" {
+		t.Errorf("the redirect to another host received X-Token %q", got)
+	}
+
+	if got := received["other"].Get("User-Agent"); got != "portaptable-test" {
+		t.Errorf("the redirect to another host received User-Agent %q, want portaptable-test", got)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, other.URL, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AddHeaders(req)
+
+	if req.Header.Get("X-Token") != "" || req.Header.Get("User-Agent") != "portaptable-test" {
+		t.Errorf("AddHeaders() for another host set %v, want the User-Agent only", req.Header)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package repolock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// LockFileEx flags and the error it fails with while another handle holds the lock
+const (
+	lockfileFailImmediately               = 0x1
+	lockfileExclusiveLock                 = 0x2
+	errorLockViolation      syscall.Errno = 33
+)
+
+// lockFile takes an exclusive lock on the first byte of file, returning ErrLocked if
+// another process holds it. Locking past the end of an empty file is allowed.
+func lockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+
+	ok, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+
+	if ok != 0 {
+		return nil
+	}
+
+	if errors.Is(err, errorLockViolation) {
+		return ErrLocked
+	}
+
+	return err
+}
+
+// unlockFile releases the lock on file
+func unlockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+
+	ok, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+
+	if ok == 0 {
+		return err
+	}
+
+	return nil
+}
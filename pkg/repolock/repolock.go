@@ -0,0 +1,53 @@
+package repolock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileName is the lock file inside a repository directory
+const FileName = ".lock"
+
+// ErrLocked is returned when another process is already modifying the repository
+var ErrLocked = errors.New("repository is locked by another refresh")
+
+// Lock is an exclusive hold on a repository, released with Unlock
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the repository lock without waiting. The lock is an flock (a
+// LockFileEx lock on Windows) on the lock file, so it is released automatically
+// if the holder dies.
+func Acquire(repoPath string) (*Lock, error) {
+	if err := os.MkdirAll(repoPath, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(repoPath, FileName), os.O_CREATE|os.O_RDWR, 0644)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+
+		if errors.Is(err, ErrLocked) {
+			return nil, ErrLocked
+		}
+
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Unlock releases the repository lock
+func (l *Lock) Unlock() error {
+	defer l.file.Close()
+
+	return unlockFile(l.file)
+}
@@ -0,0 +1,697 @@
+package repometa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"portaptable/pkg/checksum"
+	"portaptable/pkg/contents"
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+)
+
+// Origin is the Origin/Label value written to generated Release files
+const Origin = "Portaptable"
+
+// Component is the single archive component generated repositories use
+const Component = "main"
+
+// controlFields lists the package fields copied into Packages indexes, in output order
+var controlFields = []string{
+	"Essential", "Priority", "Section", "Installed-Size", "Maintainer", "Original-Maintainer",
+	"Multi-Arch", "Provides", "Pre-Depends", "Depends", "Recommends", "Suggests", "Enhances",
+	"Breaks", "Conflicts", "Replaces", "Built-Using", "Homepage", "Package-Type",
+	"Installer-Menu-Item", "Kernel-Version", "Subarchitecture",
+}
+
+// sourceFields lists the source package fields copied into Sources indexes, in output order
+var sourceFields = []string{
+	"Binary", "Maintainer", "Uploaders", "Build-Depends", "Build-Depends-Indep",
+	"Build-Depends-Arch", "Build-Conflicts", "Build-Conflicts-Indep", "Architecture",
+	"Standards-Version", "Format", "Homepage", "Vcs-Browser", "Vcs-Git", "Testsuite",
+	"Package-List", "Priority", "Section",
+}
+
+// descriptionMD5 identifies a package's English description; apt looks up
+// Translation-<language> entries by it
+const descriptionMD5 = "Description-md5"
+
+// IndexFields returns the subset of control fields that belong in a Packages index
+func IndexFields(control map[string]string) map[string]string {
+	fields := make(map[string]string)
+
+	for _, name := range append(controlFields, "Description", descriptionMD5) {
+		if value, ok := control[name]; ok && value != "" {
+			fields[name] = value
+		}
+	}
+
+	return fields
+}
+
+// SourceIndexFields returns the subset of a Sources entry that belongs in a generated
+// Sources index; the file lists are rebuilt from the pool
+func SourceIndexFields(control map[string]string) map[string]string {
+	fields := make(map[string]string)
+
+	for _, name := range sourceFields {
+		if value, ok := control[name]; ok && value != "" {
+			fields[name] = value
+		}
+	}
+
+	return fields
+}
+
+// DistPath returns the dists/<dist> directory of a repository
+func DistPath(repoPath, distribution string) string {
+	return filepath.Join(repoPath, "dists", distribution)
+}
+
+// BinaryPath returns the dists/<dist>/main/binary-<arch> directory of a repository
+func BinaryPath(repoPath, distribution, architecture string) string {
+	return filepath.Join(DistPath(repoPath, distribution), Component, "binary-"+architecture)
+}
+
+// TranslationPath returns the dists/<dist>/main/i18n directory of a repository
+func TranslationPath(repoPath, distribution string) string {
+	return filepath.Join(DistPath(repoPath, distribution), Component, "i18n")
+}
+
+// TranslationField returns the control field holding a package's long description
+// in language, as found in Translation-<language> indexes (e.g. Description-de)
+func TranslationField(language string) string {
+	return "Description-" + language
+}
+
+// SourcePath returns the dists/<dist>/main/source directory of a repository
+func SourcePath(repoPath, distribution string) string {
+	return filepath.Join(DistPath(repoPath, distribution), Component, "source")
+}
+
+// HasSources reports whether a repository ships source packages, and so a deb-src index
+func HasSources(mfest *manifest.Manifest) bool {
+	for _, pkg := range mfest.Packages {
+		if pkg.Downloaded && pkg.Type == packageinfo.TypeSource {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InstallerPath returns the dists/<dist>/main/debian-installer/binary-<arch> directory of a repository
+func InstallerPath(repoPath, distribution, architecture string) string {
+	return filepath.Join(DistPath(repoPath, distribution), Component, "debian-installer", "binary-"+architecture)
+}
+
+// WritePackages writes a Packages index entry for every downloaded binary package present in poolPath.
+// Checksums missing from the manifest are computed from the pool files; strongOnly
+// leaves out the MD5 and SHA1 fields.
+func WritePackages(w io.Writer, packages []packageinfo.PackageInfo, poolPath string, strongOnly bool) error {
+	for _, pkg := range packages {
+		if !pkg.Downloaded || pkg.Type == packageinfo.TypeSource {
+			continue
+		}
+
+		pkgPath := filepath.Join(poolPath, pkg.Filename)
+
+		if _, err := os.Stat(pkgPath); os.IsNotExist(err) {
+			continue // Skip missing files
+		}
+
+		if pkg.SHA256 == "" || pkg.MD5sum == "" || pkg.SHA1 == "" {
+			sums, err := checksum.File(pkgPath)
+
+			if err != nil {
+				return err
+			}
+
+			pkg.Size, pkg.MD5sum, pkg.SHA1, pkg.SHA256 = sums.Size, sums.MD5, sums.SHA1, sums.SHA256
+		}
+
+		fmt.Fprintf(w, "Package: %s\n", pkg.Name)
+		fmt.Fprintf(w, "Version: %s\n", pkg.Version)
+		fmt.Fprintf(w, "Architecture: %s\n", pkg.Architecture)
+
+		// apt derives changelog locations from the source package
+		if source := sourceField(pkg); source != "" {
+			fmt.Fprintf(w, "Source: %s\n", source)
+		}
+
+		fmt.Fprintf(w, "Filename: pool/%s\n", pkg.Filename)
+		fmt.Fprintf(w, "Size: %d\n", pkg.Size)
+
+		if !strongOnly {
+			fmt.Fprintf(w, "MD5sum: %s\n", pkg.MD5sum)
+			fmt.Fprintf(w, "SHA1: %s\n", pkg.SHA1)
+		}
+
+		fmt.Fprintf(w, "SHA256: %s\n", pkg.SHA256)
+
+		for _, name := range controlFields {
+			if value := pkg.Control[name]; value != "" {
+				writeField(w, name, value)
+			}
+		}
+
+		if description := pkg.Control["Description"]; description != "" {
+			writeField(w, "Description", description)
+		} else {
+			fmt.Fprintf(w, "Description: Package downloaded by portaptable\n")
+		}
+
+		if md5 := pkg.Control[descriptionMD5]; md5 != "" {
+			fmt.Fprintf(w, "%s: %s\n", descriptionMD5, md5)
+		}
+
+		fmt.Fprintf(w, "\n") // Empty line separates packages
+	}
+
+	return nil
+}
+
+// writeField writes a possibly multi-line field, indenting continuation lines
+func writeField(w io.Writer, name, value string) {
+	lines := strings.Split(value, "\n")
+
+	fmt.Fprintf(w, "%s: %s\n", name, lines[0])
+
+	for _, line := range lines[1:] {
+		fmt.Fprintf(w, " %s\n", line)
+	}
+
+	return
+}
+
+// sourceField returns the Source field value of a package, empty when the
+// source name and version equal the binary's
+func sourceField(pkg packageinfo.PackageInfo) string {
+	source := pkg.Source
+
+	if source == "" {
+		source = pkg.Name
+	}
+
+	if pkg.SourceVersion != "" && pkg.SourceVersion != pkg.Version {
+		return fmt.Sprintf("%s (%s)", source, pkg.SourceVersion)
+	}
+
+	if source != pkg.Name {
+		return source
+	}
+
+	return ""
+}
+
+// Generate writes the Packages, Packages.gz and Release files for the repository.
+// udebs are indexed separately in the debian-installer section, source packages in
+// the Sources index.
+func Generate(repoPath string, mfest *manifest.Manifest) error {
+	var debs, udebs, sources []packageinfo.PackageInfo
+
+	for _, pkg := range mfest.Packages {
+		switch pkg.Type {
+		case packageinfo.TypeUdeb:
+			udebs = append(udebs, pkg)
+		case packageinfo.TypeSource:
+			sources = append(sources, pkg)
+		default:
+			debs = append(debs, pkg)
+		}
+	}
+
+	poolPath := filepath.Join(repoPath, "pool")
+
+	for _, architecture := range mfest.Architectures() {
+		if err := writeIndex(BinaryPath(repoPath, mfest.Distribution, architecture), architectureEntries(debs, mfest, architecture), poolPath, mfest.RequireSHA256); err != nil {
+			return err
+		}
+	}
+
+	if len(udebs) > 0 {
+		if err := writeIndex(InstallerPath(repoPath, mfest.Distribution, mfest.Architecture), udebs, poolPath, mfest.RequireSHA256); err != nil {
+			return err
+		}
+	}
+
+	if err := writeSources(SourcePath(repoPath, mfest.Distribution), sources, poolPath, mfest.RequireSHA256); err != nil {
+		return err
+	}
+
+	if err := writeTranslations(TranslationPath(repoPath, mfest.Distribution), debs, mfest.IndexLanguages); err != nil {
+		return err
+	}
+
+	for _, architecture := range mfest.Architectures() {
+		if err := writeContents(repoPath, mfest.Distribution, architecture, architectureEntries(debs, mfest, architecture)); err != nil {
+			return err
+		}
+	}
+
+	return writeRelease(DistPath(repoPath, mfest.Distribution), mfest)
+}
+
+// architectureEntries returns the packages belonging in the binary-<architecture> index:
+// those built for it and the architecture-independent ones. The primary index also
+// keeps every package of an architecture without a tree of its own.
+func architectureEntries(packages []packageinfo.PackageInfo, mfest *manifest.Manifest, architecture string) []packageinfo.PackageInfo {
+	trees := mfest.Architectures()
+
+	if len(trees) == 1 {
+		return packages
+	}
+
+	var entries []packageinfo.PackageInfo
+
+	for _, pkg := range packages {
+		own := pkg.Architecture == architecture || pkg.Architecture == "all"
+
+		if architecture == mfest.Architecture && !contains(trees, pkg.Architecture) {
+			own = true
+		}
+
+		if own {
+			entries = append(entries, pkg)
+		}
+	}
+
+	return entries
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeIndex writes Packages and Packages.gz for packages into dir
+func writeIndex(dir string, entries []packageinfo.PackageInfo, poolPath string, strongOnly bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dist directories: %w", err)
+	}
+
+	var packages bytes.Buffer
+
+	if err := WritePackages(&packages, entries, poolPath, strongOnly); err != nil {
+		return fmt.Errorf("failed to generate Packages index: %w", err)
+	}
+
+	if err := WriteFile(filepath.Join(dir, "Packages"), packages.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write Packages index: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+
+	if _, err := gz.Write(packages.Bytes()); err != nil {
+		return fmt.Errorf("failed to compress Packages index: %w", err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress Packages index: %w", err)
+	}
+
+	if err := WriteFile(filepath.Join(dir, "Packages.gz"), compressed.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write Packages.gz index: %w", err)
+	}
+
+	return nil
+}
+
+// writeSourceEntries writes a Sources index entry for every source package whose files are
+// all present in poolPath, listing them below Directory: pool. The .dsc entry carries
+// the source fields; strongOnly leaves out the MD5 and SHA1 lists.
+func writeSourceEntries(w io.Writer, packages []packageinfo.PackageInfo, poolPath string, strongOnly bool) error {
+	files := make(map[string][]packageinfo.PackageInfo)
+	var dscs []packageinfo.PackageInfo
+
+	for _, pkg := range packages {
+		if !pkg.Downloaded || pkg.Type != packageinfo.TypeSource {
+			continue
+		}
+
+		key := pkg.Name + "_" + pkg.Version
+		files[key] = append(files[key], pkg)
+
+		if strings.HasSuffix(pkg.Filename, ".dsc") {
+			dscs = append(dscs, pkg)
+		}
+	}
+
+	for _, dsc := range dscs {
+		var listed []packageinfo.PackageInfo
+		complete := true
+
+		for _, file := range files[dsc.Name+"_"+dsc.Version] {
+			filePath := filepath.Join(poolPath, file.Filename)
+
+			if _, err := os.Stat(filePath); os.IsNotExist(err) {
+				complete = false
+
+				break
+			}
+
+			if file.SHA256 == "" || file.MD5sum == "" || file.SHA1 == "" {
+				sums, err := checksum.File(filePath)
+
+				if err != nil {
+					return err
+				}
+
+				file.Size, file.MD5sum, file.SHA1, file.SHA256 = sums.Size, sums.MD5, sums.SHA1, sums.SHA256
+			}
+
+			listed = append(listed, file)
+		}
+
+		// apt-get source needs every file of the package
+		if !complete {
+			continue
+		}
+
+		fmt.Fprintf(w, "Package: %s\n", dsc.Name)
+		fmt.Fprintf(w, "Version: %s\n", dsc.Version)
+
+		for _, name := range sourceFields {
+			if value := dsc.Control[name]; value != "" {
+				writeField(w, name, value)
+			}
+		}
+
+		fmt.Fprintf(w, "Directory: pool\n")
+
+		if !strongOnly {
+			fmt.Fprintf(w, "Files:\n")
+
+			for _, file := range listed {
+				fmt.Fprintf(w, " %s %d %s\n", file.MD5sum, file.Size, file.Filename)
+			}
+
+			fmt.Fprintf(w, "Checksums-Sha1:\n")
+
+			for _, file := range listed {
+				fmt.Fprintf(w, " %s %d %s\n", file.SHA1, file.Size, file.Filename)
+			}
+		}
+
+		fmt.Fprintf(w, "Checksums-Sha256:\n")
+
+		for _, file := range listed {
+			fmt.Fprintf(w, " %s %d %s\n", file.SHA256, file.Size, file.Filename)
+		}
+
+		fmt.Fprintf(w, "\n")
+	}
+
+	return nil
+}
+
+// writeSources replaces the Sources indexes in dir, leaving none without source packages
+func writeSources(dir string, packages []packageinfo.PackageInfo, poolPath string, strongOnly bool) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove stale Sources index: %w", err)
+	}
+
+	var index bytes.Buffer
+
+	if err := writeSourceEntries(&index, packages, poolPath, strongOnly); err != nil {
+		return fmt.Errorf("failed to generate Sources index: %w", err)
+	}
+
+	if index.Len() == 0 {
+		return nil
+	}
+
+	return writeCompressed(dir, "Sources", index.Bytes())
+}
+
+// writeTranslations replaces the Translation-<language> indexes in dir with those of
+// languages, listing every package whose manifest entry carries the translation
+func writeTranslations(dir string, packages []packageinfo.PackageInfo, languages []string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove stale translations: %w", err)
+	}
+
+	for _, language := range languages {
+		var index bytes.Buffer
+		seen := make(map[string]bool)
+
+		for _, pkg := range packages {
+			md5, description := pkg.Control[descriptionMD5], pkg.Control[TranslationField(language)]
+			key := pkg.Name + " " + md5
+
+			if !pkg.Downloaded || md5 == "" || description == "" || seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			fmt.Fprintf(&index, "Package: %s\n", pkg.Name)
+			fmt.Fprintf(&index, "%s: %s\n", descriptionMD5, md5)
+			writeField(&index, TranslationField(language), description)
+			fmt.Fprintf(&index, "\n")
+		}
+
+		if index.Len() == 0 {
+			continue
+		}
+
+		if err := writeCompressed(dir, "Translation-"+language, index.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeContents writes Contents-<architecture>.gz, the apt-file index of which package
+// ships each path. Packages whose pool file cannot be listed are left out.
+func writeContents(repoPath, distribution, architecture string, packages []packageinfo.PackageInfo) error {
+	owners := make(map[string][]string)
+
+	for _, pkg := range packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		files, err := contents.Files(repoPath, pkg)
+
+		if err != nil {
+			continue
+		}
+
+		qualified := contents.Qualified(pkg)
+
+		for _, file := range files {
+			if !contains(owners[file], qualified) {
+				owners[file] = append(owners[file], qualified)
+			}
+		}
+	}
+
+	dir := filepath.Join(DistPath(repoPath, distribution), Component)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dist directories: %w", err)
+	}
+
+	name := "Contents-" + architecture + ".gz"
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+
+	if err := contents.Write(gz, owners); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+
+	if err := WriteFile(filepath.Join(dir, name), compressed.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// writeCompressed writes data as name and name.gz into dir
+func writeCompressed(dir, name string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dist directories: %w", err)
+	}
+
+	if err := WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+
+	if _, err := gz.Write(data); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+
+	if err := WriteFile(filepath.Join(dir, name+".gz"), compressed.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s.gz: %w", name, err)
+	}
+
+	return nil
+}
+
+func writeRelease(distPath string, mfest *manifest.Manifest) error {
+	// Collect every index file below the dist directory
+	var indexes []string
+
+	err := filepath.Walk(distPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Dir(path) == distPath || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(distPath, path)
+
+		if err != nil {
+			return err
+		}
+
+		indexes = append(indexes, filepath.ToSlash(rel))
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to list index files: %w", err)
+	}
+
+	sums := make(map[string]checksum.Sums, len(indexes))
+
+	for _, index := range indexes {
+		sum, err := checksum.File(filepath.Join(distPath, index))
+
+		if err != nil {
+			return err
+		}
+
+		sums[index] = sum
+	}
+
+	var release strings.Builder
+
+	fmt.Fprintf(&release, "Origin: %s\n", Origin)
+	fmt.Fprintf(&release, "Label: %s\n", Origin)
+	fmt.Fprintf(&release, "Suite: %s\n", mfest.Distribution)
+	fmt.Fprintf(&release, "Codename: %s\n", mfest.Distribution)
+	fmt.Fprintf(&release, "Date: %s\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&release, "Architectures: %s\n", strings.Join(mfest.Architectures(), " "))
+	fmt.Fprintf(&release, "Components: %s\n", Component)
+	fmt.Fprintf(&release, "Description: Offline repository generated by portaptable\n")
+
+	// Under the SHA256 policy nothing can fall back to the weak hashes
+	if !mfest.RequireSHA256 {
+		release.WriteString("MD5Sum:\n")
+
+		for _, index := range indexes {
+			fmt.Fprintf(&release, " %s %16d %s\n", sums[index].MD5, sums[index].Size, index)
+		}
+
+		release.WriteString("SHA1:\n")
+
+		for _, index := range indexes {
+			fmt.Fprintf(&release, " %s %16d %s\n", sums[index].SHA1, sums[index].Size, index)
+		}
+	}
+
+	release.WriteString("SHA256:\n")
+
+	for _, index := range indexes {
+		fmt.Fprintf(&release, " %s %16d %s\n", sums[index].SHA256, sums[index].Size, index)
+	}
+
+	// Drop stale signatures; they no longer match the new Release file
+	for _, name := range []string{"InRelease", "Release.gpg"} {
+		if err := os.Remove(filepath.Join(distPath, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale %s: %w", name, err)
+		}
+	}
+
+	return WriteFile(filepath.Join(distPath, "Release"), []byte(release.String()), 0644)
+}
+
+// WriteFile replaces the file at path through a temporary file renamed into place, so
+// a server never hands out a partly written index
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path+".tmp", data, perm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+
+		return err
+	}
+
+	return nil
+}
+
+// SetupScript returns a shell script that installs the repository keyring on a target
+// and adds a signed-by sources entry pointing at defaultURI (overridable as $1), with
+// a deb-src entry as well when the repository ships source packages
+func SetupScript(defaultURI, distribution, keyringName string, sources bool) string {
+	types := "deb"
+
+	if sources {
+		types = "deb deb-src"
+	}
+
+	return fmt.Sprintf(`#!/bin/sh
+# Generated by portaptable: configure apt to use this repository with signed-by verification
+# Usage: sudo sh setup-apt.sh [REPOSITORY_URI]
+set -e
+
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+REPO_URI="${1:-%[1]s}"
+KEYRING="/etc/apt/keyrings/%[3]s"
+
+install -d -m 0755 /etc/apt/keyrings
+
+case "$REPO_URI" in
+    file://*)
+        install -m 0644 "${REPO_URI#file://}/%[3]s" "$KEYRING"
+        ;;
+    *)
+        /usr/lib/apt/apt-helper download-file "$REPO_URI/%[3]s" "$KEYRING"
+        chmod 0644 "$KEYRING"
+        ;;
+esac
+
+: > /etc/apt/sources.list.d/portaptable.list
+
+for TYPE in %[6]s; do
+    echo "$TYPE [signed-by=$KEYRING] $REPO_URI %[2]s %[4]s" >> /etc/apt/sources.list.d/portaptable.list
+done
+
+# Let 'apt changelog' read changelogs from the repository instead of the internet
+echo "Acquire::Changelogs::URI::Origin::%[5]s \"$REPO_URI/changelogs/@CHANGEPATH@_changelog\";" \
+    > /etc/apt/apt.conf.d/50portaptable-changelogs
+
+apt-get update
+`, defaultURI, distribution, keyringName, Component, Origin, types)
+}
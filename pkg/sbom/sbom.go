@@ -0,0 +1,228 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Supported output formats
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+// Component describes one package of the repository
+type Component struct {
+	Name         string
+	Version      string
+	Architecture string
+	Distro       string // purl namespace, e.g. "ubuntu" or "debian"
+	Release      string
+	MD5          string
+	SHA1         string
+	SHA256       string
+	Licenses     []string // License short names from the package's copyright file
+}
+
+// PURL returns the package URL of the component
+func (c Component) PURL() string {
+	return fmt.Sprintf("pkg:deb/%s/%s@%s?arch=%s&distro=%s",
+		c.Distro, c.Name, url.PathEscape(c.Version), c.Architecture, c.Release)
+}
+
+// Generate builds an SBOM document named name in the requested format,
+// ready to be encoded as JSON
+func Generate(format, name string, components []Component) (interface{}, error) {
+	switch format {
+	case FormatCycloneDX:
+		return cycloneDX(name, components), nil
+	case FormatSPDX:
+		return spdx(name, components), nil
+	}
+
+	return nil, fmt.Errorf("unsupported SBOM format %q (expected cyclonedx or spdx)", format)
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	// Version 4, RFC 4122 variant
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func cycloneDX(name string, components []Component) map[string]interface{} {
+	entries := make([]map[string]interface{}, 0, len(components))
+
+	for _, c := range components {
+		entry := map[string]interface{}{
+			"type":     "library",
+			"bom-ref":  c.PURL(),
+			"name":     c.Name,
+			"version":  c.Version,
+			"purl":     c.PURL(),
+			"hashes":   cycloneDXHashes(c),
+			"licenses": cycloneDXLicenses(c.Licenses),
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newUUID(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": "portaptable"}},
+			},
+			"component": map[string]string{"type": "application", "name": name},
+		},
+		"components": entries,
+	}
+}
+
+func cycloneDXHashes(c Component) []map[string]string {
+	hashes := make([]map[string]string, 0, 3)
+
+	for _, hash := range []struct{ alg, value string }{
+		{"MD5", c.MD5}, {"SHA-1", c.SHA1}, {"SHA-256", c.SHA256},
+	} {
+		if hash.value != "" {
+			hashes = append(hashes, map[string]string{"alg": hash.alg, "content": hash.value})
+		}
+	}
+
+	return hashes
+}
+
+func cycloneDXLicenses(licenses []string) []map[string]interface{} {
+	entries := make([]map[string]interface{}, 0, len(licenses))
+
+	for _, license := range licenses {
+		entries = append(entries, map[string]interface{}{"license": map[string]string{"name": license}})
+	}
+
+	return entries
+}
+
+// spdxLicenseIDs maps common Debian copyright short names to SPDX identifiers
+var spdxLicenseIDs = map[string]string{
+	"Apache-2.0":   "Apache-2.0",
+	"Artistic":     "Artistic-1.0-Perl",
+	"BSD-2-clause": "BSD-2-Clause",
+	"BSD-3-clause": "BSD-3-Clause",
+	"BSD-4-clause": "BSD-4-Clause",
+	"Expat":        "MIT",
+	"MIT":          "MIT",
+	"GPL-2":        "GPL-2.0-only",
+	"GPL-2+":       "GPL-2.0-or-later",
+	"GPL-3":        "GPL-3.0-only",
+	"GPL-3+":       "GPL-3.0-or-later",
+	"LGPL-2":       "LGPL-2.0-only",
+	"LGPL-2+":      "LGPL-2.0-or-later",
+	"LGPL-2.1":     "LGPL-2.1-only",
+	"LGPL-2.1+":    "LGPL-2.1-or-later",
+	"LGPL-3":       "LGPL-3.0-only",
+	"LGPL-3+":      "LGPL-3.0-or-later",
+	"MPL-2.0":      "MPL-2.0",
+	"ISC":          "ISC",
+	"Zlib":         "Zlib",
+	"zlib":         "Zlib",
+	"OpenSSL":      "OpenSSL",
+	"curl":         "curl",
+}
+
+// spdxLicenseExpression converts Debian license names into an SPDX expression.
+// Names without a known mapping make the result NOASSERTION.
+func spdxLicenseExpression(licenses []string) string {
+	if len(licenses) == 0 {
+		return "NOASSERTION"
+	}
+
+	ids := make([]string, 0, len(licenses))
+
+	for _, license := range licenses {
+		id, ok := spdxLicenseIDs[license]
+
+		if !ok {
+			return "NOASSERTION"
+		}
+
+		ids = append(ids, id)
+	}
+
+	return strings.Join(ids, " AND ")
+}
+
+var spdxIDInvalid = regexp.MustCompile(`[^A-Za-z0-9.\-]+`)
+
+func spdx(name string, components []Component) map[string]interface{} {
+	packages := make([]map[string]interface{}, 0, len(components))
+	relationships := make([]map[string]string, 0, len(components))
+
+	for _, c := range components {
+		id := "SPDXRef-Package-" + spdxIDInvalid.ReplaceAllString(c.Name+"-"+c.Version+"-"+c.Architecture, "-")
+
+		checksums := make([]map[string]string, 0, 3)
+
+		for _, hash := range []struct{ alg, value string }{
+			{"MD5", c.MD5}, {"SHA1", c.SHA1}, {"SHA256", c.SHA256},
+		} {
+			if hash.value != "" {
+				checksums = append(checksums, map[string]string{"algorithm": hash.alg, "checksumValue": hash.value})
+			}
+		}
+
+		pkg := map[string]interface{}{
+			"SPDXID":           id,
+			"name":             c.Name,
+			"versionInfo":      c.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"checksums":        checksums,
+			"licenseConcluded": "NOASSERTION",
+			"licenseDeclared":  spdxLicenseExpression(c.Licenses),
+			"copyrightText":    "NOASSERTION",
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  c.PURL(),
+			}},
+		}
+
+		if len(c.Licenses) > 0 {
+			pkg["licenseComments"] = "Debian copyright licenses: " + strings.Join(c.Licenses, ", ")
+		}
+
+		packages = append(packages, pkg)
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      "SPDXRef-DOCUMENT",
+			"relationshipType":   "DESCRIBES",
+			"relatedSpdxElement": id,
+		})
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": fmt.Sprintf("https://portaptable.invalid/spdx/%s-%s", name, newUUID()),
+		"creationInfo": map[string]interface{}{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: portaptable"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
@@ -0,0 +1,93 @@
+package servestats
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileName is the serve statistics file inside a repository directory
+const FileName = ".served.json"
+
+// Load returns when each pool file was last served, keyed by file name.
+// A repository that was never served has no statistics.
+func Load(repoPath string) (map[string]time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, FileName))
+
+	if os.IsNotExist(err) {
+		return map[string]time.Time{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serve statistics: %w", err)
+	}
+
+	served := make(map[string]time.Time)
+
+	if err := json.Unmarshal(data, &served); err != nil {
+		return nil, fmt.Errorf("failed to parse serve statistics: %w", err)
+	}
+
+	return served, nil
+}
+
+// Recorder collects serve times in memory and writes them out on Flush
+type Recorder struct {
+	repoPath string
+	mu       sync.Mutex
+	served   map[string]time.Time
+	dirty    bool
+}
+
+// Open returns a recorder continuing the statistics stored in the repository
+func Open(repoPath string) (*Recorder, error) {
+	served, err := Load(repoPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{repoPath: repoPath, served: served}, nil
+}
+
+// Record notes that filename was served now
+func (r *Recorder) Record(filename string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.served[filename] = time.Now().UTC()
+	r.dirty = true
+}
+
+// Flush writes the statistics if anything was recorded since the last flush
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(r.served)
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.repoPath, FileName)
+
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write serve statistics: %w", err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write serve statistics: %w", err)
+	}
+
+	r.dirty = false
+
+	return nil
+}
@@ -0,0 +1,485 @@
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PublicKeyringName is the file name used for the exported public key on targets
+const PublicKeyringName = "portaptable-archive-keyring.gpg"
+
+// Key describes a key found in the signing keyring
+type Key struct {
+	Fingerprint string
+	UserID      string
+	Created     time.Time
+	Expires     time.Time
+	HasSecret   bool
+}
+
+// Keyring wraps a GPG home directory holding the repository signing key
+type Keyring struct {
+	Home string
+
+	// KeyID selects the signing key; empty selects the first secret key
+	KeyID string
+
+	// PINFile holds the passphrase or token PIN, for unattended signing
+	PINFile string
+}
+
+// DefaultHome returns the keyring directory used when none is configured
+func DefaultHome() (string, error) {
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("failed to determine config directory: %w", err)
+	}
+
+	return filepath.Join(configDir, "portaptable", "gnupg"), nil
+}
+
+// Open returns the keyring at home, creating the directory if needed.
+// An empty home selects DefaultHome.
+func Open(home string) (*Keyring, error) {
+	if home == "" {
+		defaultHome, err := DefaultHome()
+
+		if err != nil {
+			return nil, err
+		}
+
+		home = defaultHome
+	}
+
+	// GnuPG refuses to use a home directory readable by others
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+
+	return &Keyring{Home: home}, nil
+}
+
+func (k *Keyring) command(args ...string) *exec.Cmd {
+	base := []string{"--homedir", k.Home, "--batch", "--no-tty"}
+
+	return exec.Command("gpg", append(base, args...)...)
+}
+
+func (k *Keyring) run(args ...string) ([]byte, error) {
+	cmd := k.command(args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("gpg %s failed: %w, output: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// Generate creates a new passphrase-less signing key for the repository
+func (k *Keyring) Generate(name, email, expire string) error {
+	if expire == "" {
+		expire = "never"
+	}
+
+	userID := name
+
+	if email != "" {
+		userID = fmt.Sprintf("%s <%s>", name, email)
+	}
+
+	_, err := k.run("--passphrase", "", "--pinentry-mode", "loopback",
+		"--quick-generate-key", userID, "rsa4096", "sign", expire)
+
+	return err
+}
+
+// GenerateFromKeygrip creates an OpenPGP signing key bound to an existing key,
+// typically one held on a hardware token, so the private part never touches disk
+func (k *Keyring) GenerateFromKeygrip(keygrip, name, email, expire string) error {
+	if expire == "" || expire == "never" {
+		expire = "0"
+	}
+
+	params := fmt.Sprintf("Key-Type: RSA\nKey-Grip: %s\nKey-Usage: sign\nName-Real: %s\n", keygrip, name)
+
+	if email != "" {
+		params += fmt.Sprintf("Name-Email: %s\n", email)
+	}
+
+	params += fmt.Sprintf("Expire-Date: %s\n%%no-protection\n%%commit\n", expire)
+
+	cmd := k.command(append(k.pinArgs(), "--generate-key")...)
+	cmd.Stdin = strings.NewReader(params)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("gpg --generate-key failed: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// pinArgs supplies the PIN of hardware tokens and protected keys without a pinentry
+func (k *Keyring) pinArgs() []string {
+	if k.PINFile == "" {
+		return nil
+	}
+
+	return []string{"--pinentry-mode", "loopback", "--passphrase-file", k.PINFile}
+}
+
+// ConfigurePKCS11 routes smartcard operations of this keyring through
+// gnupg-pkcs11-scd using the given PKCS#11 provider module (e.g. an HSM or YubiKey PIV library)
+func (k *Keyring) ConfigurePKCS11(module string) error {
+	scdaemon, err := exec.LookPath("gnupg-pkcs11-scd")
+
+	if err != nil {
+		return fmt.Errorf("gnupg-pkcs11-scd is required for PKCS#11 tokens: %w", err)
+	}
+
+	agentConf := fmt.Sprintf("scdaemon-program %s\n", scdaemon)
+
+	if err := os.WriteFile(filepath.Join(k.Home, "gpg-agent.conf"), []byte(agentConf), 0600); err != nil {
+		return fmt.Errorf("failed to write gpg-agent.conf: %w", err)
+	}
+
+	scdConf := fmt.Sprintf("providers token\nprovider-token-library %s\n", module)
+
+	if err := os.WriteFile(filepath.Join(k.Home, "gnupg-pkcs11-scd.conf"), []byte(scdConf), 0600); err != nil {
+		return fmt.Errorf("failed to write gnupg-pkcs11-scd.conf: %w", err)
+	}
+
+	// Restart the agent so it picks up the new scdaemon
+	if output, err := exec.Command("gpgconf", "--homedir", k.Home, "--kill", "gpg-agent").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restart gpg-agent: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// TokenKeys asks the smartcard daemon for the keys on the connected token and
+// returns their keygrips mapped to the token's key identifiers
+func (k *Keyring) TokenKeys() (map[string]string, error) {
+	output, err := exec.Command("gpg-connect-agent", "--homedir", k.Home, "SCD LEARN --force", "/bye").CombinedOutput()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+
+	for scanner.Scan() {
+		// Status lines look like "S KEYPAIRINFO <keygrip> <key-id> ..."
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) >= 4 && fields[0] == "S" && fields[1] == "KEYPAIRINFO" {
+			keys[fields[2]] = fields[3]
+		}
+
+		if len(fields) >= 2 && fields[0] == "ERR" {
+			return nil, fmt.Errorf("token query failed: %s", strings.Join(fields[2:], " "))
+		}
+	}
+
+	return keys, nil
+}
+
+// Import adds the keys contained in path to the keyring
+func (k *Keyring) Import(path string) error {
+	_, err := k.run("--import", path)
+
+	return err
+}
+
+// ExportPublic returns the public key for keyID (or every key when empty).
+// The binary form can be dropped directly into /etc/apt/keyrings.
+func (k *Keyring) ExportPublic(keyID string, armor bool) ([]byte, error) {
+	args := []string{"--export", "--export-options", "export-minimal"}
+
+	if armor {
+		args = append(args, "--armor")
+	}
+
+	if keyID != "" {
+		args = append(args, keyID)
+	}
+
+	data, err := k.run(args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no public key found in %s", k.Home)
+	}
+
+	return data, nil
+}
+
+// List returns all keys in the keyring, marking those with a secret part
+func (k *Keyring) List() ([]Key, error) {
+	publicOutput, err := k.run("--with-colons", "--fixed-list-mode", "--list-keys")
+
+	if err != nil {
+		return nil, err
+	}
+
+	secretOutput, err := k.run("--with-colons", "--fixed-list-mode", "--list-secret-keys")
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := parseColonListing(string(publicOutput), "pub")
+	secrets := make(map[string]bool)
+
+	for _, key := range parseColonListing(string(secretOutput), "sec") {
+		secrets[key.Fingerprint] = true
+	}
+
+	for i := range keys {
+		keys[i].HasSecret = secrets[keys[i].Fingerprint]
+	}
+
+	return keys, nil
+}
+
+// signArgs returns the gpg options shared by the signing operations
+func (k *Keyring) signArgs(keyID string) []string {
+	return append([]string{"--yes", "--digest-algo", "SHA256", "--local-user", keyID}, k.pinArgs()...)
+}
+
+// ClearSign writes an inline-signed copy of input to output (e.g. Release to InRelease)
+func (k *Keyring) ClearSign(keyID, input, output string) error {
+	_, err := k.run(append(k.signArgs(keyID), "--clearsign", "--output", output, input)...)
+
+	return err
+}
+
+// DetachSign writes an armored detached signature of input to output (e.g. Release.gpg)
+func (k *Keyring) DetachSign(keyID, input, output string) error {
+	_, err := k.run(append(k.signArgs(keyID), "--armor", "--detach-sign", "--output", output, input)...)
+
+	return err
+}
+
+// SigningKey returns the key selected by KeyID, or the first key that can be used for signing
+func (k *Keyring) SigningKey() (Key, error) {
+	keys, err := k.List()
+
+	if err != nil {
+		return Key{}, err
+	}
+
+	for _, key := range keys {
+		if !key.HasSecret {
+			continue
+		}
+
+		if k.KeyID == "" || strings.HasSuffix(key.Fingerprint, normalizeKeyID(k.KeyID)) {
+			return key, nil
+		}
+	}
+
+	if k.KeyID != "" {
+		return Key{}, fmt.Errorf("secret key %s not found in %s", k.KeyID, k.Home)
+	}
+
+	return Key{}, fmt.Errorf("no secret key found in %s", k.Home)
+}
+
+// normalizeKeyID turns a key ID or fingerprint as users write it (0x prefix, spaced
+// groups, lower case) into the form gpg lists fingerprints in
+func normalizeKeyID(keyID string) string {
+	keyID = strings.ReplaceAll(strings.TrimSpace(keyID), " ", "")
+
+	if len(keyID) > 2 && (keyID[:2] == "0x" || keyID[:2] == "0X") {
+		keyID = keyID[2:]
+	}
+
+	return strings.ToUpper(keyID)
+}
+
+func parseColonListing(output, primary string) []Key {
+	var keys []Key
+	var current *Key
+	expectFingerprint := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+
+		if len(fields) < 10 {
+			continue
+		}
+
+		switch fields[0] {
+		case primary:
+			keys = append(keys, Key{
+				Created: parseColonTime(fields[5]),
+				Expires: parseColonTime(fields[6]),
+			})
+			current = &keys[len(keys)-1]
+			expectFingerprint = true
+
+		case "sub", "ssb":
+			// Subkey fingerprints must not override the primary fingerprint
+			expectFingerprint = false
+
+		case "fpr":
+			if current != nil && expectFingerprint {
+				current.Fingerprint = fields[9]
+				expectFingerprint = false
+			}
+
+		case "uid":
+			if current != nil && current.UserID == "" {
+				current.UserID = fields[9]
+			}
+		}
+	}
+
+	return keys
+}
+
+func parseColonTime(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || seconds == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0)
+}
+
+// ShowKeys lists the public keys in a keyring file (binary, armored or keybox)
+// without importing them into any keyring
+func ShowKeys(path string) ([]Key, error) {
+	absPath, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	home, err := os.MkdirTemp("", "portaptable-gpg-")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary keyring: %w", err)
+	}
+
+	defer os.RemoveAll(home)
+
+	keyring := &Keyring{Home: home}
+	args := []string{"--no-default-keyring", "--keyring", absPath, "--with-colons", "--fixed-list-mode", "--list-keys"}
+
+	// Armored keys cannot be opened as a keyring
+	if data, err := os.ReadFile(absPath); err == nil && bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		args = []string{"--with-colons", "--fixed-list-mode", "--show-keys", absPath}
+	}
+
+	listing, err := keyring.run(args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseColonListing(string(listing), "pub"), nil
+}
+
+// VerifyClearsigned checks an inline-signed document (e.g. InRelease) against the keys
+// of keyrings, any of which may have signed it, and returns the signed content
+func VerifyClearsigned(data []byte, keyrings []string) ([]byte, error) {
+	var content []byte
+
+	err := withGpgv(keyrings, func(args []string) error {
+		cmd := exec.Command("gpgv", append(args, "--output", "-", "-")...)
+		cmd.Stdin = bytes.NewReader(data)
+
+		var err error
+		content, err = runGpgv(cmd, keyrings)
+
+		return err
+	})
+
+	return content, err
+}
+
+// VerifyDetached checks the detached signature of the file at path (e.g. Release.gpg
+// for Release) against the keys of keyrings
+func VerifyDetached(path, signature string, keyrings []string) error {
+	return withGpgv(keyrings, func(args []string) error {
+		_, err := runGpgv(exec.Command("gpgv", append(args, signature, path)...), keyrings)
+
+		return err
+	})
+}
+
+// withGpgv calls verify with gpgv arguments selecting keyrings in a temporary home
+func withGpgv(keyrings []string, verify func(args []string) error) error {
+	if len(keyrings) == 0 {
+		return fmt.Errorf("no keyring to verify the signature with")
+	}
+
+	home, err := os.MkdirTemp("", "portaptable-gpgv-")
+
+	if err != nil {
+		return fmt.Errorf("failed to create temporary keyring: %w", err)
+	}
+
+	defer os.RemoveAll(home)
+
+	args := []string{"--homedir", home}
+
+	for i, keyring := range keyrings {
+		path, err := filepath.Abs(keyring)
+
+		if err != nil {
+			return err
+		}
+
+		// gpgv only reads binary keyrings; apt also accepts armored .asc files
+		if content, err := os.ReadFile(path); err == nil && bytes.HasPrefix(bytes.TrimSpace(content), []byte("-----BEGIN")) {
+			dearmored := filepath.Join(home, fmt.Sprintf("keyring-%d.gpg", i))
+			cmd := exec.Command("gpg", "--homedir", home, "--batch", "--no-tty", "--dearmor", "--output", dearmored, path)
+
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to read keyring %s: %w, output: %s", keyring, err, strings.TrimSpace(string(output)))
+			}
+
+			path = dearmored
+		}
+
+		args = append(args, "--keyring", path)
+	}
+
+	return verify(args)
+}
+
+// runGpgv runs a gpgv invocation and returns its standard output
+func runGpgv(cmd *exec.Cmd, keyrings []string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	content, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("signature verification against %s failed: %w, output: %s", strings.Join(keyrings, ", "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return content, nil
+}
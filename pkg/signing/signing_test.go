@@ -0,0 +1,20 @@
+package signing
+
+import "testing"
+
+func TestNormalizeKeyID(t *testing.T) {
+	tests := map[string]string{
+		"0123456789ABCDEF":    "0123456789ABCDEF",
+		"0123456789abcdef":    "0123456789ABCDEF",
+		"0x0123456789abcdef":  "0123456789ABCDEF",
+		"0X0123456789ABCDEF":  "0123456789ABCDEF",
+		"0123 4567 89AB CDEF": "0123456789ABCDEF",
+		" 0x89ABCDEF ":        "89ABCDEF",
+	}
+
+	for keyID, want := range tests {
+		if got := normalizeKeyID(keyID); got != want {
+			t.Errorf("normalizeKeyID(%q) = %q, want %q", keyID, got, want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"portaptable/pkg/manifest"
+)
+
+// Dir is the repository subdirectory holding snapshots
+const Dir = "snapshots"
+
+// metadataEntries are the repository files and directories captured besides the pool;
+// the manifest comes last, so a rollback replaces it once the rest is in place
+var metadataEntries = []string{"dists", "changelogs", "portaptable-archive-keyring.gpg", "setup-apt.sh", manifest.FileName}
+
+// Snapshot describes a captured repository state
+type Snapshot struct {
+	Name     string
+	Created  time.Time
+	Packages int
+}
+
+// Path returns the directory of a snapshot. Each snapshot is a complete repository
+// that serve mode can publish as it is.
+func Path(repoPath, name string) string {
+	return filepath.Join(repoPath, Dir, name)
+}
+
+// ValidName rejects names that would escape the snapshots directory
+func ValidName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+
+	return nil
+}
+
+// Create captures the repository's metadata under name. Pool files referenced by the
+// manifest are hard-linked (pool files are never rewritten in place), so snapshots cost little space and survive gc and eviction.
+func Create(repoPath, name string) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+
+	target := Path(repoPath, name)
+
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	mfest, err := manifest.Load(repoPath)
+
+	if err != nil {
+		return err
+	}
+
+	// Build under a temporary name so an interrupted run never leaves a partial snapshot
+	tmp := target + ".tmp"
+	os.RemoveAll(tmp)
+
+	if err := os.MkdirAll(filepath.Join(tmp, "pool"), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	if err := copyEntries(repoPath, tmp); err != nil {
+		os.RemoveAll(tmp)
+
+		return err
+	}
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		if err := linkFile(filepath.Join(repoPath, "pool", pkg.Filename), filepath.Join(tmp, "pool", pkg.Filename)); err != nil {
+			os.RemoveAll(tmp)
+
+			return fmt.Errorf("failed to capture %s: %w", pkg.Filename, err)
+		}
+	}
+
+	return os.Rename(tmp, target)
+}
+
+// Rollback restores the repository's manifest and indexes from a snapshot. Pool files
+// the snapshot references are linked back from the snapshot, so nothing is downloaded.
+// The metadata is staged first and renamed into place, so a failure leaves the current
+// metadata as it was.
+func Rollback(repoPath, name string) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+
+	source := Path(repoPath, name)
+	mfest, err := manifest.Load(source)
+
+	if err != nil {
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	}
+
+	for _, pkg := range mfest.Packages {
+		if !pkg.Downloaded {
+			continue
+		}
+
+		target := filepath.Join(repoPath, "pool", pkg.Filename)
+
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+
+		if err := linkFile(filepath.Join(source, "pool", pkg.Filename), target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", pkg.Filename, err)
+		}
+	}
+
+	// Hidden, so exports and publication skip it should it survive a crash
+	stage, err := os.MkdirTemp(repoPath, ".rollback-")
+
+	if err != nil {
+		return fmt.Errorf("failed to stage snapshot %s: %w", name, err)
+	}
+
+	defer os.RemoveAll(stage)
+
+	if err := copyEntries(source, filepath.Join(stage, "next")); err != nil {
+		return err
+	}
+
+	return swapEntries(repoPath, stage)
+}
+
+// swapEntries replaces the metadata entries of repoPath with those staged in
+// stage/next, moving the current ones to stage/previous; on failure the moved entries
+// are put back
+func swapEntries(repoPath, stage string) error {
+	next, previous := filepath.Join(stage, "next"), filepath.Join(stage, "previous")
+
+	if err := os.MkdirAll(previous, 0755); err != nil {
+		return fmt.Errorf("failed to stage metadata: %w", err)
+	}
+
+	var moved []string
+
+	restore := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			os.RemoveAll(filepath.Join(repoPath, moved[i]))
+			os.Rename(filepath.Join(previous, moved[i]), filepath.Join(repoPath, moved[i]))
+		}
+	}
+
+	for _, entry := range metadataEntries {
+		current := filepath.Join(repoPath, entry)
+
+		if _, err := os.Lstat(current); err == nil {
+			if err := os.Rename(current, filepath.Join(previous, entry)); err != nil {
+				restore()
+
+				return fmt.Errorf("failed to replace %s: %w", entry, err)
+			}
+		}
+
+		moved = append(moved, entry)
+
+		if _, err := os.Stat(filepath.Join(next, entry)); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := os.Rename(filepath.Join(next, entry), current); err != nil {
+			restore()
+
+			return fmt.Errorf("failed to replace %s: %w", entry, err)
+		}
+	}
+
+	return nil
+}
+
+// List returns the repository's snapshots, oldest first
+func List(repoPath string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(repoPath, Dir))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var snapshots []Snapshot
+
+	for _, entry := range entries {
+		if !entry.IsDir() || ValidName(entry.Name()) != nil || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+
+		mfest, err := manifest.Load(Path(repoPath, entry.Name()))
+
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, Snapshot{Name: entry.Name(), Created: info.ModTime(), Packages: len(mfest.Packages)})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created.Before(snapshots[j].Created) })
+
+	return snapshots, nil
+}
+
+// copyEntries copies the metadata entries present in source into target. Metadata is
+// rewritten in place by later runs, so unlike pool files it cannot be shared by links.
+func copyEntries(source, target string) error {
+	for _, entry := range metadataEntries {
+		if _, err := os.Stat(filepath.Join(source, entry)); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := copyTree(filepath.Join(source, entry), filepath.Join(target, entry)); err != nil {
+			return fmt.Errorf("failed to capture %s: %w", entry, err)
+		}
+	}
+
+	return nil
+}
+
+// copyTree copies every file below source into the same place below target
+func copyTree(source, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(target, rel), 0755)
+		}
+
+		return copyFile(path, filepath.Join(target, rel))
+	})
+}
+
+// linkFile hard-links source to target, copying when the filesystem cannot link
+func linkFile(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	return copyFile(source, target)
+}
+
+// copyFile writes a copy of source to target
+func copyFile(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	info, err := in.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	return out.Close()
+}
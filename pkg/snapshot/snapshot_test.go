@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"portaptable/pkg/manifest"
+	"portaptable/pkg/packageinfo"
+)
+
+// writeRepository writes a repository holding version of app
+func writeRepository(t *testing.T, repo, version string) {
+	t.Helper()
+
+	filename := "app_" + version + "_amd64.deb"
+	files := map[string]string{
+		filepath.Join("pool", filename):                                     version,
+		filepath.Join("dists", "jammy", "main", "binary-amd64", "Packages"): "Version: " + version + "\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(repo, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mfest := &manifest.Manifest{Distribution: "jammy", Packages: []packageinfo.PackageInfo{
+		{Name: "app", Version: version, Architecture: "amd64", Filename: filename, Downloaded: true},
+	}}
+
+	if err := manifest.Save(repo, mfest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	repo := t.TempDir()
+	writeRepository(t, repo, "1.0")
+
+	if err := Create(repo, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upgrade replaces the indexes and drops the old pool file
+	writeRepository(t, repo, "2.0")
+
+	if err := os.Remove(filepath.Join(repo, "pool", "app_1.0_amd64.deb")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Rollback(repo, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	mfest, err := manifest.Load(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mfest.Packages) != 1 || mfest.Packages[0].Version != "1.0" {
+		t.Errorf("rolled back manifest holds %+v, want app 1.0", mfest.Packages)
+	}
+
+	index, err := os.ReadFile(filepath.Join(repo, "dists", "jammy", "main", "binary-amd64", "Packages"))
+
+	if err != nil || string(index) != "Version: 1.0\n" {
+		t.Errorf("rolled back index = %q, %v; want version 1.0", index, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo, "pool", "app_1.0_amd64.deb")); err != nil {
+		t.Errorf("rollback did not restore the pool file: %v", err)
+	}
+
+	entries, err := os.ReadDir(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".rollback-") {
+			t.Errorf("rollback left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestRollbackMissing(t *testing.T) {
+	repo := t.TempDir()
+	writeRepository(t, repo, "1.0")
+
+	if err := Rollback(repo, "missing"); err == nil {
+		t.Fatal("rollback to a missing snapshot succeeded")
+	}
+
+	if mfest, err := manifest.Load(repo); err != nil || mfest.Packages[0].Version != "1.0" {
+		t.Errorf("a failed rollback changed the repository")
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../x", `a\b`, "a/b", ".hidden"} {
+		if ValidName(name) == nil {
+			t.Errorf("ValidName(%q) accepted an unsafe name", name)
+		}
+	}
+
+	if err := ValidName("2024-03-01"); err != nil {
+		t.Errorf("ValidName(%q) = %v", "2024-03-01", err)
+	}
+}
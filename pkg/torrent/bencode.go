@@ -0,0 +1,199 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// encode writes a value in bencoding. Supported values are int, int64, string,
+// []byte, []interface{}, []string and map[string]interface{}.
+func encode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case []string:
+		buf.WriteByte('l')
+
+		for _, item := range v {
+			encode(buf, item)
+		}
+
+		buf.WriteByte('e')
+	case []interface{}:
+		buf.WriteByte('l')
+
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		// Dictionary keys must be sorted for the info hash to be reproducible
+		keys := make([]string, 0, len(v))
+
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+		buf.WriteByte('d')
+
+		for _, key := range keys {
+			encode(buf, key)
+
+			if err := encode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("cannot bencode %T", value)
+	}
+
+	return nil
+}
+
+// decoder parses bencoded data; strings decode to string, integers to int64
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+// decode parses one value and returns it with the raw bytes it spans
+func (d *decoder) decode() (interface{}, []byte, error) {
+	start := d.pos
+
+	if d.pos >= len(d.data) {
+		return nil, nil, fmt.Errorf("unexpected end of data")
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(d.data[d.pos:], 'e')
+
+		if end < 0 {
+			return nil, nil, fmt.Errorf("unterminated integer")
+		}
+
+		n, err := strconv.ParseInt(string(d.data[d.pos+1:d.pos+end]), 10, 64)
+		d.pos += end + 1
+
+		return n, d.data[start:d.pos], err
+
+	case c == 'l':
+		d.pos++
+		var list []interface{}
+
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			item, _, err := d.decode()
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			list = append(list, item)
+		}
+
+		d.pos++
+
+		return list, d.data[start:d.pos], nil
+
+	case c == 'd':
+		d.pos++
+		dict := make(map[string]interface{})
+
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, _, err := d.decode()
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			value, _, err := d.decode()
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			name, ok := key.(string)
+
+			if !ok {
+				return nil, nil, fmt.Errorf("dictionary key is not a string")
+			}
+
+			dict[name] = value
+		}
+
+		d.pos++
+
+		return dict, d.data[start:d.pos], nil
+
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(d.data[d.pos:], ':')
+
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("invalid string")
+		}
+
+		n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+
+		if err != nil || d.pos+colon+1+n > len(d.data) {
+			return nil, nil, fmt.Errorf("invalid string length")
+		}
+
+		d.pos += colon + 1 + n
+
+		return string(d.data[d.pos-n : d.pos]), d.data[start:d.pos], nil
+	}
+
+	return nil, nil, fmt.Errorf("invalid bencoding at offset %d", d.pos)
+}
+
+// rawInfo returns the exact bytes of the top-level "info" dictionary, which the info hash covers
+func rawInfo(data []byte) (map[string]interface{}, []byte, error) {
+	d := &decoder{data: data}
+
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, nil, fmt.Errorf("torrent is not a dictionary")
+	}
+
+	d.pos = 1
+
+	for d.pos < len(data) && data[d.pos] != 'e' {
+		key, _, err := d.decode()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value, raw, err := d.decode()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if key == "info" {
+			info, ok := value.(map[string]interface{})
+
+			if !ok {
+				return nil, nil, fmt.Errorf("info is not a dictionary")
+			}
+
+			return info, raw, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("torrent has no info dictionary")
+}
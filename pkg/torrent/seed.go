@@ -0,0 +1,305 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const protocol = "BitTorrent protocol"
+
+// Peer wire message ids used by the seeder
+const (
+	msgUnchoke  = 1
+	msgBitfield = 5
+	msgRequest  = 6
+	msgPiece    = 7
+)
+
+// maxBlockLength is the largest block a peer may request (BEP 3 clients use 16 KiB)
+const maxBlockLength = 128 << 10
+
+// defaultAnnounceInterval is used when a tracker does not specify one
+const defaultAnnounceInterval = 30 * time.Minute
+
+// Seeder uploads a complete file to peers over the BitTorrent wire protocol
+type Seeder struct {
+	Info *MetaInfo
+	Path string
+	Port int
+
+	// Logf receives connection and announce events; nil discards them
+	Logf func(format string, args ...interface{})
+
+	peerID [20]byte
+}
+
+// Seed listens for peers and announces to the torrent's trackers until the listener fails
+func (s *Seeder) Seed() error {
+	copy(s.peerID[:], "-PT0001-")
+	rand.Read(s.peerID[8:])
+
+	stat, err := os.Stat(s.Path)
+
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", s.Path, err)
+	}
+
+	if stat.Size() != s.Info.Length {
+		return fmt.Errorf("%s is %d bytes, torrent expects %d", s.Path, stat.Size(), s.Info.Length)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.Port))
+
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", s.Port, err)
+	}
+
+	defer listener.Close()
+
+	for _, tracker := range s.Info.Trackers {
+		go s.announceLoop(tracker)
+	}
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			if err := s.serve(conn); err != nil && err != io.EOF {
+				s.logf("Peer %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Seeder) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// announceLoop registers the seeder with a tracker at the interval the tracker asks for
+func (s *Seeder) announceLoop(tracker string) {
+	event := "started"
+
+	for {
+		interval, err := s.announce(tracker, event)
+
+		if err != nil {
+			s.logf("Announce to %s failed: %v", tracker, err)
+			interval = time.Minute
+		} else {
+			event = ""
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// announce sends one announce request and returns the tracker's re-announce interval
+func (s *Seeder) announce(tracker, event string) (time.Duration, error) {
+	query := url.Values{}
+	query.Set("info_hash", string(s.Info.InfoHash[:]))
+	query.Set("peer_id", string(s.peerID[:]))
+	query.Set("port", strconv.Itoa(s.Port))
+	query.Set("uploaded", "0")
+	query.Set("downloaded", "0")
+	query.Set("left", "0")
+	query.Set("compact", "1")
+
+	if event != "" {
+		query.Set("event", event)
+	}
+
+	separator := "?"
+
+	if bytes.ContainsRune([]byte(tracker), '?') {
+		separator = "&"
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(tracker + separator + query.Encode())
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("tracker returned %s", resp.Status)
+	}
+
+	d := &decoder{data: body}
+	value, _, err := d.decode()
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid tracker response: %w", err)
+	}
+
+	dict, _ := value.(map[string]interface{})
+
+	if reason, ok := dict["failure reason"].(string); ok {
+		return 0, fmt.Errorf("tracker refused announce: %s", reason)
+	}
+
+	if seconds, ok := dict["interval"].(int64); ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return defaultAnnounceInterval, nil
+}
+
+// serve handshakes with a peer, offers every piece and answers block requests
+func (s *Seeder) serve(conn net.Conn) error {
+	handshake := make([]byte, 68)
+
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return err
+	}
+
+	if handshake[0] != byte(len(protocol)) || string(handshake[1:20]) != protocol {
+		return fmt.Errorf("not a BitTorrent peer")
+	}
+
+	if !bytes.Equal(handshake[28:48], s.Info.InfoHash[:]) {
+		return fmt.Errorf("unknown info hash")
+	}
+
+	reply := make([]byte, 0, 68)
+	reply = append(reply, byte(len(protocol)))
+	reply = append(reply, protocol...)
+	reply = append(reply, make([]byte, 8)...)
+	reply = append(reply, s.Info.InfoHash[:]...)
+	reply = append(reply, s.peerID[:]...)
+
+	if _, err := conn.Write(reply); err != nil {
+		return err
+	}
+
+	if err := writeMessage(conn, msgBitfield, s.bitfield()); err != nil {
+		return err
+	}
+
+	if err := writeMessage(conn, msgUnchoke, nil); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.Path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	s.logf("Peer %s connected", conn.RemoteAddr())
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * time.Minute))
+
+		id, payload, err := readMessage(conn)
+
+		if err != nil {
+			return err
+		}
+
+		if id != msgRequest || len(payload) != 12 {
+			continue
+		}
+
+		index := int64(binary.BigEndian.Uint32(payload[0:4]))
+		begin := int64(binary.BigEndian.Uint32(payload[4:8]))
+		length := int64(binary.BigEndian.Uint32(payload[8:12]))
+		offset := index*s.Info.PieceLength + begin
+
+		if int(index) >= s.Info.NumPieces() || length > maxBlockLength || begin+length > s.Info.PieceLength || offset+length > s.Info.Length {
+			return fmt.Errorf("invalid request for piece %d", index)
+		}
+
+		block := make([]byte, 8+length)
+		copy(block, payload[0:8])
+
+		if _, err := file.ReadAt(block[8:], offset); err != nil {
+			return err
+		}
+
+		if err := writeMessage(conn, msgPiece, block); err != nil {
+			return err
+		}
+	}
+}
+
+// bitfield marks every piece as available
+func (s *Seeder) bitfield() []byte {
+	pieces := s.Info.NumPieces()
+	field := make([]byte, (pieces+7)/8)
+
+	for i := 0; i < pieces; i++ {
+		field[i/8] |= 0x80 >> uint(i%8)
+	}
+
+	return field
+}
+
+func writeMessage(w io.Writer, id byte, payload []byte) error {
+	msg := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(msg, uint32(1+len(payload)))
+	msg[4] = id
+	copy(msg[5:], payload)
+
+	_, err := w.Write(msg)
+
+	return err
+}
+
+// readMessage returns the next message, skipping keep-alives
+func readMessage(r io.Reader) (byte, []byte, error) {
+	for {
+		var header [4]byte
+
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, nil, err
+		}
+
+		length := binary.BigEndian.Uint32(header[:])
+
+		if length == 0 {
+			continue
+		}
+
+		if length > maxBlockLength+16 {
+			return 0, nil, fmt.Errorf("message of %d bytes is too large", length)
+		}
+
+		msg := make([]byte, length)
+
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return 0, nil, err
+		}
+
+		return msg[0], msg[1:], nil
+	}
+}
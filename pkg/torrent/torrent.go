@@ -0,0 +1,232 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Extension is appended to the bundle name to form the torrent file name
+const Extension = ".torrent"
+
+const (
+	minPieceLength = 256 << 10
+	maxPieceLength = 16 << 20
+	targetPieces   = 1500
+)
+
+// MetaInfo is a single-file torrent
+type MetaInfo struct {
+	Name        string
+	Length      int64
+	PieceLength int64
+	Pieces      []byte
+	Trackers    []string
+	WebSeeds    []string
+	InfoHash    [20]byte
+}
+
+// PieceLength picks a power-of-two piece size keeping the piece count near targetPieces
+func PieceLength(size int64) int64 {
+	length := int64(minPieceLength)
+
+	for length < maxPieceLength && size/length > targetPieces {
+		length *= 2
+	}
+
+	return length
+}
+
+// Create hashes the file at path into a torrent announcing to trackers and listing
+// webSeeds as HTTP sources (BEP 19); pieceLength 0 selects one automatically
+func Create(path string, pieceLength int64, trackers, webSeeds []string) (*MetaInfo, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pieceLength <= 0 {
+		pieceLength = PieceLength(stat.Size())
+	}
+
+	info := &MetaInfo{
+		Name:        filepath.Base(path),
+		Length:      stat.Size(),
+		PieceLength: pieceLength,
+		Trackers:    trackers,
+		WebSeeds:    webSeeds,
+	}
+
+	buf := make([]byte, pieceLength)
+
+	for {
+		n, err := io.ReadFull(file, buf)
+
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			info.Pieces = append(info.Pieces, sum[:]...)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	var raw bytes.Buffer
+	encode(&raw, info.infoDict())
+	info.InfoHash = sha1.Sum(raw.Bytes())
+
+	return info, nil
+}
+
+// infoDict is the dictionary the info hash is computed over
+func (m *MetaInfo) infoDict() map[string]interface{} {
+	return map[string]interface{}{
+		"name":         m.Name,
+		"length":       m.Length,
+		"piece length": m.PieceLength,
+		"pieces":       m.Pieces,
+	}
+}
+
+// NumPieces returns the number of pieces the file is split into
+func (m *MetaInfo) NumPieces() int {
+	return len(m.Pieces) / sha1.Size
+}
+
+// Write stores the torrent at path
+func (m *MetaInfo) Write(path string) error {
+	dict := map[string]interface{}{
+		"info":          m.infoDict(),
+		"created by":    "portaptable",
+		"creation date": time.Now().Unix(),
+	}
+
+	if len(m.Trackers) > 0 {
+		dict["announce"] = m.Trackers[0]
+		tiers := make([]interface{}, 0, len(m.Trackers))
+
+		for _, tracker := range m.Trackers {
+			tiers = append(tiers, []string{tracker})
+		}
+
+		dict["announce-list"] = tiers
+	}
+
+	if len(m.WebSeeds) > 0 {
+		dict["url-list"] = m.WebSeeds
+	}
+
+	var buf bytes.Buffer
+
+	if err := encode(&buf, dict); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write torrent: %w", err)
+	}
+
+	return nil
+}
+
+// Load reads a single-file torrent written by Write or another client
+func Load(path string) (*MetaInfo, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torrent: %w", err)
+	}
+
+	info, raw, err := rawInfo(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent %s: %w", path, err)
+	}
+
+	name, _ := info["name"].(string)
+	length, _ := info["length"].(int64)
+	pieceLength, _ := info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+
+	if name == "" || pieceLength <= 0 || len(pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("invalid torrent %s: not a single-file torrent", path)
+	}
+
+	m := &MetaInfo{
+		Name:        name,
+		Length:      length,
+		PieceLength: pieceLength,
+		Pieces:      []byte(pieces),
+		InfoHash:    sha1.Sum(raw),
+	}
+
+	d := &decoder{data: data}
+	top, _, err := d.decode()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent %s: %w", path, err)
+	}
+
+	dict, _ := top.(map[string]interface{})
+
+	if announce, ok := dict["announce"].(string); ok {
+		m.Trackers = append(m.Trackers, announce)
+	}
+
+	if tiers, ok := dict["announce-list"].([]interface{}); ok {
+		for _, tier := range tiers {
+			urls, _ := tier.([]interface{})
+
+			for _, url := range urls {
+				if s, ok := url.(string); ok && !contains(m.Trackers, s) {
+					m.Trackers = append(m.Trackers, s)
+				}
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verify checks that the file at path matches every piece hash of the torrent
+func (m *MetaInfo) Verify(path string) error {
+	other, err := Create(path, m.PieceLength, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if other.Length != m.Length || !bytes.Equal(other.Pieces, m.Pieces) {
+		return fmt.Errorf("%s does not match the torrent's piece hashes", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,286 @@
+package zsync
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"portaptable/pkg/remote"
+)
+
+// maxRangeGap merges missing runs separated by at most this many known blocks into
+// one range request, trading a little extra transfer for far fewer round trips
+const maxRangeGap = 16
+
+// Stats reports how a file was rebuilt
+type Stats struct {
+	Reused  int64 // Bytes copied from seed files
+	Fetched int64 // Bytes downloaded
+	Ranges  int   // Range requests made
+}
+
+// source is where a block's data was found locally
+type source struct {
+	file   *os.File
+	offset int64
+}
+
+// Update writes the file the control file describes to target, copying every block
+// found in the seed files (typically an older delivery of the same bundle) and
+// fetching the rest from fileURL with HTTP range requests. The result is verified
+// against the control file's SHA-1 before it replaces target.
+func (c *Control) Update(fileURL, target string, seeds []string) (Stats, error) {
+	var stats Stats
+
+	found := make([]*source, len(c.Blocks))
+
+	for _, seed := range seeds {
+		file, err := os.Open(seed)
+
+		if err != nil {
+			continue
+		}
+
+		defer file.Close()
+
+		if err := c.scan(file, found); err != nil {
+			return stats, fmt.Errorf("failed to read %s: %w", seed, err)
+		}
+	}
+
+	partial := target + ".partial"
+	out, err := os.Create(partial)
+
+	if err != nil {
+		return stats, fmt.Errorf("failed to create %s: %w", partial, err)
+	}
+
+	defer os.Remove(partial)
+
+	if err := c.assemble(out, fileURL, found, &stats); err != nil {
+		out.Close()
+
+		return stats, err
+	}
+
+	if err := out.Close(); err != nil {
+		return stats, err
+	}
+
+	if err := verifySHA1(partial, c.SHA1); err != nil {
+		return stats, err
+	}
+
+	if !c.MTime.IsZero() {
+		os.Chtimes(partial, c.MTime, c.MTime)
+	}
+
+	return stats, os.Rename(partial, target)
+}
+
+// assemble writes every block to out, copying found ones and fetching the rest
+func (c *Control) assemble(out *os.File, fileURL string, found []*source, stats *Stats) error {
+	buf := make([]byte, c.BlockSize)
+
+	for i := 0; i < len(c.Blocks); {
+		if found[i] != nil {
+			n := c.blockLength(i)
+
+			if _, err := found[i].file.ReadAt(buf[:n], found[i].offset); err != nil {
+				return fmt.Errorf("failed to read seed data: %w", err)
+			}
+
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			stats.Reused += int64(n)
+			i++
+
+			continue
+		}
+
+		// Extend the run over short gaps of known blocks
+		end, gap := i+1, 0
+
+		for j := i + 1; j < len(c.Blocks) && gap <= maxRangeGap; j++ {
+			if found[j] == nil {
+				end, gap = j+1, 0
+			} else {
+				gap++
+			}
+		}
+
+		offset := int64(i) * int64(c.BlockSize)
+		length := min(int64(end)*int64(c.BlockSize), c.Length) - offset
+
+		if err := fetchRange(out, fileURL, offset, length); err != nil {
+			return err
+		}
+
+		stats.Fetched += length
+		stats.Ranges++
+		i = end
+	}
+
+	return nil
+}
+
+func fetchRange(out io.Writer, fileURL string, offset, length int64) error {
+	body, err := remote.GetRange(fileURL, offset, length)
+
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	if n, err := io.CopyN(out, body, length); err != nil {
+		return fmt.Errorf("failed to fetch %s: got %d of %d bytes at offset %d: %w", fileURL, n, length, offset, err)
+	}
+
+	return nil
+}
+
+// blockLength returns the size of block i, which is short only for the last block
+func (c *Control) blockLength(i int) int {
+	return int(min(int64(c.BlockSize), c.Length-int64(i)*int64(c.BlockSize)))
+}
+
+// scan slides a block-sized window over file, recording in found where each block
+// not yet found occurs. A match needs the MD4 of the window and, when the control
+// file demands sequential matches, of the following block to agree.
+func (c *Control) scan(file *os.File, found []*source) error {
+	bs := c.BlockSize
+	mask := c.rsumMask()
+
+	index := make(map[uint32][]int)
+	filter := make([]bool, 1<<16) // Cheap rejection before the map lookup at every offset
+
+	for i, block := range c.Blocks {
+		if found[i] == nil {
+			index[block.Rsum] = append(index[block.Rsum], i)
+			filter[uint16(block.Rsum)^uint16(block.Rsum>>16)] = true
+		}
+	}
+
+	if len(index) == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 0, max(4<<20, 4*bs))
+	var base int64 // File offset of buf[0]
+	pos, eof := 0, false
+	var a, b uint16
+	fresh := true
+
+	for {
+		// Keep two blocks ahead of the window for sequential matches
+		if !eof && len(buf)-pos < 2*bs {
+			n := copy(buf[:cap(buf)], buf[pos:])
+			base += int64(pos)
+			pos = 0
+
+			read, err := io.ReadFull(file, buf[n:cap(buf)])
+			buf = buf[:n+read]
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(buf)-pos < bs {
+			return nil
+		}
+
+		if fresh {
+			sum := rsum(buf[pos : pos+bs])
+			a, b = uint16(sum>>16), uint16(sum)
+			fresh = false
+		}
+
+		if key := (uint32(a)<<16 | uint32(b)) & mask; filter[uint16(key)^uint16(key>>16)] {
+			if c.match(buf, pos, index[key], found, file, base) {
+				pos += bs
+				fresh = true
+
+				continue
+			}
+		}
+
+		if pos+bs >= len(buf) {
+			return nil
+		}
+
+		// Roll the window one byte forward
+		out, in := uint16(buf[pos]), uint16(buf[pos+bs])
+		a += in - out
+		b += a - uint16(bs)*out
+		pos++
+	}
+}
+
+// match records the candidate blocks whose checksums the window at buf[pos:] has
+func (c *Control) match(buf []byte, pos int, candidates []int, found []*source, file *os.File, base int64) bool {
+	bs := c.BlockSize
+	sum := md4(buf[pos : pos+bs])
+	var next []byte
+	matched := false
+
+	for _, i := range candidates {
+		if found[i] != nil || string(sum[:c.ChecksumBytes]) != string(c.Blocks[i].Checksum) {
+			continue
+		}
+
+		if c.SeqMatches > 1 && i+1 < len(c.Blocks) {
+			if pos+2*bs > len(buf) {
+				continue
+			}
+
+			if next == nil {
+				nextSum := md4(buf[pos+bs : pos+2*bs])
+				next = nextSum[:c.ChecksumBytes]
+			}
+
+			if string(next) != string(c.Blocks[i+1].Checksum) {
+				continue
+			}
+
+			// The following block is verified too; it may end a run of unchanged blocks
+			if found[i+1] == nil {
+				found[i+1] = &source{file: file, offset: base + int64(pos+bs)}
+			}
+		}
+
+		found[i] = &source{file: file, offset: base + int64(pos)}
+		matched = true
+	}
+
+	return matched
+}
+
+func verifySHA1(path, expected string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	hash := sha1.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("rebuilt file does not match the control file: expected SHA-1 %s, got %s", expected, actual)
+	}
+
+	return nil
+}
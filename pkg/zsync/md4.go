@@ -0,0 +1,68 @@
+package zsync
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// md4 rounds: the message word order and the rotations of each step
+var (
+	md4Order = [3][16]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15},
+		{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15},
+	}
+	md4Shifts = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+)
+
+// md4 returns the MD4 digest (RFC 1320) of data. zsync control files use MD4 for
+// their block checksums and the standard library does not provide it.
+func md4(data []byte) [16]byte {
+	s := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+
+	// Pad with 0x80 and zeros up to 56 mod 64, then append the length in bits
+	padded := make([]byte, (len(data)+8)/64*64+64)
+	copy(padded, data)
+	padded[len(data)] = 0x80
+	binary.LittleEndian.PutUint64(padded[len(padded)-8:], uint64(len(data))<<3)
+
+	var x [16]uint32
+
+	for block := padded; len(block) > 0; block = block[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[i*4:])
+		}
+
+		a, b, c, d := s[0], s[1], s[2], s[3]
+
+		for round := 0; round < 3; round++ {
+			for step, i := range md4Order[round] {
+				var f uint32
+
+				switch round {
+				case 0:
+					f = (b & c) | (^b & d)
+				case 1:
+					f = ((b & c) | (b & d) | (c & d)) + 0x5a827999
+				case 2:
+					f = (b ^ c ^ d) + 0x6ed9eba1
+				}
+
+				a, b, c, d = d, bits.RotateLeft32(a+f+x[i], md4Shifts[round][step%4]), b, c
+			}
+		}
+
+		s[0] += a
+		s[1] += b
+		s[2] += c
+		s[3] += d
+	}
+
+	var digest [16]byte
+
+	for i, v := range s {
+		binary.LittleEndian.PutUint32(digest[i*4:], v)
+	}
+
+	return digest
+}
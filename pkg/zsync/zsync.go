@@ -0,0 +1,258 @@
+package zsync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extension is appended to the bundle name to form the control file name
+const Extension = ".zsync"
+
+// version is the zsync control file format written, as by zsyncmake 0.6.2
+const version = "0.6.2"
+
+// Control is a zsync control file: the block checksums letting a client rebuild a
+// file from local data it already has plus the blocks it fetches by HTTP range
+type Control struct {
+	Filename      string
+	MTime         time.Time
+	BlockSize     int
+	Length        int64
+	SeqMatches    int // Consecutive blocks that must match, which allows shorter hashes
+	RsumBytes     int
+	ChecksumBytes int
+	URL           string // Of the file, relative to the control file unless absolute
+	SHA1          string
+	Blocks        []Block
+}
+
+// Block is the weak rolling checksum and truncated MD4 of one block
+type Block struct {
+	Rsum     uint32
+	Checksum []byte
+}
+
+// BlockSize picks the block size zsyncmake would for a file of size bytes
+func BlockSize(size int64) int {
+	if size < 100000000 {
+		return 2048
+	}
+
+	return 4096
+}
+
+// Create computes the control file of the file at path; url locates the file for
+// clients and is typically its name next to the control file
+func Create(path, url string) (*Control, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Control{
+		Filename:  filepath.Base(path),
+		MTime:     stat.ModTime(),
+		BlockSize: BlockSize(stat.Size()),
+		Length:    stat.Size(),
+		URL:       url,
+	}
+
+	c.hashLengths()
+
+	whole := sha1.New()
+	buf := make([]byte, c.BlockSize)
+
+	for {
+		n, err := io.ReadFull(file, buf)
+
+		if n > 0 {
+			whole.Write(buf[:n])
+
+			// The last block is checksummed padded with zeros
+			clear(buf[n:])
+			c.Blocks = append(c.Blocks, c.blockSum(buf))
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	c.SHA1 = hex.EncodeToString(whole.Sum(nil))
+
+	return c, nil
+}
+
+// hashLengths sizes the stored checksums for the file length as zsyncmake does,
+// keeping false matches unlikely while the control file stays small
+func (c *Control) hashLengths() {
+	length, blockSize := float64(c.Length), float64(c.BlockSize)
+	blocks := float64(1 + c.Length/int64(c.BlockSize))
+
+	c.SeqMatches = 1
+
+	if c.Length > int64(c.BlockSize) {
+		c.SeqMatches = 2
+	}
+
+	seq := float64(c.SeqMatches)
+
+	c.RsumBytes = int(math.Ceil(((math.Log(length)+math.Log(blockSize))/math.Log(2) - 8.6) / seq / 8))
+	c.RsumBytes = min(max(c.RsumBytes, 2), 4)
+
+	c.ChecksumBytes = int(math.Ceil((20 + (math.Log(length)+math.Log(blocks))/math.Log(2)) / seq / 8))
+	c.ChecksumBytes = min(max(c.ChecksumBytes, int((7.9+(20+math.Log(blocks)/math.Log(2)))/8)), 16)
+}
+
+// blockSum returns the stored checksums of a full block
+func (c *Control) blockSum(block []byte) Block {
+	sum := md4(block)
+
+	return Block{
+		Rsum:     rsum(block) & c.rsumMask(),
+		Checksum: sum[:c.ChecksumBytes],
+	}
+}
+
+// rsumMask keeps the bytes of the rolling checksum the control file stores
+func (c *Control) rsumMask() uint32 {
+	if c.RsumBytes >= 4 {
+		return math.MaxUint32
+	}
+
+	return 1<<(8*c.RsumBytes) - 1
+}
+
+// rsum is zsync's rolling checksum: a is the byte sum and b the sum weighted by
+// the distance from the block end, each modulo 2^16, stored as a<<16 | b
+func rsum(block []byte) uint32 {
+	var a, b uint16
+
+	for i, c := range block {
+		a += uint16(c)
+		b += uint16(len(block)-i) * uint16(c)
+	}
+
+	return uint32(a)<<16 | uint32(b)
+}
+
+// Write stores the control file at path
+func (c *Control) Write(path string) error {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "zsync: %s\n", version)
+	fmt.Fprintf(&buf, "Filename: %s\n", c.Filename)
+	fmt.Fprintf(&buf, "MTime: %s\n", c.MTime.UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Blocksize: %d\n", c.BlockSize)
+	fmt.Fprintf(&buf, "Length: %d\n", c.Length)
+	fmt.Fprintf(&buf, "Hash-Lengths: %d,%d,%d\n", c.SeqMatches, c.RsumBytes, c.ChecksumBytes)
+	fmt.Fprintf(&buf, "URL: %s\n", c.URL)
+	fmt.Fprintf(&buf, "SHA-1: %s\n\n", c.SHA1)
+
+	var rsumBytes [4]byte
+
+	for _, block := range c.Blocks {
+		binary.BigEndian.PutUint32(rsumBytes[:], block.Rsum)
+		buf.Write(rsumBytes[4-c.RsumBytes:])
+		buf.Write(block.Checksum)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write zsync control file: %w", err)
+	}
+
+	return nil
+}
+
+// Parse reads a control file written by Write or zsyncmake
+func Parse(r io.Reader) (*Control, error) {
+	reader := bufio.NewReader(r)
+	c := &Control{}
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid zsync control file: truncated header")
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(line, ": ")
+
+		switch key {
+		case "Filename":
+			c.Filename = value
+		case "MTime":
+			c.MTime, _ = time.Parse(time.RFC1123Z, value)
+		case "Blocksize":
+			c.BlockSize, _ = strconv.Atoi(value)
+		case "Length":
+			c.Length, _ = strconv.ParseInt(value, 10, 64)
+		case "Hash-Lengths":
+			fmt.Sscanf(value, "%d,%d,%d", &c.SeqMatches, &c.RsumBytes, &c.ChecksumBytes)
+		case "URL":
+			if c.URL == "" {
+				c.URL = value
+			}
+		case "SHA-1":
+			c.SHA1 = value
+		case "Z-Map2", "Z-URL", "Recompress":
+			// zsyncmake -z describes the uncompressed content of a gzip file instead
+			return nil, fmt.Errorf("zsync control files for compressed content (%s) are not supported", key)
+		}
+	}
+
+	if c.BlockSize <= 0 || c.Length < 0 || c.URL == "" || c.SHA1 == "" ||
+		c.SeqMatches < 1 || c.SeqMatches > 2 || c.RsumBytes < 1 || c.RsumBytes > 4 ||
+		c.ChecksumBytes < 3 || c.ChecksumBytes > 16 {
+		return nil, fmt.Errorf("invalid zsync control file: incomplete header")
+	}
+
+	count := (c.Length + int64(c.BlockSize) - 1) / int64(c.BlockSize)
+	entry := make([]byte, c.RsumBytes+c.ChecksumBytes)
+
+	for i := int64(0); i < count; i++ {
+		if _, err := io.ReadFull(reader, entry); err != nil {
+			return nil, fmt.Errorf("invalid zsync control file: truncated block checksums")
+		}
+
+		var rsumBytes [4]byte
+		copy(rsumBytes[4-c.RsumBytes:], entry[:c.RsumBytes])
+
+		c.Blocks = append(c.Blocks, Block{
+			Rsum:     binary.BigEndian.Uint32(rsumBytes[:]),
+			Checksum: append([]byte(nil), entry[c.RsumBytes:]...),
+		})
+	}
+
+	return c, nil
+}